@@ -7,74 +7,213 @@ import (
     "net/http"
     "os"
     "os/exec"
+    "strconv"
     "time"
 
     "github.com/hashicorp/yamux"
+    "github.com/shellhub-io/mini-shellhub/agent/pkg/agent/pkg/metrics"
     agentsrv "github.com/shellhub-io/mini-shellhub/agent/pkg/agent/server"
     hostmode "github.com/shellhub-io/mini-shellhub/agent/pkg/agent/server/modes/host"
     apiclient "github.com/shellhub-io/shellhub/pkg/api/client"
+    "github.com/shellhub-io/mini-shellhub/pkg/guard"
+    "github.com/shellhub-io/mini-shellhub/pkg/tracing"
     "github.com/shellhub-io/mini-shellhub/pkg/yamuxws"
     log "github.com/sirupsen/logrus"
 )
 
+// AgentVersion is the version of the agent sent to the server, embedded on the binary like:
+//
+//	go build -ldflags "-X main.AgentVersion=1.2.3"
+var AgentVersion string
+
+const (
+    // minBackoff is the time waited before reconnecting to the server, doubled after each failed attempt up to
+    // maxBackoff.
+    minBackoff = time.Second
+    maxBackoff = 30 * time.Second
+)
+
 func main() {
     var serverURL string
     var deviceID string
     var privKey string
     var singleUserPass string
+    var reversePortForward bool
+    var streamLocal string
+    var x11Forward bool
+    var proxyAllow string
+    var publish string
+    var metricsAddress string
+    var authMaxFailures int
 
     flag.StringVar(&serverURL, "server", os.Getenv("MINIMAL_SERVER"), "Server base URL, e.g. http://127.0.0.1:8080")
     flag.StringVar(&deviceID, "id", os.Getenv("MINIMAL_DEVICE_ID"), "Device ID for registration")
     flag.StringVar(&privKey, "key", os.Getenv("MINIMAL_PRIVATE_KEY"), "Path to SSH host private key (PEM)")
-    flag.StringVar(&singleUserPass, "single-pass", os.Getenv("MINIMAL_SINGLE_USER_PASSWORD"), "Enable single-user mode with this password hash")
+    flag.StringVar(&singleUserPass, "single-pass", os.Getenv("MINIMAL_SINGLE_USER_PASSWORD"), "Enable single-user mode with this password hash, bcrypt or hex SHA256")
+    flag.BoolVar(&reversePortForward, "reverse-port-forward", os.Getenv("MINIMAL_REVERSE_PORT_FORWARD") != "false", "Allow the server to listen on ports of this device for reverse port forwarding (ssh -R)")
+    flag.StringVar(&streamLocal, "streamlocal", os.Getenv("MINIMAL_STREAMLOCAL"), "Unix socket paths each user can forward, e.g. \"root=/var/run/docker.sock;*=/tmp/*.sock\"")
+    flag.BoolVar(&x11Forward, "x11-forward", os.Getenv("MINIMAL_X11_FORWARD") != "false", "Allow X11 forwarding (ssh -X); requires xauth on this device")
+    flag.StringVar(&proxyAllow, "proxy-allow", os.Getenv("MINIMAL_PROXY_ALLOW"), "Hosts and ports the server can reach through this device, e.g. \"10.0.0.0/24:22,switch01:22\"")
+    flag.StringVar(&publish, "publish", os.Getenv("MINIMAL_PUBLISH"), "Services published on ports of the server, without SSH sessions, e.g. \"web=127.0.0.1:8080\"")
+    flag.IntVar(&authMaxFailures, "auth-max-failures", envInt("MINIMAL_AUTH_MAX_FAILURES"), "Refused passwords within 10 minutes that lock a user out, for 1 minute doubled on each lockout up to 1 hour; 0 disables it")
+    flag.StringVar(&metricsAddress, "metrics-address", os.Getenv("MINIMAL_METRICS_ADDRESS"), "Address to serve the Prometheus metrics on /metrics, e.g. \"127.0.0.1:9100\"")
     flag.Parse()
 
     if serverURL == "" || deviceID == "" {
         log.Fatal("missing required params: --server, --id")
     }
 
+    // NOTE: With OTEL_EXPORTER_OTLP_ENDPOINT, like "http://collector:4318", the spans of the sessions are exported to
+    // that OpenTelemetry collector, on the traces of the server's connections.
+    shutdown, err := tracing.Setup(context.Background(), "shellhub-agent")
+    if err != nil {
+        log.WithError(err).Fatal("failed to set up the tracing")
+    }
+
+    defer shutdown(context.Background()) //nolint:errcheck
+
     deviceName := deviceID
 
+    // NOTE: The server already locks out the clients and SSHIDs with too many failures; the agent locks out its users
+    // too when MINIMAL_AUTH_MAX_FAILURES is set, like for single-user mode passwords.
+    lockouts := guard.Default()
+    lockouts.MaxFailures = authMaxFailures
+
+    authGuard, err := guard.New(lockouts)
+    if err != nil {
+        log.WithError(err).Fatal("failed to set up the brute-force protection")
+    }
+
     // Build host mode server with password auth (public key auth disabled without API).
     mode := &hostmode.Mode{
-        Authenticator: *hostmode.NewAuthenticator(nil, nil, singleUserPass, &deviceName),
+        Authenticator: *hostmode.NewAuthenticator(nil, nil, singleUserPass, &deviceName, authGuard),
         Sessioner:     *hostmode.NewSessioner(&deviceName, make(map[string]*exec.Cmd)),
     }
 
-    srv := agentsrv.NewServer(nil, mode, &agentsrv.Config{PrivateKey: privKey})
+    features := agentsrv.NoFeature
+    if reversePortForward {
+        features |= agentsrv.ReversePortForwardFeature
+    }
 
-    // Connect to server via websocket
-    ctx := context.Background()
-    conn, _, err := apiclient.DialContext(ctx, serverURL+"/ssh/connection", http.Header{"X-Device-ID": []string{deviceID}})
+    if x11Forward {
+        features |= agentsrv.X11ForwardFeature
+    }
+
+    streamLocalPolicy, err := agentsrv.ParseStreamLocalPolicy(streamLocal)
     if err != nil {
-        log.WithError(err).Fatal("failed to connect to server")
+        log.WithError(err).Fatal("failed to parse the unix socket forwarding policy")
     }
 
-    // Create yamux session over websocket
-    wsConn := yamuxws.NewWSConn(conn)
-    session, err := yamux.Client(wsConn, yamux.DefaultConfig())
+    proxyPolicy, err := agentsrv.ParseProxyPolicy(proxyAllow)
     if err != nil {
-        log.WithError(err).Fatal("failed to create yamux session")
+        log.WithError(err).Fatal("failed to parse the proxy policy")
     }
-    defer session.Close()
 
-    log.WithFields(log.Fields{"server": serverURL, "id": deviceID}).Info("connected; listening for SSH via yamux")
+    // NOTE: Publishing a service allows the server to reach it through the proxy.
+    published, err := agentsrv.ParsePublished(publish)
+    if err != nil {
+        log.WithError(err).Fatal("failed to parse the published services")
+    }
+
+    proxyPolicy = append(proxyPolicy, published.Policy()...)
+
+    srv := agentsrv.NewServer(nil, mode, &agentsrv.Config{PrivateKey: privKey, Features: features, StreamLocal: streamLocalPolicy, Proxy: proxyPolicy})
+
+    // NOTE: The metrics are served on MINIMAL_METRICS_ADDRESS only when it is set.
+    if metricsAddress != "" {
+        go func() {
+            log.WithField("addr", metricsAddress).Info("metrics listening")
+
+            if err := metrics.ListenAndServe(metricsAddress); err != nil {
+                log.WithError(err).Fatal("failed to serve the metrics")
+            }
+        }()
+    }
+
+    header := http.Header{"X-Device-ID": []string{deviceID}}
+    if AgentVersion != "" {
+        header.Set("X-Device-Version", AgentVersion)
+    }
+
+    if len(published) > 0 {
+        header.Set(agentsrv.PublishedHeader, published.String())
+    }
+
+    // NOTE: The agent reconnects when the connection to the server is lost or cannot be established, waiting longer
+    // after each failed attempt.
+    connected := false
+    backoff := minBackoff
+    for {
+        session, err := connect(serverURL, header)
+        if err != nil {
+            log.WithError(err).WithField("retry", backoff.String()).Error("failed to connect to server")
+
+            time.Sleep(backoff)
+            backoff = min(backoff*2, maxBackoff)
+
+            continue
+        }
+
+        if connected {
+            metrics.Reconnects.Inc()
+        }
+
+        connected = true
+        backoff = minBackoff
+
+        log.WithFields(log.Fields{"server": serverURL, "id": deviceID}).Info("connected; listening for SSH via yamux")
+
+        metrics.Connected.Set(1)
+        serve(srv, session)
+        metrics.Connected.Set(0)
+
+        log.WithField("retry", backoff.String()).Warn("connection to server lost")
+
+        time.Sleep(backoff)
+    }
+}
+
+// envInt parses the environment variable as an integer, or returns zero when it is unset or invalid.
+func envInt(name string) int {
+    value, _ := strconv.Atoi(os.Getenv(name))
+
+    return value
+}
+
+// connect connects to the server via websocket and creates the yamux session over it.
+func connect(serverURL string, header http.Header) (*yamux.Session, error) {
+    conn, _, err := apiclient.DialContext(context.Background(), serverURL+"/ssh/connection", header)
+    if err != nil {
+        return nil, err
+    }
+
+    session, err := yamux.Client(yamuxws.NewWSConn(conn), yamux.DefaultConfig())
+    if err != nil {
+        conn.Close()
+
+        return nil, err
+    }
+
+    return session, nil
+}
+
+// serve accepts the incoming streams (SSH connections or proxy connections) until the session is closed.
+func serve(srv *agentsrv.Server, session *yamux.Session) {
+    defer session.Close()
 
-    // Accept incoming streams (SSH connections)
     for {
         stream, err := session.Accept()
         if err != nil {
             log.WithError(err).Error("failed to accept yamux stream")
-            break
+
+            return
         }
-        
+
         go handleSSHStream(srv, stream)
     }
-
-    time.Sleep(time.Second)
 }
 
-// handleSSHStream handles a yamux stream as an SSH connection
+// handleSSHStream handles a yamux stream as an SSH connection, or as a proxy connection when it starts with CONNECT
 func handleSSHStream(serv *agentsrv.Server, stream net.Conn) {
     defer stream.Close()
     
@@ -82,6 +221,6 @@ func handleSSHStream(serv *agentsrv.Server, stream net.Conn) {
         "remote": stream.RemoteAddr(),
     }).Info("handling SSH stream")
     
-    // Handle the connection directly with the SSH server
-    serv.HandleConn(stream)
+    // Handle the connection with the SSH server, unless it is a proxy connection
+    serv.HandleStream(stream)
 }
@@ -38,7 +38,7 @@ func (m *HostMode) Serve(agent *Agent) {
 	agent.server = server.NewServer(
 		agent.cli,
 		&host.Mode{
-			Authenticator: *host.NewAuthenticator(agent.cli, agent.authData, agent.config.SingleUserPassword, &agent.authData.Name),
+			Authenticator: *host.NewAuthenticator(agent.cli, agent.authData, agent.config.SingleUserPassword, &agent.authData.Name, nil),
 			Sessioner:     *host.NewSessioner(&agent.authData.Name, make(map[string]*exec.Cmd)),
 		},
 		&server.Config{
@@ -0,0 +1,55 @@
+// Package metrics keeps the metrics of the agent, scraped by Prometheus from [Registry] on the agent's optional metrics
+// listener.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is the endpoint of the metrics listener that serves [Registry].
+const Path = "/metrics"
+
+// Registry keeps the metrics of the agent.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// Sessions are the SSH sessions open on the device, by type, like "shell" or "exec".
+	Sessions = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shellhub_agent_sessions",
+		Help: "SSH sessions open on the device.",
+	}, []string{"type"})
+	// SessionsTotal are the SSH sessions started on the device, by type.
+	SessionsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_agent_sessions_total",
+		Help: "SSH sessions started on the device.",
+	}, []string{"type"})
+	// PtyAllocations are the pseudo-terminals allocated for the sessions.
+	PtyAllocations = factory.NewCounter(prometheus.CounterOpts{
+		Name: "shellhub_agent_pty_allocations_total",
+		Help: "Pseudo-terminals allocated for the sessions.",
+	})
+	// Reconnects are the connections to the server after the first one, when it was lost or could not be established.
+	Reconnects = factory.NewCounter(prometheus.CounterOpts{
+		Name: "shellhub_agent_reconnects_total",
+		Help: "Connections to the server after the first one.",
+	})
+	// Connected is 1 while the agent is connected to the server.
+	Connected = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "shellhub_agent_connected",
+		Help: "Whether the agent is connected to the server.",
+	})
+)
+
+// ListenAndServe serves [Registry] on [Path] at the address.
+func ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})) //nolint:exhaustruct
+
+	return http.ListenAndServe(address, mux) //nolint:gosec
+}
@@ -2,16 +2,28 @@ package server
 
 import (
     gliderssh "github.com/gliderlabs/ssh"
+    "go.opentelemetry.io/otel/attribute"
 )
 
 func (s *Server) passwordHandler(ctx gliderssh.Context, pass string) bool {
-    // Accept any password for testing
-    _ = pass
-    return true
+    _, span := startSpan(ctx, "agent.auth", attribute.String("method", "password"), attribute.String("username", ctx.User()))
+    defer span.End()
+
+    // NOTE: The mode's authenticator counts the refused passwords, locking out the users with too many of them.
+    if s.mode == nil {
+        return true
+    }
+
+    return s.mode.Password(ctx, ctx.User(), pass)
 }
 
 func (s *Server) publicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
-    // Accept any public key for testing
-    _ = key
-    return true
+    _, span := startSpan(ctx, "agent.auth", attribute.String("method", "publickey"), attribute.String("username", ctx.User()))
+    defer span.End()
+
+    if s.mode == nil {
+        return true
+    }
+
+    return s.mode.PublicKey(ctx, ctx.User(), key)
 }
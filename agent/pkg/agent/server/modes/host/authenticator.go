@@ -3,6 +3,8 @@ package host
 import (
 	gliderssh "github.com/gliderlabs/ssh"
 	"github.com/shellhub-io/mini-shellhub/agent/pkg/agent/server/modes"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/shellhub/pkg/hash"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -11,20 +13,25 @@ var _ modes.Authenticator = (*Authenticator)(nil)
 
 // Authenticator implements the Authenticator interface when the server is running in host mode.
 type Authenticator struct {
-	// singleUserPassword is the password of the single user.
+	// singleUserPassword is the hash of the password of the single user, either bcrypt or hex SHA256.
 	// When it is empty, it means that the single user is disabled.
 	singleUserPassword string
 	// deviceName is the device name.
 	//
 	// NOTICE: Uses a pointer for later assignment.
 	deviceName *string
+	// guard locks out the users with too many refused passwords. When nil, the users are never locked out.
+	//
+	// NOTICE: Every connection comes from the server, so only the users are counted, not the addresses.
+	guard *guard.Guard
 }
 
 // NewAuthenticator creates a new instance of Authenticator for the host mode.
-func NewAuthenticator(api interface{}, authData interface{}, singleUserPassword string, deviceName *string) *Authenticator {
+func NewAuthenticator(api interface{}, authData interface{}, singleUserPassword string, deviceName *string, guard *guard.Guard) *Authenticator {
 	return &Authenticator{
 		singleUserPassword: singleUserPassword,
 		deviceName:         deviceName,
+		guard:              guard,
 	}
 }
 
@@ -33,17 +40,29 @@ func (a *Authenticator) Password(ctx gliderssh.Context, _ string, pass string) b
 	log := log.WithFields(log.Fields{
 		"user": ctx.User(),
 	})
+
+	if err := a.guard.Check("", ctx.User()); err != nil {
+		log.WithError(err).Warn("Refused password authentication of a locked out user")
+
+		return false
+	}
 	
 	// For mini-shellhub, accept any password for simplicity
 	ok := true
 	if a.singleUserPassword != "" {
-		ok = (pass == a.singleUserPassword)
+		ok = hash.CompareWith(pass, a.singleUserPassword)
 	}
 
 	if ok {
 		log.Info("Using password authentication")
+
+		a.guard.Succeed("", ctx.User())
 	} else {
 		log.Info("Failed to authenticate using password")
+
+		if locked := a.guard.Fail("", ctx.User()); len(locked) > 0 {
+			log.Warn("User locked out after too many authentication failures")
+		}
 	}
 
 	return ok
@@ -56,6 +75,12 @@ func (a *Authenticator) PublicKey(ctx gliderssh.Context, _ string, key gliderssh
 		return false
 	}
 
+	if err := a.guard.Check("", ctx.User()); err != nil {
+		log.WithError(err).WithField("username", ctx.User()).Warn("refused public key authentication of a locked out user")
+
+		return false
+	}
+
 	log.WithFields(
 		log.Fields{
 			"username": ctx.User(),
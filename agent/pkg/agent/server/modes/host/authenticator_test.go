@@ -0,0 +1,96 @@
+package host
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"sync"
+	"testing"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// secret is the hex SHA256 of "secret".
+const secret = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+
+func TestAuthenticatorPassword(t *testing.T) {
+	cases := []struct {
+		description string
+		single      string
+		passwords   []string
+		expected    []bool
+	}{
+		{
+			description: "succeeds with any password when single-user mode is disabled",
+			single:      "",
+			passwords:   []string{"any", "other"},
+			expected:    []bool{true, true},
+		},
+		{
+			description: "succeeds when the password matches the hash",
+			single:      secret,
+			passwords:   []string{"secret"},
+			expected:    []bool{true},
+		},
+		{
+			description: "fails when the password is the hash itself",
+			single:      secret,
+			passwords:   []string{secret},
+			expected:    []bool{false},
+		},
+		{
+			description: "fails after the user is locked out, even with the right password",
+			single:      secret,
+			passwords:   []string{"wrong", "wrong", "secret"},
+			expected:    []bool{false, false, false},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			config := guard.Default()
+			config.MaxFailures = 2
+
+			lockouts, err := guard.New(config)
+			require.NoError(t, err)
+
+			authenticator := NewAuthenticator(nil, nil, tc.single, nil, lockouts)
+			ctx := &testSSHContext{Context: context.Background(), Mutex: &sync.Mutex{}, user: "root"}
+
+			results := make([]bool, 0, len(tc.passwords))
+			for _, password := range tc.passwords {
+				results = append(results, authenticator.Password(ctx, "root", password))
+			}
+
+			assert.Equal(t, tc.expected, results)
+		})
+	}
+}
+
+func TestAuthenticatorPublicKey(t *testing.T) {
+	config := guard.Default()
+	config.MaxFailures = 2
+
+	lockouts, err := guard.New(config)
+	require.NoError(t, err)
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+
+	authenticator := NewAuthenticator(nil, nil, secret, nil, lockouts)
+	ctx := &testSSHContext{Context: context.Background(), Mutex: &sync.Mutex{}, user: "root"}
+
+	// NOTE: Public keys are accepted in single-user mode, and never counted as failures.
+	for range 3 {
+		assert.True(t, authenticator.PublicKey(ctx, "root", gliderssh.PublicKey(key)))
+	}
+
+	assert.True(t, authenticator.Password(ctx, "root", "secret"))
+}
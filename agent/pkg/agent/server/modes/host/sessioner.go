@@ -184,7 +184,7 @@ func (s *Sessioner) Exec(session gliderssh.Session) error {
 	}
 
 	cmd := exec.Command(shell, "-c", session.RawCommand())
-	cmd.Env = session.Environ()
+	cmd.Env = append(session.Environ(), forwardedEnvs(session)...)
 
 	wg := &sync.WaitGroup{}
 	if sIsPty {
@@ -20,13 +20,23 @@ func generateShellCmd(deviceName string, session gliderssh.Session, term string)
 		term = "xterm"
 	}
 
-	authSock := session.Context().Value("SSH_AUTH_SOCK")
-	if authSock != nil {
-		envs = append(envs, fmt.Sprintf("%s=%s", "SSH_AUTH_SOCK", authSock.(string)))
-	}
+	envs = append(envs, forwardedEnvs(session)...)
 
 	cmd := exec.Command(shell, "--login")
 	cmd.Env = envs
 	
 	return cmd
 }
+
+// forwardedEnvs gets the environment variables set on the session's context by the agent and X11 forwarding, pointing
+// the commands to the forwarded agent socket and display.
+func forwardedEnvs(session gliderssh.Session) []string {
+	envs := []string{}
+	for _, name := range []string{"SSH_AUTH_SOCK", "DISPLAY", "XAUTHORITY"} {
+		if value, ok := session.Context().Value(name).(string); ok {
+			envs = append(envs, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+
+	return envs
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProxyPath is the path of the HTTP CONNECT request sent by the server, through a stream of the reverse tunnel, to
+// connect to an address reachable from the device, as "CONNECT /http/proxy/10.0.0.5:22 HTTP/1.1".
+const ProxyPath = "/http/proxy/"
+
+// ProxyDialTimeout is the time the device waits for the connection to the proxied address.
+const ProxyDialTimeout = 10 * time.Second
+
+// ProxyPolicyAny matches every host or every port of a [ProxyPolicy] rule.
+const ProxyPolicyAny = "*"
+
+var ErrInvalidProxyPolicy = errors.New("invalid proxy policy; use \"host:ports,host:ports\", like \"10.0.0.0/24:22,switch01:22,127.0.0.1:8000-8999\"")
+
+// proxyRule is a rule of [ProxyPolicy]. The host is any host, when any is set, an IP prefix, when prefix is valid, or a
+// pattern matched by [path.Match] against the host name.
+type proxyRule struct {
+	any     bool
+	prefix  netip.Prefix
+	pattern string
+	low     uint16
+	high    uint16
+}
+
+// ProxyPolicy is the list of the hosts and ports the server can reach through the device, working as a gateway to the
+// hosts of its network. A nil policy denies every address.
+type ProxyPolicy []proxyRule
+
+// ParseProxyPolicy parses a policy in the format "host:ports,host:ports", where the host is an IP address, an IP prefix
+// in CIDR notation, a host name pattern or "*", and the ports are a port, a range of ports or "*", like
+// "10.0.0.0/24:22,switch01:22,*.lan:80,127.0.0.1:8000-8999". IPv6 hosts are enclosed in brackets, like "[fd00::/8]:22".
+func ParseProxyPolicy(value string) (ProxyPolicy, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var policy ProxyPolicy
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		index := strings.LastIndex(entry, ":")
+		if index <= 0 {
+			return nil, ErrInvalidProxyPolicy
+		}
+
+		host := strings.TrimSuffix(strings.TrimPrefix(entry[:index], "["), "]")
+
+		var rule proxyRule
+		if host == ProxyPolicyAny {
+			rule.any = true
+		} else if prefix, err := netip.ParsePrefix(host); err == nil {
+			rule.prefix = prefix.Masked()
+		} else if addr, err := netip.ParseAddr(host); err == nil {
+			rule.prefix = netip.PrefixFrom(addr, addr.BitLen())
+		} else if _, err := path.Match(host, ""); err == nil {
+			rule.pattern = strings.ToLower(host)
+		} else {
+			return nil, ErrInvalidProxyPolicy
+		}
+
+		low, high, err := parsePorts(entry[index+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		rule.low, rule.high = low, high
+
+		policy = append(policy, rule)
+	}
+
+	return policy, nil
+}
+
+func parsePorts(value string) (uint16, uint16, error) {
+	if value == ProxyPolicyAny {
+		return 1, 65535, nil
+	}
+
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		last = first
+	}
+
+	low, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || low == 0 {
+		return 0, 0, ErrInvalidProxyPolicy
+	}
+
+	high, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || high < low {
+		return 0, 0, ErrInvalidProxyPolicy
+	}
+
+	return uint16(low), uint16(high), nil
+}
+
+// Allow checks if the server can reach the port of host through the device. Besides the rules of any host, an IP
+// address is only matched by the IP rules, and a host name only by the host name patterns, as it is resolved by the
+// device after the check.
+func (p ProxyPolicy) Allow(host string, port uint16) bool {
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	ip := err == nil
+
+	for _, rule := range p {
+		if port < rule.low || port > rule.high {
+			continue
+		}
+
+		if rule.any {
+			return true
+		}
+
+		if ip && rule.prefix.IsValid() && rule.prefix.Contains(addr.Unmap()) {
+			return true
+		}
+
+		if !ip && rule.pattern != "" {
+			if ok, _ := path.Match(rule.pattern, strings.ToLower(host)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// bufferedConn is a connection whose first bytes were already read into reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// HandleStream handles a stream of the reverse tunnel. A stream is either an SSH connection or, when it starts with an
+// HTTP CONNECT request to [ProxyPath], a connection to an address reachable from the device, allowed by the policy.
+func (s *Server) HandleStream(stream net.Conn) {
+	reader := bufio.NewReader(stream)
+
+	// NOTE: The SSH client sends its version first, so both kinds of streams start with data from the server.
+	if method, err := reader.Peek(len(http.MethodConnect) + 1); err == nil && string(method) == http.MethodConnect+" " {
+		s.handleProxy(stream, reader)
+
+		return
+	}
+
+	s.HandleConn(&bufferedConn{Conn: stream, reader: reader})
+}
+
+// handleProxy connects the stream to the address of the CONNECT request, replying with the status of the connection.
+func (s *Server) handleProxy(stream net.Conn, reader *bufio.Reader) {
+	defer stream.Close()
+
+	reply := func(code int) {
+		(&http.Response{StatusCode: code, ProtoMajor: 1, ProtoMinor: 1}).Write(stream) //nolint:errcheck
+	}
+
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		log.WithError(err).Debug("failed to read the proxy request")
+
+		return
+	}
+
+	addr, ok := strings.CutPrefix(req.URL.Path, ProxyPath)
+	if req.Method != http.MethodConnect || !ok {
+		reply(http.StatusNotFound)
+
+		return
+	}
+
+	logger := log.WithField("addr", addr)
+
+	host, value, err := net.SplitHostPort(addr)
+	if err != nil {
+		reply(http.StatusBadRequest)
+
+		return
+	}
+
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		reply(http.StatusBadRequest)
+
+		return
+	}
+
+	if !s.proxy.Allow(host, uint16(port)) {
+		reply(http.StatusForbidden)
+		logger.Info("proxy connection denied by policy")
+
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, ProxyDialTimeout)
+	if err != nil {
+		reply(http.StatusBadGateway)
+		logger.WithError(err).Warn("failed to connect to the proxied address")
+
+		return
+	}
+
+	defer conn.Close()
+
+	reply(http.StatusOK)
+
+	logger.Info("proxy connection started")
+
+	// NOTE: Closing a stream of the reverse tunnel only closes its writing side, so the connection is half closed both
+	// ways.
+	relay.Pipe(relay.WithReader(relay.HalfCloser(stream), reader), conn)
+
+	logger.Info("proxy connection done")
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyPolicy(t *testing.T) {
+	cases := []struct {
+		description string
+		value       string
+		expected    error
+	}{
+		{
+			description: "succeeds when value is empty",
+			value:       "",
+			expected:    nil,
+		},
+		{
+			description: "succeeds when hosts have ports",
+			value:       "10.0.0.0/24:22, switch01:22,*.lan:*,127.0.0.1:8000-8999,[fd00::/8]:22",
+			expected:    nil,
+		},
+		{
+			description: "fails when the port is missing",
+			value:       "10.0.0.5",
+			expected:    ErrInvalidProxyPolicy,
+		},
+		{
+			description: "fails when the port is zero",
+			value:       "10.0.0.5:0",
+			expected:    ErrInvalidProxyPolicy,
+		},
+		{
+			description: "fails when the port range is reversed",
+			value:       "10.0.0.5:90-80",
+			expected:    ErrInvalidProxyPolicy,
+		},
+		{
+			description: "fails when the host pattern is malformed",
+			value:       "switch[:22",
+			expected:    ErrInvalidProxyPolicy,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := ParseProxyPolicy(tc.value)
+			assert.Equal(t, tc.expected, err)
+		})
+	}
+}
+
+func TestProxyPolicyAllow(t *testing.T) {
+	policy, err := ParseProxyPolicy("10.0.0.0/24:22,switch01:22,*.lan:*,127.0.0.1:8000-8999,[fd00::/8]:22")
+	assert.NoError(t, err)
+
+	anyHost, err := ParseProxyPolicy("*:22")
+	assert.NoError(t, err)
+
+	cases := []struct {
+		description string
+		policy      ProxyPolicy
+		host        string
+		port        uint16
+		expected    bool
+	}{
+		{
+			description: "fails when the policy is nil",
+			policy:      nil,
+			host:        "10.0.0.5",
+			port:        22,
+			expected:    false,
+		},
+		{
+			description: "succeeds when the address is in the prefix",
+			policy:      policy,
+			host:        "10.0.0.5",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "fails when the address is out of the prefix",
+			policy:      policy,
+			host:        "10.0.1.5",
+			port:        22,
+			expected:    false,
+		},
+		{
+			description: "fails when the port is not allowed",
+			policy:      policy,
+			host:        "10.0.0.5",
+			port:        23,
+			expected:    false,
+		},
+		{
+			description: "succeeds when the host name matches",
+			policy:      policy,
+			host:        "Switch01",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "succeeds when the host name matches a pattern on any port",
+			policy:      policy,
+			host:        "camera.lan",
+			port:        554,
+			expected:    true,
+		},
+		{
+			description: "succeeds when the port is in the range",
+			policy:      policy,
+			host:        "127.0.0.1",
+			port:        8080,
+			expected:    true,
+		},
+		{
+			description: "succeeds when the IPv6 address is in the prefix",
+			policy:      policy,
+			host:        "fd00::5",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "fails when a host name would match an IP rule",
+			policy:      policy,
+			host:        "localhost",
+			port:        8080,
+			expected:    false,
+		},
+		{
+			description: "succeeds when any host matches an IP address",
+			policy:      anyHost,
+			host:        "10.0.1.5",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "succeeds when any host matches an IPv6 address",
+			policy:      anyHost,
+			host:        "[fd00::5]",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "succeeds when any host matches a host name",
+			policy:      anyHost,
+			host:        "switch01",
+			port:        22,
+			expected:    true,
+		},
+		{
+			description: "fails when any host matches but the port is not allowed",
+			policy:      anyHost,
+			host:        "10.0.1.5",
+			port:        23,
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.Allow(tc.host, tc.port))
+		})
+	}
+}
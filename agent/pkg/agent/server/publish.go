@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PublishedHeader is the header of the reverse tunnel's connection that lists the services published by the device,
+// as "name=host:port,name=host:port".
+const PublishedHeader = "X-Published-Services"
+
+var ErrInvalidPublished = errors.New("invalid published services; use \"name=host:port,name=host:port\", like \"web=127.0.0.1:8080\"")
+
+var publishedNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// PublishedService is a service on the device's network, published to be reachable through the server without an SSH
+// session.
+type PublishedService struct {
+	Name    string
+	Address string
+}
+
+// Published is the list of services published by the device.
+type Published []PublishedService
+
+// ParsePublished parses the services in the format "name=host:port,name=host:port", like
+// "web=127.0.0.1:8080,db=127.0.0.1:5432".
+func ParsePublished(value string) (Published, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var published Published
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, address, ok := strings.Cut(entry, "=")
+		if !ok || !publishedNameRegexp.MatchString(name) || seen[name] {
+			return nil, ErrInvalidPublished
+		}
+
+		// NOTE: The host becomes a rule of the proxy policy, so it cannot have the pattern's special characters.
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || host == "" || strings.ContainsAny(host, `*?[]\`) {
+			return nil, ErrInvalidPublished
+		}
+
+		if number, err := strconv.ParseUint(port, 10, 16); err != nil || number == 0 {
+			return nil, ErrInvalidPublished
+		}
+
+		seen[name] = true
+		published = append(published, PublishedService{Name: name, Address: address})
+	}
+
+	return published, nil
+}
+
+// String formats the services as the value of [PublishedHeader].
+func (p Published) String() string {
+	entries := make([]string, 0, len(p))
+	for _, service := range p {
+		entries = append(entries, service.Name+"="+service.Address)
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// Policy is the proxy policy that allows the server to reach the published services.
+func (p Published) Policy() ProxyPolicy {
+	var policy ProxyPolicy
+	for _, service := range p {
+		host, port, _ := net.SplitHostPort(service.Address)
+
+		rules, err := ParseProxyPolicy("[" + host + "]:" + port)
+		if err != nil {
+			continue
+		}
+
+		policy = append(policy, rules...)
+	}
+
+	return policy
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePublished(t *testing.T) {
+	type Expected struct {
+		published Published
+		err       error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with nil services when value is empty",
+			value:       "",
+			expected:    Expected{published: nil, err: nil},
+		},
+		{
+			description: "succeeds when services have addresses",
+			value:       "web=127.0.0.1:8080, db=localhost:5432,cam=[fd00::5]:554",
+			expected: Expected{
+				published: Published{
+					{Name: "web", Address: "127.0.0.1:8080"},
+					{Name: "db", Address: "localhost:5432"},
+					{Name: "cam", Address: "[fd00::5]:554"},
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "fails when the name is repeated",
+			value:       "web=127.0.0.1:8080,web=127.0.0.1:8081",
+			expected:    Expected{published: nil, err: ErrInvalidPublished},
+		},
+		{
+			description: "fails when the address has no port",
+			value:       "web=127.0.0.1",
+			expected:    Expected{published: nil, err: ErrInvalidPublished},
+		},
+		{
+			description: "fails when the host is a pattern",
+			value:       "web=*:8080",
+			expected:    Expected{published: nil, err: ErrInvalidPublished},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			published, err := ParsePublished(tc.value)
+			assert.Equal(t, tc.expected, Expected{published, err})
+		})
+	}
+}
+
+func TestPublishedPolicy(t *testing.T) {
+	published, err := ParsePublished("web=127.0.0.1:8080,db=localhost:5432")
+	assert.NoError(t, err)
+	assert.Equal(t, "web=127.0.0.1:8080,db=localhost:5432", published.String())
+
+	policy := published.Policy()
+	assert.True(t, policy.Allow("127.0.0.1", 8080))
+	assert.True(t, policy.Allow("localhost", 5432))
+	assert.False(t, policy.Allow("127.0.0.1", 5432))
+	assert.False(t, policy.Allow("10.0.0.5", 8080))
+}
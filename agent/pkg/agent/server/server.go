@@ -53,6 +53,9 @@ type Server struct {
 	// Check the [modes] package for more information.
 	mode     modes.Mode
 	Sessions sync.Map
+
+	// proxy is the policy of the addresses the server can reach through the device. Check [Server.HandleStream].
+	proxy ProxyPolicy
 }
 
 // SSH channels supported by the SSH server.
@@ -78,6 +81,15 @@ const (
 	ChannelDirectTcpip string = "direct-tcpip"
 )
 
+// SSH global requests used by the reverse port forwarding, where the client asks the server to listen on a port and
+// forward the connections to it.
+//
+// Check www.ietf.org/rfc/rfc4254.txt at section 7.1 for more information.
+const (
+	RequestTcpipForward       string = "tcpip-forward"
+	RequestCancelTcpipForward string = "cancel-tcpip-forward"
+)
+
 type Feature uint
 
 const (
@@ -87,6 +99,8 @@ const (
 	LocalPortForwardFeature Feature = iota << 1
 	// ReversePortForwardFeature enable reverse port forward feature.
 	ReversePortForwardFeature
+	// X11ForwardFeature enable X11 forward feature.
+	X11ForwardFeature
 )
 
 // Config stores configuration needs for the SSH server.
@@ -97,6 +111,12 @@ type Config struct {
 	KeepAliveInterval uint32
 	// Features list of featues on SSH server.
 	Features Feature
+	// StreamLocal is the policy of the Unix socket paths each user can reach through Unix socket forwarding. When nil,
+	// Unix socket forwarding is disabled.
+	StreamLocal StreamLocalPolicy
+	// Proxy is the policy of the hosts and ports the server can reach through the device, like the hosts on its
+	// network without the agent. When nil, the device does not proxy connections.
+	Proxy ProxyPolicy
 }
 
 // NewServer creates a new server SSH agent server.
@@ -107,12 +127,28 @@ func NewServer(api client.Client, mode modes.Mode, cfg *Config) *Server {
 		cmds:              make(map[string]*exec.Cmd),
 		keepAliveInterval: cfg.KeepAliveInterval,
 		Sessions:          sync.Map{},
+		proxy:             cfg.Proxy,
 	}
 
 	if m, ok := mode.(*host.Mode); ok {
 		m.Sessioner.SetCmds(server.cmds)
 	}
 
+	forwardHandler := &gliderssh.ForwardedTCPHandler{}
+
+	// NOTE: Without the X11 forward feature, gliderssh refuses the "x11-req" requests.
+	sessionChannelHandler := gliderssh.ChannelHandler(gliderssh.DefaultSessionHandler)
+	if cfg.Features&X11ForwardFeature > 0 {
+		sessionChannelHandler = x11SessionHandler(sessionChannelHandler)
+	}
+
+	sessionChannelHandler = channelSessionHandler(sessionChannelHandler)
+
+	streamLocalHandler := &streamLocalForwardHandler{
+		policy:    cfg.StreamLocal,
+		listeners: make(map[streamLocalForward]net.Listener),
+	}
+
 	server.sshd = &gliderssh.Server{
 		PasswordHandler:        server.passwordHandler,
 		PublicKeyHandler:       server.publicKeyHandler,
@@ -141,8 +177,17 @@ func NewServer(api client.Client, mode modes.Mode, cfg *Config) *Server {
 			return cfg.Features&ReversePortForwardFeature > 0
 		},
 		ChannelHandlers: map[string]gliderssh.ChannelHandler{
-			ChannelSession:     gliderssh.DefaultSessionHandler,
-			ChannelDirectTcpip: gliderssh.DirectTCPIPHandler,
+			ChannelSession:           sessionChannelHandler,
+			ChannelDirectTcpip:       gliderssh.DirectTCPIPHandler,
+			ChannelDirectStreamlocal: directStreamLocalHandler(cfg.StreamLocal),
+		},
+		// NOTE: The forwarded connections are sent back to the server through "forwarded-tcpip" channels, gated by
+		// the ReversePortForwardingCallback.
+		RequestHandlers: map[string]gliderssh.RequestHandler{
+			RequestTcpipForward:             forwardHandler.HandleSSHRequest,
+			RequestCancelTcpipForward:       forwardHandler.HandleSSHRequest,
+			RequestStreamlocalForward:       streamLocalHandler.HandleSSHRequest,
+			RequestCancelStreamlocalForward: streamLocalHandler.HandleSSHRequest,
 		},
 	}
 
@@ -6,9 +6,13 @@ import (
 	"os/user"
 	"path"
 	"strconv"
+	"sync"
 
 	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/agent/pkg/agent/pkg/metrics"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // Type is the type of SSH session.
@@ -29,6 +33,46 @@ const (
 	SessionTypeUnknown Type = "unknown"
 )
 
+// channelContext is the context of a session's channel. gliderssh gives the connection's context to every session, so
+// the values set by a session, like its request type, X11 display or forwarded agent, are kept on the channel's context
+// instead, apart from the other sessions of the connection. The connection's values, like the user, are read through.
+type channelContext struct {
+	gliderssh.Context
+
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+func newChannelContext(ctx gliderssh.Context) *channelContext {
+	return &channelContext{Context: ctx, values: make(map[interface{}]interface{})}
+}
+
+func (c *channelContext) SetValue(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] = value
+}
+
+func (c *channelContext) Value(key interface{}) interface{} {
+	c.mu.Lock()
+	value, ok := c.values[key]
+	c.mu.Unlock()
+
+	if ok {
+		return value
+	}
+
+	return c.Context.Value(key)
+}
+
+// channelSessionHandler runs the session's channel handler with a context of the channel, see [channelContext].
+func channelSessionHandler(next gliderssh.ChannelHandler) gliderssh.ChannelHandler {
+	return func(srv *gliderssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		next(srv, conn, newChan, newChannelContext(ctx))
+	}
+}
+
 // GetSessionType returns the session's type based on the SSH client session.
 func GetSessionType(session gliderssh.Session) (Type, error) {
 	_, _, isPty := session.Pty()
@@ -107,6 +151,19 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 		go gliderssh.ForwardAgentConnections(l, session)
 	}
 
+	// NOTE: A failure to allocate the X11 display does not end the session, which runs without X11 forwarding.
+	if req, ok := x11Requested(session); ok {
+		if owner, err := user.Lookup(session.User()); err != nil {
+			log.WithError(err).Error("failed to get the user for x11 forwarding")
+		} else if display, err := listenX11(session, req, owner); err != nil {
+			log.WithError(err).Error("failed to allocate the x11 display")
+		} else {
+			defer display.Close()
+
+			display.SetEnv(session, req.ScreenNumber)
+		}
+	}
+
 	sessionType, err := GetSessionType(session)
 	if err != nil {
 		log.Error(err)
@@ -116,6 +173,17 @@ func (s *Server) sessionHandler(session gliderssh.Session) {
 
 	log.WithField("type", sessionType).Info("Request type got")
 
+	_, span := startSpan(session.Context(), "agent.session", attribute.String("type", string(sessionType)))
+	defer span.End()
+
+	if _, _, isPty := session.Pty(); isPty {
+		metrics.PtyAllocations.Inc()
+	}
+
+	metrics.Sessions.WithLabelValues(string(sessionType)).Inc()
+	metrics.SessionsTotal.WithLabelValues(string(sessionType)).Inc()
+	defer metrics.Sessions.WithLabelValues(string(sessionType)).Dec()
+
 	switch sessionType {
 	case SessionTypeShell:
 		s.mode.Shell(session) //nolint:errcheck
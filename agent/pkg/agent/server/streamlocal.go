@@ -0,0 +1,480 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// OpenSSH extensions to forward Unix domain sockets, like `ssh -L 2375:/var/run/docker.sock` and
+// `ssh -R /tmp/app.sock:localhost:3000`.
+//
+// Check https://github.com/openssh/openssh-portable/blob/master/PROTOCOL at section 2.4 for more information.
+const (
+	ChannelDirectStreamlocal        string = "direct-streamlocal@openssh.com"
+	ChannelForwardedStreamlocal     string = "forwarded-streamlocal@openssh.com"
+	RequestStreamlocalForward       string = "streamlocal-forward@openssh.com"
+	RequestCancelStreamlocalForward string = "cancel-streamlocal-forward@openssh.com"
+)
+
+// StreamLocalPolicyAnyUser is the user of [StreamLocalPolicy] whose patterns apply to every user.
+const StreamLocalPolicyAnyUser = "*"
+
+// StreamLocalBindMask is the mask of the permissions of the Unix sockets the agent listens on for the users, like the
+// default StreamLocalBindMask of OpenSSH, so only their users can connect to them.
+const StreamLocalBindMask os.FileMode = 0o177
+
+var (
+	ErrInvalidStreamLocalPolicy = errors.New("invalid stream local policy; use \"user=pattern,pattern;user=pattern\"")
+	ErrStreamLocalDenied        = errors.New("unix socket forwarding to this path is not allowed")
+	ErrStreamLocalPermission    = errors.New("unix socket forwarding to this path is not permitted to the user")
+)
+
+// StreamLocalPolicy maps the users to the patterns of the Unix socket paths they can reach, matched by [path.Match].
+// The patterns of the user [StreamLocalPolicyAnyUser] apply to every user. A nil policy denies every path.
+type StreamLocalPolicy map[string][]string
+
+// ParseStreamLocalPolicy parses a policy in the format "user=pattern,pattern;user=pattern", like
+// "root=/var/run/docker.sock,/run/postgresql/*;*=/tmp/app.sock".
+func ParseStreamLocalPolicy(value string) (StreamLocalPolicy, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	policy := make(StreamLocalPolicy)
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		user, patterns, ok := strings.Cut(entry, "=")
+		if user = strings.TrimSpace(user); !ok || user == "" {
+			return nil, ErrInvalidStreamLocalPolicy
+		}
+
+		for _, pattern := range strings.Split(patterns, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+
+			if _, err := path.Match(pattern, ""); err != nil || !path.IsAbs(pattern) {
+				return nil, ErrInvalidStreamLocalPolicy
+			}
+
+			policy[user] = append(policy[user], pattern)
+		}
+	}
+
+	return policy, nil
+}
+
+// Allow checks if the user can reach the Unix socket at socket. The symbolic links of the patterns' directories are
+// resolved, so they match the resolved paths.
+func (p StreamLocalPolicy) Allow(user, socket string) bool {
+	socket = path.Clean(socket)
+	if !path.IsAbs(socket) {
+		return false
+	}
+
+	for _, patterns := range [][]string{p[user], p[StreamLocalPolicyAnyUser]} {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(resolvePattern(pattern), socket); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolvePattern resolves the symbolic links of the directories of the pattern before its first wildcard, like
+// "/var/run/docker.sock" to "/run/docker.sock" when "/var/run" links to "/run".
+func resolvePattern(pattern string) string {
+	dir, rest := path.Dir(pattern), path.Base(pattern)
+	for strings.ContainsAny(dir, `*?[\`) {
+		dir, rest = path.Dir(dir), path.Join(path.Base(dir), rest)
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return pattern
+	}
+
+	return path.Join(resolved, rest)
+}
+
+// resolveStreamLocal resolves the symbolic links of the Unix socket path asked by the user, or of its directory when
+// the agent listens on it, and checks the resolved path against the policy and the permissions of the user on the
+// device, so neither a link nor a ".." takes the user out of the allowed paths or to a socket the user cannot reach.
+func resolveStreamLocal(policy StreamLocalPolicy, owner *user.User, socket string, listen bool) (string, error) {
+	socket = path.Clean(socket)
+	if !path.IsAbs(socket) {
+		return "", ErrStreamLocalDenied
+	}
+
+	// NOTE: The socket the agent listens on does not exist yet, and a link in its place would fail the listen.
+	var resolved string
+	if listen {
+		dir, err := filepath.EvalSymlinks(path.Dir(socket))
+		if err != nil {
+			return "", err
+		}
+
+		resolved = path.Join(dir, path.Base(socket))
+	} else {
+		var err error
+		if resolved, err = filepath.EvalSymlinks(socket); err != nil {
+			return "", err
+		}
+	}
+
+	if !policy.Allow(owner.Username, resolved) {
+		return "", ErrStreamLocalDenied
+	}
+
+	// NOTE: Connecting to a socket needs its write permission and listening on one the write and search permissions of
+	// its directory, as on the user's own processes.
+	file, mode := resolved, os.FileMode(0o2)
+	if listen {
+		file, mode = path.Dir(resolved), 0o3
+	}
+
+	if !reachable(owner, file, mode) {
+		return "", ErrStreamLocalPermission
+	}
+
+	return resolved, nil
+}
+
+// reachable checks if the user has the permissions of mode, like 0o2 for write, on the file, and the search permission
+// on each directory of its path.
+func reachable(owner *user.User, file string, mode os.FileMode) bool {
+	uid, err := strconv.ParseUint(owner.Uid, 10, 32)
+	if err != nil {
+		return false
+	}
+
+	if uid == 0 {
+		return true
+	}
+
+	groups := map[string]bool{owner.Gid: true}
+	if ids, err := owner.GroupIds(); err == nil {
+		for _, id := range ids {
+			groups[id] = true
+		}
+	}
+
+	permits := func(name string, mode os.FileMode) bool {
+		info, err := os.Stat(name)
+		if err != nil {
+			return false
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+
+		perm := info.Mode().Perm()
+		switch {
+		case uint64(stat.Uid) == uid:
+			perm >>= 6
+		case groups[strconv.FormatUint(uint64(stat.Gid), 10)]:
+			perm >>= 3
+		}
+
+		return perm&mode == mode
+	}
+
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		if !permits(dir, 0o1) {
+			return false
+		}
+
+		if dir == "/" {
+			return permits(file, mode)
+		}
+	}
+}
+
+// streamLocalUser looks up the user of the connection on the device.
+func streamLocalUser(ctx gliderssh.Context) (*user.User, error) {
+	return user.Lookup(ctx.User())
+}
+
+// rejectStreamLocal rejects the channel with the reason of the error.
+func rejectStreamLocal(newChan gossh.NewChannel, err error) {
+	reason := gossh.ConnectionFailed
+	if errors.Is(err, ErrStreamLocalDenied) || errors.Is(err, ErrStreamLocalPermission) {
+		reason = gossh.Prohibited
+	}
+
+	newChan.Reject(reason, err.Error()) //nolint:errcheck
+}
+
+type streamLocalChannelOpenDirectMsg struct {
+	SocketPath string
+	Reserved0  string
+	Reserved1  uint32
+}
+
+type streamLocalForwardMsg struct {
+	SocketPath string
+}
+
+type forwardedStreamLocalPayload struct {
+	SocketPath string
+	Reserved   string
+}
+
+// pipe copies the data between the channel and the local connection until both directions are done, closing the
+// write side of each one when the other reaches EOF.
+func pipe(channel gossh.Channel, conn net.Conn) {
+	wg := new(sync.WaitGroup)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer channel.CloseWrite() //nolint:errcheck
+
+		io.Copy(channel, conn) //nolint:errcheck
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		io.Copy(conn, channel) //nolint:errcheck
+
+		if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite() //nolint:errcheck
+		} else {
+			conn.Close()
+		}
+	}()
+
+	wg.Wait()
+}
+
+// directStreamLocalHandler handles the "direct-streamlocal@openssh.com" channels, connecting the client to a Unix
+// socket on the device allowed by the policy.
+func directStreamLocalHandler(policy StreamLocalPolicy) gliderssh.ChannelHandler {
+	return func(_ *gliderssh.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		var msg streamLocalChannelOpenDirectMsg
+		if err := gossh.Unmarshal(newChan.ExtraData(), &msg); err != nil {
+			newChan.Reject(gossh.ConnectionFailed, "failed to parse stream local data: "+err.Error()) //nolint:errcheck
+
+			return
+		}
+
+		logger := log.WithFields(log.Fields{"user": ctx.User(), "socket": msg.SocketPath})
+
+		owner, err := streamLocalUser(ctx)
+		if err != nil {
+			newChan.Reject(gossh.ConnectionFailed, "failed to get the user") //nolint:errcheck
+			logger.WithError(err).Error("failed to get the user for unix socket forwarding")
+
+			return
+		}
+
+		socket, err := resolveStreamLocal(policy, owner, msg.SocketPath, false)
+		if err != nil {
+			rejectStreamLocal(newChan, err)
+			logger.WithError(err).Info("unix socket forwarding denied")
+
+			return
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			newChan.Reject(gossh.ConnectionFailed, err.Error()) //nolint:errcheck
+			logger.WithError(err).Warn("failed to dial the unix socket")
+
+			return
+		}
+
+		defer conn.Close()
+
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			logger.WithError(err).Error("failed to accept the channel")
+
+			return
+		}
+
+		defer channel.Close()
+
+		go gossh.DiscardRequests(requests)
+
+		logger.Info("unix socket forwarding started")
+
+		pipe(channel, conn)
+	}
+}
+
+// streamLocalForwardHandler handles the "streamlocal-forward@openssh.com" requests, listening on a Unix socket on the
+// device allowed by the policy, and sending its connections back to the client.
+type streamLocalForwardHandler struct {
+	policy    StreamLocalPolicy
+	mu        sync.Mutex
+	listeners map[streamLocalForward]net.Listener
+}
+
+// streamLocalForward is a Unix socket forwarded by a connection. Each connection only cancels its own forwardings.
+type streamLocalForward struct {
+	conn   *gossh.ServerConn
+	socket string
+}
+
+func (h *streamLocalForwardHandler) HandleSSHRequest(ctx gliderssh.Context, _ *gliderssh.Server, req *gossh.Request) (bool, []byte) {
+	var msg streamLocalForwardMsg
+	if err := gossh.Unmarshal(req.Payload, &msg); err != nil {
+		return false, nil
+	}
+
+	conn, ok := ctx.Value(gliderssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return false, nil
+	}
+
+	forward := streamLocalForward{conn: conn, socket: path.Clean(msg.SocketPath)}
+	logger := log.WithFields(log.Fields{"user": ctx.User(), "socket": msg.SocketPath})
+
+	switch req.Type {
+	case RequestStreamlocalForward:
+		owner, err := streamLocalUser(ctx)
+		if err != nil {
+			logger.WithError(err).Error("failed to get the user for unix socket forwarding")
+
+			return false, nil
+		}
+
+		socket, err := resolveStreamLocal(h.policy, owner, msg.SocketPath, true)
+		if err != nil {
+			logger.WithError(err).Info("unix socket forwarding denied")
+
+			return false, nil
+		}
+
+		listener, err := listenStreamLocal(socket, owner)
+		if err != nil {
+			logger.WithError(err).Warn("failed to listen on the unix socket")
+
+			return false, nil
+		}
+
+		h.mu.Lock()
+		h.listeners[forward] = listener
+		h.mu.Unlock()
+
+		go func() {
+			<-ctx.Done()
+
+			h.close(forward, listener)
+		}()
+
+		go func() {
+			defer h.close(forward, listener)
+
+			for {
+				forwarded, err := listener.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer forwarded.Close()
+
+					payload := gossh.Marshal(&forwardedStreamLocalPayload{SocketPath: msg.SocketPath})
+					channel, requests, err := conn.OpenChannel(ChannelForwardedStreamlocal, payload)
+					if err != nil {
+						logger.WithError(err).Warn("failed to open the forwarded unix socket channel")
+
+						return
+					}
+
+					defer channel.Close()
+
+					go gossh.DiscardRequests(requests)
+
+					pipe(channel, forwarded)
+				}()
+			}
+		}()
+
+		logger.Info("unix socket reverse forwarding started")
+
+		return true, nil
+	case RequestCancelStreamlocalForward:
+		h.mu.Lock()
+		listener, ok := h.listeners[forward]
+		h.mu.Unlock()
+
+		return ok && h.close(forward, listener), nil
+	default:
+		return false, nil
+	}
+}
+
+// listenStreamLocal listens on the Unix socket for the user, who owns it with the permissions left by
+// [StreamLocalBindMask], as when listened on by the user's own processes.
+func listenStreamLocal(socket string, owner *user.User) (net.Listener, error) {
+	uid, err := strconv.Atoi(owner.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := strconv.Atoi(owner.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: The socket is created with the permissions left by the agent's umask, so they are set after the listen.
+	if err := os.Chmod(socket, 0o666&^StreamLocalBindMask); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	if err := os.Chown(socket, uid, gid); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// close closes the listener of the forwarding, unless it was already replaced, like by the same socket forwarded again
+// after a cancel.
+func (h *streamLocalForwardHandler) close(forward streamLocalForward, listener net.Listener) bool {
+	h.mu.Lock()
+	current, ok := h.listeners[forward]
+	if ok = ok && current == listener; ok {
+		delete(h.listeners, forward)
+	}
+	h.mu.Unlock()
+
+	if ok {
+		listener.Close() //nolint:errcheck
+	}
+
+	return ok
+}
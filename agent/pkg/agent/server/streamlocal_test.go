@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestParseStreamLocalPolicy(t *testing.T) {
+	type Expected struct {
+		policy StreamLocalPolicy
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with a nil policy when value is empty",
+			value:       "",
+			expected:    Expected{policy: nil, err: nil},
+		},
+		{
+			description: "succeeds when users have patterns",
+			value:       "root=/var/run/docker.sock, /run/postgresql/*;*=/tmp/app.sock",
+			expected: Expected{
+				policy: StreamLocalPolicy{
+					"root": {"/var/run/docker.sock", "/run/postgresql/*"},
+					"*":    {"/tmp/app.sock"},
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "fails when the user is missing",
+			value:       "/var/run/docker.sock",
+			expected:    Expected{policy: nil, err: ErrInvalidStreamLocalPolicy},
+		},
+		{
+			description: "fails when the pattern is not absolute",
+			value:       "root=docker.sock",
+			expected:    Expected{policy: nil, err: ErrInvalidStreamLocalPolicy},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := ParseStreamLocalPolicy(tc.value)
+			assert.Equal(t, tc.expected, Expected{policy, err})
+		})
+	}
+}
+
+func TestStreamLocalPolicyAllow(t *testing.T) {
+	policy := StreamLocalPolicy{
+		"root": {"/var/run/docker.sock", "/run/postgresql/*"},
+		"*":    {"/tmp/app.sock"},
+	}
+
+	cases := []struct {
+		description string
+		policy      StreamLocalPolicy
+		user        string
+		socket      string
+		expected    bool
+	}{
+		{
+			description: "fails when the policy is nil",
+			policy:      nil,
+			user:        "root",
+			socket:      "/var/run/docker.sock",
+			expected:    false,
+		},
+		{
+			description: "succeeds when the path matches a pattern of the user",
+			policy:      policy,
+			user:        "root",
+			socket:      "/run/postgresql/.s.PGSQL.5432",
+			expected:    true,
+		},
+		{
+			description: "succeeds when the path matches a pattern of every user",
+			policy:      policy,
+			user:        "alice",
+			socket:      "/tmp/app.sock",
+			expected:    true,
+		},
+		{
+			description: "fails when the path matches a pattern of another user",
+			policy:      policy,
+			user:        "alice",
+			socket:      "/var/run/docker.sock",
+			expected:    false,
+		},
+		{
+			description: "fails when the path escapes the pattern",
+			policy:      policy,
+			user:        "root",
+			socket:      "/run/postgresql/../app.sock",
+			expected:    false,
+		},
+		{
+			description: "fails when the path is relative",
+			policy:      policy,
+			user:        "alice",
+			socket:      "tmp/app.sock",
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.Allow(tc.user, tc.socket))
+		})
+	}
+}
+
+func TestResolveStreamLocal(t *testing.T) {
+	dir := t.TempDir()
+
+	// NOTE: The other users search the test's directories, which are only the test user's by default.
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0o755))
+	require.NoError(t, os.Chmod(dir, 0o755))
+
+	for _, name := range []string{"allowed", "private"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+	}
+
+	require.NoError(t, os.Chmod(filepath.Join(dir, "private"), 0o700))
+
+	for _, socket := range []string{"secret.sock", "allowed/app.sock", "private/app.sock"} {
+		listener, err := net.Listen("unix", filepath.Join(dir, socket))
+		require.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+
+		require.NoError(t, os.Chmod(filepath.Join(dir, socket), 0o666))
+	}
+
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.sock"), filepath.Join(dir, "allowed", "link.sock")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(dir, "allowed", "parent")))
+
+	policy := StreamLocalPolicy{"*": {filepath.Join(dir, "allowed", "*"), filepath.Join(dir, "private", "*")}}
+	root := &user.User{Uid: "0", Gid: "0", Username: "root"}
+	nobody := &user.User{Uid: "65534", Gid: "65534", Username: "nobody"}
+
+	type Expected struct {
+		socket string
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		owner       *user.User
+		socket      string
+		listen      bool
+		expected    Expected
+	}{
+		{
+			description: "succeeds when the socket is allowed",
+			owner:       nobody,
+			socket:      filepath.Join(dir, "allowed", "app.sock"),
+			expected:    Expected{socket: filepath.Join(dir, "allowed", "app.sock"), err: nil},
+		},
+		{
+			description: "fails when the user cannot write on the directory to listen on",
+			owner:       nobody,
+			socket:      filepath.Join(dir, "allowed", "new.sock"),
+			listen:      true,
+			expected:    Expected{socket: "", err: ErrStreamLocalPermission},
+		},
+		{
+			description: "succeeds when the root user listens on an allowed socket",
+			owner:       root,
+			socket:      filepath.Join(dir, "allowed", "new.sock"),
+			listen:      true,
+			expected:    Expected{socket: filepath.Join(dir, "allowed", "new.sock"), err: nil},
+		},
+		{
+			description: "fails when a symbolic link escapes the pattern",
+			owner:       root,
+			socket:      filepath.Join(dir, "allowed", "link.sock"),
+			expected:    Expected{socket: "", err: ErrStreamLocalDenied},
+		},
+		{
+			description: "fails when a dot-dot escapes the pattern",
+			owner:       root,
+			socket:      filepath.Join(dir, "allowed") + "/../secret.sock",
+			expected:    Expected{socket: "", err: ErrStreamLocalDenied},
+		},
+		{
+			description: "fails when a symbolic link of the directory escapes the pattern on listen",
+			owner:       root,
+			socket:      filepath.Join(dir, "allowed", "parent", "new.sock"),
+			listen:      true,
+			expected:    Expected{socket: "", err: ErrStreamLocalDenied},
+		},
+		{
+			description: "fails when the user cannot reach the socket",
+			owner:       nobody,
+			socket:      filepath.Join(dir, "private", "app.sock"),
+			expected:    Expected{socket: "", err: ErrStreamLocalPermission},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			socket, err := resolveStreamLocal(policy, tc.owner, tc.socket, tc.listen)
+			assert.Equal(t, tc.expected, Expected{socket, err})
+		})
+	}
+}
+
+// connContext is the context of a connection, canceled when the connection is done.
+type connContext struct {
+	gliderssh.Context
+	ctx  context.Context
+	conn *gossh.ServerConn
+}
+
+func newConnContext() (*connContext, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &connContext{ctx: ctx, conn: new(gossh.ServerConn)}, cancel
+}
+
+// User is the user running the test, who the listening sockets are given to.
+func (c *connContext) User() string {
+	current, err := user.Current()
+	if err != nil {
+		return "root"
+	}
+
+	return current.Username
+}
+
+func (c *connContext) Done() <-chan struct{} { return c.ctx.Done() }
+
+func (c *connContext) Value(key interface{}) interface{} {
+	if key == gliderssh.ContextKeyConn {
+		return c.conn
+	}
+
+	return nil
+}
+
+func TestStreamLocalForwardHandler(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+
+	handler := &streamLocalForwardHandler{
+		policy:    StreamLocalPolicy{"*": {socket}},
+		listeners: make(map[streamLocalForward]net.Listener),
+	}
+
+	request := func(ctx gliderssh.Context, kind string) bool {
+		ok, _ := handler.HandleSSHRequest(ctx, nil, &gossh.Request{
+			Type:    kind,
+			Payload: gossh.Marshal(&streamLocalForwardMsg{SocketPath: socket}),
+		})
+
+		return ok
+	}
+
+	listening := func() bool {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return false
+		}
+
+		return conn.Close() == nil
+	}
+
+	first, done := newConnContext()
+	other, _ := newConnContext()
+
+	require.True(t, request(first, RequestStreamlocalForward))
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// NOTE: Other connection cannot cancel the forwarding.
+	assert.False(t, request(other, RequestCancelStreamlocalForward))
+	assert.True(t, listening())
+
+	assert.True(t, request(first, RequestCancelStreamlocalForward))
+	assert.Eventually(t, func() bool { return !listening() }, time.Second, 10*time.Millisecond)
+
+	// NOTE: The socket forwarded again is kept when the first forwarding's connection is done.
+	second, _ := newConnContext()
+	require.True(t, request(second, RequestStreamlocalForward))
+
+	done()
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, listening())
+
+	assert.True(t, request(second, RequestCancelStreamlocalForward))
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"context"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of the agent's connections.
+var tracer = otel.Tracer("github.com/shellhub-io/mini-shellhub/agent")
+
+// startSpan starts a span of the connection, continuing the trace of the server carried by the client's version.
+func startSpan(ctx gliderssh.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(tracing.Extract(context.Background(), ctx.ClientVersion()), name, trace.WithAttributes(attrs...))
+}
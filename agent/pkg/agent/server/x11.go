@@ -0,0 +1,227 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// RequestX11 is the session's request sent by the client to enable X11 forwarding.
+	//
+	// Check www.ietf.org/rfc/rfc4254.txt at section 6.3.1 for more information.
+	RequestX11 string = "x11-req"
+	// ChannelX11 is the channel opened to the client for each connection to the forwarded display.
+	//
+	// Check www.ietf.org/rfc/rfc4254.txt at section 6.3.2 for more information.
+	ChannelX11 string = "x11"
+)
+
+const (
+	// X11DisplayOffset is the first display number tried for the forwarded displays, as on OpenSSH, to avoid the
+	// displays of real X servers.
+	X11DisplayOffset = 10
+	// X11MaxDisplays is the number of display numbers tried after [X11DisplayOffset].
+	X11MaxDisplays = 1000
+)
+
+var ErrNoDisplayAvailable = errors.New("no X11 display available")
+
+type x11Request struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+type x11ChannelData struct {
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+// x11NewChannel wraps a session's channel to handle the "x11-req" requests, as they are not supported by gliderssh.
+// The request is stored on the channel's context, to be used when the session starts, and is not passed on.
+type x11NewChannel struct {
+	gossh.NewChannel
+	ctx gliderssh.Context
+}
+
+func (c *x11NewChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	channel, requests, err := c.NewChannel.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filtered := make(chan *gossh.Request)
+
+	go func() {
+		defer close(filtered)
+
+		for req := range requests {
+			if req.Type != RequestX11 {
+				filtered <- req
+
+				continue
+			}
+
+			var payload x11Request
+			ok := gossh.Unmarshal(req.Payload, &payload) == nil
+			if ok {
+				c.ctx.SetValue(RequestX11, &payload)
+			}
+
+			if req.WantReply {
+				req.Reply(ok, nil) //nolint:errcheck
+			}
+		}
+	}()
+
+	return channel, filtered, nil
+}
+
+// x11SessionHandler wraps the session's channel handler to accept X11 forwarding requests. The handler must be run
+// with the channel's context, like by [channelSessionHandler], so each session of a connection has its own.
+func x11SessionHandler(next gliderssh.ChannelHandler) gliderssh.ChannelHandler {
+	return func(srv *gliderssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		next(srv, conn, &x11NewChannel{NewChannel: newChan, ctx: ctx}, ctx)
+	}
+}
+
+// x11Requested gets the X11 forwarding request of the session, if any.
+func x11Requested(session gliderssh.Session) (*x11Request, bool) {
+	req, ok := session.Context().Value(RequestX11).(*x11Request)
+
+	return req, ok
+}
+
+// x11Display is a display allocated on the device for a session with X11 forwarding.
+type x11Display struct {
+	listener  net.Listener
+	number    int
+	authority string
+}
+
+// listenX11 allocates a display listening on the loopback interface, creates an authority file owned by the user with
+// the cookie sent by the client, and forwards the connections to the display through "x11" channels.
+func listenX11(session gliderssh.Session, req *x11Request, owner *user.User) (*x11Display, error) {
+	conn, ok := session.Context().Value(gliderssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return nil, errors.New("failed to get server connection from session context")
+	}
+
+	display, err := allocateX11(conn, req.SingleConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	authority, err := os.CreateTemp("", "shellhub-xauth-")
+	if err != nil {
+		display.Close()
+
+		return nil, err
+	}
+
+	authority.Close()
+	display.authority = authority.Name()
+
+	// NOTE: As on OpenSSH, the cookie is set for the "unix" display, as Xlib looks up the local family for "localhost".
+	screen := fmt.Sprintf("unix:%d.%d", display.number, req.ScreenNumber)
+	if output, err := exec.Command("xauth", "-f", display.authority, "add", screen, req.AuthProtocol, req.AuthCookie).CombinedOutput(); err != nil { //nolint:gosec
+		display.Close()
+
+		return nil, fmt.Errorf("failed to add the x11 cookie with xauth: %w: %s", err, output)
+	}
+
+	uid, _ := strconv.Atoi(owner.Uid)
+	gid, _ := strconv.Atoi(owner.Gid)
+	if err := os.Chown(display.authority, uid, gid); err != nil {
+		display.Close()
+
+		return nil, err
+	}
+
+	return display, nil
+}
+
+// allocateX11 listens on the first display available on the loopback interface and forwards its connections through
+// "x11" channels on the connection, only the first one when single is set.
+func allocateX11(conn gossh.Conn, single bool) (*x11Display, error) {
+	display := &x11Display{}
+	for number := X11DisplayOffset; number < X11DisplayOffset+X11MaxDisplays; number++ {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(6000+number)))
+		if err == nil {
+			display.listener, display.number = listener, number
+
+			break
+		}
+	}
+
+	if display.listener == nil {
+		return nil, ErrNoDisplayAvailable
+	}
+
+	go func() {
+		for {
+			local, err := display.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			if single {
+				display.listener.Close()
+			}
+
+			go forwardX11(conn, local)
+		}
+	}()
+
+	return display, nil
+}
+
+// SetEnv sets the environment variables that point the X11 clients to the display on the session's context, which is
+// the channel's one, to be added to the environment of the session's commands.
+func (d *x11Display) SetEnv(session gliderssh.Session, screen uint32) {
+	session.Context().SetValue("DISPLAY", fmt.Sprintf("localhost:%d.%d", d.number, screen))
+	session.Context().SetValue("XAUTHORITY", d.authority)
+}
+
+// Close stops listening on the display and removes its authority file.
+func (d *x11Display) Close() {
+	if d.listener != nil {
+		d.listener.Close()
+	}
+
+	if d.authority != "" {
+		os.Remove(d.authority)
+	}
+}
+
+func forwardX11(conn gossh.Conn, local net.Conn) {
+	defer local.Close()
+
+	data := x11ChannelData{}
+	if origin, ok := local.RemoteAddr().(*net.TCPAddr); ok {
+		data.OriginatorAddress, data.OriginatorPort = origin.IP.String(), uint32(origin.Port)
+	}
+
+	channel, requests, err := conn.OpenChannel(ChannelX11, gossh.Marshal(&data))
+	if err != nil {
+		log.WithError(err).Warn("failed to open the x11 channel")
+
+		return
+	}
+
+	defer channel.Close()
+
+	go gossh.DiscardRequests(requests)
+
+	pipe(channel, local)
+}
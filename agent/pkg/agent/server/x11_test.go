@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// newChannel is a session's channel whose requests are sent by the test.
+type newChannel struct {
+	gossh.NewChannel
+	requests chan *gossh.Request
+}
+
+func (c *newChannel) Accept() (gossh.Channel, <-chan *gossh.Request, error) {
+	return nil, c.requests, nil
+}
+
+func TestX11NewChannel(t *testing.T) {
+	cases := []struct {
+		description string
+		payload     []byte
+		expected    *x11Request
+	}{
+		{
+			description: "stores the request on its channel's context only",
+			payload:     gossh.Marshal(&x11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "cookie", ScreenNumber: 1}),
+			expected:    &x11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "cookie", ScreenNumber: 1},
+		},
+		{
+			description: "ignores the request when the payload is invalid",
+			payload:     []byte("invalid"),
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			conn, cancel := newConnContext()
+			defer cancel()
+
+			ctx, other := newChannelContext(conn), newChannelContext(conn)
+
+			requests := make(chan *gossh.Request, 2)
+			requests <- &gossh.Request{Type: RequestX11, Payload: tc.payload}
+			requests <- &gossh.Request{Type: RequestTypeShell}
+			close(requests)
+
+			_, filtered, err := (&x11NewChannel{NewChannel: &newChannel{requests: requests}, ctx: ctx}).Accept()
+			require.NoError(t, err)
+
+			// NOTE: The "x11-req" request is handled before the requests after it are passed on.
+			req := <-filtered
+			assert.Equal(t, RequestTypeShell, req.Type)
+
+			_, ok := <-filtered
+			assert.False(t, ok)
+
+			stored, _ := ctx.Value(RequestX11).(*x11Request)
+			assert.Equal(t, tc.expected, stored)
+			assert.Nil(t, other.Value(RequestX11))
+
+			ctx.SetValue("DISPLAY", "localhost:10.0")
+			assert.Nil(t, other.Value("DISPLAY"))
+		})
+	}
+}
+
+// newX11Conn connects a client to a server's connection over the loopback interface, returning the server's connection
+// and the channels it opens to the client.
+func newX11Conn(t *testing.T) (gossh.Conn, <-chan gossh.NewChannel) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// NOTE: Both sides of the handshake write before reading, which a synchronous pipe would block.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	server, err := listener.Accept()
+	require.NoError(t, err)
+
+	type result struct {
+		conn gossh.Conn
+		err  error
+	}
+
+	done := make(chan result)
+	go func() {
+		conn, channels, requests, err := gossh.NewServerConn(server, config)
+		if err == nil {
+			go gossh.DiscardRequests(requests)
+			go func() {
+				for channel := range channels {
+					channel.Reject(gossh.Prohibited, "") //nolint:errcheck
+				}
+			}()
+		}
+
+		done <- result{conn, err}
+	}()
+
+	conn, channels, requests, err := gossh.NewClientConn(client, listener.Addr().String(), &gossh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	})
+	require.NoError(t, err)
+
+	go gossh.DiscardRequests(requests)
+
+	res := <-done
+	require.NoError(t, res.err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		res.conn.Close()
+	})
+
+	return res.conn, channels
+}
+
+// dialX11 connects to the display, writing the data through it.
+func dialX11(display *x11Display, data string) error {
+	local, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(6000+display.number)), time.Second)
+	if err != nil {
+		return err
+	}
+
+	_, err = local.Write([]byte(data))
+
+	return err
+}
+
+// acceptX11 accepts the next "x11" channel opened to the client, reading the data sent through it.
+func acceptX11(t *testing.T, channels <-chan gossh.NewChannel, size int) (x11ChannelData, string) {
+	t.Helper()
+
+	select {
+	case newChan := <-channels:
+		require.Equal(t, ChannelX11, newChan.ChannelType())
+
+		var data x11ChannelData
+		require.NoError(t, gossh.Unmarshal(newChan.ExtraData(), &data))
+
+		channel, requests, err := newChan.Accept()
+		require.NoError(t, err)
+
+		go gossh.DiscardRequests(requests)
+
+		buffer := make([]byte, size)
+		_, err = io.ReadFull(channel, buffer)
+		require.NoError(t, err)
+
+		return data, string(buffer)
+	case <-time.After(time.Second):
+		require.FailNow(t, "x11 channel not opened")
+
+		return x11ChannelData{}, ""
+	}
+}
+
+func TestAllocateX11(t *testing.T) {
+	cases := []struct {
+		description string
+		single      bool
+	}{
+		{
+			description: "forwards every connection to the display",
+			single:      false,
+		},
+		{
+			description: "forwards only the first connection to the display in single-connection mode",
+			single:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			conn, channels := newX11Conn(t)
+
+			display, err := allocateX11(conn, tc.single)
+			require.NoError(t, err)
+			defer display.Close()
+
+			assert.GreaterOrEqual(t, display.number, X11DisplayOffset)
+
+			// NOTE: The display is busy, so the next one gets another number.
+			other, err := allocateX11(conn, tc.single)
+			require.NoError(t, err)
+			other.Close()
+
+			assert.NotEqual(t, display.number, other.number)
+
+			require.NoError(t, dialX11(display, "first"))
+
+			data, received := acceptX11(t, channels, len("first"))
+			assert.Equal(t, "127.0.0.1", data.OriginatorAddress)
+			assert.Equal(t, "first", received)
+
+			if tc.single {
+				assert.Eventually(t, func() bool {
+					return dialX11(display, "second") != nil
+				}, time.Second, 10*time.Millisecond)
+
+				return
+			}
+
+			require.NoError(t, dialX11(display, "second"))
+
+			_, received = acceptX11(t, channels, len("second"))
+			assert.Equal(t, "second", received)
+		})
+	}
+}
+
+func TestX11DisplayClose(t *testing.T) {
+	conn, _ := newX11Conn(t)
+
+	display, err := allocateX11(conn, false)
+	require.NoError(t, err)
+
+	display.authority = filepath.Join(t.TempDir(), "xauthority")
+	require.NoError(t, os.WriteFile(display.authority, []byte("cookie"), 0o600))
+
+	display.Close()
+
+	assert.Error(t, dialX11(display, "data"))
+	assert.NoFileExists(t, display.authority)
+}
@@ -0,0 +1,365 @@
+// Package guard protects the authentication from brute force, shared by the server and the agent.
+//
+// The failures are counted by source, the client's address, and by target, like the user on a device. A source or
+// target with too many failures within a window is locked out, for a time doubled on each lockout up to a maximum.
+// Sources on the deny list are always refused, and sources on the allow list, like the monitoring hosts, are never
+// locked out nor lock the targets out.
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDenied        = errors.New("source is denied")
+	ErrLocked        = errors.New("too many authentication failures")
+	ErrNotFound      = errors.New("no failures nor lockout for the key")
+	ErrInvalidConfig = errors.New("invalid brute-force protection")
+	ErrInvalidSource = errors.New("invalid brute-force protection source")
+)
+
+const (
+	// SourcePrefix prefixes the keys of the sources, like "source:10.0.0.5".
+	SourcePrefix = "source:"
+	// TargetPrefix prefixes the keys of the targets, like "target:root@default:DEVICE123".
+	TargetPrefix = "target:"
+)
+
+// Config is how many failures lock a source or target out, and for how long.
+type Config struct {
+	// MaxFailures is how many failures within Window lock out. Zero disables the lockouts.
+	MaxFailures int           `yaml:"max_failures"`
+	Window      time.Duration `yaml:"window"`
+	// Lockout is the first lockout, doubled on each following one up to MaxLockout.
+	Lockout    time.Duration `yaml:"lockout"`
+	MaxLockout time.Duration `yaml:"max_lockout"`
+	// Allow are the sources never locked out, either IPs, like "10.0.0.5", or prefixes, like "10.0.0.0/8".
+	Allow []string `yaml:"allow"`
+	// Deny are the sources always refused, unless allowed.
+	Deny []string `yaml:"deny"`
+}
+
+// Default returns the configuration locking out after 5 failures in 10 minutes, for 1 minute up to 1 hour.
+func Default() Config {
+	return Config{
+		MaxFailures: 5,
+		Window:      10 * time.Minute,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+	}
+}
+
+// Validate checks the durations and parses the sources.
+func (c *Config) Validate() error {
+	_, _, err := c.parse()
+
+	return err
+}
+
+func (c *Config) parse() ([]netip.Prefix, []netip.Prefix, error) {
+	if c.MaxFailures < 0 {
+		return nil, nil, fmt.Errorf("%w: max_failures %d", ErrInvalidConfig, c.MaxFailures)
+	}
+
+	if c.MaxFailures > 0 && (c.Window <= 0 || c.Lockout <= 0 || c.MaxLockout < c.Lockout) {
+		return nil, nil, fmt.Errorf("%w: window, lockout and max_lockout must be positive, and max_lockout at least lockout", ErrInvalidConfig)
+	}
+
+	allow, err := parseSources(c.Allow)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deny, err := parseSources(c.Deny)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return allow, deny, nil
+}
+
+func parseSources(sources []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(sources))
+	for _, source := range sources {
+		if strings.Contains(source, "/") {
+			prefix, err := netip.ParsePrefix(source)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q", ErrInvalidSource, source)
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+
+			continue
+		}
+
+		addr, err := netip.ParseAddr(source)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", ErrInvalidSource, source)
+		}
+
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// LockedError is returned when a source or target is locked out.
+type LockedError struct {
+	Key   string
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s: %s is locked out until %s", ErrLocked, e.Key, e.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Status is the failures and lockout of a source or target.
+type Status struct {
+	Key string `json:"key"`
+	// Failures are the failures within the window.
+	Failures int `json:"failures"`
+	// Lockouts are the lockouts so far, which double the next one.
+	Lockouts    int        `json:"lockouts"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+type entry struct {
+	failures []time.Time
+	lockouts int
+	until    time.Time
+}
+
+// Guard counts the failures and keeps the lockouts. Its configuration can be replaced while it is used, keeping the
+// failures and lockouts.
+//
+// A nil Guard refuses nothing.
+type Guard struct {
+	mu      sync.Mutex
+	config  Config
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+// New creates a [Guard] with the configuration, which must be valid.
+func New(config Config) (*Guard, error) {
+	guard := &Guard{entries: make(map[string]*entry), now: time.Now}
+	if err := guard.Set(config); err != nil {
+		return nil, err
+	}
+
+	return guard, nil
+}
+
+// Set replaces the configuration, keeping the current one when the new one is invalid.
+func (g *Guard) Set(config Config) error {
+	allow, deny, err := config.parse()
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.config, g.allow, g.deny = config, allow, deny
+	g.mu.Unlock()
+
+	return nil
+}
+
+func contains(prefixes []netip.Prefix, source string) bool {
+	addr, err := netip.ParseAddr(source)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keys returns the keys of the source and target, skipping the empty ones, or none when the source is allowed.
+func (g *Guard) keys(source, target string) []string {
+	if source != "" && contains(g.allow, source) {
+		return nil
+	}
+
+	keys := make([]string, 0, 2)
+	if source != "" {
+		keys = append(keys, SourcePrefix+source)
+	}
+
+	if target != "" {
+		keys = append(keys, TargetPrefix+target)
+	}
+
+	return keys
+}
+
+// Check refuses the source when it is denied, with [ErrDenied], or the source or target when locked out, with a
+// [*LockedError]. Either may be empty, like the source on the agent, which only sees the server.
+func (g *Guard) Check(source, target string) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if source != "" && contains(g.deny, source) && !contains(g.allow, source) {
+		return ErrDenied
+	}
+
+	now := g.now()
+	for _, key := range g.keys(source, target) {
+		if e, ok := g.entries[key]; ok && now.Before(e.until) {
+			return &LockedError{Key: key, Until: e.until}
+		}
+	}
+
+	return nil
+}
+
+// Fail counts a failure of the source and target, returning the keys locked out by it.
+func (g *Guard) Fail(source, target string) []string {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.MaxFailures == 0 {
+		return nil
+	}
+
+	now := g.now()
+	g.prune(now)
+
+	locked := []string{}
+	for _, key := range g.keys(source, target) {
+		e, ok := g.entries[key]
+		if !ok {
+			e = &entry{}
+			g.entries[key] = e
+		}
+
+		e.failures = append(recent(e.failures, now, g.config.Window), now)
+		if len(e.failures) < g.config.MaxFailures {
+			continue
+		}
+
+		lockout := g.config.MaxLockout
+		if e.lockouts < 32 {
+			lockout = min(g.config.Lockout<<e.lockouts, g.config.MaxLockout)
+		}
+
+		e.lockouts++
+		e.until = now.Add(lockout)
+		e.failures = nil
+
+		locked = append(locked, key)
+	}
+
+	return locked
+}
+
+// Succeed forgets the failures and lockouts of the source and target.
+func (g *Guard) Succeed(source, target string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, key := range g.keys(source, target) {
+		delete(g.entries, key)
+	}
+}
+
+// List lists the sources and targets with failures within the window or lockouts, sorted by key.
+func (g *Guard) List() []Status {
+	if g == nil {
+		return []Status{}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.prune(now)
+
+	list := make([]Status, 0, len(g.entries))
+	for key, e := range g.entries {
+		status := Status{Key: key, Failures: len(recent(e.failures, now, g.config.Window)), Lockouts: e.lockouts}
+		if now.Before(e.until) {
+			until := e.until
+			status.LockedUntil = &until
+		}
+
+		list = append(list, status)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	return list
+}
+
+// Clear forgets the failures and lockouts of the key, like "source:10.0.0.5".
+func (g *Guard) Clear(key string) error {
+	if g == nil {
+		return ErrNotFound
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.entries[key]; !ok {
+		return ErrNotFound
+	}
+
+	delete(g.entries, key)
+
+	return nil
+}
+
+// prune forgets the keys without failures for the longest of the window and the maximum lockout since their lockout
+// ended, resetting their lockouts.
+func (g *Guard) prune(now time.Time) {
+	quiet := max(g.config.Window, g.config.MaxLockout)
+	for key, e := range g.entries {
+		last := e.until
+		if n := len(e.failures); n > 0 && e.failures[n-1].After(last) {
+			last = e.failures[n-1]
+		}
+
+		if now.Sub(last) > quiet {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// recent returns the failures within the window before now.
+func recent(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	for i, failure := range failures {
+		if now.Sub(failure) < window {
+			return failures[i:]
+		}
+	}
+
+	return failures[:0]
+}
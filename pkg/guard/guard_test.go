@@ -0,0 +1,230 @@
+package guard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a manual clock for the guard.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newGuard(t *testing.T, config Config) (*Guard, *clock) {
+	t.Helper()
+
+	guard, err := New(config)
+	require.NoError(t, err)
+
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	guard.now = c.Now
+
+	return guard, c
+}
+
+func TestCheck(t *testing.T) {
+	config := Default()
+	config.MaxFailures = 2
+	config.Allow = []string{"10.0.0.5"}
+	config.Deny = []string{"10.0.0.0/8", "192.168.1.10"}
+
+	cases := []struct {
+		description string
+		failures    [][2]string
+		source      string
+		target      string
+		expected    error
+	}{
+		{
+			description: "succeeds when there are no failures",
+			failures:    [][2]string{},
+			source:      "172.16.0.1",
+			target:      "root@DEVICE123",
+			expected:    nil,
+		},
+		{
+			description: "fails when source is denied",
+			failures:    [][2]string{},
+			source:      "10.1.2.3",
+			target:      "root@DEVICE123",
+			expected:    ErrDenied,
+		},
+		{
+			description: "succeeds when source is denied but allowed",
+			failures:    [][2]string{},
+			source:      "10.0.0.5",
+			target:      "root@DEVICE123",
+			expected:    nil,
+		},
+		{
+			description: "fails when source is locked out",
+			failures:    [][2]string{{"172.16.0.1", "root@DEVICE123"}, {"172.16.0.1", "alice@DEVICE123"}},
+			source:      "172.16.0.1",
+			target:      "bob@DEVICE123",
+			expected:    ErrLocked,
+		},
+		{
+			description: "fails when target is locked out from other sources",
+			failures:    [][2]string{{"172.16.0.1", "root@DEVICE123"}, {"172.16.0.2", "root@DEVICE123"}},
+			source:      "172.16.0.3",
+			target:      "root@DEVICE123",
+			expected:    ErrLocked,
+		},
+		{
+			description: "succeeds when failures below the maximum",
+			failures:    [][2]string{{"172.16.0.1", "root@DEVICE123"}},
+			source:      "172.16.0.1",
+			target:      "root@DEVICE123",
+			expected:    nil,
+		},
+		{
+			description: "succeeds when allowed source failed",
+			failures:    [][2]string{{"10.0.0.5", "root@DEVICE123"}, {"10.0.0.5", "root@DEVICE123"}},
+			source:      "172.16.0.1",
+			target:      "root@DEVICE123",
+			expected:    nil,
+		},
+		{
+			description: "fails when target is locked out on the agent, without source",
+			failures:    [][2]string{{"", "root"}, {"", "root"}},
+			source:      "",
+			target:      "root",
+			expected:    ErrLocked,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			guard, _ := newGuard(t, config)
+
+			for _, failure := range tc.failures {
+				guard.Fail(failure[0], failure[1])
+			}
+
+			assert.ErrorIs(t, guard.Check(tc.source, tc.target), tc.expected)
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	guard, clock := newGuard(t, Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: 3 * time.Minute})
+
+	fail := func() []string {
+		guard.Fail("172.16.0.1", "")
+
+		return guard.Fail("172.16.0.1", "")
+	}
+
+	// NOTE: Each lockout doubles the next one, up to the maximum.
+	for _, lockout := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		assert.Equal(t, []string{"source:172.16.0.1"}, fail())
+
+		clock.Advance(lockout - time.Second)
+		assert.ErrorIs(t, guard.Check("172.16.0.1", ""), ErrLocked)
+
+		clock.Advance(time.Second)
+		assert.NoError(t, guard.Check("172.16.0.1", ""))
+	}
+
+	// NOTE: Failures out of the window are not counted.
+	guard.Fail("172.16.0.1", "")
+	clock.Advance(time.Minute)
+	assert.Empty(t, guard.Fail("172.16.0.1", ""))
+
+	// NOTE: A quiet period as long as the maximum lockout forgets the lockouts.
+	clock.Advance(4 * time.Minute)
+	assert.Empty(t, guard.List())
+
+	fail()
+	clock.Advance(time.Minute)
+	assert.NoError(t, guard.Check("172.16.0.1", ""))
+}
+
+func TestSucceed(t *testing.T) {
+	guard, _ := newGuard(t, Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+
+	guard.Fail("172.16.0.1", "root@DEVICE123")
+	guard.Succeed("172.16.0.1", "root@DEVICE123")
+	guard.Fail("172.16.0.1", "root@DEVICE123")
+
+	assert.NoError(t, guard.Check("172.16.0.1", "root@DEVICE123"))
+}
+
+func TestListAndClear(t *testing.T) {
+	guard, clock := newGuard(t, Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+
+	guard.Fail("172.16.0.1", "root@DEVICE123")
+	guard.Fail("172.16.0.2", "root@DEVICE123")
+
+	until := clock.Now().Add(time.Minute)
+	assert.Equal(t, []Status{
+		{Key: "source:172.16.0.1", Failures: 1},
+		{Key: "source:172.16.0.2", Failures: 1},
+		{Key: "target:root@DEVICE123", Lockouts: 1, LockedUntil: &until},
+	}, guard.List())
+
+	require.NoError(t, guard.Clear("target:root@DEVICE123"))
+	assert.NoError(t, guard.Check("172.16.0.3", "root@DEVICE123"))
+
+	assert.ErrorIs(t, guard.Clear("target:root@DEVICE123"), ErrNotFound)
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		description string
+		config      Config
+		expected    error
+	}{
+		{
+			description: "succeeds when config is the default",
+			config:      Default(),
+			expected:    nil,
+		},
+		{
+			description: "succeeds when lockouts are disabled",
+			config:      Config{},
+			expected:    nil,
+		},
+		{
+			description: "fails when window is not set",
+			config:      Config{MaxFailures: 5, Lockout: time.Minute, MaxLockout: time.Hour},
+			expected:    ErrInvalidConfig,
+		},
+		{
+			description: "fails when maximum lockout is below the lockout",
+			config:      Config{MaxFailures: 5, Window: time.Minute, Lockout: time.Hour, MaxLockout: time.Minute},
+			expected:    ErrInvalidConfig,
+		},
+		{
+			description: "fails when source is invalid",
+			config:      Config{Deny: []string{"10.0.0.0/33"}},
+			expected:    ErrInvalidSource,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			guard, _ := newGuard(t, Config{Deny: []string{"10.0.0.1"}})
+
+			err := guard.Set(tc.config)
+			assert.ErrorIs(t, err, tc.expected)
+
+			if tc.expected != nil {
+				assert.ErrorIs(t, guard.Check("10.0.0.1", ""), ErrDenied)
+			}
+		})
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	var guard *Guard
+
+	assert.NoError(t, guard.Check("10.0.0.1", "root@DEVICE123"))
+	assert.Empty(t, guard.Fail("10.0.0.1", "root@DEVICE123"))
+	assert.Empty(t, guard.List())
+}
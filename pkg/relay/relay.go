@@ -0,0 +1,105 @@
+// Package relay relays the data between two connections in both directions, like between a client of the server and
+// a stream to a device, or between a stream from the server and a connection of the agent.
+package relay
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrHalfCloseUnsupported is returned when closing the writing side of a connection that can only be closed whole.
+var ErrHalfCloseUnsupported = errors.New("connection cannot be half closed")
+
+// CloseWriter is implemented by the connections whose writing side can be closed while still reading from them, like
+// the TCP connections and the SSH channels.
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// Pipe copies the data between a and b in both directions, returning when both directions are done, and then closes
+// both.
+//
+// When a side is done sending, its peer has the writing side closed, so it reads the end of the data while still
+// sending its own, like a client that sends a request and shuts down its writing side before reading the response.
+// When the peer cannot be half closed, or a copy fails, both sides are closed at once.
+func Pipe(a, b io.ReadWriteCloser) {
+	done := sync.OnceFunc(func() {
+		a.Close()
+		b.Close()
+	})
+
+	wg := new(sync.WaitGroup)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		half(b, a, done)
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		half(a, b, done)
+	}()
+
+	wg.Wait()
+
+	done()
+}
+
+// half copies src to dst, closing the writing side of dst once src is done, or both sides with done when it can't.
+func half(dst, src io.ReadWriteCloser, done func()) {
+	if _, err := io.Copy(dst, src); err != nil {
+		done()
+
+		return
+	}
+
+	if err := CloseWrite(dst); err != nil {
+		done()
+	}
+}
+
+// CloseWrite closes the writing side of conn, failing with [ErrHalfCloseUnsupported] when conn can only be closed
+// whole.
+func CloseWrite(conn io.Writer) error {
+	writer, ok := conn.(CloseWriter)
+	if !ok {
+		return ErrHalfCloseUnsupported
+	}
+
+	return writer.CloseWrite()
+}
+
+// halfCloser is a connection whose Close only closes its writing side.
+type halfCloser struct {
+	io.ReadWriteCloser
+}
+
+func (h *halfCloser) CloseWrite() error {
+	return h.ReadWriteCloser.Close()
+}
+
+// HalfCloser marks conn as a connection whose Close only closes its writing side, like a yamux stream, which keeps
+// reading until its peer closes it too, so [Pipe] half closes it.
+func HalfCloser(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &halfCloser{ReadWriteCloser: conn}
+}
+
+// reader is a connection read through another reader.
+type reader struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (r *reader) CloseWrite() error {
+	return CloseWrite(r.WriteCloser)
+}
+
+// WithReader reads the connection through r, like a buffered reader that kept the data sent right after a request,
+// while writing to, half closing and closing the connection itself.
+func WithReader(conn io.ReadWriteCloser, r io.Reader) io.ReadWriteCloser {
+	return &reader{Reader: r, WriteCloser: conn}
+}
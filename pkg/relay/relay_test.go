@@ -0,0 +1,190 @@
+package relay
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipe(t *testing.T) {
+	cases := []struct {
+		description string
+		closed      func(client, device net.Conn)
+	}{
+		{
+			description: "closes both sides when the client is done",
+			closed:      func(client, _ net.Conn) { client.Close() },
+		},
+		{
+			description: "closes both sides when the device is done",
+			closed:      func(_, device net.Conn) { device.Close() },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			client, a := net.Pipe()
+			device, b := net.Pipe()
+
+			done := make(chan struct{})
+			go func() {
+				Pipe(a, b)
+				close(done)
+			}()
+
+			_, err := client.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			data := make([]byte, 4)
+			_, err = io.ReadFull(device, data)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(data))
+
+			_, err = device.Write([]byte("pong"))
+			require.NoError(t, err)
+
+			_, err = io.ReadFull(client, data)
+			require.NoError(t, err)
+			assert.Equal(t, "pong", string(data))
+
+			tc.closed(client, device)
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the pipe was not done")
+			}
+
+			// NOTE: Both connections were closed, so the other side sees its end too.
+			_, err = a.Write([]byte("x"))
+			assert.Error(t, err)
+
+			_, err = b.Write([]byte("x"))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// pair connects two TCP connections on the loopback interface.
+func pair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	accepted, err := listener.Accept()
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+
+	return dialed, accepted
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	cases := []struct {
+		description string
+		wrap        func(conn net.Conn) io.ReadWriteCloser
+	}{
+		{
+			description: "keeps the response flowing after the client closes its writing side",
+			wrap:        func(conn net.Conn) io.ReadWriteCloser { return conn },
+		},
+		{
+			description: "keeps the response flowing through a connection read through another reader",
+			wrap:        func(conn net.Conn) io.ReadWriteCloser { return WithReader(conn, conn) },
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			client, a := pair(t)
+			device, b := pair(t)
+
+			require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+			require.NoError(t, device.SetDeadline(time.Now().Add(5*time.Second)))
+
+			done := make(chan struct{})
+			go func() {
+				Pipe(tc.wrap(a), tc.wrap(b))
+				close(done)
+			}()
+
+			_, err := client.Write([]byte("request"))
+			require.NoError(t, err)
+			require.NoError(t, CloseWrite(client))
+
+			// NOTE: The device reads the whole request, up to the end of the client's data, before answering.
+			request, err := io.ReadAll(device)
+			require.NoError(t, err)
+			assert.Equal(t, "request", string(request))
+
+			_, err = device.Write([]byte("response"))
+			require.NoError(t, err)
+			require.NoError(t, device.Close())
+
+			response, err := io.ReadAll(client)
+			require.NoError(t, err)
+			assert.Equal(t, "response", string(response))
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the pipe was not done")
+			}
+		})
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	_, conn := net.Pipe()
+	defer conn.Close()
+
+	assert.ErrorIs(t, CloseWrite(conn), ErrHalfCloseUnsupported)
+	assert.ErrorIs(t, CloseWrite(WithReader(conn, conn)), ErrHalfCloseUnsupported)
+
+	// NOTE: The Close of a half closer only closes its writing side, so it is its CloseWrite.
+	client, stream := net.Pipe()
+	defer client.Close()
+
+	go CloseWrite(HalfCloser(stream)) //nolint:errcheck
+
+	_, err := client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWithReader(t *testing.T) {
+	client, a := net.Pipe()
+	defer client.Close()
+
+	conn := WithReader(a, bufio.NewReader(strings.NewReader("buffered")))
+
+	data := make([]byte, 8)
+	_, err := io.ReadFull(conn, data)
+	require.NoError(t, err)
+	assert.Equal(t, "buffered", string(data))
+
+	go conn.Write([]byte("written")) //nolint:errcheck
+
+	data = make([]byte, 7)
+	_, err = io.ReadFull(client, data)
+	require.NoError(t, err)
+	assert.Equal(t, "written", string(data))
+
+	require.NoError(t, conn.Close())
+
+	_, err = client.Read(data)
+	assert.ErrorIs(t, err, io.EOF)
+}
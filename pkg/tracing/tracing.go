@@ -0,0 +1,88 @@
+// Package tracing sets up the OpenTelemetry tracing of the server and the agent, exporting their spans over OTLP/HTTP
+// to a collector, and propagates the trace context from the server to the agent on the SSH client's version string,
+// so a single trace shows a whole connection.
+//
+// The collector is configured with the standard environment variables of OpenTelemetry, like
+// OTEL_EXPORTER_OTLP_ENDPOINT. Without them, tracing is disabled.
+//
+// https://opentelemetry.io/docs/specs/otel/protocol/exporter/
+package tracing
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// Enabled checks if a collector is configured, on OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup sets the global tracer provider to export the spans of the service to the collector. When tracing is not
+// enabled, the spans are discarded. The returned function exports the pending spans and stops the exporter.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the service's name and attributes.
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// field prefixes the comment of the SSH version string that carries the trace context, in the W3C format, like
+// "SSH-2.0-Go traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+//
+// https://www.rfc-editor.org/rfc/rfc4253#section-4.2
+const field = "traceparent="
+
+// Version appends the trace context of ctx to the SSH version string as a comment, for the agent to continue the
+// trace. When ctx has no span, the version is returned as is.
+func Version(ctx context.Context, version string) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	parent := carrier.Get("traceparent")
+	if parent == "" {
+		return version
+	}
+
+	return version + " " + field + parent
+}
+
+// Extract returns a copy of ctx with the trace context carried by the SSH version string, when it has one.
+func Extract(ctx context.Context, version string) context.Context {
+	for _, comment := range strings.Fields(version) {
+		if parent, ok := strings.CutPrefix(comment, field); ok {
+			return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": parent})
+		}
+	}
+
+	return ctx
+}
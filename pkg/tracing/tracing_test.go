@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestVersion(t *testing.T) {
+	span := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	cases := []struct {
+		description string
+		ctx         context.Context
+		expected    string
+	}{
+		{
+			description: "succeeds when context has a span",
+			ctx:         trace.ContextWithSpanContext(context.Background(), span),
+			expected:    "SSH-2.0-Go traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			description: "succeeds when context has no span",
+			ctx:         context.Background(),
+			expected:    "SSH-2.0-Go",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Version(tc.ctx, "SSH-2.0-Go"))
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		description string
+		version     string
+		expected    string
+	}{
+		{
+			description: "succeeds when version carries the trace context",
+			version:     "SSH-2.0-Go traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expected:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			description: "succeeds when version has other comments",
+			version:     "SSH-2.0-Go build=42 traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expected:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			description: "succeeds when version has no trace context",
+			version:     "SSH-2.0-OpenSSH_9.6",
+			expected:    "00000000000000000000000000000000",
+		},
+		{
+			description: "succeeds when trace context is invalid",
+			version:     "SSH-2.0-Go traceparent=invalid",
+			expected:    "00000000000000000000000000000000",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := Extract(context.Background(), tc.version)
+			assert.Equal(t, tc.expected, trace.SpanContextFromContext(ctx).TraceID().String())
+		})
+	}
+}
@@ -64,8 +64,14 @@ func (c *WSConn) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
-// Close closes the WebSocket connection
+// closeTimeout bounds the write of the close message, so a peer that stopped reading doesn't hold the close.
+const closeTimeout = time.Second
+
+// Close sends a close message to the peer, so it sees a normal closure, and closes the WebSocket connection
 func (c *WSConn) Close() error {
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout)) //nolint:errcheck
+
 	return c.conn.Close()
 }
 
@@ -0,0 +1,232 @@
+// Package api provides the admin REST API of the server, served on the same Echo router used by the agents' reverse
+// tunnel. Every route requires HTTP basic authentication of a user with the admin role.
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/shellhub-io/mini-shellhub/ssh/cluster"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
+	log "github.com/sirupsen/logrus"
+)
+
+// Prefix is the path prefix of the admin API routes.
+const Prefix = "/api"
+
+type handler struct {
+	service *services.Service
+}
+
+// Register registers the admin API routes on the router, whose admins authenticate through the guard. When users are
+// not enabled, there is no admin to authenticate, and the API is not registered.
+func Register(e *echo.Echo, service *services.Service, store *users.Store, guard *users.Guard) *echo.Group {
+	if !store.Enabled() {
+		log.Warn("admin API is disabled because no user was configured")
+
+		return nil
+	}
+
+	h := &handler{service: service}
+
+	// NOTE: The address of the connection, not the forwarded one, which the client could spoof.
+	group := e.Group(Prefix, middleware.BasicAuth(func(name, password string, c echo.Context) (bool, error) {
+		user, err := guard.Authenticate(store, c.Request().RemoteAddr, name, password)
+		if err != nil {
+			return false, nil
+		}
+
+		return user.IsAdmin(), nil
+	}))
+
+	group.GET("/devices", h.listDevices)
+	group.GET("/devices/:uid", h.getDevice)
+	group.POST("/devices/:uid/accept", h.acceptDevice)
+	group.PUT("/devices/:uid/tags", h.setDeviceTags)
+	group.GET("/sessions", h.listSessions)
+	group.DELETE("/sessions/:uid", h.killSession)
+	group.GET("/recordings", h.listRecordings)
+	group.GET("/recordings/:uid", h.getRecording)
+	group.GET("/mappings", h.listMappings)
+	group.POST("/mappings", h.addMapping)
+	group.GET("/mappings/:name", h.getMapping)
+	group.DELETE("/mappings/:name", h.removeMapping)
+	group.GET("/publications", h.listPublications)
+	group.GET("/ports", h.listDevicePorts)
+	group.DELETE("/publications/:device/:name", h.revokePublication)
+	group.GET("/audit", h.listAuditEvents)
+	group.GET("/lockouts", h.listLockouts)
+	// NOTE: The key may have slashes, like the SSHIDs through a gateway, so it is the rest of the path.
+	group.DELETE("/lockouts/*", h.clearLockout)
+
+	return group
+}
+
+// status maps the service errors to HTTP status codes.
+func status(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDeviceNotFound),
+		errors.Is(err, services.ErrSessionNotFound),
+		errors.Is(err, services.ErrRecordingNotFound),
+		errors.Is(err, services.ErrMappingNotFound),
+		errors.Is(err, services.ErrPublicationNotFound),
+		errors.Is(err, services.ErrLockoutNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrDeviceAmbiguous),
+		errors.Is(err, services.ErrInvalidTag),
+		errors.Is(err, services.ErrInvalidMapping):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrDeviceNotPending),
+		errors.Is(err, services.ErrMappingExists),
+		errors.Is(err, cluster.ErrRemoteDevice):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func fail(c echo.Context, err error) error {
+	return c.JSON(status(err), map[string]string{"message": err.Error()})
+}
+
+func (h *handler) listDevices(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListDevices(c.QueryParam("filter")))
+}
+
+func (h *handler) getDevice(c echo.Context) error {
+	device, err := h.service.GetDevice(c.Param("uid"))
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, device)
+}
+
+func (h *handler) acceptDevice(c echo.Context) error {
+	device, err := h.service.AcceptDevice(c.Param("uid"))
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, device)
+}
+
+func (h *handler) setDeviceTags(c echo.Context) error {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "invalid body"})
+	}
+
+	device, err := h.service.SetDeviceTags(c.Param("uid"), body.Tags)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, device)
+}
+
+func (h *handler) listSessions(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListSessions())
+}
+
+func (h *handler) killSession(c echo.Context) error {
+	if err := h.service.KillSession(c.Param("uid")); err != nil {
+		return fail(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) listRecordings(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListRecordings())
+}
+
+func (h *handler) getRecording(c echo.Context) error {
+	frames, err := h.service.GetRecording(c.Param("uid"))
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, frames)
+}
+
+func (h *handler) listMappings(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListMappings())
+}
+
+func (h *handler) getMapping(c echo.Context) error {
+	mapping, err := h.service.GetMapping(c.Param("name"))
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, mapping)
+}
+
+func (h *handler) addMapping(c echo.Context) error {
+	var body portmap.Mapping
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "invalid body"})
+	}
+
+	mapping, err := h.service.AddMapping(body)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, mapping)
+}
+
+func (h *handler) removeMapping(c echo.Context) error {
+	if err := h.service.RemoveMapping(c.Param("name")); err != nil {
+		return fail(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) listPublications(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListPublications())
+}
+
+func (h *handler) revokePublication(c echo.Context) error {
+	publication, err := h.service.RevokePublication(c.Param("device"), c.Param("name"))
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, publication)
+}
+
+func (h *handler) listDevicePorts(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListDevicePorts())
+}
+
+func (h *handler) listAuditEvents(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListAuditEvents())
+}
+
+func (h *handler) listLockouts(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.service.ListLockouts())
+}
+
+func (h *handler) clearLockout(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+
+	if err := h.service.ClearLockout(key); err != nil {
+		return fail(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
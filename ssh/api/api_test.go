@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// NOTE: The password of the users is "x"; alice is an admin and bob is not.
+const file = "alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881:admin:\n" +
+	"bob:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881::\n"
+
+// devices is a [services.DeviceStore] keeping the devices in memory.
+type devices struct {
+	devices []models.Device
+}
+
+func (d *devices) Devices() []models.Device {
+	return append([]models.Device{}, d.devices...)
+}
+
+func (d *devices) set(uid string, fn func(device *models.Device)) error {
+	for i := range d.devices {
+		if d.devices[i].UID == uid {
+			fn(&d.devices[i])
+
+			return nil
+		}
+	}
+
+	return errors.New("device not found")
+}
+
+func (d *devices) SetDeviceStatus(uid string, status models.DeviceStatus) error {
+	return d.set(uid, func(device *models.Device) { device.Status = status })
+}
+
+func (d *devices) SetDeviceTags(uid string, tags []string) error {
+	return d.set(uid, func(device *models.Device) { device.Tags = tags })
+}
+
+func TestHandlers(t *testing.T) {
+	cases := []struct {
+		description string
+		user        string
+		method      string
+		path        string
+		body        string
+		expected    int
+		contains    string
+	}{
+		{
+			description: "refuses the user who is not an admin",
+			user:        "bob",
+			method:      http.MethodGet,
+			path:        "/devices",
+			expected:    http.StatusUnauthorized,
+		},
+		{
+			description: "refuses the unknown user",
+			user:        "carol",
+			method:      http.MethodGet,
+			path:        "/devices",
+			expected:    http.StatusUnauthorized,
+		},
+		{
+			description: "lists the devices matching the filter",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/devices?filter=DEVICE456",
+			expected:    http.StatusOK,
+			contains:    `"uid":"default:DEVICE456"`,
+		},
+		{
+			description: "gets the device by its name",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/devices/DEVICE123",
+			expected:    http.StatusOK,
+			contains:    `"uid":"default:DEVICE123"`,
+		},
+		{
+			description: "fails to get the unknown device",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/devices/DEVICE789",
+			expected:    http.StatusNotFound,
+			contains:    services.ErrDeviceNotFound.Error(),
+		},
+		{
+			description: "accepts the pending device",
+			user:        "alice",
+			method:      http.MethodPost,
+			path:        "/devices/DEVICE456/accept",
+			expected:    http.StatusOK,
+			contains:    `"status":"accepted"`,
+		},
+		{
+			description: "fails to accept the accepted device",
+			user:        "alice",
+			method:      http.MethodPost,
+			path:        "/devices/DEVICE123/accept",
+			expected:    http.StatusConflict,
+		},
+		{
+			description: "replaces the tags of the device",
+			user:        "alice",
+			method:      http.MethodPut,
+			path:        "/devices/DEVICE123/tags",
+			body:        `{"tags":["db","prod"]}`,
+			expected:    http.StatusOK,
+			contains:    `"tags":["db","prod"]`,
+		},
+		{
+			description: "fails to set the invalid tags",
+			user:        "alice",
+			method:      http.MethodPut,
+			path:        "/devices/DEVICE123/tags",
+			body:        `{"tags":["prod env"]}`,
+			expected:    http.StatusBadRequest,
+		},
+		{
+			description: "fails to set the tags without a body",
+			user:        "alice",
+			method:      http.MethodPut,
+			path:        "/devices/DEVICE123/tags",
+			body:        `{"tags":`,
+			expected:    http.StatusBadRequest,
+		},
+		{
+			description: "lists the sessions",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/sessions",
+			expected:    http.StatusOK,
+			contains:    "[]",
+		},
+		{
+			description: "fails to kill the unknown session",
+			user:        "alice",
+			method:      http.MethodDelete,
+			path:        "/sessions/unknown",
+			expected:    http.StatusNotFound,
+		},
+		{
+			description: "fails to get the unknown recording",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/recordings/unknown",
+			expected:    http.StatusNotFound,
+		},
+		{
+			description: "fails to add the invalid mapping",
+			user:        "alice",
+			method:      http.MethodPost,
+			path:        "/mappings",
+			body:        `{"name":"pg","listen":":15432","device":"DEVICE123","target":"localhost"}`,
+			expected:    http.StatusBadRequest,
+		},
+		{
+			description: "fails to get the unknown mapping",
+			user:        "alice",
+			method:      http.MethodGet,
+			path:        "/mappings/pg",
+			expected:    http.StatusNotFound,
+		},
+		{
+			description: "fails to clear the unknown lockout",
+			user:        "alice",
+			method:      http.MethodDelete,
+			path:        "/lockouts/target:root@DEVICE123/10.0.0.5:22",
+			expected:    http.StatusNotFound,
+		},
+	}
+
+	store, err := users.Parse(strings.NewReader(file))
+	require.NoError(t, err)
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Default())
+			require.NoError(t, err)
+
+			inventory := &devices{devices: []models.Device{
+				{UID: "default:DEVICE123", Name: "DEVICE123", TenantID: "default", Status: models.DeviceStatusAccepted},
+				{UID: "default:DEVICE456", Name: "DEVICE456", TenantID: "default", Status: models.DeviceStatusPending},
+			}}
+
+			mappings := portmap.NewManager(nil)
+			service := services.New(inventory, session.NewRegistry(), mappings, nil, nil, audit.NewLog(), lockouts)
+
+			e := echo.New()
+			Register(e, service, store, nil)
+
+			req := httptest.NewRequest(tc.method, Prefix+tc.path, strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.SetBasicAuth(tc.user, "x")
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.contains)
+		})
+	}
+}
+
+func TestBruteForce(t *testing.T) {
+	store, err := users.Parse(strings.NewReader(file))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		passwords   []string
+		expected    int
+	}{
+		{
+			description: "authenticates the admin when password is right",
+			passwords:   []string{"x"},
+			expected:    http.StatusOK,
+		},
+		{
+			description: "refuses the right password when locked out",
+			passwords:   []string{"y", "y", "x"},
+			expected:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+			require.NoError(t, err)
+
+			e := echo.New()
+			Register(e, services.New(nil, nil, nil, nil, nil, audit.NewLog(), lockouts), store, &users.Guard{Lockouts: lockouts})
+
+			rec := httptest.NewRecorder()
+			for _, password := range tc.passwords {
+				req := httptest.NewRequest(http.MethodGet, Prefix+"/audit", nil)
+				req.SetBasicAuth("alice", password)
+
+				rec = httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Package audit keeps the events relevant to audits, like the sessions the server terminated and why, logging them and
+// keeping the latest ones for the admin API.
+package audit
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxEvents is the number of events kept by the [Log].
+const MaxEvents = 1000
+
+const (
+	// TypeSessionTerminated is the type of the events of sessions terminated by the server.
+	TypeSessionTerminated = "session.terminated"
+	// TypeConnectionRejected is the type of the events of connections to the devices rejected by the server.
+	TypeConnectionRejected = "connection.rejected"
+	// TypeChannelRejected is the type of the events of channels to the devices rejected by the server.
+	TypeChannelRejected = "channel.rejected"
+	// TypeAgentRejected is the type of the events of agents' connections rejected by the server.
+	TypeAgentRejected = "agent.rejected"
+	// TypeJumpConnected is the type of the events of clients connected to the agents' SSH servers through the jump host.
+	TypeJumpConnected = "jump.connected"
+	// TypeAuthLocked is the type of the events of client's addresses or SSHIDs locked out after too many
+	// authentication failures.
+	TypeAuthLocked = "auth.locked"
+)
+
+// Event is something done by the server to a connection or session, like terminating it.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Type is what happened, like "session.terminated".
+	Type string `json:"type"`
+	// Reason is why it happened, like "idle-timeout".
+	Reason    string `json:"reason"`
+	Session   string `json:"session,omitempty"`
+	SSHID     string `json:"sshid,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// Log keeps the latest events. A nil Log only logs them.
+type Log struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewLog creates a new empty [Log].
+func NewLog() *Log {
+	return &Log{events: make([]Event, 0)}
+}
+
+// Record logs the event and keeps it, dropping the oldest one beyond [MaxEvents]. Events without a time happen now.
+func (l *Log) Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	log.WithFields(log.Fields{
+		"audit":    event.Type,
+		"reason":   event.Reason,
+		"uid":      event.Session,
+		"sshid":    event.SSHID,
+		"device":   event.Device,
+		"username": event.Username,
+		"ip":       event.IPAddress,
+	}).Info("audit event")
+
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, event)
+	if len(l.events) > MaxEvents {
+		l.events = l.events[len(l.events)-MaxEvents:]
+	}
+}
+
+// Events lists the events kept, from the oldest to the newest.
+func (l *Log) Events() []Event {
+	if l == nil {
+		return []Event{}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	events := make([]Event, len(l.events))
+	copy(events, l.events)
+
+	return events
+}
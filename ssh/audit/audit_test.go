@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	cases := []struct {
+		description string
+		recorded    int
+		expected    []string
+	}{
+		{
+			description: "succeeds when log has no events",
+			recorded:    0,
+			expected:    []string{},
+		},
+		{
+			description: "succeeds when events are below the maximum",
+			recorded:    2,
+			expected:    []string{"session-0", "session-1"},
+		},
+		{
+			description: "succeeds when the oldest events are dropped",
+			recorded:    MaxEvents + 2,
+			expected:    []string{"session-2", "session-3"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			l := NewLog()
+			for i := 0; i < tc.recorded; i++ {
+				l.Record(Event{Type: "session.terminated", Session: fmt.Sprintf("session-%d", i)})
+			}
+
+			events := l.Events()
+
+			sessions := []string{}
+			for _, event := range events {
+				sessions = append(sessions, event.Session)
+				assert.WithinDuration(t, time.Now(), event.Time, time.Second)
+			}
+
+			if len(sessions) > len(tc.expected) {
+				sessions = sessions[:len(tc.expected)]
+			}
+
+			assert.Equal(t, tc.expected, sessions)
+			assert.LessOrEqual(t, len(events), MaxEvents)
+		})
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+
+	l.Record(Event{Type: "session.terminated"})
+
+	assert.Empty(t, l.Events())
+}
@@ -0,0 +1,524 @@
+// Package cluster routes the connections to the devices between the server instances of a cluster, so a client
+// reaches a device connected to any of them, like when they are behind a load balancer.
+//
+// Each node records the devices connected to it on a [Registry] shared by the nodes, like a Redis server, refreshing
+// the entries before they expire. A node that gets a connection to a device connected to another one opens the
+// device's stream through that node, over a WebSocket signed with the secret shared by the nodes. When a node dies,
+// its entries expire and its devices are reached again on the node they reconnect to.
+//
+// NOTE: The signature binds the node it is sent to, the stream's path, the device, the time and a nonce, so a
+// signature seen by a node, or on the network, can neither be replayed on it nor on another node.
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/pkg/yamuxws"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotFound          = errors.New("key not found on the registry")
+	ErrUnknownRegistry   = errors.New("unknown registry; use \"redis://[user:password@]host:port[/db]\" or \"rediss://\"")
+	ErrInvalidSignature  = errors.New("invalid signature of the cluster's node")
+	ErrReplayedSignature = errors.New("replayed signature of the cluster's node")
+	ErrNotConnected      = errors.New("device not connected to the cluster")
+	ErrRemoteDevice      = errors.New("device connected to another node of the cluster; manage it on that node")
+)
+
+// Registry is the store shared by the nodes of the cluster, where they record the location of the devices.
+type Registry interface {
+	// Set sets the value of key, expiring it after ttl.
+	Set(key, value string, ttl time.Duration) error
+	// Get gets the value of key, or [ErrNotFound] when it has none.
+	Get(key string) (string, error)
+	// Delete deletes key when its value is still value, so a node never deletes the entry set by another one.
+	Delete(key, value string) error
+	// List lists the keys starting with prefix and their values.
+	List(prefix string) (map[string]string, error)
+}
+
+// Open opens the registry at the URL, like "redis://registry:6379/0".
+func Open(registry string) (Registry, error) {
+	u, err := url.Parse(registry)
+	if err != nil {
+		return nil, ErrUnknownRegistry
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		return NewRedis(u)
+	default:
+		return nil, ErrUnknownRegistry
+	}
+}
+
+const (
+	// StreamPath is the endpoint of the nodes that opens the stream to a device connected to them.
+	StreamPath = "/cluster/stream"
+	// SignatureHeader carries the signature of a request to [StreamPath], as "<unix time>:<nonce>:<HMAC-SHA256>".
+	SignatureHeader = "X-Cluster-Signature"
+	// DefaultTTL is the time the location of a device is kept on the registry without being refreshed by its node.
+	DefaultTTL = 15 * time.Second
+	// prefix is the prefix of the registry's keys of the devices' locations.
+	prefix = "shellhub:cluster:device:"
+	// skew is the maximum difference between the time of a signature and the node's clock.
+	skew = 30 * time.Second
+)
+
+// Local is the manager of the devices connected to the node itself.
+type Local interface {
+	OpenStream(device string) (io.ReadWriteCloser, error)
+	Devices() []models.Device
+}
+
+// Store is a [Local] that also keeps the status and tags of the devices, like the server's device manager.
+type Store interface {
+	Local
+	SetDeviceStatus(uid string, status models.DeviceStatus) error
+	SetDeviceTags(uid string, tags []string) error
+}
+
+// Location is the value of the registry's entry of a device.
+type Location struct {
+	// Node is the address of the node the device is connected to, like "http://10.0.0.1:8080".
+	Node   string        `json:"node"`
+	Device models.Device `json:"device"`
+}
+
+// Node is a server instance of the cluster. It opens streams to the devices of the whole cluster, as a manager of
+// devices, and serves the streams to its own devices to the other nodes on [StreamPath].
+//
+// A nil Node is valid for [Node.Register], [Node.Unregister] and [Node.Run], and means the server is not clustered.
+type Node struct {
+	local    Local
+	registry Registry
+	// address is the address the other nodes reach this one on, like "http://10.0.0.1:8080".
+	address string
+	secret  []byte
+	ttl     time.Duration
+	dialer  *websocket.Dialer
+
+	mu sync.Mutex
+	// written keeps the value last written to the registry for each device, to delete it only while it is the node's.
+	written map[string]string
+
+	nonces sync.Mutex
+	// seen keeps the nonces of the signatures accepted by the node until they are too old to be accepted again.
+	seen map[string]time.Time
+}
+
+// New creates a new [Node] for the devices of local, reached by the other nodes on address and signing the streams
+// between them with secret.
+func New(local Local, registry Registry, address, secret string) *Node {
+	return &Node{
+		local:    local,
+		registry: registry,
+		address:  strings.TrimSuffix(address, "/"),
+		secret:   []byte(secret),
+		ttl:      DefaultTTL,
+		dialer:   &websocket.Dialer{HandshakeTimeout: 10 * time.Second},
+		written:  make(map[string]string),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// online gets the device connected to the node itself, when it is online.
+func (n *Node) online(uid string) (models.Device, bool) {
+	for _, device := range n.local.Devices() {
+		if device.UID == uid && device.Online {
+			return device, true
+		}
+	}
+
+	return models.Device{}, false
+}
+
+// write records the device as connected to the node.
+func (n *Node) write(device models.Device) error {
+	value, err := json.Marshal(&Location{Node: n.address, Device: device})
+	if err != nil {
+		return err
+	}
+
+	if err := n.registry.Set(prefix+device.UID, string(value), n.ttl); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.written[device.UID] = string(value)
+	n.mu.Unlock()
+
+	return nil
+}
+
+// Register records the device, just connected to the node, on the registry.
+func (n *Node) Register(uid string) {
+	if n == nil {
+		return
+	}
+
+	device, ok := n.online(uid)
+	if !ok {
+		return
+	}
+
+	if err := n.write(device); err != nil {
+		log.WithError(err).WithField("device", uid).Error("failed to record the device on the cluster's registry")
+	}
+}
+
+// Unregister removes the device, just disconnected from the node, from the registry, unless it has reconnected to
+// the node or to another one since.
+func (n *Node) Unregister(uid string) {
+	if n == nil {
+		return
+	}
+
+	if _, ok := n.online(uid); ok {
+		return
+	}
+
+	n.mu.Lock()
+	value, ok := n.written[uid]
+	delete(n.written, uid)
+	n.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := n.registry.Delete(prefix+uid, value); err != nil {
+		log.WithError(err).WithField("device", uid).Warn("failed to remove the device from the cluster's registry")
+	}
+}
+
+// Refresh records the devices connected to the node again, before their entries expire.
+func (n *Node) Refresh() {
+	for _, device := range n.local.Devices() {
+		if !device.Online {
+			continue
+		}
+
+		if err := n.write(device); err != nil {
+			log.WithError(err).WithField("device", device.UID).Error("failed to refresh the device on the cluster's registry")
+		}
+	}
+}
+
+// Run refreshes the devices connected to the node three times per TTL. It never returns.
+func (n *Node) Run() {
+	if n == nil {
+		return
+	}
+
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n.Refresh()
+	}
+}
+
+// OpenStream opens a stream to the device, either directly when it is connected to the node or through the node it
+// is connected to.
+func (n *Node) OpenStream(uid string) (io.ReadWriteCloser, error) {
+	if _, ok := n.online(uid); ok {
+		return n.local.OpenStream(uid)
+	}
+
+	value, err := n.registry.Get(prefix + uid)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("device %s not connected", uid)
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("device", uid).Error("failed to get the device from the cluster's registry")
+
+		return nil, err
+	}
+
+	var location Location
+	if err := json.Unmarshal([]byte(value), &location); err != nil {
+		return nil, err
+	}
+
+	// NOTE: An entry of the node itself is left by a device that is not connected anymore.
+	if location.Node == n.address {
+		return nil, fmt.Errorf("device %s not connected", uid)
+	}
+
+	stream, err := n.dial(location.Node, uid)
+	if err != nil {
+		// NOTE: The node of the device is unreachable, like when it died, so its entry is removed, leaving the device
+		// offline until it reconnects to another node. A node that answered, even refusing the stream, is alive.
+		if !errors.Is(err, websocket.ErrBadHandshake) {
+			n.registry.Delete(prefix+uid, value) //nolint:errcheck
+		}
+
+		return nil, errors.Join(ErrNotConnected, err)
+	}
+
+	return stream, nil
+}
+
+// dial opens the stream to the device through the node at address.
+func (n *Node) dial(address, uid string) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + StreamPath
+	u.RawQuery = url.Values{"device": {uid}}.Encode()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set(SignatureHeader, n.sign(address, uid, time.Now(), hex.EncodeToString(nonce)))
+
+	conn, res, err := n.dialer.Dial(u.String(), header)
+	if err != nil {
+		if res != nil {
+			err = fmt.Errorf("%w: %s", err, res.Status)
+		}
+
+		return nil, err
+	}
+
+	return yamuxws.NewWSConn(conn), nil
+}
+
+// sign signs the request to the stream of the device on the node at address, at t, with the nonce.
+func (n *Node) sign(address, uid string, t time.Time, nonce string) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(strings.TrimSuffix(address, "/") + StreamPath + "\n" + uid + "\n" + timestamp + "\n" + nonce))
+
+	return timestamp + ":" + nonce + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature of the request to the stream of the device on the node, accepting each nonce once.
+func (n *Node) verify(uid, signature string) error {
+	parts := strings.Split(signature, ":")
+	if len(parts) != 3 || len(parts[1]) != 32 {
+		return ErrInvalidSignature
+	}
+
+	timestamp, nonce := parts[0], parts[1]
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	t := time.Unix(unix, 0)
+	if time.Since(t).Abs() > skew {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(n.sign(n.address, uid, t, nonce))) {
+		return ErrInvalidSignature
+	}
+
+	n.nonces.Lock()
+	defer n.nonces.Unlock()
+
+	// NOTE: A nonce is kept while its signature's time is within the skew, after which the time refuses it.
+	for seen, expires := range n.seen {
+		if time.Now().After(expires) {
+			delete(n.seen, seen)
+		}
+	}
+
+	if _, ok := n.seen[nonce]; ok {
+		return ErrReplayedSignature
+	}
+
+	n.seen[nonce] = t.Add(skew)
+
+	return nil
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ServeHTTP serves the stream to a device connected to the node for another node of the cluster.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("device")
+
+	logger := log.WithFields(log.Fields{"device": uid, "remote": r.RemoteAddr})
+
+	if err := n.verify(uid, r.Header.Get(SignatureHeader)); err != nil {
+		logger.WithError(err).Warn("refused the stream to the device for a node of the cluster")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+
+		return
+	}
+
+	// NOTE: Only devices connected to the node itself are served, so a stream never hops through another node.
+	stream, err := n.local.OpenStream(uid)
+	if err != nil {
+		logger.WithError(err).Info("failed to open the stream to the device for a node of the cluster")
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	defer stream.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.WithError(err).Error("failed to upgrade the stream of a node of the cluster")
+
+		return
+	}
+
+	link := yamuxws.NewWSConn(conn)
+	defer link.Close()
+
+	logger.Debug("streaming to the device for a node of the cluster")
+
+	// NOTE: The stream ends when either side closes, as a WebSocket cannot be half closed.
+	relay.Pipe(stream, link)
+}
+
+// Devices lists the devices connected to the node itself, including the offline ones, and the ones connected to the
+// other nodes of the cluster.
+func (n *Node) Devices() []models.Device {
+	devices := n.local.Devices()
+
+	known := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		if device.Online {
+			known[device.UID] = true
+		}
+	}
+
+	entries, err := n.registry.List(prefix)
+	if err != nil {
+		log.WithError(err).Error("failed to list the devices on the cluster's registry")
+
+		return devices
+	}
+
+	for key, value := range entries {
+		var location Location
+		if err := json.Unmarshal([]byte(value), &location); err != nil || location.Node == n.address {
+			continue
+		}
+
+		uid := strings.TrimPrefix(key, prefix)
+		if known[uid] {
+			continue
+		}
+
+		// NOTE: A device connected to another node replaces the offline one the node itself knew.
+		for i, device := range devices {
+			if device.UID == uid {
+				devices = append(devices[:i], devices[i+1:]...)
+
+				break
+			}
+		}
+
+		location.Device.Online = true
+		devices = append(devices, location.Device)
+	}
+
+	return devices
+}
+
+// View is the devices of the whole cluster for the admin API and shell of a node. It lists them like [Node.Devices],
+// and sets the status and tags of the devices on the store of the node.
+//
+// NOTE: Each node keeps the status and tags of the devices connected to it, so the devices connected to another node
+// are refused with [ErrRemoteDevice] instead of being changed on a copy the node they are connected to never reads.
+type View struct {
+	node  *Node
+	store Store
+}
+
+// NewView creates a new [View] of the devices of the cluster of node, whose own devices are kept by store.
+func NewView(node *Node, store Store) *View {
+	return &View{node: node, store: store}
+}
+
+// Devices lists the devices of the cluster.
+func (v *View) Devices() []models.Device {
+	return v.node.Devices()
+}
+
+// SetDeviceStatus sets the status of a device of the node.
+func (v *View) SetDeviceStatus(uid string, status models.DeviceStatus) error {
+	if err := v.local(uid); err != nil {
+		return err
+	}
+
+	return v.store.SetDeviceStatus(uid, status)
+}
+
+// SetDeviceTags replaces the tags of a device of the node.
+func (v *View) SetDeviceTags(uid string, tags []string) error {
+	if err := v.local(uid); err != nil {
+		return err
+	}
+
+	return v.store.SetDeviceTags(uid, tags)
+}
+
+// local checks the device is not connected to another node, even when the node knows it from an old connection.
+func (v *View) local(uid string) error {
+	if _, ok := v.node.online(uid); ok {
+		return nil
+	}
+
+	value, err := v.node.registry.Get(prefix + uid)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var location Location
+	if err := json.Unmarshal([]byte(value), &location); err != nil {
+		return err
+	}
+
+	if location.Node != v.node.address {
+		return ErrRemoteDevice
+	}
+
+	return nil
+}
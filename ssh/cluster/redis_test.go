@@ -0,0 +1,243 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReply(t *testing.T) {
+	type Expected struct {
+		reply any
+		err   error
+	}
+
+	cases := []struct {
+		description string
+		data        string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when reply is a simple string",
+			data:        "+OK\r\n",
+			expected:    Expected{reply: "OK", err: nil},
+		},
+		{
+			description: "succeeds when reply is an integer",
+			data:        ":1\r\n",
+			expected:    Expected{reply: int64(1), err: nil},
+		},
+		{
+			description: "succeeds when reply is a bulk string",
+			data:        "$5\r\nhello\r\n",
+			expected:    Expected{reply: "hello", err: nil},
+		},
+		{
+			description: "succeeds when reply is a nil bulk string",
+			data:        "$-1\r\n",
+			expected:    Expected{reply: nil, err: nil},
+		},
+		{
+			description: "succeeds when reply is an array with nil items",
+			data:        "*3\r\n$1\r\na\r\n$-1\r\n*1\r\n:2\r\n",
+			expected:    Expected{reply: []any{"a", nil, []any{int64(2)}}, err: nil},
+		},
+		{
+			description: "fails when reply is an error",
+			data:        "-WRONGPASS invalid password\r\n",
+			expected:    Expected{reply: nil, err: RedisError("WRONGPASS invalid password")},
+		},
+		{
+			description: "fails when reply has an unknown type",
+			data:        "!3\r\nerr\r\n",
+			expected:    Expected{reply: nil, err: fmt.Errorf("%w: unexpected type %q", ErrInvalidReply, '!')},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			reply, err := reply(bufio.NewReader(strings.NewReader(tc.data)))
+			assert.Equal(t, tc.expected, Expected{reply, err})
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	assert.Equal(t, "*3\r\n$3\r\nGET\r\n$3\r\nkey\r\n$0\r\n\r\n", string(command("GET", "key", "")))
+}
+
+// fake serves the commands used by [Redis] on the loopback, closing each connection after limit commands.
+type fake struct {
+	password string
+	limit    int
+
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func (f *fake) serve(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go f.handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func (f *fake) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for count := 0; count < f.limit; count++ {
+		request, err := reply(reader)
+		if err != nil {
+			return
+		}
+
+		items, _ := request.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+
+		if args[0] == "AUTH" {
+			if authenticated = args[len(args)-1] == f.password; !authenticated {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+
+				continue
+			}
+
+			fmt.Fprint(conn, "+OK\r\n")
+
+			continue
+		}
+
+		if !authenticated {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+
+			continue
+		}
+
+		fmt.Fprint(conn, f.execute(args))
+	}
+}
+
+func (f *fake) execute(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bulk := func(value string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	}
+
+	switch args[0] {
+	case "SET":
+		f.entries[args[1]] = args[2]
+
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.entries[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+
+		return bulk(value)
+	case "EVAL":
+		if f.entries[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+
+		delete(f.entries, args[3])
+
+		return ":1\r\n"
+	case "SCAN":
+		prefix := strings.ReplaceAll(strings.TrimSuffix(args[3], "*"), `\`, "")
+
+		keys := ""
+		count := 0
+		for key := range f.entries {
+			if strings.HasPrefix(key, prefix) {
+				keys += bulk(key)
+				count++
+			}
+		}
+
+		return fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk("0"), count, keys)
+	case "MGET":
+		values := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if value, ok := f.entries[key]; ok {
+				values += bulk(value)
+			} else {
+				values += "$-1\r\n"
+			}
+		}
+
+		return values
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func TestRedis(t *testing.T) {
+	server := &fake{password: "secret", limit: 4, entries: make(map[string]string)}
+	address := server.serve(t)
+
+	r, err := NewRedis(&url.URL{Scheme: "redis", Host: address, User: url.UserPassword("", "secret")})
+	require.NoError(t, err)
+
+	require.NoError(t, r.Set("shellhub:first", "node-a", time.Minute))
+	require.NoError(t, r.Set("shellhub:second", "node-b", time.Minute))
+	require.NoError(t, r.Set("other", "node-c", time.Minute))
+
+	// NOTE: The server closed the connection after its fourth command, so the client reconnects.
+	value, err := r.Get("shellhub:first")
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", value)
+
+	_, err = r.Get("shellhub:third")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := r.List("shellhub:")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"shellhub:first": "node-a", "shellhub:second": "node-b"}, entries)
+
+	// NOTE: The entry is only deleted while it has the value.
+	require.NoError(t, r.Delete("shellhub:first", "node-b"))
+	require.NoError(t, r.Delete("shellhub:second", "node-b"))
+
+	entries, err = r.List("shellhub:")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"shellhub:first": "node-a"}, entries)
+}
+
+func TestRedisAuthentication(t *testing.T) {
+	server := &fake{password: "secret", limit: 10, entries: make(map[string]string)}
+	address := server.serve(t)
+
+	r, err := NewRedis(&url.URL{Scheme: "redis", Host: address, User: url.UserPassword("", "wrong")})
+	require.NoError(t, err)
+
+	assert.Equal(t, RedisError("WRONGPASS invalid password"), r.Set("shellhub:first", "node-a", time.Minute))
+}
@@ -0,0 +1,110 @@
+// Command ssh-ws tunnels an SSH connection over a WebSocket to the server, for clients behind networks that block the
+// SSH port but allow HTTPS. It is meant to be the ProxyCommand of OpenSSH, relaying its standard input and output:
+//
+//	ssh -o ProxyCommand='ssh-ws https://server.example' root@DEVICE123@server.example
+//
+// The proxy of the HTTPS_PROXY and HTTP_PROXY environment variables is used, unless NO_PROXY excludes the server.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/shellhub-io/mini-shellhub/pkg/yamuxws"
+)
+
+// Path is the WebSocket endpoint of the server that carries the SSH connections of the clients.
+const Path = "/ssh/websocket"
+
+func main() {
+	insecure := flag.Bool("insecure", false, "Skip the verification of the server's TLS certificate")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--insecure] <server URL>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	endpoint, err := Endpoint(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ssh-ws: %s\n", err)
+		os.Exit(2)
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: *insecure}, //nolint:gosec
+	}
+
+	conn, res, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		if res != nil {
+			err = fmt.Errorf("%w: %s", err, res.Status)
+		}
+
+		fmt.Fprintf(os.Stderr, "ssh-ws: failed to connect to %s: %s\n", endpoint, err)
+		os.Exit(1)
+	}
+
+	stream := yamuxws.NewWSConn(conn)
+	defer stream.Close()
+
+	// NOTE: The tunnel ends when either side closes, as a WebSocket cannot be half closed.
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(stream, os.Stdin) //nolint:errcheck
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(os.Stdout, stream) //nolint:errcheck
+		done <- struct{}{}
+	}()
+
+	<-done
+}
+
+// Endpoint converts the server URL, like "https://server.example", to the URL of its WebSocket endpoint, like
+// "wss://server.example/ssh/websocket". A URL with a path other than "/" is kept as is, apart from its scheme.
+func Endpoint(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "wss"
+	case "http", "ws":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q; use https or http", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("missing the server's host on %q", server)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = Path
+	}
+
+	return u.String(), nil
+}
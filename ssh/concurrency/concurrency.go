@@ -0,0 +1,234 @@
+// Package concurrency caps how many connections and channels reach the devices at once, per device, per user on the
+// devices and per tenant, and how many agents each tenant has connected.
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrLimitReached = errors.New("concurrency limit reached")
+	ErrInvalidCap   = errors.New("invalid concurrency cap")
+)
+
+// Resource is what the caps limit.
+type Resource string
+
+const (
+	Connections Resource = "connections"
+	Channels    Resource = "channels"
+	Agents      Resource = "agents"
+)
+
+// Scope is who shares a cap.
+type Scope string
+
+const (
+	ScopeDevice Scope = "device"
+	ScopeUser   Scope = "user"
+	ScopeTenant Scope = "tenant"
+)
+
+// Caps are the maximum concurrent connections and channels of a scope. Zero means no cap.
+type Caps struct {
+	Connections int `yaml:"connections"`
+	Channels    int `yaml:"channels"`
+}
+
+func (c Caps) of(resource Resource) int {
+	if resource == Channels {
+		return c.Channels
+	}
+
+	return c.Connections
+}
+
+// Config are the caps of each scope.
+type Config struct {
+	// Device caps each device, as "tenant:name".
+	Device Caps `yaml:"device"`
+	// User caps each user on the devices of a tenant, across its devices. The same username on other tenant, like
+	// "root", is another user.
+	User Caps `yaml:"user"`
+	// Tenant caps each tenant, across its devices.
+	Tenant Caps `yaml:"tenant"`
+	// AgentsPerTenant caps the agents connected at once on each tenant. Zero means no cap.
+	AgentsPerTenant int `yaml:"agents_per_tenant"`
+}
+
+// Validate checks that no cap is negative.
+func (c *Config) Validate() error {
+	for _, value := range []int{
+		c.Device.Connections, c.Device.Channels,
+		c.User.Connections, c.User.Channels,
+		c.Tenant.Connections, c.Tenant.Channels,
+		c.AgentsPerTenant,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%w %d", ErrInvalidCap, value)
+		}
+	}
+
+	return nil
+}
+
+// Owner is who holds a connection or channel: the device, as "tenant:name", its tenant and the user on it. The user
+// is capped within the tenant.
+type Owner struct {
+	Tenant string
+	Device string
+	User   string
+}
+
+// Error is returned when a cap is reached, with the scope and resource of the cap.
+type Error struct {
+	Scope    Scope
+	Resource Resource
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrLimitReached, e.Scope, e.Resource)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrLimitReached
+}
+
+// Reason identifies the cap reached, like "device-connections", on logs and audit events.
+func (e *Error) Reason() string {
+	return fmt.Sprintf("%s-%s", e.Scope, e.Resource)
+}
+
+// Limiter counts the connections, channels and agents held, refusing new ones over the caps. Its caps can be replaced
+// while they are held; the new caps only apply to the new ones.
+//
+// A nil Limiter has no caps.
+type Limiter struct {
+	mu     sync.Mutex
+	config Config
+	// held counts the connections and channels held by resource, scope and owner.
+	held map[string]int
+	// agents counts the connections of each device, by tenant, since an agent may reconnect before its previous
+	// connection is done.
+	agents map[string]map[string]int
+}
+
+// New creates a [Limiter] with the caps, which must be valid.
+func New(config Config) (*Limiter, error) {
+	limiter := &Limiter{
+		held:   make(map[string]int),
+		agents: make(map[string]map[string]int),
+	}
+
+	if err := limiter.Set(config); err != nil {
+		return nil, err
+	}
+
+	return limiter, nil
+}
+
+// Set replaces the caps, keeping the current ones when the new ones are invalid.
+func (l *Limiter) Set(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.config = config
+	l.mu.Unlock()
+
+	return nil
+}
+
+func key(resource Resource, scope Scope, value string) string {
+	return fmt.Sprintf("%s/%s/%s", resource, scope, value)
+}
+
+// Acquire holds a connection or channel of the owner, returning the function that releases it, or an [*Error] when
+// any of the owner's caps is reached.
+func (l *Limiter) Acquire(resource Resource, owner Owner) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	scopes := []struct {
+		scope Scope
+		value string
+		cap   int
+	}{
+		{ScopeDevice, owner.Device, l.config.Device.of(resource)},
+		{ScopeUser, owner.Tenant + ":" + owner.User, l.config.User.of(resource)},
+		{ScopeTenant, owner.Tenant, l.config.Tenant.of(resource)},
+	}
+
+	for _, s := range scopes {
+		if s.cap > 0 && l.held[key(resource, s.scope, s.value)] >= s.cap {
+			return nil, &Error{Scope: s.scope, Resource: resource}
+		}
+	}
+
+	for _, s := range scopes {
+		l.held[key(resource, s.scope, s.value)]++
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			for _, s := range scopes {
+				k := key(resource, s.scope, s.value)
+				if l.held[k]--; l.held[k] <= 0 {
+					delete(l.held, k)
+				}
+			}
+		})
+	}, nil
+}
+
+// AcquireAgent holds a connection of the device's agent on the tenant, returning the function that releases it, or an
+// [*Error] when the tenant already has as many other agents as its cap. An agent reconnecting is not counted twice.
+func (l *Limiter) AcquireAgent(tenant, device string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	devices := l.agents[tenant]
+	if devices == nil {
+		devices = make(map[string]int)
+		l.agents[tenant] = devices
+	}
+
+	if _, ok := devices[device]; !ok && l.config.AgentsPerTenant > 0 && len(devices) >= l.config.AgentsPerTenant {
+		return nil, &Error{Scope: ScopeTenant, Resource: Agents}
+	}
+
+	devices[device]++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			if devices[device]--; devices[device] <= 0 {
+				delete(devices, device)
+			}
+
+			if len(devices) == 0 {
+				delete(l.agents, tenant)
+			}
+		})
+	}, nil
+}
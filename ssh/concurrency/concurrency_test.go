@@ -0,0 +1,186 @@
+package concurrency
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	config := Config{
+		Device: Caps{Connections: 2, Channels: 1},
+		User:   Caps{Connections: 3},
+		Tenant: Caps{Connections: 4},
+	}
+
+	lab := func(device, user string) Owner {
+		return Owner{Tenant: "lab", Device: "lab:" + device, User: user}
+	}
+
+	cases := []struct {
+		description string
+		held        []Owner
+		resource    Resource
+		owner       Owner
+		expected    string
+	}{
+		{
+			description: "succeeds when nothing is held",
+			held:        []Owner{},
+			resource:    Connections,
+			owner:       lab("switch", "root"),
+			expected:    "",
+		},
+		{
+			description: "fails when the device reached its cap",
+			held:        []Owner{lab("switch", "root"), lab("switch", "alice")},
+			resource:    Connections,
+			owner:       lab("switch", "bob"),
+			expected:    "device-connections",
+		},
+		{
+			description: "fails when the user reached its cap",
+			held:        []Owner{lab("switch", "root"), lab("router", "root"), lab("modem", "root")},
+			resource:    Connections,
+			owner:       lab("printer", "root"),
+			expected:    "user-connections",
+		},
+		{
+			description: "succeeds when the same user on other tenant reached its cap",
+			held:        []Owner{lab("switch", "root"), lab("router", "root"), lab("modem", "root")},
+			resource:    Connections,
+			owner:       Owner{Tenant: "default", Device: "default:DEVICE123", User: "root"},
+			expected:    "",
+		},
+		{
+			description: "fails when the tenant reached its cap",
+			held:        []Owner{lab("switch", "root"), lab("router", "alice"), lab("modem", "bob"), lab("printer", "eve")},
+			resource:    Connections,
+			owner:       lab("camera", "carol"),
+			expected:    "tenant-connections",
+		},
+		{
+			description: "succeeds when other tenant reached its cap",
+			held:        []Owner{lab("switch", "root"), lab("router", "alice"), lab("modem", "bob"), lab("printer", "eve")},
+			resource:    Connections,
+			owner:       Owner{Tenant: "default", Device: "default:DEVICE123", User: "carol"},
+			expected:    "",
+		},
+		{
+			description: "fails when the device reached its channels cap",
+			held:        []Owner{lab("switch", "root")},
+			resource:    Channels,
+			owner:       lab("switch", "alice"),
+			expected:    "device-channels",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			limiter, err := New(config)
+			require.NoError(t, err)
+
+			for _, owner := range tc.held {
+				_, err := limiter.Acquire(tc.resource, owner)
+				require.NoError(t, err)
+			}
+
+			release, err := limiter.Acquire(tc.resource, tc.owner)
+			if tc.expected == "" {
+				require.NoError(t, err)
+				release()
+
+				return
+			}
+
+			var limit *Error
+			require.True(t, errors.As(err, &limit))
+			assert.ErrorIs(t, err, ErrLimitReached)
+			assert.Equal(t, tc.expected, limit.Reason())
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	limiter, err := New(Config{Device: Caps{Connections: 1}})
+	require.NoError(t, err)
+
+	owner := Owner{Tenant: "default", Device: "default:DEVICE123", User: "root"}
+
+	release, err := limiter.Acquire(Connections, owner)
+	require.NoError(t, err)
+
+	_, err = limiter.Acquire(Connections, owner)
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	// NOTE: Releasing twice releases only once.
+	release()
+	release()
+
+	again, err := limiter.Acquire(Connections, owner)
+	require.NoError(t, err)
+
+	_, err = limiter.Acquire(Connections, owner)
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	again()
+}
+
+func TestAcquireAgent(t *testing.T) {
+	limiter, err := New(Config{AgentsPerTenant: 2})
+	require.NoError(t, err)
+
+	switch1, err := limiter.AcquireAgent("lab", "lab:switch")
+	require.NoError(t, err)
+
+	_, err = limiter.AcquireAgent("lab", "lab:router")
+	require.NoError(t, err)
+
+	// NOTE: A reconnecting agent is the same agent.
+	switch2, err := limiter.AcquireAgent("lab", "lab:switch")
+	require.NoError(t, err)
+
+	_, err = limiter.AcquireAgent("lab", "lab:modem")
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	_, err = limiter.AcquireAgent("default", "default:DEVICE123")
+	require.NoError(t, err)
+
+	switch1()
+	_, err = limiter.AcquireAgent("lab", "lab:modem")
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	switch2()
+	_, err = limiter.AcquireAgent("lab", "lab:modem")
+	require.NoError(t, err)
+}
+
+func TestSet(t *testing.T) {
+	limiter, err := New(Config{Device: Caps{Connections: 1}})
+	require.NoError(t, err)
+
+	err = limiter.Set(Config{User: Caps{Channels: -1}})
+	assert.ErrorIs(t, err, ErrInvalidCap)
+
+	owner := Owner{Tenant: "default", Device: "default:DEVICE123", User: "root"}
+
+	_, err = limiter.Acquire(Connections, owner)
+	require.NoError(t, err)
+
+	_, err = limiter.Acquire(Connections, owner)
+	assert.ErrorIs(t, err, ErrLimitReached)
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+
+	release, err := limiter.Acquire(Connections, Owner{})
+	require.NoError(t, err)
+	release()
+
+	release, err = limiter.AcquireAgent("default", "default:DEVICE123")
+	require.NoError(t, err)
+	release()
+}
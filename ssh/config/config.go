@@ -0,0 +1,513 @@
+// Package config loads the configuration of the SSH server from a YAML file, whose settings are overridden by the
+// environment variables, like `LOG_LEVEL=debug`.
+//
+// The settings tagged with `reload:"true"` are applied again when the configuration is reloaded, without dropping the
+// sessions; the other ones only apply after a restart.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/cluster"
+	"github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+	"github.com/shellhub-io/mini-shellhub/ssh/firewall"
+	"github.com/shellhub-io/mini-shellhub/ssh/limits"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/forward"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/ports"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/proxyprotocol"
+	"github.com/shellhub-io/mini-shellhub/ssh/socks"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrInvalidTimeout = errors.New("invalid timeout")
+	ErrInvalidEnv     = errors.New("invalid environment variable")
+	ErrInvalidMode    = errors.New("invalid device acceptance; use \"auto\" or \"manual\"")
+	ErrMissingSetting = errors.New("missing setting")
+)
+
+const (
+	// DefaultHTTPAddress is the default address of the HTTP server, for the devices' tunnels, the API and the metrics.
+	DefaultHTTPAddress = ":8080"
+	// DefaultSSHAddress is the default address of the SSH server.
+	DefaultSSHAddress = ":2222"
+	// DefaultConnectTimeout is the default time the sessions wait for the devices to answer.
+	DefaultConnectTimeout = 30 * time.Second
+	// DefaultShutdownDrain is how long the active sessions are waited for to finish when the server shuts down.
+	DefaultShutdownDrain = 30 * time.Second
+)
+
+const (
+	// AcceptanceAuto accepts the new devices when they connect.
+	AcceptanceAuto = "auto"
+	// AcceptanceManual keeps the new devices pending until an admin accepts them.
+	AcceptanceManual = "manual"
+)
+
+// Config is the configuration of the SSH server.
+type Config struct {
+	HTTPAddress string `yaml:"http_address"`
+	SSHAddress  string `yaml:"ssh_address"`
+	// ProxyProtocol reads the PROXY protocol header on the SSH connections, sent by the trusted load balancers.
+	ProxyProtocol ProxyProtocol `yaml:"proxy_protocol"`
+	// ConnectTimeout bounds the stream open, the SSH handshake and the channel opens on the devices. Zero means no
+	// timeout.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// StreamIdleTimeout closes the streams to the devices without data either way for it. Zero means no timeout.
+	StreamIdleTimeout time.Duration `yaml:"stream_idle_timeout"`
+	// LogLevel is the level of the logs, like "debug" or "warning".
+	LogLevel string `yaml:"log_level" reload:"true"`
+	// UsersFile is the users file; its users are loaded again on reloads, but enabling or disabling them, or using
+	// another file, needs a restart.
+	UsersFile          string             `yaml:"users_file"`
+	LocalPortForward   bool               `yaml:"local_port_forward"`
+	ReversePortForward ReversePortForward `yaml:"reverse_port_forward"`
+	Yamux              Yamux              `yaml:"yamux"`
+	Firewall           []firewall.Rule    `yaml:"firewall" reload:"true"`
+	// SessionLimits are the idle timeouts and maximum durations of the sessions; reloads only apply to new sessions.
+	SessionLimits []limits.Rule `yaml:"session_limits" reload:"true"`
+	// Concurrency caps the concurrent connections and channels to the devices and the agents of the tenants; reloads
+	// only apply to new connections.
+	Concurrency concurrency.Config `yaml:"concurrency" reload:"true"`
+	// BruteForce locks out the clients' addresses and the SSHIDs with too many authentication failures, and refuses the
+	// denied addresses; reloads keep the failures and lockouts.
+	BruteForce guard.Config `yaml:"brute_force" reload:"true"`
+	Messages   Messages     `yaml:"messages" reload:"true"`
+	// DeviceAcceptance is [AcceptanceAuto] or [AcceptanceManual].
+	DeviceAcceptance string `yaml:"device_acceptance"`
+	// SSHIDForms are the alternative forms of the SSHID, comma separated, like "device+user,user%device".
+	SSHIDForms string `yaml:"sshid_forms"`
+	// AgentPoolIdle keeps the connections authenticated on the agents for that long after their last use, for the next
+	// client connections. Zero disables the pool.
+	AgentPoolIdle time.Duration `yaml:"agent_pool_idle"`
+	// ShutdownDrain is how long the active sessions are waited for to finish on shutdown. Zero closes them at once.
+	ShutdownDrain time.Duration `yaml:"shutdown_drain"`
+	// MetricsAddress is the address of the metrics' listener. Empty disables it.
+	MetricsAddress string `yaml:"metrics_address"`
+	// MappingsFile is the file of the port mappings started with the server.
+	MappingsFile string `yaml:"mappings_file"`
+	// PublishPorts is the range of ports given to the services published by the agents, like "20000-20999". Empty
+	// disables the publications.
+	PublishPorts string      `yaml:"publish_ports"`
+	DevicePorts  DevicePorts `yaml:"device_ports"`
+	Cluster      Cluster     `yaml:"cluster"`
+	WebProxy     WebProxy    `yaml:"web_proxy"`
+	Socks        Socks       `yaml:"socks"`
+}
+
+// DevicePorts are the SSH ports of the devices, like `ssh -p 22017 root@server`. Without both, the devices are only
+// reached through the SSHID.
+type DevicePorts struct {
+	// Assignments are the explicit ports of the devices, like "default:DEVICE123=22017".
+	Assignments string `yaml:"assignments"`
+	// Range is the range of ports given to the other devices when they connect, like "22000-22999".
+	Range string `yaml:"range"`
+}
+
+// Cluster are the settings of the server as a node of a cluster, enabled by the registry.
+type Cluster struct {
+	// Registry is the URL of the registry shared by the nodes, like "redis://registry:6379/0".
+	Registry string `yaml:"registry"`
+	// NodeAddress is the URL the other nodes reach the node on, like "http://10.0.0.1:8080".
+	NodeAddress string `yaml:"node_address"`
+	// Secret signs the streams between the nodes.
+	Secret string `yaml:"secret"`
+}
+
+// WebProxy are the settings of the HTTPS reverse proxy to the devices' web UIs, enabled by its address.
+type WebProxy struct {
+	Address string `yaml:"address"`
+	// Domain is the domain of the "<port>-<device>.<domain>" hosts.
+	Domain  string `yaml:"domain"`
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+// Socks are the settings of the SOCKS5 proxy to the devices' networks, enabled by its address.
+type Socks struct {
+	Address string `yaml:"address"`
+	// Domain is the domain of the "<host>.<device>.<domain>" destinations.
+	Domain string `yaml:"domain"`
+}
+
+// ReversePortForward is the policy of reverse port forwarding, like `ssh -R`.
+type ReversePortForward struct {
+	Enabled bool `yaml:"enabled"`
+	// Addresses are the allowed bind addresses, comma separated, like "localhost,127.0.0.1".
+	Addresses string `yaml:"addresses"`
+	// Ports is the range of allowed ports, like "1024-65535".
+	Ports string `yaml:"ports"`
+}
+
+// ProxyProtocol is the policy of the PROXY protocol header on the SSH connections. It is disabled by default, as
+// any client could otherwise spoof its address with the header.
+type ProxyProtocol struct {
+	Enabled bool `yaml:"enabled"`
+	// Trusted are the load balancers allowed to send the header, comma separated IPs or prefixes, like "10.0.0.0/8".
+	// The header is refused from any other address.
+	Trusted string `yaml:"trusted"`
+	// Versions are the allowed versions of the header, comma separated, like "1,2". Empty allows both.
+	Versions string `yaml:"versions"`
+	// Required refuses the connections from the trusted load balancers without the header.
+	Required bool `yaml:"required"`
+}
+
+// Yamux are the settings of the sessions multiplexing the streams to the devices.
+type Yamux struct {
+	AcceptBacklog          int           `yaml:"accept_backlog"`
+	KeepAliveInterval      time.Duration `yaml:"keepalive_interval"`
+	ConnectionWriteTimeout time.Duration `yaml:"connection_write_timeout"`
+	StreamOpenTimeout      time.Duration `yaml:"stream_open_timeout"`
+	MaxStreamWindowSize    uint32        `yaml:"max_stream_window_size"`
+}
+
+// Messages are shown on the banner when the connections cannot reach the devices. Empty ones are the default messages.
+type Messages struct {
+	InvalidSSHID      string `yaml:"invalid_sshid"`
+	ConnectionFailed  string `yaml:"connection_failed"`
+	ConnectionTimeout string `yaml:"connection_timeout"`
+	AccessDenied      string `yaml:"access_denied"`
+	ConnectionLimit   string `yaml:"connection_limit"`
+}
+
+// Default returns the configuration used when there is neither file nor environment variables.
+func Default() *Config {
+	defaults := yamux.DefaultConfig()
+
+	return &Config{
+		HTTPAddress:        DefaultHTTPAddress,
+		SSHAddress:         DefaultSSHAddress,
+		ConnectTimeout:     DefaultConnectTimeout,
+		LogLevel:           log.InfoLevel.String(),
+		LocalPortForward:   true,
+		BruteForce:         guard.Default(),
+		ReversePortForward: ReversePortForward{Enabled: true},
+		DeviceAcceptance:   AcceptanceAuto,
+		ShutdownDrain:      DefaultShutdownDrain,
+		Socks:              Socks{Domain: socks.Domain},
+		Yamux: Yamux{
+			AcceptBacklog:          defaults.AcceptBacklog,
+			KeepAliveInterval:      defaults.KeepAliveInterval,
+			ConnectionWriteTimeout: defaults.ConnectionWriteTimeout,
+			StreamOpenTimeout:      defaults.StreamOpenTimeout,
+			MaxStreamWindowSize:    defaults.MaxStreamWindowSize,
+		},
+	}
+}
+
+// Load loads the configuration from the file at path, when set, overrides it with the environment variables got from
+// lookup and validates it.
+func Load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	for name, override := range config.overrides() {
+		if value, ok := lookup(name); ok && value != "" {
+			if err := override(value); err != nil {
+				return nil, fmt.Errorf("%w %s=%q", ErrInvalidEnv, name, value)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// overrides are the environment variables that override the settings, with the functions that parse them.
+func (c *Config) overrides() map[string]func(string) error {
+	text := func(setting *string) func(string) error {
+		return func(value string) error {
+			*setting = value
+
+			return nil
+		}
+	}
+
+	boolean := func(setting *bool) func(string) error {
+		return func(value string) (err error) {
+			*setting, err = strconv.ParseBool(value)
+
+			return err
+		}
+	}
+
+	duration := func(setting *time.Duration) func(string) error {
+		return func(value string) (err error) {
+			*setting, err = time.ParseDuration(value)
+
+			return err
+		}
+	}
+
+	return map[string]func(string) error{
+		"HTTP_ADDRESS":                   text(&c.HTTPAddress),
+		"SSH_ADDRESS":                    text(&c.SSHAddress),
+		"PROXY_PROTOCOL":                 boolean(&c.ProxyProtocol.Enabled),
+		"PROXY_PROTOCOL_TRUSTED":         text(&c.ProxyProtocol.Trusted),
+		"PROXY_PROTOCOL_VERSIONS":        text(&c.ProxyProtocol.Versions),
+		"PROXY_PROTOCOL_REQUIRED":        boolean(&c.ProxyProtocol.Required),
+		"CONNECT_TIMEOUT":                duration(&c.ConnectTimeout),
+		"STREAM_IDLE_TIMEOUT":            duration(&c.StreamIdleTimeout),
+		"LOG_LEVEL":                      text(&c.LogLevel),
+		"USERS_FILE":                     text(&c.UsersFile),
+		"LOCAL_PORT_FORWARD":             boolean(&c.LocalPortForward),
+		"REVERSE_PORT_FORWARD":           boolean(&c.ReversePortForward.Enabled),
+		"REVERSE_PORT_FORWARD_ADDRESSES": text(&c.ReversePortForward.Addresses),
+		"REVERSE_PORT_FORWARD_PORTS":     text(&c.ReversePortForward.Ports),
+		"YAMUX_KEEPALIVE_INTERVAL":       duration(&c.Yamux.KeepAliveInterval),
+		"YAMUX_STREAM_OPEN_TIMEOUT":      duration(&c.Yamux.StreamOpenTimeout),
+		"DEVICE_ACCEPTANCE":              text(&c.DeviceAcceptance),
+		"SSHID_FORMS":                    text(&c.SSHIDForms),
+		"AGENT_POOL_IDLE":                duration(&c.AgentPoolIdle),
+		"SHUTDOWN_DRAIN":                 duration(&c.ShutdownDrain),
+		"METRICS_ADDRESS":                text(&c.MetricsAddress),
+		"MAPPINGS_FILE":                  text(&c.MappingsFile),
+		"PUBLISH_PORTS":                  text(&c.PublishPorts),
+		"DEVICE_PORTS":                   text(&c.DevicePorts.Assignments),
+		"DEVICE_PORT_RANGE":              text(&c.DevicePorts.Range),
+		"CLUSTER_REGISTRY":               text(&c.Cluster.Registry),
+		"CLUSTER_NODE_ADDRESS":           text(&c.Cluster.NodeAddress),
+		"CLUSTER_SECRET":                 text(&c.Cluster.Secret),
+		"WEB_PROXY_ADDRESS":              text(&c.WebProxy.Address),
+		"WEB_PROXY_DOMAIN":               text(&c.WebProxy.Domain),
+		"WEB_PROXY_TLS_CERT":             text(&c.WebProxy.TLSCert),
+		"WEB_PROXY_TLS_KEY":              text(&c.WebProxy.TLSKey),
+		"SOCKS_ADDRESS":                  text(&c.Socks.Address),
+		"SOCKS_DOMAIN":                   text(&c.Socks.Domain),
+	}
+}
+
+// Validate checks every setting, returning all the errors found.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	// NOTE: The listeners other than the HTTP and SSH servers are disabled when their addresses are empty.
+	for i, address := range []string{c.HTTPAddress, c.SSHAddress, c.MetricsAddress, c.WebProxy.Address, c.Socks.Address} {
+		if i > 1 && address == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			errs = append(errs, fmt.Errorf("%w %q", ErrInvalidAddress, address))
+		}
+	}
+
+	for _, timeout := range []time.Duration{c.ConnectTimeout, c.StreamIdleTimeout, c.AgentPoolIdle, c.ShutdownDrain} {
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("%w %s", ErrInvalidTimeout, timeout))
+		}
+	}
+
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.Users(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to load the users file: %w", err))
+	}
+
+	if c.DeviceAcceptance != AcceptanceAuto && c.DeviceAcceptance != AcceptanceManual {
+		errs = append(errs, fmt.Errorf("%w, not %q", ErrInvalidMode, c.DeviceAcceptance))
+	}
+
+	if _, err := c.Forms(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.Mappings(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to load the mappings file: %w", err))
+	}
+
+	if c.PublishPorts != "" {
+		if _, _, err := ports.ParseRange(c.PublishPorts); err != nil {
+			errs = append(errs, fmt.Errorf("publish_ports: %w", err))
+		}
+	}
+
+	if _, err := c.DevicePortAssignments(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.DevicePorts.Range != "" {
+		if _, _, err := ports.ParseRange(c.DevicePorts.Range); err != nil {
+			errs = append(errs, fmt.Errorf("device_ports: %w", err))
+		}
+	}
+
+	if _, err := c.ClusterRegistry(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Cluster.Registry != "" && (c.Cluster.NodeAddress == "" || c.Cluster.Secret == "") {
+		errs = append(errs, fmt.Errorf("%w: cluster needs the node address and the secret", ErrMissingSetting))
+	}
+
+	web := c.WebProxy
+	if web.Address != "" && (c.UsersFile == "" || strings.Trim(web.Domain, ".") == "" || web.TLSCert == "" ||
+		web.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("%w: web proxy needs the users file, the domain and the TLS certificate and key",
+			ErrMissingSetting))
+	}
+
+	if c.Socks.Address != "" && c.UsersFile == "" {
+		errs = append(errs, fmt.Errorf("%w: SOCKS5 proxy needs the users file", ErrMissingSetting))
+	}
+
+	if _, err := c.ProxyProtocolPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.ReversePortForwardPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := yamux.VerifyConfig(c.YamuxConfig()); err != nil {
+		errs = append(errs, fmt.Errorf("invalid yamux settings: %w", err))
+	}
+
+	for i := range c.Firewall {
+		if err := c.Firewall[i].Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i := range c.SessionLimits {
+		if err := c.SessionLimits[i].Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.Concurrency.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.BruteForce.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Level parses the level of the logs.
+func (c *Config) Level() (log.Level, error) {
+	return log.ParseLevel(c.LogLevel)
+}
+
+// Users loads the users of the users file, or returns nil when it is not set.
+func (c *Config) Users() (*users.Store, error) {
+	if c.UsersFile == "" {
+		return nil, nil
+	}
+
+	return users.Load(c.UsersFile)
+}
+
+// Forms parses the alternative forms of the SSHID.
+func (c *Config) Forms() ([]target.Form, error) {
+	return target.ParseForms(c.SSHIDForms)
+}
+
+// Mappings loads the port mappings of the mappings file, or returns nil when it is not set.
+func (c *Config) Mappings() ([]portmap.Mapping, error) {
+	if c.MappingsFile == "" {
+		return nil, nil
+	}
+
+	return portmap.Load(c.MappingsFile)
+}
+
+// DevicePortAssignments parses the explicit ports of the devices.
+func (c *Config) DevicePortAssignments() (map[string]int, error) {
+	return ports.ParseAssignments(c.DevicePorts.Assignments)
+}
+
+// ClusterRegistry opens the registry of the cluster, or returns nil when the server is not clustered.
+func (c *Config) ClusterRegistry() (cluster.Registry, error) {
+	if c.Cluster.Registry == "" {
+		return nil, nil
+	}
+
+	return cluster.Open(c.Cluster.Registry)
+}
+
+// ProxyProtocolPolicy parses the policy of the PROXY protocol header, or returns nil when it is disabled.
+func (c *Config) ProxyProtocolPolicy() (*proxyprotocol.Policy, error) {
+	if !c.ProxyProtocol.Enabled {
+		return nil, nil
+	}
+
+	return proxyprotocol.ParsePolicy(c.ProxyProtocol.Trusted, c.ProxyProtocol.Versions, c.ProxyProtocol.Required)
+}
+
+// ReversePortForwardPolicy parses the policy of reverse port forwarding, or returns nil when it is disabled.
+func (c *Config) ReversePortForwardPolicy() (*forward.Policy, error) {
+	if !c.ReversePortForward.Enabled {
+		return nil, nil
+	}
+
+	return forward.ParsePolicy(c.ReversePortForward.Addresses, c.ReversePortForward.Ports)
+}
+
+// YamuxConfig returns the configuration of the yamux sessions to the devices.
+func (c *Config) YamuxConfig() *yamux.Config {
+	config := yamux.DefaultConfig()
+	config.AcceptBacklog = c.Yamux.AcceptBacklog
+	config.KeepAliveInterval = c.Yamux.KeepAliveInterval
+	config.ConnectionWriteTimeout = c.Yamux.ConnectionWriteTimeout
+	config.StreamOpenTimeout = c.Yamux.StreamOpenTimeout
+	config.MaxStreamWindowSize = c.Yamux.MaxStreamWindowSize
+
+	return config
+}
+
+// Restart lists the settings that changed on next but are only applied after a restart.
+func (c *Config) Restart(next *Config) []string {
+	current, changed := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+
+	settings := []string{}
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			continue
+		}
+
+		if !reflect.DeepEqual(current.Field(i).Interface(), changed.Field(i).Interface()) {
+			settings = append(settings, field.Tag.Get("yaml"))
+		}
+	}
+
+	return settings
+}
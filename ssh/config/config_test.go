@@ -0,0 +1,224 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/firewall"
+	"github.com/shellhub-io/mini-shellhub/ssh/limits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		description string
+		file        string
+		env         map[string]string
+		expected    func(c *Config)
+		err         string
+	}{
+		{
+			description: "succeeds when file is empty",
+			file:        "",
+			expected:    func(*Config) {},
+		},
+		{
+			description: "succeeds when file sets the settings",
+			file: `
+ssh_address: "127.0.0.1:2200"
+proxy_protocol:
+  enabled: true
+  trusted: 10.0.0.0/8
+  versions: "2"
+connect_timeout: 5s
+stream_idle_timeout: 1h
+log_level: debug
+reverse_port_forward:
+  enabled: false
+yamux:
+  keepalive_interval: 10s
+firewall:
+  - name: office
+    action: allow
+    source: 10.0.0.0/8
+session_limits:
+  - device: "lab:*"
+    idle_timeout: 15m
+    max_duration: 8h
+messages:
+  access_denied: Ask the lab team.
+device_acceptance: manual
+sshid_forms: device+user
+agent_pool_idle: 30s
+shutdown_drain: 1m
+metrics_address: 127.0.0.1:9090
+publish_ports: 20000-20999
+device_ports:
+  assignments: default:DEVICE123=22017
+  range: 22000-22999
+cluster:
+  registry: redis://registry:6379/0
+  node_address: http://10.0.0.1:8080
+  secret: secret
+`,
+			expected: func(c *Config) {
+				c.SSHAddress = "127.0.0.1:2200"
+				c.ProxyProtocol = ProxyProtocol{Enabled: true, Trusted: "10.0.0.0/8", Versions: "2"}
+				c.ConnectTimeout = 5 * time.Second
+				c.StreamIdleTimeout = time.Hour
+				c.LogLevel = "debug"
+				c.ReversePortForward.Enabled = false
+				c.Yamux.KeepAliveInterval = 10 * time.Second
+				c.Firewall = []firewall.Rule{{Name: "office", Action: firewall.ActionAllow, Source: "10.0.0.0/8"}}
+				c.SessionLimits = []limits.Rule{{Device: "lab:*", IdleTimeout: 15 * time.Minute, MaxDuration: 8 * time.Hour}}
+				c.Messages.AccessDenied = "Ask the lab team."
+				c.DeviceAcceptance = AcceptanceManual
+				c.SSHIDForms = "device+user"
+				c.AgentPoolIdle = 30 * time.Second
+				c.ShutdownDrain = time.Minute
+				c.MetricsAddress = "127.0.0.1:9090"
+				c.PublishPorts = "20000-20999"
+				c.DevicePorts = DevicePorts{Assignments: "default:DEVICE123=22017", Range: "22000-22999"}
+				c.Cluster = Cluster{
+					Registry:    "redis://registry:6379/0",
+					NodeAddress: "http://10.0.0.1:8080",
+					Secret:      "secret",
+				}
+			},
+		},
+		{
+			description: "succeeds when environment overrides the file",
+			file:        "log_level: debug\nreverse_port_forward:\n  ports: 2000-3000\n",
+			env: map[string]string{
+				"LOG_LEVEL":              "warning",
+				"REVERSE_PORT_FORWARD":   "false",
+				"CONNECT_TIMEOUT":        "3s",
+				"PROXY_PROTOCOL":         "true",
+				"PROXY_PROTOCOL_TRUSTED": "10.0.0.5",
+				"SHUTDOWN_DRAIN":         "0s",
+				"DEVICE_PORT_RANGE":      "22000-22999",
+				"SOCKS_DOMAIN":           "lab",
+			},
+			expected: func(c *Config) {
+				c.LogLevel = "warning"
+				c.ReversePortForward = ReversePortForward{Enabled: false, Ports: "2000-3000"}
+				c.ConnectTimeout = 3 * time.Second
+				c.ProxyProtocol = ProxyProtocol{Enabled: true, Trusted: "10.0.0.5"}
+				c.ShutdownDrain = 0
+				c.DevicePorts.Range = "22000-22999"
+				c.Socks.Domain = "lab"
+			},
+		},
+		{
+			description: "fails when file has an unknown setting",
+			file:        "listen: :2222\n",
+			err:         "field listen not found",
+		},
+		{
+			description: "fails when environment variable is not parsable",
+			env:         map[string]string{"PROXY_PROTOCOL": "sometimes"},
+			err:         `invalid environment variable PROXY_PROTOCOL="sometimes"`,
+		},
+		{
+			description: "fails with every invalid setting",
+			file: "ssh_address: '2222'\nstream_idle_timeout: -1s\nproxy_protocol:\n  enabled: true\nlog_level: loud\n" +
+				"yamux:\n  accept_backlog: 0\nfirewall:\n  - action: drop\nsession_limits:\n  - name: lab\n" +
+				"    max_duration: -1h\nconcurrency:\n  agents_per_tenant: -1\nbrute_force:\n  deny: [10.0.0.0/33]\n" +
+				"device_acceptance: never\nsshid_forms: user@device\nagent_pool_idle: -1s\nmetrics_address: '9090'\n" +
+				"mappings_file: /nonexistent\npublish_ports: '20000'\ndevice_ports:\n  assignments: DEVICE123\n  range: 0-1\n" +
+				"cluster:\n  registry: http://registry\nweb_proxy:\n  address: :8443\nsocks:\n  address: :1080\n",
+			err: `invalid address "2222"
+invalid address "9090"
+invalid timeout -1s
+invalid timeout -1s
+not a valid logrus Level: "loud"
+invalid device acceptance; use "auto" or "manual", not "never"
+invalid SSHID form; use "user<separator>device" or "device<separator>user"
+failed to load the mappings file: open /nonexistent: no such file or directory
+publish_ports: invalid port range; use "<min>-<max>", like "22000-22999"
+invalid device ports; use "device=port,device=port"
+device_ports: invalid port range; use "<min>-<max>", like "22000-22999"
+unknown registry; use "redis://[user:password@]host:port[/db]" or "rediss://"
+missing setting: cluster needs the node address and the secret
+missing setting: web proxy needs the users file, the domain and the TLS certificate and key
+missing setting: SOCKS5 proxy needs the users file
+PROXY protocol needs the trusted load balancers
+invalid yamux settings: backlog must be positive
+invalid firewall action "drop" on rule ""
+invalid session limit duration on rule "lab"
+invalid concurrency cap -1
+invalid brute-force protection source "10.0.0.0/33"`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tc.file), 0o600))
+
+			config, err := Load(path, func(name string) (string, bool) {
+				value, ok := tc.env[name]
+
+				return value, ok
+			})
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			expected := Default()
+			tc.expected(expected)
+
+			// NOTE: The parsed sources of the firewall rules are internal, so only the fields are compared.
+			for i := range config.Firewall {
+				expected.Firewall[i].Validate() //nolint:errcheck
+			}
+
+			assert.Equal(t, expected, config)
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	cases := []struct {
+		description string
+		change      func(c *Config)
+		expected    []string
+	}{
+		{
+			description: "succeeds when only reloadable settings changed",
+			change: func(c *Config) {
+				c.LogLevel = "debug"
+				c.Firewall = []firewall.Rule{{Action: firewall.ActionDeny}}
+				c.Messages.InvalidSSHID = "Use user@device."
+			},
+			expected: []string{},
+		},
+		{
+			description: "succeeds when settings need a restart",
+			change: func(c *Config) {
+				c.SSHAddress = ":2200"
+				c.UsersFile = "/etc/users"
+				c.Yamux.AcceptBacklog = 10
+				c.Cluster.Secret = "rotated"
+				c.Socks.Address = ":1080"
+			},
+			expected: []string{"ssh_address", "users_file", "yamux", "cluster", "socks"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			next := Default()
+			tc.change(next)
+
+			assert.Equal(t, tc.expected, Default().Restart(next))
+		})
+	}
+}
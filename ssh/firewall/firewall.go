@@ -0,0 +1,137 @@
+// Package firewall decides which sessions reach the devices, through rules matched against the client's address, the
+// user on the device and the device.
+//
+// Rules are evaluated in order and the first one that matches decides. When no rule matches, the session is allowed.
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/match"
+)
+
+var (
+	ErrInvalidAction  = errors.New("invalid firewall action")
+	ErrInvalidSource  = errors.New("invalid firewall source")
+	ErrInvalidPattern = errors.New("invalid firewall pattern")
+)
+
+// Action is what a rule does with the sessions it matches.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+// DefaultRule is the name of the decision taken when no rule matches.
+const DefaultRule = "default"
+
+// Rule allows or denies the sessions matching all of its fields. Empty fields match everything.
+type Rule struct {
+	// Name identifies the rule on logs and metrics.
+	Name   string `yaml:"name"`
+	Action Action `yaml:"action"`
+	// Source is the client's address, either an IP, like "10.0.0.5", or a prefix, like "10.0.0.0/8".
+	Source string `yaml:"source"`
+	// Username is a pattern of the user on the device, like "root" or "dev-*".
+	Username string `yaml:"username"`
+	// Device is a pattern of the device, as "tenant:name", like "default:DEVICE123" or "lab:*".
+	Device string `yaml:"device"`
+
+	prefix netip.Prefix
+}
+
+// Validate checks the rule's action and fields, and parses its source.
+func (r *Rule) Validate() error {
+	if r.Action != ActionAllow && r.Action != ActionDeny {
+		return fmt.Errorf("%w %q on rule %q", ErrInvalidAction, r.Action, r.Name)
+	}
+
+	if r.Source != "" {
+		prefix, err := parseSource(r.Source)
+		if err != nil {
+			return fmt.Errorf("%w %q on rule %q", ErrInvalidSource, r.Source, r.Name)
+		}
+
+		r.prefix = prefix
+	}
+
+	if pattern, invalid := match.Invalid(r.Username, r.Device); invalid {
+		return fmt.Errorf("%w %q on rule %q", ErrInvalidPattern, pattern, r.Name)
+	}
+
+	return nil
+}
+
+func parseSource(source string) (netip.Prefix, error) {
+	if strings.Contains(source, "/") {
+		prefix, err := netip.ParsePrefix(source)
+
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(source)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (r *Rule) matches(source netip.Addr, username, device string) bool {
+	if r.Source != "" && !r.prefix.Contains(source.Unmap()) {
+		return false
+	}
+
+	return match.Session(r.Username, r.Device, username, device)
+}
+
+// Firewall keeps the rules evaluated on each session. Its rules can be replaced while sessions are evaluated.
+//
+// A nil Firewall allows every session.
+type Firewall struct {
+	rules match.Rules[Rule]
+}
+
+// New creates a [Firewall] with the rules, which must be valid.
+func New(rules []Rule) (*Firewall, error) {
+	firewall := &Firewall{}
+	if err := firewall.Set(rules); err != nil {
+		return nil, err
+	}
+
+	return firewall, nil
+}
+
+// Set replaces the rules of the firewall, keeping the current ones when any of the new ones is invalid.
+func (f *Firewall) Set(rules []Rule) error {
+	return f.rules.Set(rules, (*Rule).Validate)
+}
+
+// Evaluate decides whether the client at source reaches the device as the user, returning the name of the rule that
+// decided it. An unparsable source only matches the rules without one.
+func (f *Firewall) Evaluate(source, username, device string) (string, bool) {
+	if f == nil {
+		return DefaultRule, true
+	}
+
+	addr, _ := netip.ParseAddr(source)
+
+	i, rule, ok := f.rules.First(func(rule *Rule) bool {
+		return rule.matches(addr, username, device)
+	})
+	if !ok {
+		return DefaultRule, true
+	}
+
+	name := rule.Name
+	if name == "" {
+		name = fmt.Sprintf("rule-%d", i+1)
+	}
+
+	return name, rule.Action == ActionAllow
+}
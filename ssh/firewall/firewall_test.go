@@ -0,0 +1,137 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Name: "office", Action: ActionAllow, Source: "10.0.0.0/8", Device: "lab:*"},
+		{Name: "no-lab", Action: ActionDeny, Device: "lab:*"},
+		{Action: ActionDeny, Source: "192.168.1.10", Username: "root"},
+	}
+
+	cases := []struct {
+		description string
+		source      string
+		username    string
+		device      string
+		rule        string
+		allowed     bool
+	}{
+		{
+			description: "allows when source is in the prefix of the first rule",
+			source:      "10.1.2.3",
+			username:    "root",
+			device:      "lab:switch",
+			rule:        "office",
+			allowed:     true,
+		},
+		{
+			description: "denies when source is out of the prefix",
+			source:      "172.16.0.1",
+			username:    "root",
+			device:      "lab:switch",
+			rule:        "no-lab",
+			allowed:     false,
+		},
+		{
+			description: "denies when every field of an unnamed rule matches",
+			source:      "192.168.1.10",
+			username:    "root",
+			device:      "default:DEVICE123",
+			rule:        "rule-3",
+			allowed:     false,
+		},
+		{
+			description: "allows when the username does not match",
+			source:      "192.168.1.10",
+			username:    "alice",
+			device:      "default:DEVICE123",
+			rule:        DefaultRule,
+			allowed:     true,
+		},
+		{
+			description: "allows when source is IPv4-mapped",
+			source:      "::ffff:10.1.2.3",
+			username:    "root",
+			device:      "lab:switch",
+			rule:        "office",
+			allowed:     true,
+		},
+		{
+			description: "skips the rules with a source when source is unparsable",
+			source:      "unknown",
+			username:    "root",
+			device:      "lab:switch",
+			rule:        "no-lab",
+			allowed:     false,
+		},
+	}
+
+	firewall, err := New(rules)
+	require.NoError(t, err)
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			rule, allowed := firewall.Evaluate(tc.source, tc.username, tc.device)
+			assert.Equal(t, tc.rule, rule)
+			assert.Equal(t, tc.allowed, allowed)
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		description string
+		rules       []Rule
+		expected    error
+	}{
+		{
+			description: "succeeds when rules are valid",
+			rules:       []Rule{{Action: ActionDeny, Source: "::1", Username: "dev-*"}},
+			expected:    nil,
+		},
+		{
+			description: "fails when action is unknown",
+			rules:       []Rule{{Action: "drop"}},
+			expected:    ErrInvalidAction,
+		},
+		{
+			description: "fails when source is not an address",
+			rules:       []Rule{{Action: ActionDeny, Source: "10.0.0.0/33"}},
+			expected:    ErrInvalidSource,
+		},
+		{
+			description: "fails when pattern is malformed",
+			rules:       []Rule{{Action: ActionDeny, Device: "lab:["}},
+			expected:    ErrInvalidPattern,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			firewall, err := New([]Rule{{Name: "current", Action: ActionDeny}})
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, firewall.Set(tc.rules), tc.expected)
+
+			if tc.expected != nil {
+				rule, allowed := firewall.Evaluate("10.0.0.1", "root", "default:DEVICE123")
+				assert.Equal(t, "current", rule)
+				assert.False(t, allowed)
+			}
+		})
+	}
+}
+
+func TestNilFirewall(t *testing.T) {
+	var firewall *Firewall
+
+	rule, allowed := firewall.Evaluate("10.0.0.1", "root", "default:DEVICE123")
+	assert.Equal(t, DefaultRule, rule)
+	assert.True(t, allowed)
+}
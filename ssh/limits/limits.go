@@ -0,0 +1,92 @@
+// Package limits decides how long the sessions may last, through rules matched against the user on the device and the
+// device, so that a tenant, a device or a user gets its own idle timeout and maximum duration.
+//
+// Rules are evaluated in order and the first one that matches decides. When no rule matches, the session has no
+// limits.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/match"
+)
+
+var (
+	ErrInvalidPattern  = errors.New("invalid session limit pattern")
+	ErrInvalidDuration = errors.New("invalid session limit duration")
+)
+
+// Limit is how long a session may last. Zero durations mean no limit.
+type Limit struct {
+	// Idle is how long an interactive session may go without data flowing through it.
+	Idle time.Duration
+	// Max is how long any session may last.
+	Max time.Duration
+}
+
+// Rule sets the limit of the sessions matching all of its fields. Empty fields match everything.
+type Rule struct {
+	// Name identifies the rule on logs.
+	Name string `yaml:"name"`
+	// Username is a pattern of the user on the device, like "root" or "dev-*".
+	Username string `yaml:"username"`
+	// Device is a pattern of the device, as "tenant:name", like "default:DEVICE123" or "lab:*" for a whole tenant.
+	Device      string        `yaml:"device"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// Validate checks the rule's patterns and durations.
+func (r *Rule) Validate() error {
+	if pattern, invalid := match.Invalid(r.Username, r.Device); invalid {
+		return fmt.Errorf("%w %q on rule %q", ErrInvalidPattern, pattern, r.Name)
+	}
+
+	if r.IdleTimeout < 0 || r.MaxDuration < 0 {
+		return fmt.Errorf("%w on rule %q", ErrInvalidDuration, r.Name)
+	}
+
+	return nil
+}
+
+
+// Limits keeps the rules matched on each session. Its rules can be replaced while sessions are matched, but the
+// sessions keep the limit they got.
+//
+// A nil Limits has no limits.
+type Limits struct {
+	rules match.Rules[Rule]
+}
+
+// New creates a [Limits] with the rules, which must be valid.
+func New(rules []Rule) (*Limits, error) {
+	limits := &Limits{}
+	if err := limits.Set(rules); err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// Set replaces the rules, keeping the current ones when any of the new ones is invalid.
+func (l *Limits) Set(rules []Rule) error {
+	return l.rules.Set(rules, (*Rule).Validate)
+}
+
+// Match returns the limit of the sessions of the user on the device, as "tenant:name".
+func (l *Limits) Match(username, device string) Limit {
+	if l == nil {
+		return Limit{}
+	}
+
+	_, rule, ok := l.rules.First(func(rule *Rule) bool {
+		return match.Session(rule.Username, rule.Device, username, device)
+	})
+	if !ok {
+		return Limit{}
+	}
+
+	return Limit{Idle: rule.IdleTimeout, Max: rule.MaxDuration}
+}
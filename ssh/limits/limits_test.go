@@ -0,0 +1,102 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	rules := []Rule{
+		{Name: "root", Username: "root", Device: "lab:*", IdleTimeout: 5 * time.Minute},
+		{Name: "lab", Device: "lab:*", IdleTimeout: 15 * time.Minute, MaxDuration: 8 * time.Hour},
+		{Name: "device", Device: "default:DEVICE123", MaxDuration: time.Hour},
+	}
+
+	cases := []struct {
+		description string
+		username    string
+		device      string
+		expected    Limit
+	}{
+		{
+			description: "matches the user on the tenant",
+			username:    "root",
+			device:      "lab:switch",
+			expected:    Limit{Idle: 5 * time.Minute},
+		},
+		{
+			description: "matches the tenant when the user does not match",
+			username:    "alice",
+			device:      "lab:switch",
+			expected:    Limit{Idle: 15 * time.Minute, Max: 8 * time.Hour},
+		},
+		{
+			description: "matches the device",
+			username:    "root",
+			device:      "default:DEVICE123",
+			expected:    Limit{Max: time.Hour},
+		},
+		{
+			description: "has no limits when no rule matches",
+			username:    "root",
+			device:      "default:OTHER",
+			expected:    Limit{},
+		},
+	}
+
+	limits, err := New(rules)
+	require.NoError(t, err)
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, limits.Match(tc.username, tc.device))
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		description string
+		rules       []Rule
+		expected    error
+	}{
+		{
+			description: "succeeds when rules are valid",
+			rules:       []Rule{{Device: "lab:*", IdleTimeout: time.Minute}},
+			expected:    nil,
+		},
+		{
+			description: "fails when pattern is invalid",
+			rules:       []Rule{{Device: "lab:["}},
+			expected:    ErrInvalidPattern,
+		},
+		{
+			description: "fails when duration is negative",
+			rules:       []Rule{{MaxDuration: -time.Minute}},
+			expected:    ErrInvalidDuration,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			limits, err := New([]Rule{{Name: "current", MaxDuration: time.Hour}})
+			require.NoError(t, err)
+
+			err = limits.Set(tc.rules)
+			assert.ErrorIs(t, err, tc.expected)
+
+			if tc.expected != nil {
+				assert.Equal(t, Limit{Max: time.Hour}, limits.Match("root", "default:DEVICE123"))
+			}
+		})
+	}
+}
+
+func TestNilLimits(t *testing.T) {
+	var limits *Limits
+
+	assert.Equal(t, Limit{}, limits.Match("root", "default:DEVICE123"))
+}
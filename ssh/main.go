@@ -1,20 +1,52 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "flag"
     "fmt"
     "io"
+    "net"
     "net/http"
+    "os"
+    "os/signal"
     "sync"
+    "syscall"
+    "time"
 
     "github.com/gorilla/websocket"
     "github.com/hashicorp/yamux"
     "github.com/labstack/echo/v4"
+    "github.com/shellhub-io/mini-shellhub/pkg/guard"
+    "github.com/shellhub-io/mini-shellhub/pkg/tracing"
     "github.com/shellhub-io/mini-shellhub/pkg/yamuxws"
+    "github.com/shellhub-io/mini-shellhub/ssh/api"
+    "github.com/shellhub-io/mini-shellhub/ssh/cluster"
+    "github.com/shellhub-io/mini-shellhub/ssh/config"
+    "github.com/shellhub-io/mini-shellhub/ssh/audit"
+    "github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+    "github.com/shellhub-io/mini-shellhub/ssh/firewall"
+    "github.com/shellhub-io/mini-shellhub/ssh/limits"
+    "github.com/shellhub-io/mini-shellhub/ssh/metrics"
+    "github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+    "github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+    "github.com/shellhub-io/mini-shellhub/ssh/portmap"
+    "github.com/shellhub-io/mini-shellhub/ssh/publish"
     "github.com/shellhub-io/mini-shellhub/ssh/server"
+    "github.com/shellhub-io/mini-shellhub/ssh/server/ports"
+    "github.com/shellhub-io/mini-shellhub/ssh/services"
+    "github.com/shellhub-io/mini-shellhub/ssh/session"
+    "github.com/shellhub-io/mini-shellhub/ssh/socks"
+    "github.com/shellhub-io/mini-shellhub/ssh/web"
+    "github.com/shellhub-io/shellhub/pkg/models"
     log "github.com/sirupsen/logrus"
 )
 
-const ListenAddress = ":8080"
+// ClientConnectionPath is the WebSocket endpoint that carries the SSH connections of the clients.
+const ClientConnectionPath = "/ssh/websocket"
+
+// DeviceVersionHeader carries the version of the agent connecting the device.
+const DeviceVersionHeader = "X-Device-Version"
 
 var upgrader = websocket.Upgrader{
     ReadBufferSize:  1024,
@@ -27,16 +59,24 @@ var upgrader = websocket.Upgrader{
 // DeviceManager manages yamux sessions per device
 type DeviceManager struct {
     sessions map[string]*yamux.Session
-    mutex    sync.RWMutex
+    // devices keeps every device that has connected since the server started, so disconnected ones are still listed
+    // as offline.
+    devices map[string]*models.Device
+    mutex   sync.RWMutex
+
+    // RequireAcceptance sets new devices as pending until an admin accepts them. Otherwise, they are accepted when
+    // they connect for the first time.
+    RequireAcceptance bool
 }
 
 func NewDeviceManager() *DeviceManager {
     return &DeviceManager{
         sessions: make(map[string]*yamux.Session),
+        devices:  make(map[string]*models.Device),
     }
 }
 
-func (dm *DeviceManager) AddDevice(deviceID string, session *yamux.Session) {
+func (dm *DeviceManager) AddDevice(deviceID, version string, session *yamux.Session) {
     dm.mutex.Lock()
     defer dm.mutex.Unlock()
     
@@ -46,18 +86,84 @@ func (dm *DeviceManager) AddDevice(deviceID string, session *yamux.Session) {
     }
     
     dm.sessions[deviceID] = session
-    log.WithFields(log.Fields{"device": deviceID}).Info("device connected via yamux")
+
+    device, exists := dm.devices[deviceID]
+    if !exists {
+        tenant, name := target.SplitDevice(deviceID)
+
+        device = &models.Device{UID: deviceID, Name: name, TenantID: tenant, CreatedAt: time.Now()}
+        device.Status = models.DeviceStatusAccepted
+        if dm.RequireAcceptance {
+            device.Status = models.DeviceStatusPending
+        }
+
+        device.StatusUpdatedAt = device.CreatedAt
+        dm.devices[deviceID] = device
+    }
+
+    device.Online = true
+    device.LastSeen = time.Now()
+    device.Info = &models.DeviceInfo{Version: version}
+    log.WithFields(log.Fields{"device": deviceID, "version": version}).Info("device connected via yamux")
+}
+
+// Accepts checks if the device is accepted, or would be when it connects for the first time.
+func (dm *DeviceManager) Accepts(deviceID string) bool {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    device, exists := dm.devices[deviceID]
+    if !exists {
+        return !dm.RequireAcceptance
+    }
+
+    return device.Status == models.DeviceStatusAccepted
 }
 
-func (dm *DeviceManager) RemoveDevice(deviceID string) {
+// RemoveDevice removes the device's session when it is still the provided one, returning if it was. A device that
+// reconnected has its old session replaced on [DeviceManager.AddDevice] and must not be marked as offline when the old
+// one finishes.
+func (dm *DeviceManager) RemoveDevice(deviceID string, current *yamux.Session) bool {
     dm.mutex.Lock()
     defer dm.mutex.Unlock()
     
-    if session, exists := dm.sessions[deviceID]; exists {
+    session, exists := dm.sessions[deviceID]
+    if exists && session == current {
         session.Close()
         delete(dm.sessions, deviceID)
+
+        if device, ok := dm.devices[deviceID]; ok {
+            device.Online = false
+            device.LastSeen = time.Now()
+        }
         log.WithFields(log.Fields{"device": deviceID}).Info("device disconnected")
     }
+
+    return exists && session == current
+}
+
+// Disconnect closes the sessions of every connected device, marking them as offline, so their agents reconnect, like
+// to another server behind the same address. It returns the devices disconnected.
+func (dm *DeviceManager) Disconnect() []string {
+    dm.mutex.Lock()
+    defer dm.mutex.Unlock()
+
+    disconnected := make([]string, 0, len(dm.sessions))
+    for deviceID, session := range dm.sessions {
+        // NOTE: GoAway tells the agent to stop opening streams before the session is closed.
+        session.GoAway() //nolint:errcheck
+        session.Close()
+        delete(dm.sessions, deviceID)
+
+        if device, ok := dm.devices[deviceID]; ok {
+            device.Online = false
+            device.LastSeen = time.Now()
+        }
+
+        disconnected = append(disconnected, deviceID)
+    }
+
+    return disconnected
 }
 
 func (dm *DeviceManager) OpenStream(deviceID string) (io.ReadWriteCloser, error) {
@@ -68,54 +174,522 @@ func (dm *DeviceManager) OpenStream(deviceID string) (io.ReadWriteCloser, error)
     if !exists {
         return nil, fmt.Errorf("device %s not connected", deviceID)
     }
+
+    if device := dm.devices[deviceID]; device.Status != models.DeviceStatusAccepted {
+        return nil, fmt.Errorf("device %s is %s", deviceID, device.Status)
+    }
     
     return session.Open()
 }
 
+// SetDeviceStatus sets the status of a known device.
+func (dm *DeviceManager) SetDeviceStatus(deviceID string, status models.DeviceStatus) error {
+    dm.mutex.Lock()
+    defer dm.mutex.Unlock()
+
+    device, exists := dm.devices[deviceID]
+    if !exists {
+        return fmt.Errorf("device %s not found", deviceID)
+    }
+
+    // NOTE: A pending device accepted while connected is disconnected, so its agent reconnects as accepted and gets
+    // what pending devices are not given, like its port.
+    if session, connected := dm.sessions[deviceID]; connected && device.Status != models.DeviceStatusAccepted && status == models.DeviceStatusAccepted {
+        session.GoAway() //nolint:errcheck
+        session.Close()
+    }
+
+    device.Status = status
+    device.StatusUpdatedAt = time.Now()
+
+    return nil
+}
+
+// SetDeviceTags replaces the tags of a known device.
+func (dm *DeviceManager) SetDeviceTags(deviceID string, tags []string) error {
+    dm.mutex.Lock()
+    defer dm.mutex.Unlock()
+
+    device, exists := dm.devices[deviceID]
+    if !exists {
+        return fmt.Errorf("device %s not found", deviceID)
+    }
+
+    device.Tags = append([]string{}, tags...)
+
+    return nil
+}
+
+// Devices lists every known device with its online status.
+func (dm *DeviceManager) Devices() []models.Device {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    devices := make([]models.Device, 0, len(dm.devices))
+    for _, device := range dm.devices {
+        devices = append(devices, *device)
+    }
+
+    return devices
+}
+
+// Streams counts the yamux streams open to the connected devices.
+func (dm *DeviceManager) Streams() int {
+    dm.mutex.RLock()
+    defer dm.mutex.RUnlock()
+
+    streams := 0
+    for _, session := range dm.sessions {
+        streams += session.NumStreams()
+    }
+
+    return streams
+}
+
 func init() {
     log.SetFormatter(&log.JSONFormatter{})
 }
 
 // main starts the SSH server with yamux-based device connections
 func main() {
+    path := flag.String("config", os.Getenv("CONFIG_FILE"), "configuration file (YAML), overridden by the environment variables")
+    check := flag.Bool("check-config", false, "check the configuration and exit")
+    flag.Parse()
+
+    cfg, err := config.Load(*path, os.LookupEnv)
+    if *check {
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+
+        fmt.Println("configuration is valid")
+        os.Exit(0)
+    }
+
+    if err != nil {
+        log.WithError(err).Fatal("failed to load the configuration")
+    }
+
+    level, _ := cfg.Level()
+    log.SetLevel(level)
+
+    // NOTE: With OTEL_EXPORTER_OTLP_ENDPOINT, like "http://collector:4318", the spans of the connections are exported to
+    // that OpenTelemetry collector, continued by the agents' spans.
+    shutdown, err := tracing.Setup(context.Background(), "shellhub-ssh")
+    if err != nil {
+        log.WithError(err).Fatal("failed to set up the tracing")
+    }
+
+    defer shutdown(context.Background()) //nolint:errcheck
+
     deviceManager := NewDeviceManager()
+    // NOTE: With the device acceptance set to "manual", new devices wait for an admin to accept them.
+    deviceManager.RequireAcceptance = cfg.DeviceAcceptance == config.AcceptanceManual
+
+    // NOTE: Without a users file, the server runs in test mode, where the admin shell and API are disabled.
+    store, err := cfg.Users()
+    if err != nil {
+        log.WithError(err).WithField("path", cfg.UsersFile).Fatal("failed to load the users file")
+    }
+
+    // NOTE: The PROXY protocol header is only read when enabled on the configuration, and only from the trusted load
+    // balancers, so the clients can't spoof their addresses.
+    proxyProtocol, err := cfg.ProxyProtocolPolicy()
+    if err != nil {
+        log.WithError(err).Fatal("failed to parse the PROXY protocol policy")
+    }
+
+    // NOTE: Reverse port forwarding is enabled unless disabled on the configuration. The allowed bind addresses and
+    // ports default to the loopback interface and unprivileged ports.
+    reverse, err := cfg.ReversePortForwardPolicy()
+    if err != nil {
+        log.WithError(err).Fatal("failed to parse the reverse port forwarding policy")
+    }
+
+    rules, err := firewall.New(cfg.Firewall)
+    if err != nil {
+        log.WithError(err).Fatal("failed to load the firewall rules")
+    }
+
+    sessionLimits, err := limits.New(cfg.SessionLimits)
+    if err != nil {
+        log.WithError(err).Fatal("failed to load the session limits")
+    }
+
+    limiter, err := concurrency.New(cfg.Concurrency)
+    if err != nil {
+        log.WithError(err).Fatal("failed to load the concurrency caps")
+    }
+
+    bruteForce, err := guard.New(cfg.BruteForce)
+    if err != nil {
+        log.WithError(err).Fatal("failed to load the brute-force protection")
+    }
+
+    events := audit.NewLog()
+
+    // NOTE: The users authenticating on the API and the proxies are locked out like on the SSH server.
+    logins := &users.Guard{Lockouts: bruteForce, Audit: events}
+
+    // NOTE: The alternative forms of the SSHID, like "device+user,user%device", are for clients that cannot handle
+    // the "@" between the user and the device.
+    forms, err := cfg.Forms()
+    if err != nil {
+        log.WithError(err).Fatal("failed to parse the SSHID forms")
+    }
+
+    // NOTE: With the agent pool's idle time, like "30s", the connections authenticated on the agents are kept for that
+    // long after the last client connection using them is done, so bursts of short connections skip the SSH handshake.
+    var pool *session.Pool
+    if cfg.AgentPoolIdle > 0 {
+        pool = session.NewPool(cfg.AgentPoolIdle)
+    }
+
+    // NOTE: With a cluster registry, like "redis://registry:6379/0", the server is a node of a cluster, reaching the
+    // devices connected to the other nodes through them. The nodes reach each other on the node address, like
+    // "http://10.0.0.1:8080", signing the streams with the cluster's secret.
+    var node *cluster.Node
+    var devices server.DeviceManager = deviceManager
+    // NOTE: The admin API and shell list the devices of the whole cluster, but each node keeps the status and tags of
+    // its own devices.
+    var inventory services.DeviceStore = deviceManager
+    registry, err := cfg.ClusterRegistry()
+    if err != nil {
+        log.WithError(err).Fatal("failed to open the cluster's registry")
+    }
+
+    if registry != nil {
+        node = cluster.New(deviceManager, registry, cfg.Cluster.NodeAddress, cfg.Cluster.Secret)
+        devices = node
+        inventory = cluster.NewView(node, deviceManager)
+
+        go node.Run()
+    }
+
+    // Create tunnel wrapper for device manager
+    tunnel := server.NewDeviceManagerTunnel(devices, cfg.ConnectTimeout, cfg.StreamIdleTimeout)
+
+    // NOTE: The port mappings of the mappings file are started with the server; others can be added through the admin
+    // API and shell, but are not kept after a restart.
+    mappings := portmap.NewManager(tunnel)
+    loaded, err := cfg.Mappings()
+    if err != nil {
+        log.WithError(err).WithField("path", cfg.MappingsFile).Fatal("failed to load the mappings file")
+    }
+
+    for _, mapping := range loaded {
+        if _, err := mappings.Add(mapping); err != nil {
+            log.WithError(err).WithField("mapping", mapping.Name).Fatal("failed to start the port mapping")
+        }
+    }
+
+    // NOTE: The services published by the agents are served on the publish ports, like "20000-20999", so publications
+    // are disabled without them. Their port mappings are kept apart from the ones of the admins, which can neither
+    // remove them nor take their names.
+    var publisher *publish.Publisher
+    if cfg.PublishPorts != "" {
+        low, high, err := ports.ParseRange(cfg.PublishPorts)
+        if err != nil {
+            log.WithError(err).Fatal("failed to parse the publish ports")
+        }
+
+        publisher = publish.New(portmap.NewManager(tunnel), low, high)
+    }
+
+    // NOTE: The devices get a dedicated SSH port, like `ssh -p 22017 root@server`, either explicitly, like
+    // "default:DEVICE123=22017", or from the device port range, like "22000-22999", when they connect. Without both,
+    // the devices are only reached through the SSHID.
+    var sshServer *server.Server
+    var devicePorts *ports.Manager
+    explicit, err := cfg.DevicePortAssignments()
+    if err != nil {
+        log.WithError(err).Fatal("failed to parse the device ports")
+    }
+
+    if value := cfg.DevicePorts.Range; value != "" || len(explicit) > 0 {
+        var low, high int
+        if value != "" {
+            if low, high, err = ports.ParseRange(value); err != nil {
+                log.WithError(err).Fatal("failed to parse the device port range")
+            }
+        }
+
+        devicePorts = ports.NewManager(func(listener net.Listener, device string) error {
+            return sshServer.ServeDevice(listener, device)
+        }, low, high)
+    }
+
+    sessions := session.NewRegistry()
+    service := services.New(inventory, sessions, mappings, publisher, devicePorts, events, bruteForce)
     
+    sshServer = server.NewServer(&server.Options{
+        Address:                      cfg.SSHAddress,
+        ProxyProtocol:                proxyProtocol,
+        ConnectTimeout:               cfg.ConnectTimeout,
+        AllowPublickeyAccessBelow060: false,
+        Users:                        store,
+        Sessions:                     sessions,
+        Service:                      adminService(store, service),
+        ReversePortForward:           reverse,
+        LocalPortForward:             cfg.LocalPortForward,
+        Firewall:                     rules,
+        Limits:                       sessionLimits,
+        Concurrency:                  limiter,
+        Guard:                        bruteForce,
+        Audit:                        events,
+        Messages:                     server.Messages(cfg.Messages),
+        SSHIDForms:                   forms,
+        AgentPool:                    pool,
+    }, tunnel)
+
+    for device, port := range explicit {
+        if err := devicePorts.Listen(device, port); err != nil {
+            log.WithError(err).WithFields(log.Fields{"device": device, "port": port}).Fatal("failed to listen on the port of the device")
+        }
+    }
+
     // Setup Echo router
     e := echo.New()
     e.HideBanner = true
     
+    // NOTE: The yamux settings only apply after a restart, so they are bound to the configuration loaded at startup,
+    // not to the one replaced on reloads.
+    yamuxConfig := cfg.YamuxConfig
+
     // WebSocket endpoint for device connections
     e.GET("/ssh/connection", func(c echo.Context) error {
-        return handleDeviceConnection(c, deviceManager)
+        return handleDeviceConnection(c, deviceManager, node, publisher, devicePorts, yamuxConfig(), limiter, events)
+    })
+
+    if node != nil {
+        e.GET(cluster.StreamPath, echo.WrapHandler(node))
+    }
+
+    // NOTE: Clients behind networks that block the SSH port reach the SSH server through a WebSocket, like with the
+    // ssh-ws helper as the ProxyCommand of OpenSSH.
+    e.GET(ClientConnectionPath, func(c echo.Context) error {
+        return handleClientConnection(c, sshServer)
     })
+
+    api.Register(e, service, store, logins)
+
+    // NOTE: The metrics of the devices are of the ones connected to this server, even when it is a node of a cluster.
+    metrics.Registry.MustRegister(metrics.Devices(deviceManager.Devices), metrics.Streams(deviceManager.Streams))
     
+    // NOTE: On SIGTERM or SIGINT, the server stops accepting connections and waits the shutdown drain, like "1m", for
+    // the active sessions to finish before closing them and the devices' sessions.
+    drain := cfg.ShutdownDrain
+
     errs := make(chan error)
     
     // Start HTTP server
+    httpAddress := cfg.HTTPAddress
     go func() {
-        errs <- e.Start(ListenAddress)
+        errs <- e.Start(httpAddress)
     }()
     
-    // Create tunnel wrapper for device manager
-    tunnel := server.NewDeviceManagerTunnel(deviceManager)
-    
+    var webProxy *echo.Echo
+
+    // NOTE: The web proxy listens on its address, serving HTTPS with its TLS certificate and key on the hosts of its
+    // domain. It authenticates the users of the users file, so the configuration requires them.
+    if proxy := cfg.WebProxy; proxy.Address != "" {
+        webProxy, err = web.New(tunnel, store, logins, proxy.Domain)
+        if err != nil {
+            log.WithError(err).Fatal("failed to set up the web proxy")
+        }
+
+        go func() {
+            errs <- startWebProxy(webProxy, proxy.Address, proxy.TLSCert, proxy.TLSKey)
+        }()
+    }
+
+    // NOTE: The metrics are served on the metrics address only when it is set, apart from the HTTP router reachable by
+    // the devices and the clients.
+    if address := cfg.MetricsAddress; address != "" {
+        go func() {
+            log.WithField("addr", address).Info("metrics listening")
+
+            errs <- metrics.ListenAndServe(address)
+        }()
+    }
+
+    // NOTE: The SOCKS5 proxy listens on its address and authenticates the users of the users file, so the
+    // configuration requires them.
+    if proxy := cfg.Socks; proxy.Address != "" {
+        // NOTE: The SOCKS5 proxy is local port forwarding without an SSH session, so it follows the same policy.
+        localPortForward := cfg.LocalPortForward
+        allow := func(host string, port uint32) bool {
+            return localPortForward && server.AllowLocalPortForwarding(host, port)
+        }
+
+        go func() {
+            errs <- socks.New(tunnel, store, logins, proxy.Domain, allow).ListenAndServe(proxy.Address)
+        }()
+    }
+
     // Start SSH server with yamux support
     go func() {
-        errs <- server.NewServer(&server.Options{
-            ConnectTimeout:               0,
-            AllowPublickeyAccessBelow060: false,
-        }, tunnel).ListenAndServe()
+        errs <- sshServer.ListenAndServe()
     }()
     
-    if err := <-errs; err != nil {
+    // NOTE: On SIGHUP, the configuration is loaded again and its reloadable settings, the log level, the users, the
+    // firewall rules, the session limits, the concurrency caps, the brute-force protection and the messages, are
+    // applied without dropping the sessions. The other ones need a restart.
+    //
+    // NOTE: The current configuration keeps the reloadable settings that were applied, and the other ones as started,
+    // so the next reload compares against what is running.
+    reload := func() {
+        next, err := config.Load(*path, os.LookupEnv)
+        if err != nil {
+            log.WithError(err).Error("failed to reload the configuration; keeping the current one")
+
+            return
+        }
+
+        if settings := cfg.Restart(next); len(settings) > 0 {
+            log.WithField("settings", settings).Warn("changed settings only apply after a restart")
+        }
+
+        applied := *cfg
+
+        level, _ := next.Level()
+        log.SetLevel(level)
+        applied.LogLevel = next.LogLevel
+
+        if store.Enabled() && next.UsersFile == cfg.UsersFile {
+            loaded, err := next.Users()
+            if err != nil {
+                log.WithError(err).Error("failed to reload the users file; keeping the current users")
+            } else {
+                store.Replace(loaded)
+            }
+        }
+
+        if err := rules.Set(next.Firewall); err != nil {
+            log.WithError(err).Error("failed to reload the firewall rules; keeping the current ones")
+        } else {
+            applied.Firewall = next.Firewall
+        }
+
+        if err := sessionLimits.Set(next.SessionLimits); err != nil {
+            log.WithError(err).Error("failed to reload the session limits; keeping the current ones")
+        } else {
+            applied.SessionLimits = next.SessionLimits
+        }
+
+        if err := limiter.Set(next.Concurrency); err != nil {
+            log.WithError(err).Error("failed to reload the concurrency caps; keeping the current ones")
+        } else {
+            applied.Concurrency = next.Concurrency
+        }
+
+        if err := bruteForce.Set(next.BruteForce); err != nil {
+            log.WithError(err).Error("failed to reload the brute-force protection; keeping the current one")
+        } else {
+            applied.BruteForce = next.BruteForce
+        }
+
+        sshServer.SetMessages(server.Messages(next.Messages))
+        applied.Messages = next.Messages
+
+        cfg = &applied
+
+        log.WithField("path", *path).Info("configuration reloaded")
+    }
+
+    hangups := make(chan os.Signal, 1)
+    signal.Notify(hangups, syscall.SIGHUP)
+
+    go func() {
+        for range hangups {
+            reload()
+        }
+    }()
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+    select {
+    case err := <-errs:
         log.WithError(err).Fatal("fatal error from HTTP or SSH server")
+    case sig := <-signals:
+        log.WithFields(log.Fields{"signal": sig.String(), "drain": drain.String()}).Info("shutting down")
     }
-    
+
+    ctx, cancel := context.WithTimeout(context.Background(), drain)
+    defer cancel()
+
+    // NOTE: Echo's shutdown doesn't wait for the WebSocket connections, already hijacked from it, so the clients' SSH
+    // connections are drained by the SSH server and the devices' sessions are kept for them until they are done.
+    if err := e.Shutdown(ctx); err != nil {
+        log.WithError(err).Warn("failed to shut down the HTTP server")
+    }
+
+    if webProxy != nil {
+        if err := webProxy.Shutdown(ctx); err != nil {
+            log.WithError(err).Warn("failed to shut down the web proxy")
+        }
+    }
+
+    if err := sshServer.Shutdown(ctx); err != nil {
+        log.WithError(err).Warn("closed the sessions still active after the drain period")
+    }
+
+    for _, deviceID := range deviceManager.Disconnect() {
+        node.Unregister(deviceID)
+    }
+
     log.Warn("ssh service is closed")
 }
 
+// adminService returns the service for the admin shell, which is disabled when users are not enabled.
+func adminService(store *users.Store, service *services.Service) *services.Service {
+    if !store.Enabled() {
+        return nil
+    }
+
+    return service
+}
+
+// startWebProxy starts the web proxy on address, with TLS when the certificate and the key are set.
+func startWebProxy(e *echo.Echo, address, cert, key string) error {
+    log.WithField("addr", address).Info("web proxy listening")
+
+    return e.StartTLS(address, cert, key)
+}
+
+// handleClientConnection handles the WebSocket upgrade of a client and serves the SSH connection carried by it.
+func handleClientConnection(c echo.Context, sshServer *server.Server) error {
+    conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+    if err != nil {
+        log.WithError(err).Error("failed to upgrade websocket")
+        return err
+    }
+    defer conn.Close()
+
+    log.WithField("remote", conn.RemoteAddr().String()).Debug("ssh connection over websocket")
+
+    sshServer.HandleConn(yamuxws.NewWSConn(conn))
+
+    return nil
+}
+
+// remoteIP gets the IP address the request comes from. Headers like X-Forwarded-For are ignored, as any client can set
+// them.
+func remoteIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+
+    return host
+}
+
 // handleDeviceConnection handles WebSocket upgrade and yamux session creation
-func handleDeviceConnection(c echo.Context, dm *DeviceManager) error {
+//
+// An accepted agent over the cap of agents of its tenant is rejected before the upgrade, with an audit event.
+func handleDeviceConnection(c echo.Context, dm *DeviceManager, node *cluster.Node, publisher *publish.Publisher, devicePorts *ports.Manager, config *yamux.Config, limiter *concurrency.Limiter, events *audit.Log) error {
     // Get device ID from header
     deviceID := c.Request().Header.Get("X-Device-ID")
     if deviceID == "" {
@@ -124,6 +698,33 @@ func handleDeviceConnection(c echo.Context, dm *DeviceManager) error {
     if deviceID == "" {
         return c.String(http.StatusBadRequest, "missing X-Device-ID header")
     }
+
+    // NOTE: The device ID is either `tenant:device` or `device`, when the tenant is the default one; the device is
+    // known by the server with its tenant, like the SSHIDs, the firewall and the caps refer to it.
+    deviceID = target.DeviceUID(deviceID)
+    tenant, name := target.SplitDevice(deviceID)
+
+    // NOTE: Anyone can connect as any device, so only the accepted ones count on the cap of agents of their tenant.
+    // Otherwise, made-up devices would fill the tenant's cap and keep its devices out.
+    if dm.Accepts(deviceID) {
+        release, err := limiter.AcquireAgent(tenant, tenant+":"+name)
+        if err != nil {
+            log.WithError(err).WithFields(log.Fields{"device": deviceID, "tenant": tenant}).Warn("too many agents on the tenant")
+
+            var limit *concurrency.Error
+            if errors.As(err, &limit) {
+                events.Record(audit.Event{Type: audit.TypeAgentRejected, Reason: limit.Reason(), Device: tenant + ":" + name, IPAddress: remoteIP(c.Request())})
+            }
+
+            return c.String(http.StatusTooManyRequests, err.Error())
+        }
+        defer release()
+    }
+
+    published, err := publish.Parse(c.Request().Header.Get(publish.Header))
+    if err != nil {
+        log.WithError(err).WithField("device", deviceID).Warn("ignoring the services published by the device")
+    }
     
     // Upgrade to WebSocket
     conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
@@ -135,7 +736,7 @@ func handleDeviceConnection(c echo.Context, dm *DeviceManager) error {
     
     // Create yamux session
     wsConn := yamuxws.NewWSConn(conn)
-    session, err := yamux.Server(wsConn, yamux.DefaultConfig())
+    session, err := yamux.Server(wsConn, config)
     if err != nil {
         log.WithError(err).Error("failed to create yamux session")
         return err
@@ -143,8 +744,23 @@ func handleDeviceConnection(c echo.Context, dm *DeviceManager) error {
     defer session.Close()
     
     // Register device
-    dm.AddDevice(deviceID, session)
-    defer dm.RemoveDevice(deviceID)
+    dm.AddDevice(deviceID, c.Request().Header.Get(DeviceVersionHeader), session)
+    defer func() {
+        if dm.RemoveDevice(deviceID, session) {
+            devicePorts.Release(deviceID)
+        }
+
+        node.Unregister(deviceID)
+    }()
+
+    node.Register(deviceID)
+
+    // NOTE: Anyone can connect as any device, so only the accepted ones get the server's ports. A pending device gets
+    // them when it reconnects after being accepted.
+    if dm.Accepts(deviceID) {
+        publisher.Publish(deviceID, published)
+        devicePorts.Assign(deviceID)
+    }
     
     // Keep session alive until it closes
     <-session.CloseChan()
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// agent connects a yamux session of a device's agent to a server one, returning both.
+func agent(t *testing.T) (*yamux.Session, *yamux.Session) {
+	t.Helper()
+
+	server, client := net.Pipe()
+
+	serverSession, err := yamux.Server(server, nil)
+	require.NoError(t, err)
+
+	clientSession, err := yamux.Client(client, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		serverSession.Close()
+		clientSession.Close()
+	})
+
+	return serverSession, clientSession
+}
+
+func TestDeviceManagerDisconnect(t *testing.T) {
+	cases := []struct {
+		description string
+		devices     []string
+		expected    []string
+	}{
+		{
+			description: "disconnects nothing when no device is connected",
+			devices:     []string{},
+			expected:    []string{},
+		},
+		{
+			description: "disconnects every connected device",
+			devices:     []string{"default:DEVICE123", "lab:switch"},
+			expected:    []string{"default:DEVICE123", "lab:switch"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			dm := NewDeviceManager()
+
+			agents := make([]*yamux.Session, 0, len(tc.devices))
+			for _, device := range tc.devices {
+				server, client := agent(t)
+				dm.AddDevice(device, "0.1.0", server)
+
+				agents = append(agents, client)
+			}
+
+			assert.ElementsMatch(t, tc.expected, dm.Disconnect())
+
+			for _, device := range dm.Devices() {
+				assert.False(t, device.Online, device.UID)
+			}
+
+			// NOTE: The agents see their sessions closed, so they reconnect.
+			for _, client := range agents {
+				assert.Eventually(t, client.IsClosed, 5*time.Second, 10*time.Millisecond)
+			}
+
+			_, err := dm.OpenStream("default:DEVICE123")
+			assert.Error(t, err)
+		})
+	}
+}
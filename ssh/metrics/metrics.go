@@ -0,0 +1,227 @@
+// Package metrics keeps the metrics of the server, scraped by Prometheus from [Registry] on the server's metrics
+// listener.
+//
+// NOTE: The metrics are served on their own listener, not on the server's HTTP router, which is reachable by the
+// devices and the clients, so they are only exposed where the operator binds it, like on a private network.
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/shellhub/pkg/models"
+)
+
+// Path is the endpoint of the metrics listener that serves [Registry].
+const Path = "/metrics"
+
+// Registry keeps the metrics of the server.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// Sessions are the SSH sessions open on the devices, by the type of the first request that started a program, like
+	// "shell", "exec" or "subsystem".
+	Sessions = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shellhub_ssh_sessions",
+		Help: "SSH sessions open on the devices.",
+	}, []string{"type"})
+	// SessionsTotal are the SSH sessions started on the devices, by type.
+	SessionsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_ssh_sessions_total",
+		Help: "SSH sessions started on the devices.",
+	}, []string{"type"})
+	// Authentications are the authentication attempts of the clients, by method, kind of connection and result.
+	Authentications = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_ssh_authentications_total",
+		Help: "Authentication attempts of the SSH clients.",
+	}, []string{"method", "kind", "result"})
+	// DialDuration is the time taken to open a stream to a device, by result.
+	DialDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shellhub_dial_duration_seconds",
+		Help:    "Time taken to open a stream to a device.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+	// RelayedBytes are the bytes relayed between the clients and the devices, by relay and direction, "upstream" from
+	// the client to the device or "downstream" back.
+	RelayedBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_relayed_bytes_total",
+		Help: "Bytes relayed between the SSH clients and the devices.",
+	}, []string{"relay", "direction"})
+	// FirewallDecisions are the decisions of the rules that allow or deny the connections, by rule and decision.
+	FirewallDecisions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_firewall_decisions_total",
+		Help: "Decisions of the rules allowing or denying the connections.",
+	}, []string{"rule", "decision"})
+	// Timeouts are the operations on the streams to the devices that did not finish within the connect timeout, and
+	// the streams closed after the idle timeout, by stage.
+	Timeouts = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shellhub_timeouts_total",
+		Help: "Operations on the streams to the devices that timed out.",
+	}, []string{"stage"})
+)
+
+const (
+	// StageOpen is the opening of the stream to the device and of its connection to the address, through the device.
+	StageOpen = "open"
+	// StageHandshake is the SSH handshake and authentication on the agent.
+	StageHandshake = "handshake"
+	// StageChannel is the opening of a channel on the agent.
+	StageChannel = "channel"
+	// StageIdle is a stream without data either way for the idle timeout.
+	StageIdle = "idle"
+)
+
+const (
+	// RelayPipe is the relay of the session channels, which may be recorded.
+	RelayPipe = "pipe"
+	// RelayHose is the relay of the channels of agent and X11 forwarding.
+	RelayHose = "hose"
+	// RelayDirectTCPIP is the relay of the direct-tcpip channels, of local port forwarding.
+	RelayDirectTCPIP = "direct-tcpip"
+	// RelayJump is the relay of the jump host connections to the agents' SSH servers.
+	RelayJump = "jump"
+)
+
+const (
+	// Upstream is the direction from the client to the device.
+	Upstream = "upstream"
+	// Downstream is the direction from the device to the client.
+	Downstream = "downstream"
+)
+
+// result labels a boolean outcome.
+func result(ok bool, yes, no string) string {
+	if ok {
+		return yes
+	}
+
+	return no
+}
+
+// Authenticated records an authentication attempt with the method, like "password", on a connection of the kind, and
+// returns whether it succeeded.
+func Authenticated(method, kind string, succeeded bool) bool {
+	Authentications.WithLabelValues(method, kind, result(succeeded, "success", "failure")).Inc()
+
+	return succeeded
+}
+
+// Decided records the decision of the firewall's rule and returns whether it allowed the connection.
+func Decided(rule string, allowed bool) bool {
+	FirewallDecisions.WithLabelValues(rule, result(allowed, "allow", "deny")).Inc()
+
+	return allowed
+}
+
+// Dialed records the time taken to open a stream to a device since started, and whether it failed.
+func Dialed(started time.Time, err error) {
+	DialDuration.WithLabelValues(result(err == nil, "success", "failure")).Observe(time.Since(started).Seconds())
+}
+
+// counter counts the bytes written to a writer.
+type counter struct {
+	io.Writer
+	relay     string
+	direction string
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	if n > 0 {
+		RelayedBytes.WithLabelValues(c.relay, c.direction).Add(float64(n))
+	}
+
+	return n, err
+}
+
+// Relayed wraps the writer to count the bytes written to it as relayed in the direction.
+func Relayed(w io.Writer, relay, direction string) io.Writer {
+	return &counter{Writer: w, relay: relay, direction: direction}
+}
+
+// version matches the agents' versions kept on the labels, like "v0.20.0" or "0.20.0".
+var version = regexp.MustCompile(`^v?[0-9]{1,4}\.[0-9]{1,4}\.[0-9]{1,4}$`)
+
+// Version is the label of the agent's version reported by the device. As it comes from the agent, any other than a
+// plain version, like "v0.20.0", is labelled "unknown", so the devices cannot fill the labels with arbitrary values.
+func Version(device models.Device) string {
+	if device.Info == nil || !version.MatchString(device.Info.Version) {
+		return "unknown"
+	}
+
+	return device.Info.Version
+}
+
+// devices is a collector of the devices online, by tenant and by the version of their agents.
+type devices struct {
+	desc *prometheus.Desc
+	list func() []models.Device
+}
+
+// Devices creates a collector of the devices online, by tenant and by the version of their agents, listed when the
+// metrics are scraped.
+func Devices(list func() []models.Device) prometheus.Collector {
+	return &devices{
+		desc: prometheus.NewDesc(
+			"shellhub_devices_connected",
+			"Devices connected to the server.",
+			[]string{"tenant", "version"},
+			nil,
+		),
+		list: list,
+	}
+}
+
+// Describe implements [prometheus.Collector].
+func (d *devices) Describe(descs chan<- *prometheus.Desc) {
+	descs <- d.desc
+}
+
+// Collect implements [prometheus.Collector].
+func (d *devices) Collect(metrics chan<- prometheus.Metric) {
+	type labels struct{ tenant, version string }
+
+	counts := make(map[labels]float64)
+	for _, device := range d.list() {
+		if !device.Online {
+			continue
+		}
+
+		tenant := device.TenantID
+		if tenant == "" {
+			tenant = target.DefaultTenant
+		}
+
+		counts[labels{tenant, Version(device)}]++
+	}
+
+	for labels, count := range counts {
+		metrics <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, count, labels.tenant, labels.version)
+	}
+}
+
+// Streams creates a collector of the yamux streams open to the devices, counted when the metrics are scraped.
+func Streams(count func() int) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shellhub_yamux_streams",
+		Help: "Yamux streams open to the devices.",
+	}, func() float64 {
+		return float64(count())
+	})
+}
+
+// ListenAndServe serves [Registry] on [Path] at the address.
+func ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})) //nolint:exhaustruct
+
+	return http.ListenAndServe(address, mux) //nolint:gosec
+}
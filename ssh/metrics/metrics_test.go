@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevices(t *testing.T) {
+	cases := []struct {
+		description string
+		devices     []models.Device
+		expected    string
+	}{
+		{
+			description: "succeeds when devices are offline",
+			devices:     []models.Device{{UID: "default:DEVICE123", Online: false}},
+			expected:    "",
+		},
+		{
+			description: "succeeds when devices are grouped by tenant and version",
+			devices: []models.Device{
+				{UID: "tenant:DEVICE123", TenantID: "tenant", Online: true, Info: &models.DeviceInfo{Version: "v0.20.0"}},
+				{UID: "tenant:DEVICE456", TenantID: "tenant", Online: true, Info: &models.DeviceInfo{Version: "v0.20.0"}},
+				{UID: "DEVICE789", Online: true},
+			},
+			expected: `shellhub_devices_connected{tenant="default",version="unknown"} 1
+shellhub_devices_connected{tenant="tenant",version="v0.20.0"} 2
+`,
+		},
+		{
+			description: "succeeds when devices report arbitrary versions",
+			devices: []models.Device{
+				{UID: "DEVICE123", Online: true, Info: &models.DeviceInfo{Version: "v0.20.0-" + strings.Repeat("x", 64)}},
+				{UID: "DEVICE456", Online: true, Info: &models.DeviceInfo{Version: "\"injected\"\n"}},
+			},
+			expected: `shellhub_devices_connected{tenant="default",version="unknown"} 2
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			collector := Devices(func() []models.Device {
+				return tc.devices
+			})
+
+			header := "# HELP shellhub_devices_connected Devices connected to the server.\n" +
+				"# TYPE shellhub_devices_connected gauge\n"
+			if tc.expected == "" {
+				header = ""
+			}
+
+			assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(header+tc.expected)))
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	cases := []struct {
+		description string
+		info        *models.DeviceInfo
+		expected    string
+	}{
+		{
+			description: "succeeds when the version has the prefix",
+			info:        &models.DeviceInfo{Version: "v0.20.0"},
+			expected:    "v0.20.0",
+		},
+		{
+			description: "succeeds when the version has no prefix",
+			info:        &models.DeviceInfo{Version: "1.2.3"},
+			expected:    "1.2.3",
+		},
+		{
+			description: "fails when the device has no info",
+			info:        nil,
+			expected:    "unknown",
+		},
+		{
+			description: "fails when the version is not a plain version",
+			info:        &models.DeviceInfo{Version: "v1.2.3-rc.1+build"},
+			expected:    "unknown",
+		},
+		{
+			description: "fails when the version is too long",
+			info:        &models.DeviceInfo{Version: "v12345.0.0"},
+			expected:    "unknown",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Version(models.Device{Info: tc.info}))
+		})
+	}
+}
+
+func TestRelayed(t *testing.T) {
+	var buffer bytes.Buffer
+
+	w := Relayed(&buffer, "test", Upstream)
+
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", buffer.String())
+	assert.Equal(t, float64(5), testutil.ToFloat64(RelayedBytes.WithLabelValues("test", Upstream)))
+}
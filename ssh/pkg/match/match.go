@@ -0,0 +1,75 @@
+// Package match matches the sessions against ordered lists of rules, by the user on the device and the device, like
+// the firewall and the session limits do.
+//
+// Rules are evaluated in order and the first one that matches decides.
+package match
+
+import (
+	"path"
+	"sync"
+)
+
+// Invalid returns the first pattern that [path.Match] cannot parse, if any.
+func Invalid(patterns ...string) (string, bool) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// Session checks if the user on the device and the device, as "tenant:name", match the patterns of a rule. Empty
+// patterns match everything.
+func Session(usernames, devices, username, device string) bool {
+	if ok, _ := path.Match(usernames, username); usernames != "" && !ok {
+		return false
+	}
+
+	if ok, _ := path.Match(devices, device); devices != "" && !ok {
+		return false
+	}
+
+	return true
+}
+
+// Rules keeps an ordered list of rules, which can be replaced while they are matched.
+type Rules[R any] struct {
+	mu    sync.RWMutex
+	rules []R
+}
+
+// Set replaces the rules with validated copies of them, keeping the current ones when any of the new ones is invalid.
+func (r *Rules[R]) Set(rules []R, validate func(*R) error) error {
+	validated := make([]R, len(rules))
+	copy(validated, rules)
+
+	for i := range validated {
+		if err := validate(&validated[i]); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.rules = validated
+	r.mu.Unlock()
+
+	return nil
+}
+
+// First returns the first rule that matches, with its position, or false when none does.
+func (r *Rules[R]) First(matches func(*R) bool) (int, R, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.rules {
+		if matches(&r.rules[i]) {
+			return i, r.rules[i], true
+		}
+	}
+
+	var none R
+
+	return -1, none, false
+}
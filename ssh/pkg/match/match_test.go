@@ -0,0 +1,78 @@
+package match
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	cases := []struct {
+		description string
+		usernames   string
+		devices     string
+		expected    bool
+	}{
+		{
+			description: "succeeds when the patterns are empty",
+			usernames:   "",
+			devices:     "",
+			expected:    true,
+		},
+		{
+			description: "succeeds when both patterns match",
+			usernames:   "dev-*",
+			devices:     "lab:*",
+			expected:    true,
+		},
+		{
+			description: "fails when the username does not match",
+			usernames:   "root",
+			devices:     "",
+			expected:    false,
+		},
+		{
+			description: "fails when the device does not match",
+			usernames:   "",
+			devices:     "default:*",
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Session(tc.usernames, tc.devices, "dev-alice", "lab:switch"))
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	pattern, invalid := Invalid("root", "lab:[")
+	assert.True(t, invalid)
+	assert.Equal(t, "lab:[", pattern)
+
+	_, invalid = Invalid("root", "lab:*", "")
+	assert.False(t, invalid)
+}
+
+func TestRules(t *testing.T) {
+	rules := &Rules[string]{}
+
+	_, _, ok := rules.First(func(*string) bool { return true })
+	assert.False(t, ok)
+
+	assert.NoError(t, rules.Set([]string{"a", "b", "c"}, func(*string) error { return nil }))
+
+	i, rule, ok := rules.First(func(rule *string) bool { return *rule != "a" })
+	assert.True(t, ok)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, "b", rule)
+
+	// NOTE: The current rules are kept when any of the new ones is invalid.
+	invalid := errors.New("invalid")
+	assert.ErrorIs(t, rules.Set([]string{"d"}, func(*string) error { return invalid }), invalid)
+
+	_, rule, _ = rules.First(func(*string) bool { return true })
+	assert.Equal(t, "a", rule)
+}
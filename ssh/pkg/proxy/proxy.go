@@ -0,0 +1,176 @@
+// Package proxy connects to the addresses reachable from a device, like the hosts on its network without the agent,
+// through the HTTP CONNECT requests handled by the agent on the streams of the reverse tunnel.
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/shellhub/pkg/models"
+)
+
+// Path is the path of the CONNECT request handled by the agent, followed by the address to connect to.
+const Path = "/http/proxy/"
+
+var (
+	ErrRefused = errors.New("device refused to connect to the address")
+	// ErrTimeout is returned when the device doesn't answer within the connect timeout.
+	ErrTimeout = errors.New("the device did not answer in time")
+)
+
+// Dialer opens the streams of the reverse tunnel to the devices.
+type Dialer interface {
+	// Dial opens a stream to the device with the UID.
+	Dial(target string) (net.Conn, error)
+}
+
+// DialerFunc is a [Dialer] opening the streams with the function.
+type DialerFunc func(target string) (net.Conn, error)
+
+func (f DialerFunc) Dial(target string) (net.Conn, error) {
+	return f(target)
+}
+
+// Tunnel is the reverse tunnel to the devices, whose streams [Dial] connects to the addresses reachable from them.
+type Tunnel interface {
+	Dialer
+	// Connect connects to addr through the device with the UID, within the connect timeout of the tunnel, like
+	// [Connect].
+	Connect(target, addr string) (net.Conn, error)
+	// Devices lists the devices known by the tunnel.
+	Devices() []models.Device
+}
+
+// Conn is a connection to an address through a device.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite closes the writing side of the connection, when the stream supports it.
+func (c *Conn) CloseWrite() error {
+	return relay.CloseWrite(c.Conn)
+}
+
+// Dial asks the device, through a stream of the reverse tunnel, to connect to addr, in the form "host:port". On
+// success, the stream is returned as a connection to the address.
+func Dial(stream net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Path: Path + addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if err := req.Write(stream); err != nil {
+		return nil, err
+	}
+
+	// NOTE: The reader keeps any data sent by the address right after the response, like the version of an SSH server.
+	reader := bufio.NewReader(stream)
+
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrRefused, res.Status)
+	}
+
+	return &Conn{Conn: stream, reader: reader}, nil
+}
+
+// DialTimeout is [Dial] giving up after the timeout, when set, with a deadline on the stream that is cleared once the
+// device answers.
+func DialTimeout(stream net.Conn, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return Dial(stream, addr)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := stream.SetDeadline(deadline); err != nil {
+		return Dial(stream, addr)
+	}
+
+	conn, err := Dial(stream, addr)
+	if err != nil {
+		if !time.Now().Before(deadline) {
+			metrics.Timeouts.WithLabelValues(metrics.StageOpen).Inc()
+
+			return nil, errors.Join(ErrTimeout, err)
+		}
+
+		return nil, err
+	}
+
+	stream.SetDeadline(time.Time{}) //nolint:errcheck
+
+	return conn, nil
+}
+
+// Open opens a stream to the target through the tunnel, giving up after the timeout, when set. A stream opened after
+// it is closed.
+func Open(tunnel Dialer, target string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return tunnel.Dial(target)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		conn, err := tunnel.Dial(target)
+		done <- result{conn: conn, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+
+		metrics.Timeouts.WithLabelValues(metrics.StageOpen).Inc()
+
+		return nil, ErrTimeout
+	}
+}
+
+// Connect opens a stream to the target through the tunnel and connects it to addr, each within the timeout, when
+// set. The stream is closed when the device doesn't connect.
+func Connect(tunnel Dialer, target, addr string, timeout time.Duration) (net.Conn, error) {
+	stream, err := Open(tunnel, target, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := DialTimeout(stream, addr, timeout)
+	if err != nil {
+		stream.Close()
+
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDial(t *testing.T) {
+	cases := []struct {
+		description string
+		response    string
+		expected    error
+	}{
+		{
+			description: "succeeds when device connects to the address",
+			response:    "HTTP/1.1 200 OK\r\n\r\nSSH-2.0-OpenSSH\r\n",
+			expected:    nil,
+		},
+		{
+			description: "fails when device refuses the address",
+			response:    "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n",
+			expected:    ErrRefused,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			server, agent := net.Pipe()
+			defer server.Close()
+			defer agent.Close()
+
+			requests := make(chan *http.Request, 1)
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(agent))
+				if err == nil {
+					requests <- req
+				}
+
+				io.WriteString(agent, tc.response) //nolint:errcheck
+			}()
+
+			conn, err := Dial(server, "10.0.0.5:22")
+			assert.True(t, errors.Is(err, tc.expected))
+
+			req := <-requests
+			assert.Equal(t, http.MethodConnect, req.Method)
+			assert.Equal(t, Path+"10.0.0.5:22", req.URL.Path)
+
+			if err != nil {
+				return
+			}
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, "SSH-2.0-OpenSSH\r\n", line)
+		})
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	cases := []struct {
+		description string
+		answer      bool
+		expected    error
+	}{
+		{
+			description: "succeeds when device answers before the timeout",
+			answer:      true,
+			expected:    nil,
+		},
+		{
+			description: "fails when device does not answer before the timeout",
+			answer:      false,
+			expected:    ErrTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			server, agent := net.Pipe()
+			defer server.Close()
+			defer agent.Close()
+
+			go func() {
+				if _, err := http.ReadRequest(bufio.NewReader(agent)); err != nil || !tc.answer {
+					return
+				}
+
+				io.WriteString(agent, "HTTP/1.1 200 OK\r\n\r\n") //nolint:errcheck
+			}()
+
+			conn, err := DialTimeout(server, "10.0.0.5:22", 50*time.Millisecond)
+			assert.ErrorIs(t, err, tc.expected)
+
+			if err != nil {
+				assert.Nil(t, conn)
+
+				return
+			}
+
+			// NOTE: The deadline is cleared once the device answers.
+			time.Sleep(100 * time.Millisecond)
+
+			go io.WriteString(agent, "SSH-2.0-OpenSSH\r\n") //nolint:errcheck
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, "SSH-2.0-OpenSSH\r\n", line)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	cases := []struct {
+		description string
+		delay       time.Duration
+		timeout     time.Duration
+		expected    error
+	}{
+		{
+			description: "succeeds when timeout is not set",
+			delay:       10 * time.Millisecond,
+			timeout:     0,
+			expected:    nil,
+		},
+		{
+			description: "succeeds when stream opens before the timeout",
+			delay:       0,
+			timeout:     time.Second,
+			expected:    nil,
+		},
+		{
+			description: "fails when stream opens after the timeout",
+			delay:       200 * time.Millisecond,
+			timeout:     10 * time.Millisecond,
+			expected:    ErrTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer remote.Close()
+
+			conn, err := Open(DialerFunc(func(string) (net.Conn, error) {
+				time.Sleep(tc.delay)
+
+				return local, nil
+			}), "default:DEVICE123", tc.timeout)
+			assert.ErrorIs(t, err, tc.expected)
+
+			if tc.expected != nil {
+				assert.Nil(t, conn)
+
+				// NOTE: The stream opened after the timeout is closed.
+				remote.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+				_, err := remote.Read(make([]byte, 1))
+				assert.ErrorIs(t, err, io.EOF)
+			}
+		})
+	}
+}
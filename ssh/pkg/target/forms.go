@@ -0,0 +1,115 @@
+package target
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrInvalidForm    = errors.New("invalid SSHID form; use \"user<separator>device\" or \"device<separator>user\"")
+	ErrAmbiguousSSHID = errors.New("SSHID has the separators of the forms, but does not match exactly one of them")
+)
+
+// Form is an alternative syntax of the SSHID, for clients that cannot handle the "@" between the user and the device,
+// like "user%device" or "device+user".
+type Form struct {
+	Separator string
+	// DeviceFirst is true when the device comes before the separator, like "device+user".
+	DeviceFirst bool
+}
+
+// ParseForms parses a comma separated list of forms, like "device+user,user%device,user:device". The separator is
+// made of characters that are neither letters, digits, "@", ".", "_" nor "-".
+func ParseForms(value string) ([]Form, error) {
+	forms := make([]Form, 0)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		var form Form
+		switch {
+		case strings.HasPrefix(entry, "user") && strings.HasSuffix(entry, "device"):
+			form = Form{Separator: entry[len("user") : len(entry)-len("device")]}
+		case strings.HasPrefix(entry, "device") && strings.HasSuffix(entry, "user"):
+			form = Form{Separator: entry[len("device") : len(entry)-len("user")], DeviceFirst: true}
+		default:
+			return nil, ErrInvalidForm
+		}
+
+		// NOTE: The separator cannot have the characters of the names of users and devices, nor the ones of the SSHID.
+		if form.Separator == "" || strings.ContainsFunc(form.Separator, func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@._-", r)
+		}) {
+			return nil, ErrInvalidForm
+		}
+
+		forms = append(forms, form)
+	}
+
+	return forms, nil
+}
+
+// Normalize converts a username in one of the forms into the SSHID, as "user@device". Usernames with an "@" are
+// already SSHIDs, and those without the separators of the forms are returned as they are.
+//
+// The user is split at the first separator of a "user<separator>device" form, and at the last one of a
+// "device<separator>user" form, so it never has the separator. A form matches only when the user has no separator of
+// the other forms; when none or more than one matches, [ErrAmbiguousSSHID] is returned.
+func Normalize(username string, forms []Form) (string, error) {
+	if strings.Contains(username, "@") {
+		return username, nil
+	}
+
+	var sshid string
+	for _, form := range forms {
+		var user, device string
+
+		if form.DeviceFirst {
+			index := strings.LastIndex(username, form.Separator)
+			if index < 0 {
+				continue
+			}
+
+			device, user = username[:index], username[index+len(form.Separator):]
+		} else {
+			index := strings.Index(username, form.Separator)
+			if index < 0 {
+				continue
+			}
+
+			user, device = username[:index], username[index+len(form.Separator):]
+		}
+
+		if user == "" || device == "" || hasSeparator(user, forms) {
+			continue
+		}
+
+		if sshid != "" && sshid != user+"@"+device {
+			return "", ErrAmbiguousSSHID
+		}
+
+		sshid = user + "@" + device
+	}
+
+	if sshid == "" {
+		if hasSeparator(username, forms) {
+			return "", ErrAmbiguousSSHID
+		}
+
+		return username, nil
+	}
+
+	return sshid, nil
+}
+
+func hasSeparator(value string, forms []Form) bool {
+	for _, form := range forms {
+		if strings.Contains(value, form.Separator) {
+			return true
+		}
+	}
+
+	return false
+}
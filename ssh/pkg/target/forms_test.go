@@ -0,0 +1,123 @@
+package target
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForms(t *testing.T) {
+	type Expected struct {
+		forms []Form
+		err   error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with no forms when value is empty",
+			value:       "",
+			expected:    Expected{forms: []Form{}, err: nil},
+		},
+		{
+			description: "succeeds when forms have the user or the device first",
+			value:       "device+user, user%device,user::device",
+			expected: Expected{
+				forms: []Form{
+					{Separator: "+", DeviceFirst: true},
+					{Separator: "%", DeviceFirst: false},
+					{Separator: "::", DeviceFirst: false},
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "fails when form has no separator",
+			value:       "userdevice",
+			expected:    Expected{forms: nil, err: ErrInvalidForm},
+		},
+		{
+			description: "fails when separator is a character of the names",
+			value:       "user.device",
+			expected:    Expected{forms: nil, err: ErrInvalidForm},
+		},
+		{
+			description: "fails when form misses the device",
+			value:       "user%host",
+			expected:    Expected{forms: nil, err: ErrInvalidForm},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			forms, err := ParseForms(tc.value)
+			assert.Equal(t, tc.expected, Expected{forms, err})
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	type Expected struct {
+		sshid string
+		err   error
+	}
+
+	forms := []Form{{Separator: "+", DeviceFirst: true}, {Separator: "%"}, {Separator: ":"}}
+
+	cases := []struct {
+		description string
+		username    string
+		expected    Expected
+	}{
+		{
+			description: "succeeds keeping the SSHID when username has an @",
+			username:    "root@default:DEVICE123",
+			expected:    Expected{sshid: "root@default:DEVICE123", err: nil},
+		},
+		{
+			description: "succeeds keeping the username when it matches no form",
+			username:    "alice",
+			expected:    Expected{sshid: "alice", err: nil},
+		},
+		{
+			description: "succeeds when device comes first",
+			username:    "DEVICE123+root",
+			expected:    Expected{sshid: "root@DEVICE123", err: nil},
+		},
+		{
+			description: "succeeds when device is a gateway and comes first",
+			username:    "DEVICE123+switch01+admin",
+			expected:    Expected{sshid: "admin@DEVICE123+switch01", err: nil},
+		},
+		{
+			description: "succeeds when device has a tenant",
+			username:    "root%default:DEVICE123",
+			expected:    Expected{sshid: "root@default:DEVICE123", err: nil},
+		},
+		{
+			description: "succeeds when user comes first",
+			username:    "root:DEVICE123",
+			expected:    Expected{sshid: "root@DEVICE123", err: nil},
+		},
+		{
+			description: "fails when username matches no form",
+			username:    "DEVICE123+root:DEVICE456",
+			expected:    Expected{sshid: "", err: ErrAmbiguousSSHID},
+		},
+		{
+			description: "fails when username matches more than one form",
+			username:    "root%DEVICE123+admin",
+			expected:    Expected{sshid: "", err: ErrAmbiguousSSHID},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			sshid, err := Normalize(tc.username, forms)
+			assert.Equal(t, tc.expected, Expected{sshid, err})
+		})
+	}
+}
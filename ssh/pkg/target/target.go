@@ -2,14 +2,47 @@ package target
 
 import (
 	"errors"
+	"net"
+	"strconv"
 	"strings"
+
+	"github.com/shellhub-io/shellhub/pkg/models"
 )
 
 var (
 	ErrSplitTarget = errors.New("could not split the target into two parts")
 	ErrNotSSHID    = errors.New("target is not from SSHID type")
+	ErrNotGateway  = errors.New("target is not a host through a device")
+	ErrGatewayHost = errors.New("invalid host through the device; use <device>/<host>:<port> or <device>+<host>")
+
+	ErrDeviceNotFound  = errors.New("device not found")
+	ErrDeviceAmbiguous = errors.New("more than one device matches the name; use <device>.<namespace>")
 )
 
+// GatewayPort is the port of the host reached through a device when the target omits it.
+const GatewayPort = "22"
+
+// DefaultTenant is the tenant of the devices written without one, like "DEVICE123" for "default:DEVICE123".
+const DefaultTenant = "default"
+
+// SplitDevice splits the device ID, either "tenant:name" or "name" on the [DefaultTenant], into its tenant and name.
+func SplitDevice(id string) (string, string) {
+	tenant, name, found := strings.Cut(id, ":")
+	if !found {
+		return DefaultTenant, id
+	}
+
+	return tenant, name
+}
+
+// DeviceUID is the device ID with its tenant, like "default:DEVICE123" for "DEVICE123", the way the devices are known
+// by the server.
+func DeviceUID(id string) string {
+	tenant, name := SplitDevice(id)
+
+	return tenant + ":" + name
+}
+
 type Target struct {
 	Username string
 	Data     string
@@ -56,3 +89,88 @@ func (t *Target) SplitSSHID() (string, string, error) {
 
 	return parts[NAMESPACE], parts[HOSTNAME], nil
 }
+
+// ParseJumpHost parses the host of a connection through the server as a jump host, like `ssh -J jump@server
+// user@DEVICE123`, into the device's name and namespace. The host is either the device's name, or its name and
+// namespace in the form "<device>.<namespace>"; in the former, the namespace is empty.
+func ParseJumpHost(host string) (string, string) {
+	const SEPARATOR = "."
+
+	index := strings.LastIndex(host, SEPARATOR)
+	if index <= 0 || index == len(host)-1 {
+		return host, ""
+	}
+
+	return host[:index], host[index+1:]
+}
+
+// ResolveDevice finds the device addressed by host, that is either the device's UID, its name, when unique, or its name
+// and namespace in the form "<device>.<namespace>", regardless of case.
+func ResolveDevice(devices []models.Device, host string) (*models.Device, error) {
+	name, namespace := ParseJumpHost(host)
+
+	var found *models.Device
+	for i, device := range devices {
+		if strings.EqualFold(device.UID, host) {
+			return &devices[i], nil
+		}
+
+		matches := strings.EqualFold(device.Name, host) ||
+			(namespace != "" && strings.EqualFold(device.Name, name) && strings.EqualFold(device.TenantID, namespace))
+		if !matches {
+			continue
+		}
+
+		if found != nil {
+			return nil, ErrDeviceAmbiguous
+		}
+
+		found = &devices[i]
+	}
+
+	if found == nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	return found, nil
+}
+
+// IsGateway checks if target is a host on the network of a device, reached through the device working as a gateway,
+// as "<device>/<host>:<port>" or "<device>+<host>".
+func (t *Target) IsGateway() bool {
+	return strings.ContainsAny(t.Data, "/+")
+}
+
+// SplitGateway splits the target into the device working as a gateway and the address of the host reached through it.
+// The host is an IP address or a host name resolved by the device, and its port is [GatewayPort] when omitted.
+func (t *Target) SplitGateway() (string, string, error) {
+	index := strings.IndexAny(t.Data, "/+")
+	if index < 0 {
+		return "", "", ErrNotGateway
+	}
+
+	device, addr := t.Data[:index], t.Data[index+1:]
+	if device == "" || addr == "" {
+		return "", "", ErrGatewayHost
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// NOTE: Without a port, the address is the host itself, including IPv6 addresses without brackets.
+		if strings.ContainsAny(addr, "[]") {
+			return "", "", ErrGatewayHost
+		}
+
+		host, port = addr, GatewayPort
+	}
+
+	if host == "" {
+		return "", "", ErrGatewayHost
+	}
+
+	if number, err := strconv.ParseUint(port, 10, 16); err != nil || number == 0 {
+		return "", "", ErrGatewayHost
+	}
+
+	return device, net.JoinHostPort(host, port), nil
+}
@@ -3,6 +3,7 @@ package target
 import (
 	"testing"
 
+	"github.com/shellhub-io/shellhub/pkg/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -123,3 +124,195 @@ func TestSplitSSHID(t *testing.T) {
 		})
 	}
 }
+
+func TestParseJumpHost(t *testing.T) {
+	type Expected struct {
+		name      string
+		namespace string
+	}
+
+	cases := []struct {
+		description string
+		host        string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when host is the device's name",
+			host:        "DEVICE123",
+			expected:    Expected{name: "DEVICE123", namespace: ""},
+		},
+		{
+			description: "succeeds when host has the device's name and namespace",
+			host:        "DEVICE123.default",
+			expected:    Expected{name: "DEVICE123", namespace: "default"},
+		},
+		{
+			description: "succeeds when device's name has dots",
+			host:        "web.01.lab",
+			expected:    Expected{name: "web.01", namespace: "lab"},
+		},
+		{
+			description: "succeeds with the whole host when it ends with a dot",
+			host:        "DEVICE123.",
+			expected:    Expected{name: "DEVICE123.", namespace: ""},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			name, namespace := ParseJumpHost(tc.host)
+			assert.Equal(t, tc.expected, Expected{name, namespace})
+		})
+	}
+}
+
+func TestSplitGateway(t *testing.T) {
+	type Expected struct {
+		device string
+		addr   string
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		target      *Target
+		expected    Expected
+	}{
+		{
+			description: "fails when target is not a gateway",
+			target:      &Target{Username: "root", Data: "lab:DEVICE123"},
+			expected:    Expected{device: "", addr: "", err: ErrNotGateway},
+		},
+		{
+			description: "succeeds when host has address and port",
+			target:      &Target{Username: "admin", Data: "lab:DEVICE123/10.0.0.5:2222"},
+			expected:    Expected{device: "lab:DEVICE123", addr: "10.0.0.5:2222", err: nil},
+		},
+		{
+			description: "succeeds with the default port when host has no port",
+			target:      &Target{Username: "admin", Data: "DEVICE123/10.0.0.5"},
+			expected:    Expected{device: "DEVICE123", addr: "10.0.0.5:22", err: nil},
+		},
+		{
+			description: "succeeds when host is a name",
+			target:      &Target{Username: "admin", Data: "DEVICE123+switch01"},
+			expected:    Expected{device: "DEVICE123", addr: "switch01:22", err: nil},
+		},
+		{
+			description: "succeeds when host is an IPv6 address without port",
+			target:      &Target{Username: "admin", Data: "DEVICE123/fd00::5"},
+			expected:    Expected{device: "DEVICE123", addr: "[fd00::5]:22", err: nil},
+		},
+		{
+			description: "succeeds when host is an IPv6 address with port",
+			target:      &Target{Username: "admin", Data: "DEVICE123/[fd00::5]:2222"},
+			expected:    Expected{device: "DEVICE123", addr: "[fd00::5]:2222", err: nil},
+		},
+		{
+			description: "fails when device is empty",
+			target:      &Target{Username: "admin", Data: "+switch01"},
+			expected:    Expected{device: "", addr: "", err: ErrGatewayHost},
+		},
+		{
+			description: "fails when host is empty",
+			target:      &Target{Username: "admin", Data: "DEVICE123/:22"},
+			expected:    Expected{device: "", addr: "", err: ErrGatewayHost},
+		},
+		{
+			description: "fails when port is invalid",
+			target:      &Target{Username: "admin", Data: "DEVICE123/10.0.0.5:ssh"},
+			expected:    Expected{device: "", addr: "", err: ErrGatewayHost},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			device, addr, err := tc.target.SplitGateway()
+			assert.Equal(t, tc.expected, Expected{device, addr, err})
+		})
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	devices := []models.Device{
+		{UID: "default:DEVICE123", Name: "DEVICE123", TenantID: "default"},
+		{UID: "lab:router", Name: "router", TenantID: "lab"},
+		{UID: "office:router", Name: "router", TenantID: "office"},
+	}
+
+	type Expected struct {
+		device *models.Device
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		host        string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when host is the device's UID",
+			host:        "lab:router",
+			expected:    Expected{device: &devices[1], err: nil},
+		},
+		{
+			description: "succeeds when host is the unique device's name regardless of case",
+			host:        "device123",
+			expected:    Expected{device: &devices[0], err: nil},
+		},
+		{
+			description: "succeeds when host has the device's name and namespace",
+			host:        "router.office",
+			expected:    Expected{device: &devices[2], err: nil},
+		},
+		{
+			description: "fails when more than one device has the name",
+			host:        "router",
+			expected:    Expected{device: nil, err: ErrDeviceAmbiguous},
+		},
+		{
+			description: "fails when no device matches",
+			host:        "printer",
+			expected:    Expected{device: nil, err: ErrDeviceNotFound},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			device, err := ResolveDevice(devices, tc.host)
+			assert.Equal(t, tc.expected, Expected{device, err})
+		})
+	}
+}
+
+func TestSplitDevice(t *testing.T) {
+	type Expected struct {
+		tenant string
+		name   string
+		uid    string
+	}
+
+	cases := []struct {
+		description string
+		id          string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when device has its tenant",
+			id:          "lab:DEVICE123",
+			expected:    Expected{tenant: "lab", name: "DEVICE123", uid: "lab:DEVICE123"},
+		},
+		{
+			description: "succeeds when device is on the default tenant",
+			id:          "DEVICE123",
+			expected:    Expected{tenant: DefaultTenant, name: "DEVICE123", uid: "default:DEVICE123"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			tenant, name := SplitDevice(tc.id)
+			assert.Equal(t, tc.expected, Expected{tenant, name, DeviceUID(tc.id)})
+		})
+	}
+}
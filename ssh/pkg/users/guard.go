@@ -0,0 +1,54 @@
+package users
+
+import (
+	"net"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// Guard protects the authentications of the users outside of the SSH server, like on the API and the proxies, with
+// the brute-force protection of the SSH server, so the failures of a client's address and of a user count together
+// whatever the entry point.
+//
+// A nil Guard, or one without lockouts, only checks the credentials.
+type Guard struct {
+	Lockouts *guard.Guard
+	// Audit records the lockouts; when nil, they are only logged.
+	Audit *audit.Log
+}
+
+// Authenticate authenticates the user called name on the store, like [Store.Authenticate], from the client's address,
+// as "host:port" or "host". The address and the user are refused while denied or locked out, a wrong password counts
+// against them and a right one forgets their failures.
+func (g *Guard) Authenticate(store *Store, addr, name, password string) (*User, error) {
+	if g == nil {
+		return store.Authenticate(name, password)
+	}
+
+	source := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		source = host
+	}
+
+	if err := g.Lockouts.Check(source, name); err != nil {
+		return nil, err
+	}
+
+	user, err := store.Authenticate(name, password)
+	if err != nil {
+		for _, key := range g.Lockouts.Fail(source, name) {
+			log.WithFields(log.Fields{"user": name, "ip": source, "key": key}).
+				Warn("locked out after too many authentication failures")
+
+			g.Audit.Record(audit.Event{Type: audit.TypeAuthLocked, Reason: key, Username: name, IPAddress: source})
+		}
+
+		return nil, err
+	}
+
+	g.Lockouts.Succeed(source, name)
+
+	return user, nil
+}
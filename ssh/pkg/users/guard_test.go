@@ -0,0 +1,80 @@
+package users
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardAuthenticate(t *testing.T) {
+	store, err := Parse(strings.NewReader("alice:" + hashed + "\n"))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		passwords   []string
+		expected    error
+		locked      bool
+	}{
+		{
+			description: "succeeds when password is right",
+			passwords:   []string{"x"},
+			expected:    nil,
+			locked:      false,
+		},
+		{
+			description: "succeeds when failures are below the maximum",
+			passwords:   []string{"y", "x"},
+			expected:    nil,
+			locked:      false,
+		},
+		{
+			description: "fails when password is wrong",
+			passwords:   []string{"y"},
+			expected:    ErrInvalidCredentials,
+			locked:      false,
+		},
+		{
+			description: "fails with right password when locked out",
+			passwords:   []string{"y", "y", "x"},
+			expected:    guard.ErrLocked,
+			locked:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+			require.NoError(t, err)
+
+			events := audit.NewLog()
+			logins := &Guard{Lockouts: lockouts, Audit: events}
+
+			for _, password := range tc.passwords {
+				_, err = logins.Authenticate(store, "10.0.0.5:40000", "alice", password)
+			}
+
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Equal(t, tc.locked, len(events.Events()) > 0)
+		})
+	}
+}
+
+func TestNilGuardAuthenticate(t *testing.T) {
+	store, err := Parse(strings.NewReader("alice:" + hashed + "\n"))
+	require.NoError(t, err)
+
+	var logins *Guard
+
+	user, err := logins.Authenticate(store, "10.0.0.5:40000", "alice", "x")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Name)
+
+	_, err = logins.Authenticate(store, "10.0.0.5:40000", "alice", "y")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
@@ -0,0 +1,208 @@
+// Package users provides the users who authenticate on the server itself, instead of on a device, like the ones
+// choosing a device through the device picker or managing the server through the admin shell.
+//
+// Users are loaded from a file with one user per line, in the format:
+//
+//	name:password-hash:roles:devices
+//
+// The password hash is either a bcrypt hash or a hex encoded SHA256 one. Roles is a comma separated list of roles,
+// where "admin" grants access to the admin shell and API. Devices is a comma separated list of patterns, matched
+// against the device's UID, like "default:DEVICE123" or "lab:*". When it is empty, the user can access every device.
+// Empty lines and lines starting with "#" are ignored.
+package users
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/shellhub-io/shellhub/pkg/hash"
+	"github.com/shellhub-io/shellhub/pkg/models"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidLine        = errors.New("invalid user line")
+)
+
+// RoleAdmin is the role that grants access to the admin shell and API.
+const RoleAdmin = "admin"
+
+// User is a user who authenticates on the server.
+type User struct {
+	Name     string
+	Password string
+	Roles    []string
+	Devices  []string
+}
+
+// IsAdmin checks if the user has the [RoleAdmin] role.
+func (u *User) IsAdmin() bool {
+	for _, role := range u.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccess checks if the user can access the device.
+func (u *User) CanAccess(device models.Device) bool {
+	if len(u.Devices) == 0 {
+		return true
+	}
+
+	for _, pattern := range u.Devices {
+		if ok, _ := path.Match(pattern, device.UID); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Store keeps the users who can authenticate on the server.
+//
+// A nil Store is valid and means no user was configured. In this case, the server runs in test mode: any credential is
+// accepted, every device is accessible and nobody is an admin.
+type Store struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// Load loads the users from the file at path.
+func Load(path string) (*Store, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse parses the users from r.
+func Parse(r io.Reader) (*Store, error) {
+	store := &Store{users: make(map[string]*User)}
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		const (
+			NAME = iota
+			PASSWORD
+			ROLES
+			DEVICES
+		)
+
+		// NOTE: The devices field may contain colons, like in "tenant:device", so it is the remaining of the line.
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 2 || parts[NAME] == "" || parts[PASSWORD] == "" {
+			return nil, fmt.Errorf("%w at line %d", ErrInvalidLine, number)
+		}
+
+		user := &User{Name: parts[NAME], Password: parts[PASSWORD]}
+		if len(parts) > ROLES {
+			user.Roles = split(parts[ROLES])
+		}
+
+		if len(parts) > DEVICES {
+			user.Devices = split(parts[DEVICES])
+		}
+
+		store.users[user.Name] = user
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func split(field string) []string {
+	values := []string{}
+	for _, value := range strings.Split(field, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// Enabled checks if users were configured, meaning the server is not running in test mode.
+func (s *Store) Enabled() bool {
+	return s != nil
+}
+
+// Get gets a user by its name.
+func (s *Store) Get(name string) (*User, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[name]
+
+	return user, ok
+}
+
+// Authenticate checks the user's password, returning the user when it matches.
+func (s *Store) Authenticate(name, password string) (*User, error) {
+	user, ok := s.Get(name)
+	if !ok || !hash.CompareWith(password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Accessible filters the devices the user called name can access. When users are not enabled, every device is
+// returned.
+//
+// NOTE: The devices are resolved only among the accessible ones, so the others look like they do not exist.
+func (s *Store) Accessible(name string, devices []models.Device) []models.Device {
+	if !s.Enabled() {
+		return devices
+	}
+
+	user, ok := s.Get(name)
+	if !ok {
+		return []models.Device{}
+	}
+
+	accessible := make([]models.Device, 0, len(devices))
+	for _, device := range devices {
+		if user.CanAccess(device) {
+			accessible = append(accessible, device)
+		}
+	}
+
+	return accessible
+}
+
+// Replace replaces the users of the store with the ones of other, like the ones of the users file loaded again.
+// Sessions already authenticated are kept.
+func (s *Store) Replace(other *Store) {
+	other.mu.RLock()
+	users := other.users
+	other.mu.RUnlock()
+
+	s.mu.Lock()
+	s.users = users
+	s.mu.Unlock()
+}
@@ -0,0 +1,169 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// NOTE: SHA256 hash of the password "x".
+const hashed = "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881"
+
+func TestParse(t *testing.T) {
+	type Expected struct {
+		users map[string]*User
+		err   error
+	}
+
+	cases := []struct {
+		description string
+		file        string
+		expected    Expected
+	}{
+		{
+			description: "fails when the line has no password",
+			file:        "alice",
+			expected:    Expected{users: nil, err: ErrInvalidLine},
+		},
+		{
+			description: "succeeds when the line has only name and password",
+			file:        "alice:" + hashed,
+			expected: Expected{
+				users: map[string]*User{
+					"alice": {Name: "alice", Password: hashed},
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "succeeds when the devices have colons and lines are ignored",
+			file:        "# comment\n\nalice:" + hashed + ":admin:default:*, lab:DEVICE1\n",
+			expected: Expected{
+				users: map[string]*User{
+					"alice": {
+						Name:     "alice",
+						Password: hashed,
+						Roles:    []string{"admin"},
+						Devices:  []string{"default:*", "lab:DEVICE1"},
+					},
+				},
+				err: nil,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			store, err := Parse(strings.NewReader(tc.file))
+			assert.ErrorIs(t, err, tc.expected.err)
+			if err == nil {
+				assert.Equal(t, tc.expected.users, store.users)
+			}
+		})
+	}
+}
+
+func TestCanAccess(t *testing.T) {
+	cases := []struct {
+		description string
+		user        *User
+		device      models.Device
+		expected    bool
+	}{
+		{
+			description: "succeeds when the user has no devices",
+			user:        &User{Name: "alice"},
+			device:      models.Device{UID: "default:DEVICE1"},
+			expected:    true,
+		},
+		{
+			description: "succeeds when a pattern matches the device",
+			user:        &User{Name: "alice", Devices: []string{"lab:*", "default:DEVICE1"}},
+			device:      models.Device{UID: "lab:switch01"},
+			expected:    true,
+		},
+		{
+			description: "fails when no pattern matches the device",
+			user:        &User{Name: "alice", Devices: []string{"lab:*"}},
+			device:      models.Device{UID: "default:DEVICE1"},
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.user.CanAccess(tc.device))
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store, err := Parse(strings.NewReader("alice:" + hashed + ":admin\nbob:" + hashed))
+	assert.NoError(t, err)
+
+	cases := []struct {
+		description string
+		store       *Store
+		name        string
+		password    string
+		expected    error
+	}{
+		{
+			description: "fails when users are not enabled",
+			store:       nil,
+			name:        "alice",
+			password:    "x",
+			expected:    ErrInvalidCredentials,
+		},
+		{
+			description: "fails when the user does not exist",
+			store:       store,
+			name:        "carol",
+			password:    "x",
+			expected:    ErrInvalidCredentials,
+		},
+		{
+			description: "fails when the password does not match",
+			store:       store,
+			name:        "alice",
+			password:    "y",
+			expected:    ErrInvalidCredentials,
+		},
+		{
+			description: "succeeds when the password matches",
+			store:       store,
+			name:        "bob",
+			password:    "x",
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			user, err := tc.store.Authenticate(tc.name, tc.password)
+			assert.ErrorIs(t, err, tc.expected)
+			if err == nil {
+				assert.Equal(t, tc.name, user.Name)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	store, err := Parse(strings.NewReader("alice:" + hashed + ":admin"))
+	assert.NoError(t, err)
+
+	other, err := Parse(strings.NewReader("bob:" + hashed))
+	assert.NoError(t, err)
+
+	store.Replace(other)
+
+	_, ok := store.Get("alice")
+	assert.False(t, ok)
+
+	user, err := store.Authenticate("bob", "x")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", user.Name)
+}
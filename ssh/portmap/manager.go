@@ -0,0 +1,297 @@
+package portmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrMappingExists   = errors.New("mapping already exists")
+	ErrMappingNotFound = errors.New("mapping not found")
+)
+
+// Status is a mapping with its counters.
+type Status struct {
+	Mapping
+	// Active is the number of open connections.
+	Active int64 `json:"active"`
+	// Connections is the number of connections accepted since the mapping started.
+	Connections uint64 `json:"connections"`
+	// Rejected is the number of connections refused by the sources or the connections limit.
+	Rejected uint64 `json:"rejected"`
+	// BytesIn is the number of bytes sent by the clients to the device.
+	BytesIn uint64 `json:"bytes_in"`
+	// BytesOut is the number of bytes sent by the device to the clients.
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// mapping is a running mapping.
+type mapping struct {
+	Mapping
+	prefixes []netip.Prefix
+	listener net.Listener
+
+	active      atomic.Int64
+	connections atomic.Uint64
+	rejected    atomic.Uint64
+	in          atomic.Uint64
+	out         atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (m *mapping) status() Status {
+	return Status{
+		Mapping:     m.Mapping,
+		Active:      m.active.Load(),
+		Connections: m.connections.Load(),
+		Rejected:    m.rejected.Load(),
+		BytesIn:     m.in.Load(),
+		BytesOut:    m.out.Load(),
+	}
+}
+
+// allow checks if a client at addr can connect to the mapping.
+func (m *mapping) allow(addr net.Addr) bool {
+	if len(m.prefixes) == 0 {
+		return true
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range m.prefixes {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Manager runs the mappings, listening on their ports.
+type Manager struct {
+	tunnel proxy.Tunnel
+
+	mu       sync.Mutex
+	mappings map[string]*mapping
+}
+
+// NewManager creates a new [Manager].
+func NewManager(tunnel proxy.Tunnel) *Manager {
+	return &Manager{tunnel: tunnel, mappings: make(map[string]*mapping)}
+}
+
+// Add validates the mapping and starts listening on its port.
+func (m *Manager) Add(value Mapping) (*Status, error) {
+	if err := value.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMapping, err)
+	}
+
+	prefixes, _ := value.prefixes()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mappings[value.Name]; ok {
+		return nil, ErrMappingExists
+	}
+
+	listener, err := net.Listen("tcp", value.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	running := &mapping{
+		Mapping:  value,
+		prefixes: prefixes,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	m.mappings[value.Name] = running
+
+	go m.serve(running)
+
+	log.WithFields(log.Fields{
+		"mapping": value.Name,
+		"listen":  listener.Addr().String(),
+		"device":  value.Device,
+		"target":  value.Target,
+	}).Info("port mapping started")
+
+	status := running.status()
+
+	return &status, nil
+}
+
+// Remove stops the mapping, closing its listener and connections.
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	running, ok := m.mappings[name]
+	delete(m.mappings, name)
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrMappingNotFound
+	}
+
+	running.listener.Close() //nolint:errcheck
+
+	running.mu.Lock()
+	for conn := range running.conns {
+		conn.Close()
+	}
+	running.mu.Unlock()
+
+	log.WithField("mapping", name).Info("port mapping removed")
+
+	return nil
+}
+
+// Get gets the status of a mapping.
+func (m *Manager) Get(name string) (*Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running, ok := m.mappings[name]
+	if !ok {
+		return nil, ErrMappingNotFound
+	}
+
+	status := running.status()
+
+	return &status, nil
+}
+
+// List lists the status of the mappings sorted by name.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Status, 0, len(m.mappings))
+	for _, running := range m.mappings {
+		list = append(list, running.status())
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func (m *Manager) serve(running *mapping) {
+	for {
+		conn, err := running.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go m.handle(running, conn)
+	}
+}
+
+// handle forwards a connection accepted on the mapping's port to the target on the device.
+func (m *Manager) handle(running *mapping, conn net.Conn) {
+	defer conn.Close()
+
+	logger := log.WithFields(log.Fields{
+		"mapping": running.Name,
+		"remote":  conn.RemoteAddr().String(),
+	})
+
+	if !running.allow(conn.RemoteAddr()) {
+		running.rejected.Add(1)
+		logger.Info("port mapping refused a client out of its sources")
+
+		return
+	}
+
+	// NOTE: The limit is checked after counting the connection, so concurrent ones cannot exceed it.
+	defer running.active.Add(-1)
+	if active := running.active.Add(1); running.MaxConnections > 0 && active > int64(running.MaxConnections) {
+		running.rejected.Add(1)
+		logger.Info("port mapping refused a client over its connections limit")
+
+		return
+	}
+
+	running.mu.Lock()
+	running.conns[conn] = struct{}{}
+	running.mu.Unlock()
+
+	defer func() {
+		running.mu.Lock()
+		delete(running.conns, conn)
+		running.mu.Unlock()
+	}()
+
+	running.connections.Add(1)
+
+	device, err := target.ResolveDevice(m.tunnel.Devices(), running.Device)
+	if err != nil {
+		logger.WithError(err).Warn("port mapping failed to resolve the device")
+
+		return
+	}
+
+	remote, err := m.tunnel.Connect(device.UID, running.Target)
+	if err != nil {
+		logger.WithError(err).Warn("port mapping failed to connect to the mapping's target through the device")
+
+		return
+	}
+
+	defer remote.Close()
+
+	logger.Debug("port mapping connection started")
+
+	relay.Pipe(&counter{ReadWriteCloser: conn, in: &running.in, out: &running.out}, remote)
+
+	logger.Debug("port mapping connection done")
+}
+
+// counter counts the bytes read from and written to the client's connection.
+type counter struct {
+	io.ReadWriteCloser
+	in  *atomic.Uint64
+	out *atomic.Uint64
+}
+
+func (c *counter) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	c.in.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	c.out.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *counter) CloseWrite() error {
+	return relay.CloseWrite(c.ReadWriteCloser)
+}
@@ -0,0 +1,183 @@
+// Package portmap implements the persistent TCP port mappings, where the server listens on a port and forwards each
+// accepted connection to an address on a device, like `server:15432 -> DEVICE123:5432`, for the clients that cannot
+// forward ports through SSH.
+//
+// Mappings are loaded from a file with one mapping per line, in the format:
+//
+//	name listen device target [sources=prefix,prefix] [max=connections]
+//
+// The listen address is the server's address, like ":15432". The device is its UID, its name, when unique, or its name
+// and namespace, as "<device>.<namespace>". The target is the address the device connects to, like "10.0.0.5:502", or
+// only a port on the device's loopback interface, like "5432". Sources are the IP addresses or prefixes the clients
+// can connect from, and max is the number of concurrent connections; both are unlimited when omitted. Empty lines and
+// lines starting with "#" are ignored.
+package portmap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TargetHost is the host of the target when the mapping only has the port.
+const TargetHost = "127.0.0.1"
+
+var (
+	ErrInvalidName    = errors.New("name must have from 1 to 64 letters, numbers, dots, dashes or underscores")
+	ErrInvalidListen  = errors.New("listen must be an address with port, like \":15432\"")
+	ErrInvalidDevice  = errors.New("device must not be empty")
+	ErrInvalidTarget  = errors.New("target must be a port or an address with port, like \"5432\" or \"10.0.0.5:502\"")
+	ErrInvalidSources = errors.New("sources must be IP addresses or prefixes, like \"10.0.0.0/8\"")
+	ErrInvalidMax     = errors.New("max must be a positive number of connections")
+	ErrInvalidLine    = errors.New("invalid mapping line")
+	ErrInvalidMapping = errors.New("invalid mapping")
+)
+
+// Mapping maps a port on the server to an address on a device.
+type Mapping struct {
+	Name           string   `json:"name"`
+	Listen         string   `json:"listen"`
+	Device         string   `json:"device"`
+	Target         string   `json:"target"`
+	Sources        []string `json:"sources,omitempty"`
+	MaxConnections int      `json:"max_connections,omitempty"`
+}
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Validate checks the mapping, setting the target's host when omitted.
+func (m *Mapping) Validate() error {
+	if !nameRegexp.MatchString(m.Name) {
+		return ErrInvalidName
+	}
+
+	if _, port, err := net.SplitHostPort(m.Listen); err != nil || !validPort(port) {
+		return ErrInvalidListen
+	}
+
+	if m.Device == "" {
+		return ErrInvalidDevice
+	}
+
+	if validPort(m.Target) {
+		m.Target = net.JoinHostPort(TargetHost, m.Target)
+	}
+
+	if host, port, err := net.SplitHostPort(m.Target); err != nil || host == "" || !validPort(port) {
+		return ErrInvalidTarget
+	}
+
+	if _, err := m.prefixes(); err != nil {
+		return err
+	}
+
+	if m.MaxConnections < 0 {
+		return ErrInvalidMax
+	}
+
+	return nil
+}
+
+// prefixes parses the sources of the mapping.
+func (m *Mapping) prefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(m.Sources))
+	for _, source := range m.Sources {
+		if prefix, err := netip.ParsePrefix(source); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+
+			continue
+		}
+
+		addr, err := netip.ParseAddr(source)
+		if err != nil {
+			return nil, ErrInvalidSources
+		}
+
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func validPort(value string) bool {
+	port, err := strconv.ParseUint(value, 10, 16)
+
+	return err == nil && port > 0
+}
+
+// Load loads the mappings from the file at path.
+func Load(path string) ([]Mapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse parses the mappings, one per line.
+func Parse(r io.Reader) ([]Mapping, error) {
+	mappings := make([]Mapping, 0)
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		mapping, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w at line %d", err, number)
+		}
+
+		mappings = append(mappings, *mapping)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// ParseLine parses a mapping in the format of a line of the mappings file.
+func ParseLine(line string) (*Mapping, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, ErrInvalidLine
+	}
+
+	mapping := &Mapping{Name: fields[0], Listen: fields[1], Device: fields[2], Target: fields[3]}
+	for _, option := range fields[4:] {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "sources":
+			mapping.Sources = strings.Split(value, ",")
+		case "max":
+			connections, err := strconv.Atoi(value)
+			if err != nil || connections <= 0 {
+				return nil, ErrInvalidMax
+			}
+
+			mapping.MaxConnections = connections
+		default:
+			return nil, ErrInvalidLine
+		}
+	}
+
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,124 @@
+package portmap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	type Expected struct {
+		mapping *Mapping
+		err     error
+	}
+
+	cases := []struct {
+		description string
+		line        string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with the target's host when the target is a port",
+			line:        "pg :15432 DEVICE123 5432",
+			expected: Expected{
+				mapping: &Mapping{Name: "pg", Listen: ":15432", Device: "DEVICE123", Target: "127.0.0.1:5432"},
+				err:     nil,
+			},
+		},
+		{
+			description: "succeeds when the mapping has options",
+			line:        "plc 0.0.0.0:1502 lab:gateway 10.0.0.5:502 sources=10.0.0.0/8,192.168.1.7 max=2",
+			expected: Expected{
+				mapping: &Mapping{
+					Name:           "plc",
+					Listen:         "0.0.0.0:1502",
+					Device:         "lab:gateway",
+					Target:         "10.0.0.5:502",
+					Sources:        []string{"10.0.0.0/8", "192.168.1.7"},
+					MaxConnections: 2,
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "fails when fields are missing",
+			line:        "pg :15432 DEVICE123",
+			expected:    Expected{mapping: nil, err: ErrInvalidLine},
+		},
+		{
+			description: "fails when the option is unknown",
+			line:        "pg :15432 DEVICE123 5432 limit=2",
+			expected:    Expected{mapping: nil, err: ErrInvalidLine},
+		},
+		{
+			description: "fails when the name is invalid",
+			line:        "p/g :15432 DEVICE123 5432",
+			expected:    Expected{mapping: nil, err: ErrInvalidName},
+		},
+		{
+			description: "fails when the listen address has no port",
+			line:        "pg 15432 DEVICE123 5432",
+			expected:    Expected{mapping: nil, err: ErrInvalidListen},
+		},
+		{
+			description: "fails when the target's port is invalid",
+			line:        "pg :15432 DEVICE123 10.0.0.5:0",
+			expected:    Expected{mapping: nil, err: ErrInvalidTarget},
+		},
+		{
+			description: "fails when a source is invalid",
+			line:        "pg :15432 DEVICE123 5432 sources=office",
+			expected:    Expected{mapping: nil, err: ErrInvalidSources},
+		},
+		{
+			description: "fails when max is not positive",
+			line:        "pg :15432 DEVICE123 5432 max=0",
+			expected:    Expected{mapping: nil, err: ErrInvalidMax},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			mapping, err := ParseLine(tc.line)
+			assert.Equal(t, tc.expected, Expected{mapping, err})
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		description string
+		content     string
+		expected    []string
+		err         error
+	}{
+		{
+			description: "succeeds ignoring empty lines and comments",
+			content:     "# name listen device target\n\npg :15432 DEVICE123 5432\nweb :18080 DEVICE123 8080\n",
+			expected:    []string{"pg", "web"},
+			err:         nil,
+		},
+		{
+			description: "fails when a line is invalid",
+			content:     "pg :15432 DEVICE123 5432\nweb :18080\n",
+			expected:    nil,
+			err:         ErrInvalidLine,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			mappings, err := Parse(strings.NewReader(tc.content))
+			assert.True(t, errors.Is(err, tc.err))
+
+			var names []string
+			for _, mapping := range mappings {
+				names = append(names, mapping.Name)
+			}
+
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Package publish makes the services published by the agents, like `--publish web=127.0.0.1:8080`, reachable on ports
+// of the server, with no SSH session involved.
+//
+// The agent lists its services on the [Header] of the reverse tunnel's connection. Each service gets a port of the
+// server's range, kept for the device and the service's name while the server runs, even when the device reconnects,
+// and served by a port mapping whose connections are sent to the agent through streams of the reverse tunnel.
+//
+// Publications are revoked by the admins, which stops their port mapping and ignores the service until the server
+// restarts.
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	log "github.com/sirupsen/logrus"
+)
+
+// Header is the header of the reverse tunnel's connection that lists the services published by the device, as
+// "name=host:port,name=host:port".
+const Header = "X-Published-Services"
+
+var (
+	ErrInvalidServices     = errors.New("invalid published services; use \"name=host:port,name=host:port\"")
+	ErrPublicationNotFound = errors.New("publication not found")
+)
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Service is a service published by a device.
+type Service struct {
+	Name    string
+	Address string
+}
+
+// Parse parses the value of [Header]. Like the agent, it refuses a name published more than once.
+func Parse(value string) ([]Service, error) {
+	services := make([]Service, 0)
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		name, address, ok := strings.Cut(entry, "=")
+		if !ok || !nameRegexp.MatchString(name) || seen[name] {
+			return nil, ErrInvalidServices
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || host == "" {
+			return nil, ErrInvalidServices
+		}
+
+		if number, err := strconv.ParseUint(port, 10, 16); err != nil || number == 0 {
+			return nil, ErrInvalidServices
+		}
+
+		seen[name] = true
+		services = append(services, Service{Name: name, Address: address})
+	}
+
+	return services, nil
+}
+
+// Publication is a service published by a device on a port of the server.
+type Publication struct {
+	Device  string `json:"device"`
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Port    int    `json:"port"`
+	Revoked bool   `json:"revoked"`
+}
+
+// Mappings is the interface of the manager of the port mappings that serve the publications.
+//
+// NOTE: The publisher owns every mapping of its manager, so it must not be the one of the admins' mappings, whose
+// names could clash with the ones of the publications.
+type Mappings interface {
+	Add(mapping portmap.Mapping) (*portmap.Status, error)
+	Remove(name string) error
+}
+
+// Publisher keeps the publications of the devices.
+//
+// A nil Publisher is valid and means publications are disabled: the services published by the devices are ignored.
+type Publisher struct {
+	mappings Mappings
+	low      int
+	high     int
+
+	mu           sync.Mutex
+	publications map[string]*Publication
+}
+
+// New creates a new [Publisher] that gives the ports from low to high to the publications.
+func New(mappings Mappings, low, high int) *Publisher {
+	return &Publisher{
+		mappings:     mappings,
+		low:          low,
+		high:         high,
+		publications: make(map[string]*Publication),
+	}
+}
+
+func key(device, name string) string {
+	return device + "/" + name
+}
+
+// mapping is the name of the port mapping that serves the publication on port.
+func mapping(port int) string {
+	return "publish-" + strconv.Itoa(port)
+}
+
+// Publish updates the publications of the device to its services, when it connects. The services already published
+// keep their ports, the new ones get a free port and the ones not published anymore are removed.
+func (p *Publisher) Publish(device string, services []Service) {
+	if p == nil {
+		if len(services) > 0 {
+			log.WithField("device", device).Warn("device published services, but publications are disabled")
+		}
+
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	published := make(map[string]bool)
+	for _, service := range services {
+		published[key(device, service.Name)] = true
+
+		logger := log.WithFields(log.Fields{"device": device, "service": service.Name, "target": service.Address})
+
+		publication, ok := p.publications[key(device, service.Name)]
+		switch {
+		case ok && publication.Revoked:
+			logger.Info("ignoring the revoked publication")
+
+			continue
+		case ok && publication.Target == service.Address:
+			continue
+		case ok:
+			p.mappings.Remove(mapping(publication.Port)) //nolint:errcheck
+
+			publication.Target = service.Address
+			if err := p.serve(publication); err != nil {
+				logger.WithError(err).Warn("failed to publish the service again on its port")
+			}
+
+			continue
+		}
+
+		publication = &Publication{Device: device, Name: service.Name, Target: service.Address}
+		if err := p.allocate(publication); err != nil {
+			logger.WithError(err).Warn("failed to publish the service")
+
+			continue
+		}
+
+		p.publications[key(device, service.Name)] = publication
+
+		logger.WithField("port", publication.Port).Info("service published")
+	}
+
+	for k, publication := range p.publications {
+		if publication.Device != device || published[k] || publication.Revoked {
+			continue
+		}
+
+		p.mappings.Remove(mapping(publication.Port)) //nolint:errcheck
+		delete(p.publications, k)
+
+		log.WithFields(log.Fields{"device": device, "service": publication.Name}).Info("service unpublished")
+	}
+}
+
+// allocate serves the publication on the first free port of the range.
+func (p *Publisher) allocate(publication *Publication) error {
+	used := make(map[int]bool)
+	for _, other := range p.publications {
+		used[other.Port] = true
+	}
+
+	for port := p.low; port <= p.high; port++ {
+		if used[port] {
+			continue
+		}
+
+		publication.Port = port
+
+		// NOTE: A port in use by another process, or by a port mapping, is skipped.
+		if err := p.serve(publication); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no free port from %d to %d", p.low, p.high)
+}
+
+func (p *Publisher) serve(publication *Publication) error {
+	_, err := p.mappings.Add(portmap.Mapping{
+		Name:   mapping(publication.Port),
+		Listen: net.JoinHostPort("", strconv.Itoa(publication.Port)),
+		Device: publication.Device,
+		Target: publication.Target,
+	})
+
+	return err
+}
+
+// List lists the publications sorted by device and name.
+func (p *Publisher) List() []Publication {
+	if p == nil {
+		return []Publication{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]Publication, 0, len(p.publications))
+	for _, publication := range p.publications {
+		list = append(list, *publication)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Device != list[j].Device {
+			return list[i].Device < list[j].Device
+		}
+
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// Revoke stops serving the publication, closing its connections. The service is ignored when the device publishes it
+// again.
+func (p *Publisher) Revoke(device, name string) (*Publication, error) {
+	if p == nil {
+		return nil, ErrPublicationNotFound
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	publication, ok := p.publications[key(device, name)]
+	if !ok {
+		return nil, ErrPublicationNotFound
+	}
+
+	if !publication.Revoked {
+		p.mappings.Remove(mapping(publication.Port)) //nolint:errcheck
+		publication.Revoked = true
+
+		log.WithFields(log.Fields{"device": device, "service": name}).Info("publication revoked")
+	}
+
+	revoked := *publication
+
+	return &revoked, nil
+}
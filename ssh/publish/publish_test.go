@@ -0,0 +1,126 @@
+package publish
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	type Expected struct {
+		services []Service
+		err      error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with no services when value is empty",
+			value:       "",
+			expected:    Expected{services: []Service{}, err: nil},
+		},
+		{
+			description: "succeeds when services have addresses",
+			value:       "web=127.0.0.1:8080,db=localhost:5432",
+			expected: Expected{
+				services: []Service{{Name: "web", Address: "127.0.0.1:8080"}, {Name: "db", Address: "localhost:5432"}},
+				err:      nil,
+			},
+		},
+		{
+			description: "fails when the name is invalid",
+			value:       "web/ui=127.0.0.1:8080",
+			expected:    Expected{services: nil, err: ErrInvalidServices},
+		},
+		{
+			description: "fails when a name is repeated",
+			value:       "web=127.0.0.1:8080,web=127.0.0.1:8081",
+			expected:    Expected{services: nil, err: ErrInvalidServices},
+		},
+		{
+			description: "fails when the address has no port",
+			value:       "web=127.0.0.1",
+			expected:    Expected{services: nil, err: ErrInvalidServices},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			services, err := Parse(tc.value)
+			assert.Equal(t, tc.expected, Expected{services, err})
+		})
+	}
+}
+
+// mappings is a fake port mappings manager whose busy ports cannot be listened on.
+type mappings struct {
+	busy    map[string]bool
+	running map[string]portmap.Mapping
+}
+
+func (m *mappings) Add(mapping portmap.Mapping) (*portmap.Status, error) {
+	if m.busy[mapping.Listen] {
+		return nil, errors.New("address already in use")
+	}
+
+	m.running[mapping.Name] = mapping
+
+	return &portmap.Status{Mapping: mapping}, nil
+}
+
+func (m *mappings) Remove(name string) error {
+	delete(m.running, name)
+
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	fake := &mappings{busy: map[string]bool{":20001": true}, running: make(map[string]portmap.Mapping)}
+	publisher := New(fake, 20000, 20003)
+
+	publisher.Publish("default:DEVICE123", []Service{
+		{Name: "web", Address: "127.0.0.1:8080"},
+		{Name: "db", Address: "127.0.0.1:5432"},
+	})
+
+	assert.Equal(t, []Publication{
+		{Device: "default:DEVICE123", Name: "db", Target: "127.0.0.1:5432", Port: 20002},
+		{Device: "default:DEVICE123", Name: "web", Target: "127.0.0.1:8080", Port: 20000},
+	}, publisher.List())
+
+	// NOTE: On a reconnection, the services keep their ports, and the ones not published anymore are removed.
+	publisher.Publish("default:DEVICE123", []Service{{Name: "web", Address: "127.0.0.1:8081"}})
+
+	assert.Equal(t, []Publication{
+		{Device: "default:DEVICE123", Name: "web", Target: "127.0.0.1:8081", Port: 20000},
+	}, publisher.List())
+	assert.Equal(t, "127.0.0.1:8081", fake.running["publish-20000"].Target)
+	assert.NotContains(t, fake.running, "publish-20002")
+
+	revoked, err := publisher.Revoke("default:DEVICE123", "web")
+	assert.NoError(t, err)
+	assert.True(t, revoked.Revoked)
+	assert.NotContains(t, fake.running, "publish-20000")
+
+	publisher.Publish("default:DEVICE123", []Service{{Name: "web", Address: "127.0.0.1:8081"}})
+	assert.NotContains(t, fake.running, "publish-20000")
+
+	_, err = publisher.Revoke("default:DEVICE123", "db")
+	assert.ErrorIs(t, err, ErrPublicationNotFound)
+}
+
+func TestNilPublisher(t *testing.T) {
+	var publisher *Publisher
+
+	publisher.Publish("default:DEVICE123", []Service{{Name: "web", Address: "127.0.0.1:8080"}})
+
+	assert.Equal(t, []Publication{}, publisher.List())
+
+	_, err := publisher.Revoke("default:DEVICE123", "web")
+	assert.ErrorIs(t, err, ErrPublicationNotFound)
+}
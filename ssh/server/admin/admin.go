@@ -0,0 +1,496 @@
+// Package admin implements the admin shell, a command line interface to manage the server offered on the session
+// channel of admins connecting to the reserved admin target, like `ssh -p 2222 admin@server`.
+//
+// The shell is backed by the same [services.Service] used by the admin REST API, and every command prints either a
+// table or, with the "--json" flag, a JSON document.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/publish"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/ports"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"golang.org/x/term"
+)
+
+// Prompt is the prompt of the interactive admin shell.
+const Prompt = "admin> "
+
+var (
+	// ErrExit is returned by [Shell.Exec] when the user asks to leave the shell.
+	ErrExit = errors.New("exit")
+	// ErrUnknownCommand is returned by [Shell.Exec] when the command does not exist.
+	ErrUnknownCommand = errors.New("unknown command; type \"help\" for the list of commands")
+	// ErrUsage is returned by [Shell.Exec] when the command arguments are invalid.
+	ErrUsage = errors.New("invalid arguments")
+)
+
+const help = `Commands:
+  devices [filter|tag:<tag>]    list the devices
+  accept <device>               accept a pending device
+  tags <device> [tag...]        show or replace the tags of a device; "tags <device> --clear" removes them
+  sessions                      list the active sessions
+  kill <session>                close an active session
+  recordings [session]          list the recorded sessions or print the output of one
+  mappings                      list the port mappings with their counters
+  map <name> <listen> <device> <target> [sources=<prefix,...>] [max=<connections>]
+                                start a port mapping, like "map pg :15432 DEVICE123 5432"
+  unmap <name>                  stop a port mapping and close its connections
+  publications                  list the services published by the devices
+  revoke <device> <service>     stop serving a published service, ignoring it until the server restarts
+  ports                         list the SSH ports assigned to the devices
+  audit                         list the latest audit events, like the sessions terminated by their limits
+  lockouts                      list the addresses and SSHIDs with authentication failures or locked out
+  unlock <key>                  clear the failures and lockout of a key, like "source:10.0.0.5"
+  help                          show this help
+  exit                          leave the shell
+Add "--json" to any command to print JSON instead of a table.
+`
+
+// Shell is the admin shell.
+type Shell struct {
+	service *services.Service
+}
+
+// New creates a new [Shell] backed by the service.
+func New(service *services.Service) *Shell {
+	return &Shell{service: service}
+}
+
+// Run runs the interactive shell on rw, that is expected to be a channel with a pty attached, until the user exits.
+// The resize function, when not nil, receives a callback to update the terminal dimensions.
+func (s *Shell) Run(rw io.ReadWriter, user string, resize func(func(width, height int))) error {
+	t := term.NewTerminal(rw, Prompt)
+	if resize != nil {
+		resize(func(width, height int) {
+			t.SetSize(width, height) //nolint:errcheck
+		})
+	}
+
+	fmt.Fprintf(t, "Welcome to the admin shell, %s. Type \"help\" for the list of commands.\n", user)
+
+	for {
+		line, err := t.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := s.Exec(t, strings.Fields(line)); err != nil {
+			if errors.Is(err, ErrExit) {
+				return nil
+			}
+
+			fmt.Fprintf(t, "error: %s\n", err)
+		}
+	}
+}
+
+// Exec executes a single command, writing its output to w.
+func (s *Shell) Exec(w io.Writer, args []string) error {
+	asJSON := false
+
+	positional := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--json" {
+			asJSON = true
+
+			continue
+		}
+
+		positional = append(positional, arg)
+	}
+
+	if len(positional) == 0 {
+		return nil
+	}
+
+	command, params := positional[0], positional[1:]
+
+	switch command {
+	case "help", "?":
+		fmt.Fprint(w, help)
+
+		return nil
+	case "exit", "quit":
+		return ErrExit
+	case "devices":
+		if len(params) > 1 {
+			return fmt.Errorf("%w: devices [filter|tag:<tag>]", ErrUsage)
+		}
+
+		devices := s.service.ListDevices(strings.Join(params, ""))
+		if asJSON {
+			return encode(w, devices)
+		}
+
+		writeDevices(w, devices)
+
+		return nil
+	case "accept":
+		if len(params) != 1 {
+			return fmt.Errorf("%w: accept <device>", ErrUsage)
+		}
+
+		device, err := s.service.AcceptDevice(params[0])
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, device)
+		}
+
+		fmt.Fprintf(w, "device %s accepted\n", device.UID)
+
+		return nil
+	case "tags":
+		if len(params) == 0 {
+			return fmt.Errorf("%w: tags <device> [tag...]", ErrUsage)
+		}
+
+		device, err := s.tags(params[0], params[1:])
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, device.Tags)
+		}
+
+		fmt.Fprintf(w, "%s: %s\n", device.UID, strings.Join(device.Tags, " "))
+
+		return nil
+	case "sessions":
+		sessions := s.service.ListSessions()
+		if asJSON {
+			return encode(w, sessions)
+		}
+
+		writeSessions(w, sessions)
+
+		return nil
+	case "kill":
+		if len(params) != 1 {
+			return fmt.Errorf("%w: kill <session>", ErrUsage)
+		}
+
+		if err := s.service.KillSession(params[0]); err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, map[string]string{"killed": params[0]})
+		}
+
+		fmt.Fprintf(w, "session %s killed\n", params[0])
+
+		return nil
+	case "recordings":
+		switch len(params) {
+		case 0:
+			recordings := s.service.ListRecordings()
+			if asJSON {
+				return encode(w, recordings)
+			}
+
+			writeSessions(w, recordings)
+
+			return nil
+		case 1:
+			frames, err := s.service.GetRecording(params[0])
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				return encode(w, frames)
+			}
+
+			for _, frame := range frames {
+				io.WriteString(w, frame.Output) //nolint:errcheck
+			}
+
+			fmt.Fprintln(w)
+
+			return nil
+		default:
+			return fmt.Errorf("%w: recordings [session]", ErrUsage)
+		}
+	case "mappings":
+		mappings := s.service.ListMappings()
+		if asJSON {
+			return encode(w, mappings)
+		}
+
+		writeMappings(w, mappings)
+
+		return nil
+	case "map":
+		// NOTE: The arguments are the fields of a line of the mappings file.
+		value, err := portmap.ParseLine(strings.Join(params, " "))
+		if errors.Is(err, portmap.ErrInvalidLine) {
+			return fmt.Errorf("%w: map <name> <listen> <device> <target> [sources=<prefix,...>] [max=<connections>]", ErrUsage)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		mapping, err := s.service.AddMapping(*value)
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, mapping)
+		}
+
+		fmt.Fprintf(w, "mapping %s started: %s -> %s %s\n", mapping.Name, mapping.Listen, mapping.Device, mapping.Target)
+
+		return nil
+	case "unmap":
+		if len(params) != 1 {
+			return fmt.Errorf("%w: unmap <name>", ErrUsage)
+		}
+
+		if err := s.service.RemoveMapping(params[0]); err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, map[string]string{"removed": params[0]})
+		}
+
+		fmt.Fprintf(w, "mapping %s removed\n", params[0])
+
+		return nil
+	case "publications":
+		publications := s.service.ListPublications()
+		if asJSON {
+			return encode(w, publications)
+		}
+
+		writePublications(w, publications)
+
+		return nil
+	case "revoke":
+		if len(params) != 2 {
+			return fmt.Errorf("%w: revoke <device> <service>", ErrUsage)
+		}
+
+		publication, err := s.service.RevokePublication(params[0], params[1])
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, publication)
+		}
+
+		fmt.Fprintf(w, "publication %s of %s revoked\n", publication.Name, publication.Device)
+
+		return nil
+	case "ports":
+		assignments := s.service.ListDevicePorts()
+		if asJSON {
+			return encode(w, assignments)
+		}
+
+		writeDevicePorts(w, assignments)
+
+		return nil
+	case "audit":
+		events := s.service.ListAuditEvents()
+		if asJSON {
+			return encode(w, events)
+		}
+
+		writeAuditEvents(w, events)
+
+		return nil
+	case "lockouts":
+		lockouts := s.service.ListLockouts()
+		if asJSON {
+			return encode(w, lockouts)
+		}
+
+		writeLockouts(w, lockouts)
+
+		return nil
+	case "unlock":
+		if len(params) != 1 {
+			return fmt.Errorf("%w: unlock <key>", ErrUsage)
+		}
+
+		if err := s.service.ClearLockout(params[0]); err != nil {
+			return err
+		}
+
+		if asJSON {
+			return encode(w, map[string]string{"unlocked": params[0]})
+		}
+
+		fmt.Fprintf(w, "%s unlocked\n", params[0])
+
+		return nil
+	default:
+		return ErrUnknownCommand
+	}
+}
+
+func (s *Shell) tags(id string, tags []string) (*models.Device, error) {
+	switch {
+	case len(tags) == 0:
+		return s.service.GetDevice(id)
+	case len(tags) == 1 && tags[0] == "--clear":
+		return s.service.SetDeviceTags(id, []string{})
+	default:
+		return s.service.SetDeviceTags(id, tags)
+	}
+}
+
+func encode(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+func writeDevices(w io.Writer, devices []models.Device) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "UID\tNAME\tNAMESPACE\tSTATUS\tONLINE\tLAST SEEN\tTAGS")
+	for _, device := range devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n",
+			device.UID,
+			device.Name,
+			device.TenantID,
+			device.Status,
+			device.Online,
+			device.LastSeen.Format(time.DateTime),
+			strings.Join(device.Tags, ","),
+		)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writeSessions(w io.Writer, sessions []models.Session) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "UID\tDEVICE\tUSERNAME\tIP\tSTARTED\tACTIVE\tRECORDED")
+	for _, sess := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%t\n",
+			sess.UID,
+			sess.DeviceUID,
+			sess.Username,
+			sess.IPAddress,
+			sess.StartedAt.Format(time.DateTime),
+			sess.Active,
+			sess.Recorded,
+		)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writeMappings(w io.Writer, mappings []portmap.Status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAME\tLISTEN\tDEVICE\tTARGET\tSOURCES\tMAX\tACTIVE\tCONNECTIONS\tREJECTED\tBYTES IN\tBYTES OUT")
+	for _, mapping := range mappings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			mapping.Name,
+			mapping.Listen,
+			mapping.Device,
+			mapping.Target,
+			strings.Join(mapping.Sources, ","),
+			mapping.MaxConnections,
+			mapping.Active,
+			mapping.Connections,
+			mapping.Rejected,
+			mapping.BytesIn,
+			mapping.BytesOut,
+		)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writePublications(w io.Writer, publications []publish.Publication) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "DEVICE\tSERVICE\tTARGET\tPORT\tREVOKED")
+	for _, publication := range publications {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%t\n",
+			publication.Device,
+			publication.Name,
+			publication.Target,
+			publication.Port,
+			publication.Revoked,
+		)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writeDevicePorts(w io.Writer, assignments []ports.Assignment) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "PORT\tDEVICE\tEXPLICIT")
+	for _, assignment := range assignments {
+		fmt.Fprintf(tw, "%d\t%s\t%t\n", assignment.Port, assignment.Device, assignment.Explicit)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writeAuditEvents(w io.Writer, events []audit.Event) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TIME\tTYPE\tREASON\tSESSION\tDEVICE\tUSERNAME\tIP")
+	for _, event := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			event.Time.Format(time.DateTime),
+			event.Type,
+			event.Reason,
+			event.Session,
+			event.Device,
+			event.Username,
+			event.IPAddress,
+		)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
+
+func writeLockouts(w io.Writer, lockouts []guard.Status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "KEY\tFAILURES\tLOCKOUTS\tLOCKED UNTIL")
+	for _, lockout := range lockouts {
+		until := "-"
+		if lockout.LockedUntil != nil {
+			until = lockout.LockedUntil.Format(time.DateTime)
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", lockout.Key, lockout.Failures, lockout.Lockouts, until)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
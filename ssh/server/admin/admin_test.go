@@ -0,0 +1,196 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// store is a [services.DeviceStore] keeping the devices in memory.
+type store struct {
+	devices []models.Device
+}
+
+func (s *store) Devices() []models.Device {
+	return append([]models.Device{}, s.devices...)
+}
+
+func (s *store) set(uid string, fn func(device *models.Device)) error {
+	for i := range s.devices {
+		if s.devices[i].UID == uid {
+			fn(&s.devices[i])
+
+			return nil
+		}
+	}
+
+	return errors.New("device not found")
+}
+
+func (s *store) SetDeviceStatus(uid string, status models.DeviceStatus) error {
+	return s.set(uid, func(device *models.Device) { device.Status = status })
+}
+
+func (s *store) SetDeviceTags(uid string, tags []string) error {
+	return s.set(uid, func(device *models.Device) { device.Tags = tags })
+}
+
+func TestExec(t *testing.T) {
+	cases := []struct {
+		description string
+		args        []string
+		expected    string
+		err         error
+	}{
+		{
+			description: "does nothing without a command",
+			args:        []string{},
+			expected:    "",
+			err:         nil,
+		},
+		{
+			description: "prints the help",
+			args:        []string{"help"},
+			expected:    "Commands:",
+			err:         nil,
+		},
+		{
+			description: "exits the shell",
+			args:        []string{"exit"},
+			err:         ErrExit,
+		},
+		{
+			description: "fails on the unknown command",
+			args:        []string{"reboot"},
+			err:         ErrUnknownCommand,
+		},
+		{
+			description: "lists the devices matching the filter as a table",
+			args:        []string{"devices", "DEVICE456"},
+			expected:    "default:DEVICE456  DEVICE456  default    pending",
+			err:         nil,
+		},
+		{
+			description: "lists the devices as JSON when the flag is anywhere",
+			args:        []string{"--json", "devices", "tag:prod"},
+			expected:    `"uid": "default:DEVICE123"`,
+			err:         nil,
+		},
+		{
+			description: "fails to list the devices with more than one filter",
+			args:        []string{"devices", "DEVICE123", "DEVICE456"},
+			err:         ErrUsage,
+		},
+		{
+			description: "accepts the pending device",
+			args:        []string{"accept", "DEVICE456"},
+			expected:    "device default:DEVICE456 accepted\n",
+			err:         nil,
+		},
+		{
+			description: "fails to accept the accepted device",
+			args:        []string{"accept", "DEVICE123"},
+			err:         services.ErrDeviceNotPending,
+		},
+		{
+			description: "fails to accept without a device",
+			args:        []string{"accept"},
+			err:         ErrUsage,
+		},
+		{
+			description: "shows the tags of the device",
+			args:        []string{"tags", "DEVICE123"},
+			expected:    "default:DEVICE123: prod\n",
+			err:         nil,
+		},
+		{
+			description: "replaces the tags of the device",
+			args:        []string{"tags", "DEVICE123", "db", "eu"},
+			expected:    "default:DEVICE123: db eu\n",
+			err:         nil,
+		},
+		{
+			description: "clears the tags of the device as JSON",
+			args:        []string{"tags", "DEVICE123", "--clear", "--json"},
+			expected:    "[]\n",
+			err:         nil,
+		},
+		{
+			description: "fails to set the invalid tags",
+			args:        []string{"tags", "DEVICE123", "prod/eu"},
+			err:         services.ErrInvalidTag,
+		},
+		{
+			description: "fails to kill the unknown session",
+			args:        []string{"kill", "unknown"},
+			err:         services.ErrSessionNotFound,
+		},
+		{
+			description: "fails to print more than one recording",
+			args:        []string{"recordings", "first", "second"},
+			err:         ErrUsage,
+		},
+		{
+			description: "fails to start the mapping without a target",
+			args:        []string{"map", "pg", ":15432", "DEVICE123"},
+			err:         ErrUsage,
+		},
+		{
+			description: "fails to stop the unknown mapping",
+			args:        []string{"unmap", "pg"},
+			err:         services.ErrMappingNotFound,
+		},
+		{
+			description: "fails to revoke without a service",
+			args:        []string{"revoke", "DEVICE123"},
+			err:         ErrUsage,
+		},
+		{
+			description: "fails to clear the unknown lockout",
+			args:        []string{"unlock", "source:10.0.0.5"},
+			err:         services.ErrLockoutNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Default())
+			require.NoError(t, err)
+
+			devices := &store{devices: []models.Device{
+				{
+					UID:      "default:DEVICE123",
+					Name:     "DEVICE123",
+					TenantID: "default",
+					Status:   models.DeviceStatusAccepted,
+					Tags:     []string{"prod"},
+				},
+				{UID: "default:DEVICE456", Name: "DEVICE456", TenantID: "default", Status: models.DeviceStatusPending},
+			}}
+
+			service := services.New(devices, session.NewRegistry(), portmap.NewManager(nil), nil, nil, audit.NewLog(), lockouts)
+
+			var out bytes.Buffer
+
+			err = New(service).Exec(&out, tc.args)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, out.String())
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), tc.expected)
+		})
+	}
+}
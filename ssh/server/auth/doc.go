@@ -3,4 +3,8 @@
 // This package includes two authentication methods: [PasswordHandler] and [PublicKeyHandler].
 // [PasswordHandler] is the second authentication method tried by the server to connect the client to the agent,
 // while [PublicKeyHandler] is the first authentication method attempted.
+//
+// Connections handled by the server itself, like the device picker and the admin shell, are authenticated against the
+// users store by the handlers created with [NewPasswordHandler] and [NewPublicKeyHandler], which delegate the device
+// connections to the handlers above.
 package auth
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	log "github.com/sirupsen/logrus"
+)
+
+// source returns the client's address without the port; behind a load balancer speaking the PROXY protocol, the
+// address of the original client.
+func source(ctx gliderssh.Context) string {
+	host, _, err := net.SplitHostPort(ctx.RemoteAddr().String())
+	if err != nil {
+		return ctx.RemoteAddr().String()
+	}
+
+	return host
+}
+
+// target returns the user on the device the connection authenticates for, like "root@default:DEVICE123", so every
+// form of the SSHID reaching the same device, like "root@DEVICE123" or a dedicated port, is counted together. The
+// connections without a device, like the admin shell's, are counted by their SSHID.
+func target(ctx gliderssh.Context) string {
+	if sess, state := session.ObtainSession(ctx); state >= session.StateCreated && session.GetKind(ctx) == session.KindDevice {
+		return sess.Data.Target.Username + "@" + sess.DeviceKey()
+	}
+
+	return session.GetSSHID(ctx)
+}
+
+// guarded checks the client's address and the target against the connection's guard, returning false when either is
+// denied or locked out.
+func guarded(ctx gliderssh.Context, logger *log.Entry) bool {
+	if err := session.GetGuard(ctx).Check(source(ctx), target(ctx)); err != nil {
+		logger.WithError(err).WithField("ip", source(ctx)).Warn("authentication refused by the brute-force protection")
+
+		return false
+	}
+
+	return true
+}
+
+// failed counts a refused credential against the client's address and the target, recording the lockouts it causes on
+// the audit log.
+func failed(ctx gliderssh.Context) {
+	ip, sshid := source(ctx), session.GetSSHID(ctx)
+
+	for _, key := range session.GetGuard(ctx).Fail(ip, target(ctx)) {
+		log.WithFields(log.Fields{"uid": ctx.SessionID(), "sshid": sshid, "ip": ip, "key": key}).
+			Warn("locked out after too many authentication failures")
+
+		session.GetAudit(ctx).Record(audit.Event{
+			Type:      audit.TypeAuthLocked,
+			Reason:    key,
+			Session:   ctx.SessionID(),
+			SSHID:     sshid,
+			Username:  session.LocalUser(ctx),
+			IPAddress: ip,
+		})
+	}
+}
+
+// succeeded forgets the failures of the client's address and the target.
+func succeeded(ctx gliderssh.Context) {
+	session.GetGuard(ctx).Succeed(source(ctx), target(ctx))
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewPasswordHandler creates a password handler that authenticates the connections handled by the server itself, like
+// the device picker and the admin shell, against the users store, and delegates the device ones to [PasswordHandler].
+//
+// The credentials refused by the users store or by the device count against the client's address and the SSHID on the
+// connection's guard, which refuses them once locked out.
+func NewPasswordHandler(store *users.Store) gliderssh.PasswordHandler {
+	handler := func(ctx gliderssh.Context, passwd string) bool {
+		logger := log.WithFields(
+			log.Fields{
+				"uid":   ctx.SessionID(),
+				"sshid": ctx.User(),
+				"user":  session.LocalUser(ctx),
+			})
+
+		switch session.GetKind(ctx) {
+		case session.KindPicker:
+			// NOTE: When the SSHID has no device, the client authenticates on the server and picks the device later.
+			// The password is kept to authenticate on the agent once the device is chosen.
+			if store.Enabled() {
+				if _, err := store.Authenticate(session.LocalUser(ctx), passwd); err != nil {
+					logger.WithError(err).Warn("failed to authenticate the user for the device picker")
+					failed(ctx)
+
+					return false
+				}
+			}
+
+			session.SetCredential(ctx, session.AuthPassword(passwd))
+
+			logger.Info("succeeded to use password authentication for the device picker")
+
+			return true
+		case session.KindAdmin:
+			user, err := store.Authenticate(session.LocalUser(ctx), passwd)
+			if err != nil {
+				logger.WithError(err).Warn("failed to authenticate the user for the admin shell")
+				failed(ctx)
+
+				return false
+			}
+
+			if !user.IsAdmin() {
+				logger.Warn("user is not an admin")
+				failed(ctx)
+
+				return false
+			}
+
+			logger.Info("succeeded to use password authentication for the admin shell")
+
+			return true
+		default:
+			return PasswordHandler(ctx, passwd)
+		}
+	}
+
+	return func(ctx gliderssh.Context, passwd string) bool {
+		logger := log.WithFields(log.Fields{"uid": ctx.SessionID(), "sshid": ctx.User()})
+		if !guarded(ctx, logger) {
+			return metrics.Authenticated("password", session.GetKind(ctx).String(), false)
+		}
+
+		ok := handler(ctx, passwd)
+		if ok {
+			succeeded(ctx)
+		}
+
+		return metrics.Authenticated("password", session.GetKind(ctx).String(), ok)
+	}
+}
+
+// NewPublicKeyHandler creates a public key handler that handles the connections handled by the server itself, like
+// the device picker and the admin shell, and delegates the device ones to [PublicKeyHandler].
+//
+// Users of the store have no public keys, so the admin shell, and the device picker when users are enabled, require
+// the password authentication.
+func NewPublicKeyHandler(store *users.Store) gliderssh.PublicKeyHandler {
+	handler := func(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
+		logger := log.WithFields(
+			log.Fields{
+				"uid":   ctx.SessionID(),
+				"sshid": ctx.User(),
+			})
+
+		switch session.GetKind(ctx) {
+		case session.KindPicker:
+			if store.Enabled() {
+				logger.Trace("device picker requires password authentication when users are enabled")
+
+				return false
+			}
+
+			session.SetCredential(ctx, session.AuthPublicKey(key))
+
+			logger.Info("accepted public key for the device picker")
+
+			return true
+		case session.KindAdmin:
+			logger.Trace("admin shell requires password authentication")
+
+			return false
+		default:
+			return PublicKeyHandler(ctx, key)
+		}
+	}
+
+	return func(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
+		logger := log.WithFields(log.Fields{"uid": ctx.SessionID(), "sshid": ctx.User()})
+		if !guarded(ctx, logger) {
+			return metrics.Authenticated("publickey", session.GetKind(ctx).String(), false)
+		}
+
+		return metrics.Authenticated("publickey", session.GetKind(ctx).String(), handler(ctx, key))
+	}
+}
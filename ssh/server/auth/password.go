@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"net"
 
 	gliderssh "github.com/gliderlabs/ssh"
@@ -31,7 +32,11 @@ func PasswordHandler(ctx gliderssh.Context, passwd string) bool {
 	}
 
 	if err := sess.Auth(ctx, session.AuthPassword(passwd)); err != nil {
-		logger.Warn("failed to authenticate on device using password")
+		logger.WithError(err).Warn("failed to authenticate on device using password")
+
+		if errors.Is(err, session.ErrAuthRejected) {
+			failed(ctx)
+		}
 
 		return false
 	}
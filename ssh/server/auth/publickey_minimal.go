@@ -1,23 +1,36 @@
 package auth
 
 import (
+    "net"
+
     gliderssh "github.com/gliderlabs/ssh"
     "github.com/shellhub-io/mini-shellhub/ssh/session"
     log "github.com/sirupsen/logrus"
 )
 
-// PublicKeyHandler: accept any public key and attempt to auth to agent with dummy password.
-// This mirrors the lenient behavior used previously in minimal mode.
-func PublicKeyHandler(ctx gliderssh.Context, _ gliderssh.PublicKey) bool {
+// PublicKeyHandler handles ShellHub client's connection using the public key authentication method.
+//
+// The connections whose session was not evaluated, like the ones refused by the firewall or over a concurrency cap on
+// the banner, are refused and closed, as the ones the agent refuses.
+func PublicKeyHandler(ctx gliderssh.Context, key gliderssh.PublicKey) bool {
     logger := log.WithFields(log.Fields{"uid": ctx.SessionID(), "user": ctx.User()})
     sess, state := session.ObtainSession(ctx)
-    if state < session.StateEvaluated {
-        logger.Trace("session not evaluated yet on public key handler; accepting")
+    if state == session.StateFinished {
+        // NOTE: The client may offer other keys after the session is authenticated on the agent.
         return true
     }
-    if err := sess.Auth(ctx, session.AuthPassword("any")); err != nil {
-        logger.WithError(err).Warn("failed to authenticate on agent after pubkey; accepting anyway")
-        return true
+    if state < session.StateEvaluated {
+        logger.Trace("session not evaluated on public key handler; refusing")
+
+        if conn, ok := ctx.Value("conn").(net.Conn); ok {
+            conn.Close()
+        }
+
+        return false
+    }
+    if err := sess.Auth(ctx, session.AuthPublicKey(key)); err != nil {
+        logger.WithError(err).Warn("failed to authenticate on agent after pubkey")
+        return false
     }
     logger.Info("accepted public key")
     return true
@@ -0,0 +1,183 @@
+package channels
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/anmitsu/go-shlex"
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/admin"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// AdminSessionHandler is the handler for session's channel of admins connected to the reserved admin target.
+//
+// The channel is handled by the server itself, without any device involved. A shell request with a pty runs the
+// interactive admin shell, and an exec request runs a single command, like `ssh admin@server devices --json`.
+func AdminSessionHandler(shell *admin.Shell) gliderssh.ChannelHandler {
+	return func(_ *gliderssh.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		logger := log.WithFields(
+			log.Fields{
+				"uid":   ctx.SessionID(),
+				"sshid": ctx.User(),
+				"ip":    ctx.RemoteAddr().String(),
+			})
+
+		logger.Info("admin channel started")
+		defer logger.Info("admin channel done")
+
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			logger.WithError(err).Error("failed to accept the channel opening")
+
+			return
+		}
+
+		defer channel.Close()
+		defer session.AddLocalChannel(ctx, channel)()
+
+		var (
+			mu     sync.Mutex
+			hasPty bool
+			size   [2]int
+			resize func(width, height int)
+		)
+
+		setSize := func(width, height int) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			size = [2]int{width, height}
+			if resize != nil {
+				resize(width, height)
+			}
+		}
+
+		type start struct {
+			kind    string
+			command string
+		}
+
+		started := make(chan start, 1)
+
+		go func() {
+			for req := range requests {
+				ok := true
+
+				switch req.Type {
+				case PtyRequestType:
+					var pty models.SSHPty
+					if err := gossh.Unmarshal(req.Payload, &pty); err != nil {
+						ok = false
+
+						break
+					}
+
+					mu.Lock()
+					hasPty = true
+					mu.Unlock()
+
+					setSize(int(pty.Columns), int(pty.Rows))
+				case WindowChangeRequestType:
+					var dimensions models.SSHWindowChange
+					if err := gossh.Unmarshal(req.Payload, &dimensions); err != nil {
+						ok = false
+
+						break
+					}
+
+					setSize(int(dimensions.Columns), int(dimensions.Rows))
+				case ShellRequestType:
+					select {
+					case started <- start{kind: req.Type}:
+					default:
+						ok = false
+					}
+				case ExecRequestType:
+					var payload struct{ Command string }
+					if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+						ok = false
+
+						break
+					}
+
+					select {
+					case started <- start{kind: req.Type, command: payload.Command}:
+					default:
+						ok = false
+					}
+				case EnvRequestType:
+				default:
+					ok = false
+				}
+
+				if req.WantReply {
+					if err := req.Reply(ok, nil); err != nil {
+						logger.WithError(err).Error(err)
+					}
+				}
+			}
+		}()
+
+		var request start
+		select {
+		case <-ctx.Done():
+			return
+		case request = <-started:
+		}
+
+		if request.kind == ExecRequestType {
+			logger.WithField("command", request.command).Info("admin command")
+
+			args, err := shlex.Split(request.command, true)
+			if err != nil {
+				fmt.Fprintf(channel.Stderr(), "error: %s\n", err)
+				exit(channel, 1)
+
+				return
+			}
+
+			if err := shell.Exec(channel, args); err != nil && !errors.Is(err, admin.ErrExit) {
+				fmt.Fprintf(channel.Stderr(), "error: %s\n", err)
+				exit(channel, 1)
+
+				return
+			}
+
+			exit(channel, 0)
+
+			return
+		}
+
+		mu.Lock()
+		interactive := hasPty
+		mu.Unlock()
+
+		if !interactive {
+			fmt.Fprint(channel.Stderr(), "The admin shell requires a pty. Use `ssh -t` or run a single command.\n")
+			exit(channel, 1)
+
+			return
+		}
+
+		err = shell.Run(channel, session.LocalUser(ctx), func(fn func(width, height int)) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			resize = fn
+			resize(size[0], size[1])
+		})
+		if err != nil {
+			logger.WithError(err).Error("admin shell failed")
+			exit(channel, 1)
+
+			return
+		}
+
+		exit(channel, 0)
+	}
+}
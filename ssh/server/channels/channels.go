@@ -12,5 +12,10 @@ const (
 	//
 	// Example of dynamic application-level port forwarding: `ssh -D 1080 user@sshid`.
 	DirectTCPIPChannel = "direct-tcpip"
-	SessionChannel     = "session"
+	// DirectStreamLocalChannel is the channel type for the OpenSSH extension to forward a Unix domain socket from the
+	// device to the client.
+	//
+	// Example: `ssh -L 2375:/var/run/docker.sock user@sshid`.
+	DirectStreamLocalChannel = "direct-streamlocal@openssh.com"
+	SessionChannel           = "session"
 )
@@ -0,0 +1,155 @@
+package channels
+
+import (
+	"fmt"
+	"io"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// JumpPort is the only port a client can reach on jump host mode, where the agent's SSH server listens.
+	JumpPort = 22
+	// JumpType is the type of the sessions of the jump host connections.
+	JumpType = "jump"
+)
+
+// JumpDirectTCPIPHandler is the handler for direct-tcpip channels of clients using the server as a standard SSH jump
+// host, like `ssh -J jump@server user@DEVICE123`.
+//
+// The client authenticates on the server itself, like on the device picker, and the destination host of the channel
+// names the device, as "<device>" or "<device>.<namespace>", on port 22. The channel is bridged to the agent's SSH
+// server as is, so the client gets a true end-to-end SSH connection, verifying the agent's host key and authenticating
+// on the agent. Each hop is a session of its own, capped, checked by the firewall, audited and listed like the other
+// sessions.
+//
+// The devices function lists the devices the user of the connection can reach.
+func JumpDirectTCPIPHandler(devices func(ctx gliderssh.Context) []models.Device, tunnel session.Tunnel) gliderssh.ChannelHandler {
+	return func(_ *gliderssh.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		data := struct {
+			DestAddr   string
+			DestPort   uint32
+			OriginAddr string
+			OriginPort uint32
+		}{}
+
+		if err := gossh.Unmarshal(newChan.ExtraData(), &data); err != nil {
+			newChan.Reject(gossh.ConnectionFailed, "failed to parse forward data: "+err.Error()) //nolint:errcheck
+
+			return
+		}
+
+		logger := log.WithFields(log.Fields{
+			"uid":       ctx.SessionID(),
+			"sshid":     ctx.User(),
+			"dest_addr": data.DestAddr,
+			"dest_port": data.DestPort,
+		})
+
+		if data.DestPort != JumpPort {
+			newChan.Reject(gossh.Prohibited, "jump host only connects to port 22 of the devices") //nolint:errcheck
+			logger.Info("jump host refused a port other than 22")
+
+			return
+		}
+
+		device, err := target.ResolveDevice(devices(ctx), data.DestAddr)
+		if err != nil {
+			newChan.Reject(gossh.ConnectionFailed, err.Error()) //nolint:errcheck
+			logger.WithError(err).Info("jump host failed to resolve the device")
+
+			return
+		}
+
+		logger = logger.WithField("device", device.UID)
+
+		// NOTE: The client authenticates on the agent itself, so the hop is checked against the caps and the firewall
+		// as the user authenticated on the server.
+		sess, err := session.NewSessionWithSSHID(ctx, tunnel, fmt.Sprintf("%s@%s", session.LocalUser(ctx), device.UID))
+		if err != nil {
+			newChan.Reject(gossh.ConnectionFailed, err.Error()) //nolint:errcheck
+			logger.WithError(err).Error("jump host failed to create the session")
+
+			return
+		}
+
+		if err := sess.Admit(ctx); err != nil {
+			newChan.Reject(gossh.ResourceShortage, session.ErrConnectionLimit.Error()) //nolint:errcheck
+
+			return
+		}
+
+		defer sess.Leave()
+
+		if err := sess.Dial(ctx); err != nil {
+			newChan.Reject(gossh.ConnectionFailed, "device is offline or cannot be reached") //nolint:errcheck
+			logger.WithError(err).Warn("jump host failed to dial the device")
+
+			return
+		}
+
+		agent := sess.Agent.Conn
+		defer agent.Close()
+
+		if err := sess.Evaluate(ctx); err != nil {
+			newChan.Reject(gossh.Prohibited, session.ErrFirewallBlock.Error()) //nolint:errcheck
+			logger.WithError(err).Info("jump host connection blocked by the firewall")
+			sess.Audit(ctx, audit.Event{Type: audit.TypeConnectionRejected, Reason: err.Error()})
+
+			return
+		}
+
+		client, reqs, err := newChan.Accept()
+		if err != nil {
+			logger.WithError(err).Error("failed accepting the channel")
+
+			return
+		}
+
+		defer client.Close()
+
+		go gossh.DiscardRequests(reqs)
+
+		sess.Type = JumpType
+
+		remove := session.AddHop(ctx, sess)
+		defer remove()
+
+		logger = logger.WithField("session", sess.UID)
+		logger.Info("jump host connected the client to the device")
+		sess.Audit(ctx, audit.Event{Type: audit.TypeJumpConnected})
+
+		metrics.Sessions.WithLabelValues(JumpType).Inc()
+		metrics.SessionsTotal.WithLabelValues(JumpType).Inc()
+		defer metrics.Sessions.WithLabelValues(JumpType).Dec()
+
+		relay.Pipe(
+			&relayed{ReadWriteCloser: client, writer: metrics.Relayed(client, metrics.RelayJump, metrics.Downstream)},
+			&relayed{ReadWriteCloser: agent, writer: metrics.Relayed(agent, metrics.RelayJump, metrics.Upstream)},
+		)
+
+		logger.Info("jump host connection done")
+	}
+}
+
+// relayed is a connection whose writes go through the writer, like one counting the bytes relayed to it.
+type relayed struct {
+	io.ReadWriteCloser
+	writer io.Writer
+}
+
+func (r *relayed) Write(b []byte) (int, error) {
+	return r.writer.Write(b)
+}
+
+func (r *relayed) CloseWrite() error {
+	return relay.CloseWrite(r.ReadWriteCloser)
+}
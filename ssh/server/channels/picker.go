@@ -0,0 +1,349 @@
+package channels
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/picker"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// EnvRequestType is the request type used by the client to pass environment variables to the session.
+//
+// https://www.rfc-editor.org/rfc/rfc4254#section-6.4
+const EnvRequestType = "env"
+
+// DeviceEnv is the environment variable the client sets to choose the device without the picker, like
+// `ssh -o SetEnv=SHELLHUB_DEVICE=DEVICE123 alice@server`, for clients that cannot have the device on the SSHID.
+const DeviceEnv = "SHELLHUB_DEVICE"
+
+// PickerSessionHandler is the handler for session's channel when the client's SSHID has no device.
+//
+// The channel is accepted by the server itself, which shows the device picker on it. When the user chooses a device,
+// a session to that device is created, authenticated with the credential used on the server, and the channel is
+// bridged to the device as it would have been by [DefaultSessionHandler]. The requests received from the client while
+// the picker was running, like "pty-req" and "env", are replayed to the agent before the "shell" one.
+//
+// When the client sets [DeviceEnv], the channel is bridged to that device without the picker, for shells, command
+// executions and subsystems alike. Otherwise, clients that request a command execution or a shell without a pty
+// receive the device list and an exit status of 1, as there is no terminal to interact with.
+//
+// The devices function lists the devices the user of the connection can choose from.
+func PickerSessionHandler(devices func(ctx gliderssh.Context) []models.Device, tunnel session.Tunnel) gliderssh.ChannelHandler {
+	return func(_ *gliderssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+		logger := log.WithFields(
+			log.Fields{
+				"uid":   ctx.SessionID(),
+				"sshid": ctx.User(),
+				"ip":    ctx.RemoteAddr().String(),
+			})
+
+		logger.Info("device picker channel started")
+		defer logger.Info("device picker channel done")
+
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			logger.WithError(err).Error("failed to accept the channel opening")
+
+			return
+		}
+
+		defer channel.Close()
+		defer session.AddLocalChannel(ctx, channel)()
+
+		choose := picker.New(channel, ctx.User(), func() []models.Device {
+			return devices(ctx)
+		})
+
+		var (
+			mu       sync.Mutex
+			bridged  bool
+			hasPty   bool
+			replay   []*gossh.Request
+			selected string
+		)
+
+		forwarded := make(chan *gossh.Request)
+		started := make(chan *gossh.Request, 1)
+
+		go func() {
+			defer close(forwarded)
+
+			for req := range requests {
+				mu.Lock()
+				if bridged {
+					mu.Unlock()
+
+					forwarded <- req
+
+					continue
+				}
+
+				ok := true
+
+				switch req.Type {
+				case PtyRequestType:
+					var pty models.SSHPty
+					if err := gossh.Unmarshal(req.Payload, &pty); err != nil {
+						logger.WithError(err).Error("failed to recover the session dimensions")
+
+						ok = false
+
+						break
+					}
+
+					hasPty = true
+					choose.SetSize(int(pty.Columns), int(pty.Rows)) //nolint:errcheck
+
+					replay = append(replay, &gossh.Request{Type: req.Type, Payload: req.Payload})
+				case WindowChangeRequestType:
+					var dimensions models.SSHWindowChange
+					if err := gossh.Unmarshal(req.Payload, &dimensions); err != nil {
+						logger.WithError(err).Error("failed to recover the session dimensions")
+
+						ok = false
+
+						break
+					}
+
+					choose.SetSize(int(dimensions.Columns), int(dimensions.Rows)) //nolint:errcheck
+
+					replay = append(replay, &gossh.Request{Type: req.Type, Payload: req.Payload})
+				case EnvRequestType:
+					var env struct {
+						Name  string
+						Value string
+					}
+
+					if err := gossh.Unmarshal(req.Payload, &env); err == nil && env.Name == DeviceEnv {
+						selected = env.Value
+
+						break
+					}
+
+					replay = append(replay, &gossh.Request{Type: req.Type, Payload: req.Payload})
+				case AuthRequestOpenSSHRequest, X11RequestType:
+					replay = append(replay, &gossh.Request{Type: req.Type, Payload: req.Payload})
+				case ShellRequestType, ExecRequestType, SubsystemRequestType:
+					select {
+					case started <- &gossh.Request{Type: req.Type, Payload: req.Payload}:
+					default:
+						// NOTE: Only one of these requests can succeed per channel.
+						ok = false
+					}
+				default:
+					ok = false
+				}
+				mu.Unlock()
+
+				if req.WantReply {
+					if err := req.Reply(ok, nil); err != nil {
+						logger.WithError(err).Error(err)
+					}
+				}
+			}
+		}()
+
+		var start *gossh.Request
+		select {
+		case <-ctx.Done():
+			return
+		case start = <-started:
+		}
+
+		mu.Lock()
+		interactive := hasPty && start.Type == ShellRequestType
+		name := selected
+		mu.Unlock()
+
+		// enter bridges the channel to the session of the device, replaying the requests received so far.
+		enter := func(sess *session.Session, logger *log.Entry) {
+			go func() {
+				// NOTE: As [gossh.ServerConn] is shared by all channels calls, close it after a channel close block any
+				// other channel invocation. To avoid it, we wait for the connection to be closed to finish the session.
+				conn.Wait() //nolint:errcheck
+
+				sess.Finish() //nolint:errcheck
+			}()
+
+			seat, err := sess.NewSeat(ctx)
+			if err != nil {
+				logger.WithError(err).Error("failed to create a new seat on the SSH session")
+
+				fmt.Fprintf(channel.Stderr(), "Failed to connect to %s: %s\n", sess.Device.Name, err)
+				exit(channel, 1)
+
+				return
+			}
+
+			defer sess.LeaveSeat(seat)
+
+			mu.Lock()
+			bridged = true
+			pending := append(replay, start)
+			mu.Unlock()
+
+			reqs := make(chan *gossh.Request)
+			go func() {
+				defer close(reqs)
+
+				for _, req := range pending {
+					reqs <- req
+				}
+
+				for req := range forwarded {
+					reqs <- req
+				}
+			}()
+
+			client, err := sess.AttachClientChannel(channel, reqs, seat)
+			if err != nil {
+				logger.WithError(err).Error("failed to attach the picker channel to the session")
+
+				return
+			}
+
+			bridge(ctx, sess, client, seat, logger.WithFields(log.Fields{
+				"uid":      sess.UID,
+				"sshid":    sess.SSHID,
+				"username": sess.Target.Username,
+			}))
+		}
+
+		if name != "" {
+			logger := logger.WithField("device", name)
+
+			device, err := target.ResolveDevice(devices(ctx), name)
+			if err != nil {
+				logger.WithError(err).Info("failed to resolve the device set on the environment")
+
+				fmt.Fprintf(channel.Stderr(), "Failed to connect to %s: %s\n", name, err)
+				exit(channel, 1)
+
+				return
+			}
+
+			logger = logger.WithField("device", device.UID)
+			logger.Info("device chosen on the environment")
+
+			sess, err := connect(ctx, tunnel, device)
+			if err != nil {
+				logger.WithError(err).Warn("failed to connect to the device chosen on the environment")
+
+				fmt.Fprintf(channel.Stderr(), "Failed to connect to %s: %s\n", device.Name, err)
+				exit(channel, 1)
+
+				return
+			}
+
+			enter(sess, logger)
+
+			return
+		}
+
+		if !interactive {
+			logger.WithField("type", start.Type).Info("device picker requires a shell with a pty; listing the devices")
+
+			picker.Render(channel, picker.Filter(devices(ctx), "", false))
+			fmt.Fprintf(channel.Stderr(), "\nNo device was informed. Connect with: ssh %s@<device>@<server>\n", ctx.User())
+
+			exit(channel, 1)
+
+			return
+		}
+
+		for {
+			device, err := choose.Run()
+			if err != nil {
+				if !errors.Is(err, picker.ErrCanceled) {
+					logger.WithError(err).Error("device picker failed")
+				}
+
+				exit(channel, 1)
+
+				return
+			}
+
+			logger := logger.WithField("device", device.UID)
+			logger.Info("device chosen on the device picker")
+
+			fmt.Fprintf(channel, "Connecting to %s...\r\n", device.Name)
+
+			sess, err := connect(ctx, tunnel, device)
+			if err != nil {
+				logger.WithError(err).Warn("failed to connect to the device chosen on the device picker")
+
+				fmt.Fprintf(channel, "Failed to connect to %s: %s\r\n\r\n", device.Name, err)
+
+				continue
+			}
+
+			enter(sess, logger)
+
+			return
+		}
+	}
+}
+
+// connect creates, dials and authenticates a session to the device chosen on the picker, using the credential the
+// client used to authenticate on the server.
+func connect(ctx gliderssh.Context, tunnel session.Tunnel, device *models.Device) (*session.Session, error) {
+	credential, ok := session.GetCredential(ctx)
+	if !ok {
+		return nil, errors.New("no credential to authenticate on the device")
+	}
+
+	sess, err := session.NewSessionWithSSHID(ctx, tunnel, fmt.Sprintf("%s@%s", ctx.User(), device.UID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sess.Admit(ctx); err != nil {
+		return nil, session.ErrConnectionLimit
+	}
+
+	if err := sess.Dial(ctx); err != nil {
+		sess.Leave()
+
+		if errors.Is(err, session.ErrTimeout) {
+			return nil, session.ErrTimeout
+		}
+
+		return nil, session.ErrDial
+	}
+
+	// NOTE: A failed authentication resets the agent's connection on the session, so we keep it to close.
+	agent := sess.Agent.Conn
+
+	if err := sess.Evaluate(ctx); err != nil {
+		sess.Leave()
+		agent.Close()
+
+		return nil, err
+	}
+
+	if err := sess.Auth(ctx, credential); err != nil {
+		sess.Leave()
+		agent.Close()
+
+		if errors.Is(err, session.ErrTimeout) {
+			return nil, session.ErrTimeout
+		}
+
+		return nil, errors.New("authentication on the device failed")
+	}
+
+	return sess, nil
+}
+
+// exit sends the exit status to the client and closes the channel.
+func exit(channel gossh.Channel, status uint32) {
+	channel.SendRequest(ExitStatusRequest, false, gossh.Marshal(models.SSHExitStatus{Status: status})) //nolint:errcheck
+	channel.Close()                                                                                    //nolint:errcheck
+}
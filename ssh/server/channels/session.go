@@ -1,10 +1,12 @@
 package channels
 
 import (
+	"errors"
 	"strings"
 	"sync"
 
 	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
     "github.com/shellhub-io/shellhub/pkg/models"
     "github.com/shellhub-io/mini-shellhub/ssh/session"
 	log "github.com/sirupsen/logrus"
@@ -78,6 +80,18 @@ const AuthRequestOpenSSHRequest = "auth-agent-req@openssh.com"
 // https://www.ietf.org/archive/id/draft-miller-ssh-agent-11.html#section-4.2
 const AuthRequestOpenSSHChannel = "auth-agent@openssh.com"
 
+// A client may request X11 forwarding for a session, sending the authentication protocol and a fake cookie the agent
+// sets for the display it allocates.
+//
+// https://www.rfc-editor.org/rfc/rfc4254#section-6.3.1
+const X11RequestType = "x11-req"
+
+// After X11 forwarding was requested, the agent opens an X11Channel for each connection to its display, which is
+// bridged to a channel of the same type opened to the client.
+//
+// https://www.rfc-editor.org/rfc/rfc4254#section-6.3.2
+const X11Channel = "x11"
+
 // DefaultSessionHandler is the default handler for session's channel.
 //
 // A session is a remote execution of a program. The program may be a shell, an application, a system command, or some
@@ -101,6 +115,7 @@ func DefaultSessionHandler() gliderssh.ChannelHandler {
 				"uid":      sess.UID,
 				"sshid":    sess.SSHID,
 				"device":   sess.Device.UID,
+				"gateway":  sess.Gateway,
 				"username": sess.Target.Username,
 				"ip":       sess.IPAddress,
 			})
@@ -114,13 +129,23 @@ func DefaultSessionHandler() gliderssh.ChannelHandler {
 		logger.Info("session channel started")
 		defer logger.Info("session channel done")
 
-		seat, err := sess.NewSeat()
+		seat, err := sess.NewSeat(ctx)
 		if err != nil {
+			if errors.Is(err, session.ErrChannelLimit) {
+				logger.WithError(err).Warn("too many channels to the device")
+
+				newChan.Reject(gossh.ResourceShortage, session.ErrChannelLimit.Error()) //nolint:errcheck
+
+				return
+			}
+
 			reject(err, "failed to create a new seat on the SSH session")
 
 			return
 		}
 
+		defer sess.LeaveSeat(seat)
+
 		client, err := sess.NewClientChannel(newChan, seat)
 		if err != nil {
 			reject(err, "failed to accept the channel opening")
@@ -130,237 +155,317 @@ func DefaultSessionHandler() gliderssh.ChannelHandler {
 
 		defer client.Close()
 
-		agent, err := sess.NewAgentChannel(SessionChannel, seat)
-		if err != nil {
-			reject(err, "failed to open the session channel on agent")
+		bridge(ctx, sess, client, seat, logger)
+	}
+}
 
-			return
-		}
+// bridge opens the session channel on the agent and relays data and requests between it and the client's channel,
+// that must have already been accepted, until both sides are done.
+func bridge(ctx gliderssh.Context, sess *session.Session, client *session.ClientChannel, seat int, logger *log.Entry) {
+	agent, err := sess.NewAgentChannel(SessionChannel, seat)
+	if err != nil {
+		logger.WithError(err).Error("failed to open the session channel on agent")
 
-		defer agent.Close()
+		return
+	}
 
-		var wg sync.WaitGroup
+	defer agent.Close()
 
-		done := make(chan bool)
+	var wg sync.WaitGroup
 
-		oncePipe := sync.OnceFunc(func() {
-			go pipe(sess, client.Channel, agent.Channel, seat, done)
-		})
+	// NOTE: The session is counted once the agent starts its program, by the type of the request that started it.
+	var program string
+	defer func() {
+		if program != "" {
+			metrics.Sessions.WithLabelValues(program).Dec()
+		}
+	}()
 
-		wg.Add(3)
+	done := make(chan bool)
 
-		go func() {
-			defer wg.Done()
+	oncePipe := sync.OnceFunc(func() {
+		go pipe(sess, client.Channel, agent.Channel, seat, done)
+	})
 
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Info("context has done (global requests)")
+	wg.Add(3)
 
-					return
-				case req, ok := <-sess.Agent.Requests:
-					if !ok {
-						logger.Trace("global requests is closed")
+	go func() {
+		defer wg.Done()
 
-						return
-					}
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("context has done (global requests)")
 
-					logger.Debugf("global request from agent: %s", req.Type)
+				return
+			case req, ok := <-sess.Agent.Requests:
+				if !ok {
+					logger.Trace("global requests is closed")
 
-					switch {
-					// NOTE: The Agent sends "keepalive" requests to the server to avoid the Web Socket being closed due
-					// to inactivity. Through the time, the request type sent from agent to server changed its name, but
-					// always keeping the prefix "keepalive". So, to maintain the retro compatibility, we check if this
-					// prefix exists and perform the necessary operations.
-					case strings.HasPrefix(req.Type, KeepAliveRequestTypePrefix):
-						if _, err := client.Channel.SendRequest(KeepAliveRequestType, req.WantReply, req.Payload); err != nil {
-							logger.Error("failed to send the keepalive request received from agent to client")
+					return
+				}
 
-							return
-						}
+				logger.Debugf("global request from agent: %s", req.Type)
 
-						if err := sess.KeepAlive(); err != nil {
-							logger.WithError(err).Error("failed to send the API request to inform that the session is open")
+				switch {
+				// NOTE: The Agent sends "keepalive" requests to the server to avoid the Web Socket being closed due
+				// to inactivity. Through the time, the request type sent from agent to server changed its name, but
+				// always keeping the prefix "keepalive". So, to maintain the retro compatibility, we check if this
+				// prefix exists and perform the necessary operations.
+				case strings.HasPrefix(req.Type, KeepAliveRequestTypePrefix):
+					if _, err := client.Channel.SendRequest(KeepAliveRequestType, req.WantReply, req.Payload); err != nil {
+						logger.Error("failed to send the keepalive request received from agent to client")
 
-							return
-						}
-					default:
-						if req.WantReply {
-							if err := req.Reply(false, nil); err != nil {
-								logger.WithError(err).Error(err)
-							}
+						return
+					}
+
+					if err := sess.KeepAlive(); err != nil {
+						logger.WithError(err).Error("failed to send the API request to inform that the session is open")
+
+						return
+					}
+				default:
+					if req.WantReply {
+						if err := req.Reply(false, nil); err != nil {
+							logger.WithError(err).Error(err)
 						}
 					}
 				}
 			}
-		}()
+		}
+	}()
 
-		go func() {
-			defer wg.Done()
-			defer func() {
-				logger.Debug("agent waiting for data done to close client")
+	go func() {
+		defer wg.Done()
+		defer func() {
+			logger.Debug("agent waiting for data done to close client")
 
-				<-done
-				client.Close()
-			}()
+			<-done
+			client.Close()
+		}()
 
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Info("context has done (agent requests)")
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("context has done (agent requests)")
 
-					return
-				case req, ok := <-agent.Requests:
-					if !ok {
-						logger.Trace("agent requests is closed")
+				return
+			case req, ok := <-agent.Requests:
+				if !ok {
+					logger.Trace("agent requests is closed")
 
-						return
-					}
+					return
+				}
 
-					switch req.Type {
-					case ExitStatusRequest:
-						session.Event[models.SSHExitStatus](sess, req.Type, req.Payload, seat)
-					case ExitSignalRequest:
-						session.Event[models.SSHSignal](sess, req.Type, req.Payload, seat)
-					default:
-						sess.Event(req.Type, req.Payload, seat)
-					}
+				switch req.Type {
+				case ExitStatusRequest:
+					session.Event[models.SSHExitStatus](sess, req.Type, req.Payload, seat)
+				case ExitSignalRequest:
+					session.Event[models.SSHSignal](sess, req.Type, req.Payload, seat)
+				default:
+					sess.Event(req.Type, req.Payload, seat)
+				}
 
-					logger.Debugf("request from agent to client: %s", req.Type)
+				logger.Debugf("request from agent to client: %s", req.Type)
 
-					ok, err := client.Channel.SendRequest(req.Type, req.WantReply, req.Payload)
-					if err != nil {
-						logger.WithError(err).Error("failed to send the request from agent to client")
+				ok, err := client.Channel.SendRequest(req.Type, req.WantReply, req.Payload)
+				if err != nil {
+					logger.WithError(err).Error("failed to send the request from agent to client")
 
-						continue
-					}
+					continue
+				}
 
-					if req.WantReply {
-						if err := req.Reply(ok, nil); err != nil {
-							logger.WithError(err).Error(err)
-						}
+				if req.WantReply {
+					if err := req.Reply(ok, nil); err != nil {
+						logger.WithError(err).Error(err)
 					}
 				}
 			}
-		}()
+		}
+	}()
 
-		go func() {
-			defer wg.Done()
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("context has done (client requests)")
 
-			for {
-				select {
-				case <-ctx.Done():
-					logger.Info("context has done (client requests)")
+				return
+			case req, ok := <-client.Requests:
+				if !ok {
+					logger.Trace("client requests is closed")
 
 					return
-				case req, ok := <-client.Requests:
-					if !ok {
-						logger.Trace("client requests is closed")
+				}
 
-						return
+				// NOTE: The agent's channels of agent forwarding and X11 are handled once per connection, so a pooled
+				// connection, shared with other client connections, could send them to the wrong client.
+				if sess.Pooled() && (req.Type == AuthRequestOpenSSHRequest || req.Type == X11RequestType) {
+					logger.Infof("%s refused on a pooled connection to the agent", req.Type)
+
+					if req.WantReply {
+						req.Reply(false, nil) //nolint:errcheck
 					}
 
-					switch req.Type {
-					case ShellRequestType:
-						if seat, ok := sess.Seats.Get(seat); ok && seat.HasPty {
-							if err := sess.Announce(client.Channel); err != nil {
-								logger.WithError(err).Warn("failed to get the namespace announcement")
-							}
+					continue
+				}
+
+				switch req.Type {
+				case ShellRequestType:
+					if seat, ok := sess.Seats.Get(seat); ok && seat.HasPty {
+						if err := sess.Announce(client.Channel); err != nil {
+							logger.WithError(err).Warn("failed to get the namespace announcement")
 						}
+					}
 
-						sess.Event(req.Type, req.Payload, seat)
-					case ExecRequestType, SubsystemRequestType:
-						session.Event[models.SSHCommand](sess, req.Type, req.Payload, seat)
+					sess.Event(req.Type, req.Payload, seat)
+				case ExecRequestType, SubsystemRequestType:
+					session.Event[models.SSHCommand](sess, req.Type, req.Payload, seat)
 
-						sess.Type = ExecRequestType
-					case PtyRequestType:
-						var pty models.SSHPty
+					sess.Type = ExecRequestType
+				case PtyRequestType:
+					var pty models.SSHPty
 
-						if err := gossh.Unmarshal(req.Payload, &pty); err != nil {
-							reject(nil, "failed to recover the session dimensions")
-						}
+					if err := gossh.Unmarshal(req.Payload, &pty); err != nil {
+						logger.Error("failed to recover the session dimensions")
+					}
 
-						sess.Seats.SetPty(seat, true)
+					sess.Seats.SetPty(seat, true)
+					sess.SetInteractive()
 
-						sess.Event(req.Type, pty, seat) //nolint:errcheck
-					case WindowChangeRequestType:
-						var dimensions models.SSHWindowChange
+					sess.Event(req.Type, pty, seat) //nolint:errcheck
+				case WindowChangeRequestType:
+					var dimensions models.SSHWindowChange
 
-						if err := gossh.Unmarshal(req.Payload, &dimensions); err != nil {
-							reject(nil, "failed to recover the session dimensions")
-						}
+					if err := gossh.Unmarshal(req.Payload, &dimensions); err != nil {
+						logger.Error("failed to recover the session dimensions")
+					}
 
-						sess.Event(req.Type, dimensions, seat) //nolint:errcheck
-					case AuthRequestOpenSSHRequest:
-						gliderssh.SetAgentRequested(ctx)
+					sess.Event(req.Type, dimensions, seat) //nolint:errcheck
+				case AuthRequestOpenSSHRequest:
+					gliderssh.SetAgentRequested(ctx)
 
-						sess.Event(req.Type, req.Payload, seat)
-						go func() {
-							clientConn := ctx.Value(gliderssh.ContextKeyConn).(gossh.Conn)
-							agentChannels := sess.Agent.Client.HandleChannelOpen(AuthRequestOpenSSHChannel)
+					sess.Event(req.Type, req.Payload, seat)
+					go func() {
+						clientConn := ctx.Value(gliderssh.ContextKeyConn).(gossh.Conn)
+						agentChannels := sess.Agent.Client.HandleChannelOpen(AuthRequestOpenSSHChannel)
 
-							for {
-								newAgentChannel, ok := <-agentChannels
-								if !ok {
-									reject(nil, "channel for agent forwarding done")
+						for {
+							newAgentChannel, ok := <-agentChannels
+							if !ok {
+								logger.Error("channel for agent forwarding done")
 
-									return
-								}
+								return
+							}
+
+							agentChannel, agentReqs, err := newAgentChannel.Accept()
+							if err != nil {
+								logger.Error("failed to accept the chanel request from agent on auth request")
 
-								agentChannel, agentReqs, err := newAgentChannel.Accept()
-								if err != nil {
-									reject(nil, "failed to accept the chanel request from agent on auth request")
+								return
+							}
 
-									return
-								}
+							defer agentChannel.Close()
+							go gossh.DiscardRequests(agentReqs)
 
-								defer agentChannel.Close()
-								go gossh.DiscardRequests(agentReqs)
+							clientChannel, clientReqs, err := clientConn.OpenChannel(AuthRequestOpenSSHChannel, nil)
+							if err != nil {
+								logger.Error("failed to open the auth request channel from agent to client")
 
-								clientChannel, clientReqs, err := clientConn.OpenChannel(AuthRequestOpenSSHChannel, nil)
-								if err != nil {
-									reject(nil, "failed to open the auth request channel from agent to client")
+								return
+							}
 
-									return
-								}
+							defer clientChannel.Close()
+							go gossh.DiscardRequests(clientReqs)
 
-								defer clientChannel.Close()
-								go gossh.DiscardRequests(clientReqs)
+							hose(sess, agentChannel, clientChannel)
 
-								hose(sess, agentChannel, clientChannel)
+							logger.WithError(err).Trace("auth request channel piping done")
+						}
+					}()
+				case X11RequestType:
+					sess.Event(req.Type, req.Payload, seat)
 
-								logger.WithError(err).Trace("auth request channel piping done")
-							}
-						}()
-					default:
-						sess.Event(req.Type, req.Payload, seat)
+					// NOTE: The agent's X11 channels are handled once per connection, as every session's channel with
+					// X11 forwarding shares them.
+					if x11Channels := sess.Agent.Client.HandleChannelOpen(X11Channel); x11Channels != nil {
+						clientConn := ctx.Value(gliderssh.ContextKeyConn).(gossh.Conn)
+
+						go forwardX11(sess, clientConn, x11Channels, logger)
 					}
+				default:
+					sess.Event(req.Type, req.Payload, seat)
+				}
 
-					logger.Debugf("request from client to agent: %s", req.Type)
+				logger.Debugf("request from client to agent: %s", req.Type)
 
-					ok, err := agent.Channel.SendRequest(req.Type, req.WantReply, req.Payload)
-					if err != nil {
-						logger.WithError(err).Error("failed to send the request from client to agent")
+				ok, err := agent.Channel.SendRequest(req.Type, req.WantReply, req.Payload)
+				if err != nil {
+					logger.WithError(err).Error("failed to send the request from client to agent")
 
-						continue
-					}
+					continue
+				}
 
-					if req.WantReply {
-						if err := req.Reply(ok, nil); err != nil {
-							logger.WithError(err).Error(err)
-						}
+				if req.WantReply {
+					if err := req.Reply(ok, nil); err != nil {
+						logger.WithError(err).Error(err)
 					}
+				}
+
+				switch req.Type {
+				case ShellRequestType, ExecRequestType, SubsystemRequestType:
+					if program == "" && (ok || !req.WantReply) {
+						program = req.Type
 
-					switch req.Type {
-					case PtyRequestType, ExecRequestType, SubsystemRequestType:
-						oncePipe()
+						metrics.Sessions.WithLabelValues(program).Inc()
+						metrics.SessionsTotal.WithLabelValues(program).Inc()
 					}
 				}
+
+				switch req.Type {
+				case PtyRequestType, ExecRequestType, SubsystemRequestType:
+					oncePipe()
+				}
 			}
-		}()
+		}
+	}()
+
+	wg.Wait()
+
+	logger.Debug("session done after waiting")
+}
+
+// forwardX11 bridges each X11 channel opened by the agent to a channel opened to the client, with the same originator
+// address and port.
+func forwardX11(sess *session.Session, clientConn gossh.Conn, x11Channels <-chan gossh.NewChannel, logger *log.Entry) {
+	for newAgentChannel := range x11Channels {
+		go func(newAgentChannel gossh.NewChannel) {
+			clientChannel, clientReqs, err := clientConn.OpenChannel(X11Channel, newAgentChannel.ExtraData())
+			if err != nil {
+				newAgentChannel.Reject(gossh.ConnectionFailed, err.Error()) //nolint:errcheck
+				logger.WithError(err).Error("failed to open the x11 channel to client")
+
+				return
+			}
+
+			defer clientChannel.Close()
+			go gossh.DiscardRequests(clientReqs)
+
+			agentChannel, agentReqs, err := newAgentChannel.Accept()
+			if err != nil {
+				logger.WithError(err).Error("failed to accept the x11 channel from agent")
+
+				return
+			}
+
+			defer agentChannel.Close()
+			go gossh.DiscardRequests(agentReqs)
 
-		wg.Wait()
+			hose(sess, agentChannel, clientChannel)
 
-		logger.Debug("session done after waiting")
+			logger.Trace("x11 channel piping done")
+		}(newAgentChannel)
 	}
 }
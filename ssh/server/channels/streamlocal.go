@@ -0,0 +1,102 @@
+package channels
+
+import (
+	"io"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultDirectStreamLocalHandler is the channel's handler for direct-streamlocal@openssh.com channels, used to forward
+// a Unix domain socket from the device to the client.
+//
+// The server does not check the socket path; the agent decides which paths each user can reach.
+func DefaultDirectStreamLocalHandler(_ *gliderssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
+	sess, state := session.ObtainSession(ctx)
+	if state < session.StateFinished || sess.Agent == nil || sess.Agent.Client == nil {
+		newChan.Reject(gossh.ConnectionFailed, "session to the device is not established") //nolint:errcheck
+
+		return
+	}
+
+	go func() {
+		// NOTICE: As [gossh.ServerConn] is shared by all channels calls, close it after a channel close block any
+		// other channel involkation. To avoid it, we wait for the connection be closed to finish the sesison.
+		conn.Wait() //nolint:errcheck
+
+		sess.Finish() //nolint:errcheck
+	}()
+
+	data := struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}{}
+
+	if err := gossh.Unmarshal(newChan.ExtraData(), &data); err != nil {
+		newChan.Reject(gossh.ConnectionFailed, "failed to parse stream local data: "+err.Error()) //nolint:errcheck
+
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"username": sess.Target.Username,
+		"sshid":    sess.Target.Data,
+		"socket":   data.SocketPath,
+	})
+
+	agent, err := sess.Agent.Client.Dial("unix", data.SocketPath)
+	if err != nil {
+		newChan.Reject(gossh.ConnectionFailed, "failed dialing the agent to the unix socket: "+err.Error()) //nolint:errcheck
+		logger.WithError(err).Error("failed dialing the agent to the unix socket")
+
+		return
+	}
+
+	defer agent.Close()
+
+	client, reqs, err := newChan.Accept()
+	if err != nil {
+		logger.WithError(err).Error("failed accepting the channel")
+
+		return
+	}
+
+	defer client.Close()
+
+	go gossh.DiscardRequests(reqs)
+
+	logger.Info("piping unix socket data between client and agent")
+
+	wg := new(sync.WaitGroup)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer client.CloseWrite() //nolint:errcheck
+
+		if _, err := io.Copy(client, agent); err != nil && err != io.EOF {
+			logger.WithError(err).Error("failed to copy data from agent to client")
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		if _, err := io.Copy(agent, client); err != nil && err != io.EOF {
+			logger.WithError(err).Error("failed to copy data from client to agent")
+		}
+
+		// NOTE: The agent's end is a channel too, so the client's EOF is passed on without closing the other direction.
+		if closer, ok := agent.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite() //nolint:errcheck
+		}
+	}()
+
+	wg.Wait()
+
+	logger.Trace("handling direct-streamlocal finished")
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
     "github.com/shellhub-io/mini-shellhub/ssh/session"
 	log "github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
@@ -15,7 +16,13 @@ import (
 // DefaultDirectTCPIPHandler is the channel's handler for direct-tcpip channels like "local port forwarding" and "dynamic
 // application-level port forwarding".
 func DefaultDirectTCPIPHandler(server *gliderssh.Server, conn *gossh.ServerConn, newChan gossh.NewChannel, ctx gliderssh.Context) {
-	sess, _ := session.ObtainSession(ctx)
+	sess, state := session.ObtainSession(ctx)
+	if state < session.StateFinished || sess.Agent == nil || sess.Agent.Client == nil {
+		newChan.Reject(gossh.ConnectionFailed, "session to the device is not established") //nolint:errcheck
+
+		return
+	}
+
 	go func() {
 		// NOTICE: As [gossh.ServerConn] is shared by all channels calls, close it after a channel close block any
 		// other channel involkation. To avoid it, we wait for the connection be closed to finish the sesison.
@@ -134,7 +141,7 @@ func DefaultDirectTCPIPHandler(server *gliderssh.Server, conn *gossh.ServerConn,
 			"dest_addr":   data.DestAddr,
 		}).Trace("copying data from client to agent")
 
-		if _, err := io.Copy(client, agent); err != nil && err != io.EOF {
+		if _, err := io.Copy(metrics.Relayed(client, metrics.RelayDirectTCPIP, metrics.Downstream), agent); err != nil && err != io.EOF {
 			log.WithError(err).Error("failed to copy data from agent to client")
 
 			return
@@ -154,7 +161,7 @@ func DefaultDirectTCPIPHandler(server *gliderssh.Server, conn *gossh.ServerConn,
 			"dest_addr":   data.DestAddr,
 		}).Trace("copying data from agent to client")
 
-		if _, err := io.Copy(agent, client); err != nil && err != io.EOF {
+		if _, err := io.Copy(metrics.Relayed(agent, metrics.RelayDirectTCPIP, metrics.Upstream), client); err != nil && err != io.EOF {
 			log.WithError(err).Error("failed to copy data from client to agent")
 
 			return
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/Masterminds/semver"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
 	"github.com/shellhub-io/shellhub/pkg/envs"
 	"github.com/shellhub-io/shellhub/pkg/models"
     "github.com/shellhub-io/mini-shellhub/ssh/session"
@@ -38,8 +39,14 @@ func (c *Recorder) Write(output []byte) (int, error) {
 	return len(output), nil // len output
 }
 
+// isRecording checks if sessions should be recorded. Besides Cloud and Enterprise instances, the minimal server records
+// sessions in memory when SHELLHUB_RECORD_SESSIONS is "true".
+func isRecording() bool {
+	return envs.IsEnterprise() || envs.IsCloud() || envs.DefaultBackend.Get("SHELLHUB_RECORD_SESSIONS") == envs.ENABLED
+}
+
 // pipe function pipes data between client and agent, and vice versa, recording each frame when ShellHub instance are
-// Cloud or Enterprise.
+// Cloud or Enterprise, or when the session recording is enabled. Data flowing either way marks the session as active.
 func pipe(sess *session.Session, client gossh.Channel, agent gossh.Channel, seat int, done chan bool) {
 	defer log.
 		WithFields(log.Fields{"session": sess.UID, "sshid": sess.SSHID}).
@@ -58,8 +65,8 @@ func pipe(sess *session.Session, client gossh.Channel, agent gossh.Channel, seat
 			done <- true
 		}()
 
-		writers := []io.Writer{client}
-		if envs.IsEnterprise() || envs.IsCloud() {
+		writers := []io.Writer{sess.Active(metrics.Relayed(client, metrics.RelayPipe, metrics.Downstream))}
+		if isRecording() {
 			recorder, err := NewRecorder(sess, seat)
 			if err != nil {
 				log.WithError(err).
@@ -106,7 +113,7 @@ func pipe(sess *session.Session, client gossh.Channel, agent gossh.Channel, seat
 			}
 		}()
 
-		if _, err := io.Copy(agent, c); err != nil && err != io.EOF {
+		if _, err := io.Copy(sess.Active(metrics.Relayed(agent, metrics.RelayPipe, metrics.Upstream)), c); err != nil && err != io.EOF {
 			log.WithError(err).Error("failed on coping data from client to agent")
 		}
 
@@ -132,7 +139,7 @@ func hose(sess *session.Session, agent gossh.Channel, client gossh.Channel) {
 		defer wg.Done()
 		defer agent.CloseWrite() //nolint:errcheck
 
-		if _, err := io.Copy(agent, c); err != nil && err != io.EOF {
+		if _, err := io.Copy(metrics.Relayed(agent, metrics.RelayHose, metrics.Upstream), c); err != nil && err != io.EOF {
 			log.WithError(err).Error("failed on coping data from client to agent")
 		}
 
@@ -143,7 +150,7 @@ func hose(sess *session.Session, agent gossh.Channel, client gossh.Channel) {
 		defer wg.Done()
 		defer client.CloseWrite() //nolint:errcheck
 
-		if _, err := io.Copy(client, a); err != nil && err != io.EOF {
+		if _, err := io.Copy(metrics.Relayed(client, metrics.RelayHose, metrics.Downstream), a); err != nil && err != io.EOF {
 			log.WithError(err).Error("failed on coping data from agent to client")
 		}
 
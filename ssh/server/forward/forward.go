@@ -0,0 +1,345 @@
+// Package forward implements the reverse port forwarding, like `ssh -R 9000:localhost:3000 user@device@server`, where
+// the device listens on a port and the connections to it are forwarded back to the client.
+//
+// The server does not listen on its own ports. When the client asks for a forwarding, the server asks the agent,
+// through the SSH connection to the device, to listen on the port. Each connection accepted by the device is received
+// by the server as a "forwarded-tcpip" channel from the agent, and bridged to a "forwarded-tcpip" channel opened to the
+// client.
+//
+// Unix domain sockets are forwarded the same way, through the OpenSSH "streamlocal-forward@openssh.com" extension, like
+// `ssh -R /tmp/app.sock:localhost:3000 user@device@server`. In this case, the agent decides which socket paths each
+// user can listen on.
+package forward
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// RequestTcpipForward is the global request sent by the client to start a reverse port forwarding.
+	//
+	// Check www.ietf.org/rfc/rfc4254.txt at section 7.1 for more information.
+	RequestTcpipForward = "tcpip-forward"
+	// RequestCancelTcpipForward is the global request sent by the client to stop a reverse port forwarding.
+	RequestCancelTcpipForward = "cancel-tcpip-forward"
+	// ForwardedTCPIPChannel is the channel opened to the client for each connection accepted on the forwarded port.
+	//
+	// Check www.ietf.org/rfc/rfc4254.txt at section 7.2 for more information.
+	ForwardedTCPIPChannel = "forwarded-tcpip"
+	// RequestStreamLocalForward is the global request sent by the client to start a Unix socket reverse forwarding.
+	//
+	// Check https://github.com/openssh/openssh-portable/blob/master/PROTOCOL at section 2.4 for more information.
+	RequestStreamLocalForward = "streamlocal-forward@openssh.com"
+	// RequestCancelStreamLocalForward is the global request sent by the client to stop a Unix socket reverse
+	// forwarding.
+	RequestCancelStreamLocalForward = "cancel-streamlocal-forward@openssh.com"
+	// ForwardedStreamLocalChannel is the channel opened to the client for each connection accepted on the forwarded
+	// Unix socket.
+	ForwardedStreamLocalChannel = "forwarded-streamlocal@openssh.com"
+)
+
+var (
+	ErrInvalidAddress        = errors.New("bind address must be an IP address, \"localhost\" or empty")
+	ErrSessionNotEstablished = errors.New("session to the device is not established")
+)
+
+type remoteForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type remoteForwardSuccess struct {
+	BindPort uint32
+}
+
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+type forwardedStreamLocalChannelData struct {
+	SocketPath string
+	Reserved   string
+}
+
+type remoteForwardChannelData struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// Handler handles the reverse port forwarding global requests. The requests are allowed by the server's
+// ReversePortForwardingCallback.
+type Handler struct {
+	mu       sync.Mutex
+	forwards map[string]net.Listener
+}
+
+// NewHandler creates a new [Handler].
+func NewHandler() *Handler {
+	return &Handler{forwards: make(map[string]net.Listener)}
+}
+
+// HandleSSHRequest handles the "tcpip-forward", "cancel-tcpip-forward", "streamlocal-forward@openssh.com" and
+// "cancel-streamlocal-forward@openssh.com" global requests.
+func (h *Handler) HandleSSHRequest(ctx gliderssh.Context, srv *gliderssh.Server, req *gossh.Request) (bool, []byte) {
+	switch req.Type {
+	case RequestTcpipForward:
+		return h.forward(ctx, srv, req)
+	case RequestCancelTcpipForward:
+		return h.cancel(ctx, req)
+	case RequestStreamLocalForward:
+		return h.forwardStreamLocal(ctx, req)
+	case RequestCancelStreamLocalForward:
+		return h.cancelStreamLocal(ctx, req)
+	default:
+		return false, nil
+	}
+}
+
+func (h *Handler) forward(ctx gliderssh.Context, srv *gliderssh.Server, req *gossh.Request) (bool, []byte) {
+	var payload remoteForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+
+	logger := log.WithFields(log.Fields{
+		"uid":       ctx.SessionID(),
+		"sshid":     ctx.User(),
+		"bind_addr": payload.BindAddr,
+		"bind_port": payload.BindPort,
+	})
+
+	if srv.ReversePortForwardingCallback == nil || !srv.ReversePortForwardingCallback(ctx, payload.BindAddr, payload.BindPort) {
+		logger.Info("reverse port forwarding denied by policy")
+
+		return false, nil
+	}
+
+	sess, state := session.ObtainSession(ctx)
+	if state < session.StateFinished || sess.Agent == nil || sess.Agent.Client == nil {
+		logger.WithError(ErrSessionNotEstablished).Warn("failed to start the reverse port forwarding")
+
+		return false, nil
+	}
+
+	conn, ok := ctx.Value(gliderssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return false, nil
+	}
+
+	ip, err := resolve(payload.BindAddr)
+	if err != nil {
+		logger.WithError(err).Warn("failed to start the reverse port forwarding")
+
+		return false, nil
+	}
+
+	listener, err := sess.Agent.Client.ListenTCP(&net.TCPAddr{IP: ip, Port: int(payload.BindPort)})
+	if err != nil {
+		logger.WithError(err).Warn("device refused to listen on the port")
+
+		return false, nil
+	}
+
+	port := uint32(listener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert
+
+	logger.WithField("port", port).Info("reverse port forwarding started")
+
+	h.serve(ctx, h.key(ctx, payload.BindAddr, port), listener, func(forwarded net.Conn) {
+		data := remoteForwardChannelData{DestAddr: payload.BindAddr, DestPort: port}
+		if origin, ok := forwarded.RemoteAddr().(*net.TCPAddr); ok {
+			data.OriginAddr, data.OriginPort = origin.IP.String(), uint32(origin.Port)
+		}
+
+		bridge(conn, forwarded, ForwardedTCPIPChannel, gossh.Marshal(&data), logger)
+	})
+
+	if payload.BindPort == 0 {
+		return true, gossh.Marshal(&remoteForwardSuccess{BindPort: port})
+	}
+
+	return true, nil
+}
+
+func (h *Handler) cancel(ctx gliderssh.Context, req *gossh.Request) (bool, []byte) {
+	var payload remoteForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+
+	if !h.remove(h.key(ctx, payload.BindAddr, payload.BindPort)) {
+		return false, nil
+	}
+
+	log.WithFields(log.Fields{
+		"uid":       ctx.SessionID(),
+		"sshid":     ctx.User(),
+		"bind_addr": payload.BindAddr,
+		"bind_port": payload.BindPort,
+	}).Info("reverse port forwarding canceled")
+
+	return true, nil
+}
+
+func (h *Handler) forwardStreamLocal(ctx gliderssh.Context, req *gossh.Request) (bool, []byte) {
+	var payload streamLocalForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+
+	logger := log.WithFields(log.Fields{
+		"uid":    ctx.SessionID(),
+		"sshid":  ctx.User(),
+		"socket": payload.SocketPath,
+	})
+
+	sess, state := session.ObtainSession(ctx)
+	if state < session.StateFinished || sess.Agent == nil || sess.Agent.Client == nil {
+		logger.WithError(ErrSessionNotEstablished).Warn("failed to start the unix socket reverse forwarding")
+
+		return false, nil
+	}
+
+	conn, ok := ctx.Value(gliderssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok {
+		return false, nil
+	}
+
+	listener, err := sess.Agent.Client.ListenUnix(payload.SocketPath)
+	if err != nil {
+		logger.WithError(err).Warn("device refused to listen on the unix socket")
+
+		return false, nil
+	}
+
+	logger.Info("unix socket reverse forwarding started")
+
+	h.serve(ctx, h.keyStreamLocal(ctx, payload.SocketPath), listener, func(forwarded net.Conn) {
+		data := forwardedStreamLocalChannelData{SocketPath: payload.SocketPath}
+
+		bridge(conn, forwarded, ForwardedStreamLocalChannel, gossh.Marshal(&data), logger)
+	})
+
+	return true, nil
+}
+
+func (h *Handler) cancelStreamLocal(ctx gliderssh.Context, req *gossh.Request) (bool, []byte) {
+	var payload streamLocalForwardRequest
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+
+	return h.remove(h.keyStreamLocal(ctx, payload.SocketPath)), nil
+}
+
+// serve keeps the listener under key until the connection is closed or the forwarding is canceled, bridging each
+// accepted connection with open.
+func (h *Handler) serve(ctx gliderssh.Context, key string, listener net.Listener, open func(net.Conn)) {
+	h.mu.Lock()
+	h.forwards[key] = listener
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		h.remove(key)
+	}()
+
+	go func() {
+		defer h.remove(key)
+
+		for {
+			forwarded, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go open(forwarded)
+		}
+	}()
+}
+
+func (h *Handler) keyStreamLocal(ctx gliderssh.Context, socket string) string {
+	return ctx.SessionID() + "/unix:" + socket
+}
+
+func (h *Handler) key(ctx gliderssh.Context, addr string, port uint32) string {
+	return ctx.SessionID() + "/" + net.JoinHostPort(addr, strconv.FormatUint(uint64(port), 10))
+}
+
+// remove stops the forwarding, closing the listener on the device. It returns false when it was not found.
+func (h *Handler) remove(key string) bool {
+	h.mu.Lock()
+	listener, ok := h.forwards[key]
+	delete(h.forwards, key)
+	h.mu.Unlock()
+
+	if ok {
+		listener.Close() //nolint:errcheck
+	}
+
+	return ok
+}
+
+// resolve converts the bind address sent by the client to the IP address sent to the agent, as the agent reports the
+// forwarded connections with the IP it listens on.
+func resolve(addr string) (net.IP, error) {
+	switch addr {
+	case "", Any:
+		return net.IPv4zero, nil
+	case "localhost":
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, ErrInvalidAddress
+	}
+
+	return ip, nil
+}
+
+// bridge opens a forwarded channel to the client for a connection accepted on the device and copies the data between
+// them.
+func bridge(conn *gossh.ServerConn, forwarded net.Conn, kind string, data []byte, logger *log.Entry) {
+	defer forwarded.Close()
+
+	channel, requests, err := conn.OpenChannel(kind, data)
+	if err != nil {
+		logger.WithError(err).Warn("client refused the forwarded connection")
+
+		return
+	}
+
+	defer channel.Close()
+
+	go gossh.DiscardRequests(requests)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(channel, forwarded) //nolint:errcheck
+		channel.CloseWrite()        //nolint:errcheck
+
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(forwarded, channel) //nolint:errcheck
+
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+}
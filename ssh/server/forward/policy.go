@@ -0,0 +1,85 @@
+package forward
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPorts = errors.New("invalid port range; use \"<port>\" or \"<min>-<max>\"")
+
+// Any is the address pattern that allows any bind address.
+const Any = "*"
+
+// Policy controls the addresses and ports the clients can ask the devices to listen on.
+type Policy struct {
+	// Addresses are the allowed bind addresses, as sent by the client, like "localhost" or "0.0.0.0". The empty bind
+	// address, used by clients to listen on every interface, is allowed by "0.0.0.0". [Any] allows every address.
+	Addresses []string
+	// MinPort and MaxPort are the range of allowed ports. The port 0, which lets the device choose a free port, is
+	// always allowed.
+	MinPort uint32
+	MaxPort uint32
+}
+
+// DefaultPolicy allows the devices to listen only on the loopback interface, on unprivileged ports.
+var DefaultPolicy = Policy{
+	Addresses: []string{"localhost", "127.0.0.1", "::1"},
+	MinPort:   1024,
+	MaxPort:   65535,
+}
+
+// ParsePolicy parses a policy from a comma separated list of addresses and a port range. Empty values keep the ones
+// from [DefaultPolicy].
+func ParsePolicy(addresses, ports string) (*Policy, error) {
+	policy := DefaultPolicy
+
+	if addresses = strings.TrimSpace(addresses); addresses != "" {
+		policy.Addresses = []string{}
+		for _, address := range strings.Split(addresses, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				policy.Addresses = append(policy.Addresses, address)
+			}
+		}
+	}
+
+	if ports = strings.TrimSpace(ports); ports != "" {
+		low, high, found := strings.Cut(ports, "-")
+		if !found {
+			high = low
+		}
+
+		min, err := strconv.ParseUint(strings.TrimSpace(low), 10, 16)
+		if err != nil {
+			return nil, ErrInvalidPorts
+		}
+
+		max, err := strconv.ParseUint(strings.TrimSpace(high), 10, 16)
+		if err != nil || min > max {
+			return nil, ErrInvalidPorts
+		}
+
+		policy.MinPort, policy.MaxPort = uint32(min), uint32(max)
+	}
+
+	return &policy, nil
+}
+
+// Allow checks if the client can ask the device to listen on the address and port.
+func (p *Policy) Allow(address string, port uint32) bool {
+	if port != 0 && (port < p.MinPort || port > p.MaxPort) {
+		return false
+	}
+
+	if address == "" || address == Any {
+		address = "0.0.0.0"
+	}
+
+	for _, allowed := range p.Addresses {
+		if allowed == Any || allowed == address {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package forward
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	type Expected struct {
+		policy *Policy
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		addresses   string
+		ports       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with the default policy when values are empty",
+			addresses:   "",
+			ports:       "",
+			expected:    Expected{policy: &DefaultPolicy, err: nil},
+		},
+		{
+			description: "succeeds when addresses and a port range are set",
+			addresses:   "localhost, 0.0.0.0",
+			ports:       "8000-9000",
+			expected: Expected{
+				policy: &Policy{Addresses: []string{"localhost", "0.0.0.0"}, MinPort: 8000, MaxPort: 9000},
+				err:    nil,
+			},
+		},
+		{
+			description: "succeeds when a single port is set",
+			addresses:   "",
+			ports:       "9000",
+			expected: Expected{
+				policy: &Policy{Addresses: DefaultPolicy.Addresses, MinPort: 9000, MaxPort: 9000},
+				err:    nil,
+			},
+		},
+		{
+			description: "fails when the range is reversed",
+			addresses:   "",
+			ports:       "9000-8000",
+			expected:    Expected{policy: nil, err: ErrInvalidPorts},
+		},
+		{
+			description: "fails when the port is out of range",
+			addresses:   "",
+			ports:       "70000",
+			expected:    Expected{policy: nil, err: ErrInvalidPorts},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := ParsePolicy(tc.addresses, tc.ports)
+			assert.Equal(t, tc.expected, Expected{policy, err})
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	cases := []struct {
+		description string
+		policy      Policy
+		address     string
+		port        uint32
+		expected    bool
+	}{
+		{
+			description: "succeeds when address and port are allowed",
+			policy:      DefaultPolicy,
+			address:     "localhost",
+			port:        9000,
+			expected:    true,
+		},
+		{
+			description: "succeeds when the device chooses the port",
+			policy:      DefaultPolicy,
+			address:     "127.0.0.1",
+			port:        0,
+			expected:    true,
+		},
+		{
+			description: "fails when the port is privileged",
+			policy:      DefaultPolicy,
+			address:     "localhost",
+			port:        80,
+			expected:    false,
+		},
+		{
+			description: "fails when the empty address is not allowed",
+			policy:      DefaultPolicy,
+			address:     "",
+			port:        9000,
+			expected:    false,
+		},
+		{
+			description: "succeeds when the empty address is allowed by 0.0.0.0",
+			policy:      Policy{Addresses: []string{"0.0.0.0"}, MinPort: 1, MaxPort: 65535},
+			address:     "",
+			port:        9000,
+			expected:    true,
+		},
+		{
+			description: "succeeds when any address is allowed",
+			policy:      Policy{Addresses: []string{Any}, MinPort: 1, MaxPort: 65535},
+			address:     "10.0.0.1",
+			port:        9000,
+			expected:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.policy.Allow(tc.address, tc.port))
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Package picker implements the text-mode device chooser offered to clients that connect without a device in their
+// SSHID, like `ssh -p 2222 alice@server`.
+//
+// The picker runs on top of the client's session channel. It lists the devices the user can access with their online
+// status, lets the user filter the list by typing part of the device's name or namespace, and returns the chosen
+// device so the server can bridge the same connection to it.
+package picker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"golang.org/x/term"
+)
+
+var (
+	// ErrCanceled is returned by [Picker.Run] when the user leaves the picker without choosing a device.
+	ErrCanceled = errors.New("device selection was canceled")
+	// ErrNoDevices is returned by [Picker.Run] when there is no device the user can access.
+	ErrNoDevices = errors.New("there is no device available")
+)
+
+// Prompt is the prompt shown while waiting for the user input.
+const Prompt = "device> "
+
+const help = `Type the number of a device to connect to it, or some text to filter the list by name or namespace.
+Commands: ":online" shows only online devices, ":all" shows all devices, ":help" shows this help, "q" quits.
+An empty line clears the filter and refreshes the list.
+`
+
+// Picker is an interactive device chooser running on a terminal.
+type Picker struct {
+	term *term.Terminal
+	// user is the name of the user choosing the device, used only in the greeting.
+	user string
+	// devices returns the current list of devices the user can access. It is called again every time the list is
+	// rendered to reflect devices going online and offline.
+	devices func() []models.Device
+
+	query      string
+	onlineOnly bool
+}
+
+// New creates a new [Picker] reading the user input from and writing the device list to rw, that is expected to be a
+// channel with a pty attached.
+func New(rw io.ReadWriter, user string, devices func() []models.Device) *Picker {
+	return &Picker{
+		term:    term.NewTerminal(rw, Prompt),
+		user:    user,
+		devices: devices,
+	}
+}
+
+// SetSize updates the terminal dimensions used by the picker.
+func (p *Picker) SetSize(width, height int) error {
+	return p.term.SetSize(width, height)
+}
+
+// Run shows the device list and waits for the user to choose a device, returning it. The returned device is always
+// online at the moment it was chosen.
+func (p *Picker) Run() (*models.Device, error) {
+	fmt.Fprintf(p.term, "Hello %s, choose the device to connect to.\n\n", p.user)
+
+	shown := p.render()
+	for {
+		line, err := p.term.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrCanceled
+			}
+
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		switch strings.ToLower(line) {
+		case "q", "quit", "exit":
+			return nil, ErrCanceled
+		case ":help", "?":
+			fmt.Fprint(p.term, help)
+
+			continue
+		case ":online":
+			p.onlineOnly = true
+			shown = p.render()
+
+			continue
+		case ":all":
+			p.onlineOnly = false
+			shown = p.render()
+
+			continue
+		case "":
+			p.query = ""
+			shown = p.render()
+
+			continue
+		}
+
+		if index, err := strconv.Atoi(line); err == nil {
+			if index < 1 || index > len(shown) {
+				fmt.Fprintf(p.term, "There is no device number %d in the list.\n", index)
+
+				continue
+			}
+
+			device := shown[index-1]
+			if !p.online(device.UID) {
+				fmt.Fprintf(p.term, "Device %s is offline, choose another one.\n", device.Name)
+
+				continue
+			}
+
+			return &device, nil
+		}
+
+		p.query = line
+		shown = p.render()
+
+		// NOTE: When the user types the exact name of an online device, connects to it straight away.
+		for _, device := range shown {
+			if strings.EqualFold(device.Name, line) || strings.EqualFold(device.UID, line) {
+				if p.online(device.UID) {
+					return &device, nil
+				}
+			}
+		}
+	}
+}
+
+// online checks whether the device is online right now, as the list shown to the user may be outdated.
+func (p *Picker) online(uid string) bool {
+	for _, device := range p.devices() {
+		if device.UID == uid {
+			return device.Online
+		}
+	}
+
+	return false
+}
+
+// render writes the devices matching the current filter to the terminal and returns them in the order shown.
+func (p *Picker) render() []models.Device {
+	all := p.devices()
+	if len(all) == 0 {
+		fmt.Fprintln(p.term, "There is no device available. Press enter to refresh or q to quit.")
+
+		return nil
+	}
+
+	shown := Filter(all, p.query, p.onlineOnly)
+	if len(shown) == 0 {
+		fmt.Fprintf(p.term, "No device matches %q. Press enter to clear the filter.\n", p.query)
+
+		return nil
+	}
+
+	Render(p.term, shown)
+	fmt.Fprintln(p.term, `Type ":help" for the available commands.`)
+
+	return shown
+}
+
+// Filter returns the devices whose name, UID or namespace contain the query, ignoring case. When onlineOnly is true,
+// offline devices are left out. The result is sorted with online devices first and then by namespace and name.
+func Filter(devices []models.Device, query string, onlineOnly bool) []models.Device {
+	query = strings.ToLower(query)
+
+	filtered := make([]models.Device, 0, len(devices))
+	for _, device := range devices {
+		if onlineOnly && !device.Online {
+			continue
+		}
+
+		if query != "" &&
+			!strings.Contains(strings.ToLower(device.Name), query) &&
+			!strings.Contains(strings.ToLower(device.UID), query) &&
+			!strings.Contains(strings.ToLower(device.TenantID), query) {
+			continue
+		}
+
+		filtered = append(filtered, device)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if filtered[i].Online != filtered[j].Online {
+			return filtered[i].Online
+		}
+
+		if filtered[i].TenantID != filtered[j].TenantID {
+			return filtered[i].TenantID < filtered[j].TenantID
+		}
+
+		return filtered[i].Name < filtered[j].Name
+	})
+
+	return filtered
+}
+
+// Render writes the devices as a numbered table to w.
+func Render(w io.Writer, devices []models.Device) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "#\tNAME\tNAMESPACE\tSTATUS")
+	for i, device := range devices {
+		status := "offline"
+		if device.Online {
+			status = "online"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, device.Name, device.TenantID, status)
+	}
+
+	tw.Flush() //nolint:errcheck
+}
@@ -0,0 +1,65 @@
+package picker
+
+import (
+	"testing"
+
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	devices := []models.Device{
+		{UID: "lab:rpi", Name: "rpi", TenantID: "lab", Online: false},
+		{UID: "default:DEVICE123", Name: "DEVICE123", TenantID: "default", Online: true},
+		{UID: "lab:camera", Name: "camera", TenantID: "lab", Online: true},
+	}
+
+	cases := []struct {
+		description string
+		query       string
+		onlineOnly  bool
+		expected    []string
+	}{
+		{
+			description: "returns every device with online ones first when query is empty",
+			query:       "",
+			onlineOnly:  false,
+			expected:    []string{"default:DEVICE123", "lab:camera", "lab:rpi"},
+		},
+		{
+			description: "returns only online devices",
+			query:       "",
+			onlineOnly:  true,
+			expected:    []string{"default:DEVICE123", "lab:camera"},
+		},
+		{
+			description: "matches the namespace ignoring case",
+			query:       "LAB",
+			onlineOnly:  false,
+			expected:    []string{"lab:camera", "lab:rpi"},
+		},
+		{
+			description: "matches part of the name",
+			query:       "device",
+			onlineOnly:  false,
+			expected:    []string{"default:DEVICE123"},
+		},
+		{
+			description: "returns nothing when no device matches",
+			query:       "switch",
+			onlineOnly:  false,
+			expected:    []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			uids := []string{}
+			for _, device := range Filter(devices, tc.query, tc.onlineOnly) {
+				uids = append(uids, device.UID)
+			}
+
+			assert.Equal(t, tc.expected, uids)
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package ports assigns a dedicated SSH listening port to the devices, so `ssh -p 22017 root@server` reaches a device
+// like `ssh root@device@server`, for clients that cannot handle the SSHID format on the username.
+//
+// A device gets its port either explicitly, from a list of assignments like "default:DEVICE123=22017", or from a range
+// of ports, like "22000-22999", when it connects while accepted. Explicit ports are kept while the server runs, and the
+// ones from the range are released when the device disconnects, so devices that come and go cannot use up the range.
+package ports
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidRange       = errors.New("invalid port range; use \"<min>-<max>\", like \"22000-22999\"")
+	ErrInvalidAssignments = errors.New("invalid device ports; use \"device=port,device=port\"")
+	ErrAlreadyAssigned    = errors.New("device already has a port")
+	ErrDisabled           = errors.New("ports of the devices are disabled")
+)
+
+// ParseRange parses a range of ports, in the format "min-max", like the ones assigned to the devices or given to the
+// published services.
+func ParseRange(value string) (int, int, error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, ErrInvalidRange
+	}
+
+	low, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil || low == 0 {
+		return 0, 0, ErrInvalidRange
+	}
+
+	high, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err != nil || high < low {
+		return 0, 0, ErrInvalidRange
+	}
+
+	return int(low), int(high), nil
+}
+
+// ParseAssignments parses the explicit ports of the devices, in the format "device=port,device=port". The device is
+// written as on the SSHID, like "default:DEVICE123".
+func ParseAssignments(value string) (map[string]int, error) {
+	assignments := make(map[string]int)
+	ports := make(map[int]bool)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		// NOTE: The device may have a colon between the tenant and the name, but never an equal sign.
+		device, value, ok := strings.Cut(entry, "=")
+		if device = strings.TrimSpace(device); !ok || device == "" || strings.ContainsAny(device, "@ ") {
+			return nil, ErrInvalidAssignments
+		}
+
+		port, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+		if err != nil || port == 0 || ports[int(port)] {
+			return nil, ErrInvalidAssignments
+		}
+
+		if _, ok := assignments[device]; ok {
+			return nil, ErrInvalidAssignments
+		}
+
+		assignments[device] = int(port)
+		ports[int(port)] = true
+	}
+
+	return assignments, nil
+}
+
+// uid gets the UID of the device written as on the SSHID, with the default tenant when it has none.
+func uid(device string) string {
+	return target.DeviceUID(device)
+}
+
+// Assignment is the port assigned to a device.
+type Assignment struct {
+	Device string `json:"device"`
+	Port   int    `json:"port"`
+	// Explicit is true when the port was assigned explicitly, and false when it came from the range.
+	Explicit bool `json:"explicit"`
+}
+
+// ServeFunc serves the SSH connections accepted on the listener as connections to the device, until the listener is
+// closed.
+type ServeFunc func(listener net.Listener, device string) error
+
+// Manager listens on the ports assigned to the devices.
+//
+// A nil Manager is valid and means the ports of the devices are disabled.
+type Manager struct {
+	serve ServeFunc
+	// low and high are the range of ports assigned when devices connect; when low is zero, only the explicit
+	// assignments are served.
+	low  int
+	high int
+
+	mu          sync.Mutex
+	assignments map[string]*Assignment
+	// listeners are the listeners of the assignments from the range, closed when they are released.
+	listeners map[string]net.Listener
+}
+
+// NewManager creates a new [Manager] that serves the devices' ports with serve, assigning the ports from low to high
+// when they connect. When low is zero, devices without an explicit port get none.
+func NewManager(serve ServeFunc, low, high int) *Manager {
+	return &Manager{
+		serve:       serve,
+		low:         low,
+		high:        high,
+		assignments: make(map[string]*Assignment),
+		listeners:   make(map[string]net.Listener),
+	}
+}
+
+// Listen listens on port for the device, serving it in the background, and keeps it as an explicit assignment. The
+// device is written as on the SSHID, like "DEVICE123" or "default:DEVICE123".
+func (m *Manager) Listen(device string, port int) error {
+	if m == nil {
+		return ErrDisabled
+	}
+
+	device = uid(device)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.assignments[device]; ok {
+		return ErrAlreadyAssigned
+	}
+
+	assignment := &Assignment{Device: device, Port: port, Explicit: true}
+	if _, err := m.listen(assignment); err != nil {
+		return err
+	}
+
+	m.assignments[device] = assignment
+
+	return nil
+}
+
+// Assign assigns a port of the range to the device, when it connects while accepted, unless it already has one.
+func (m *Manager) Assign(device string) {
+	if m == nil || m.low == 0 {
+		return
+	}
+
+	device = uid(device)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTE: A device with an explicit port keeps it.
+	if _, ok := m.assignments[device]; ok {
+		return
+	}
+
+	used := make(map[int]bool)
+	for _, other := range m.assignments {
+		used[other.Port] = true
+	}
+
+	for port := m.low; port <= m.high; port++ {
+		if used[port] {
+			continue
+		}
+
+		// NOTE: A port in use by another process is skipped.
+		assignment := &Assignment{Device: device, Port: port}
+		listener, err := m.listen(assignment)
+		if err != nil {
+			continue
+		}
+
+		m.assignments[device] = assignment
+		m.listeners[device] = listener
+
+		return
+	}
+
+	log.WithField("device", device).Warnf("no free port from %d to %d for the device", m.low, m.high)
+}
+
+// Release releases the port of the range assigned to the device, when it disconnects, closing its listener. Explicit
+// ports are kept.
+func (m *Manager) Release(device string) {
+	if m == nil {
+		return
+	}
+
+	device = uid(device)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	listener, ok := m.listeners[device]
+	if !ok {
+		return
+	}
+
+	listener.Close()
+	delete(m.listeners, device)
+	delete(m.assignments, device)
+}
+
+func (m *Manager) listen(assignment *Assignment) (net.Listener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(assignment.Port)))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		// NOTE: A released port closes its listener, which is not an error.
+		if err := m.serve(listener, assignment.Device); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.WithError(err).WithFields(log.Fields{
+				"device": assignment.Device,
+				"port":   assignment.Port,
+			}).Warn("stopped serving the port of the device")
+		}
+	}()
+
+	return listener, nil
+}
+
+// List lists the ports assigned to the devices, sorted by port.
+func (m *Manager) List() []Assignment {
+	if m == nil {
+		return []Assignment{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Assignment, 0, len(m.assignments))
+	for _, assignment := range m.assignments {
+		list = append(list, *assignment)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Port < list[j].Port
+	})
+
+	return list
+}
@@ -0,0 +1,177 @@
+package ports
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	type Expected struct {
+		low  int
+		high int
+		err  error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when range is valid",
+			value:       "22000-22999",
+			expected:    Expected{low: 22000, high: 22999, err: nil},
+		},
+		{
+			description: "fails when range is reversed",
+			value:       "22999-22000",
+			expected:    Expected{low: 0, high: 0, err: ErrInvalidRange},
+		},
+		{
+			description: "fails when value is a single port",
+			value:       "22000",
+			expected:    Expected{low: 0, high: 0, err: ErrInvalidRange},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			low, high, err := ParseRange(tc.value)
+			assert.Equal(t, tc.expected, Expected{low, high, err})
+		})
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	type Expected struct {
+		assignments map[string]int
+		err         error
+	}
+
+	cases := []struct {
+		description string
+		value       string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with no assignments when value is empty",
+			value:       "",
+			expected:    Expected{assignments: map[string]int{}, err: nil},
+		},
+		{
+			description: "succeeds when devices have tenants",
+			value:       "default:DEVICE123=22017, lab:gateway=22018,DEVICE456=22019",
+			expected: Expected{
+				assignments: map[string]int{"default:DEVICE123": 22017, "lab:gateway": 22018, "DEVICE456": 22019},
+				err:         nil,
+			},
+		},
+		{
+			description: "fails when the port is repeated",
+			value:       "DEVICE123=22017,DEVICE456=22017",
+			expected:    Expected{assignments: nil, err: ErrInvalidAssignments},
+		},
+		{
+			description: "fails when the device is repeated",
+			value:       "DEVICE123=22017,DEVICE123=22018",
+			expected:    Expected{assignments: nil, err: ErrInvalidAssignments},
+		},
+		{
+			description: "fails when the device is an SSHID",
+			value:       "root@DEVICE123=22017",
+			expected:    Expected{assignments: nil, err: ErrInvalidAssignments},
+		},
+		{
+			description: "fails when the port is missing",
+			value:       "DEVICE123",
+			expected:    Expected{assignments: nil, err: ErrInvalidAssignments},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assignments, err := ParseAssignments(tc.value)
+			assert.Equal(t, tc.expected, Expected{assignments, err})
+		})
+	}
+}
+
+// free returns two consecutive free ports, keeping the first one in use by the returned listener.
+func free(t *testing.T) (net.Listener, int) {
+	t.Helper()
+
+	for {
+		listener, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		next, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port+1)))
+		if err == nil {
+			next.Close()
+
+			return listener, port
+		}
+
+		listener.Close()
+	}
+}
+
+func TestManager(t *testing.T) {
+	busy, port := free(t)
+	defer busy.Close()
+
+	served := make(chan string, 2)
+	manager := NewManager(func(listener net.Listener, device string) error {
+		served <- device
+
+		return listener.Close()
+	}, port, port+1)
+
+	// NOTE: The first port of the range is in use by another process, so the device gets the next one.
+	manager.Assign("default:DEVICE123")
+	assert.Equal(t, "default:DEVICE123", <-served)
+
+	manager.Assign("default:DEVICE123")
+
+	assert.Equal(t, []Assignment{{Device: "default:DEVICE123", Port: port + 1}}, manager.List())
+	assert.ErrorIs(t, manager.Listen("DEVICE123", port+1), ErrAlreadyAssigned)
+
+	// NOTE: The range has no free port left.
+	manager.Assign("default:DEVICE456")
+	assert.Len(t, manager.List(), 1)
+
+	// NOTE: The port released by a disconnected device is given to the next one.
+	manager.Release("DEVICE123")
+	manager.Assign("default:DEVICE456")
+	assert.Equal(t, "default:DEVICE456", <-served)
+	assert.Equal(t, []Assignment{{Device: "default:DEVICE456", Port: port + 1}}, manager.List())
+}
+
+func TestManagerRelease(t *testing.T) {
+	busy, port := free(t)
+	busy.Close()
+
+	manager := NewManager(func(listener net.Listener, _ string) error {
+		return listener.Close()
+	}, 0, 0)
+	require.NoError(t, manager.Listen("default:DEVICE123", port))
+
+	// NOTE: Explicit ports are kept when the device disconnects.
+	manager.Release("default:DEVICE123")
+	assert.Equal(t, []Assignment{{Device: "default:DEVICE123", Port: port, Explicit: true}}, manager.List())
+}
+
+func TestNilManager(t *testing.T) {
+	var manager *Manager
+
+	manager.Assign("default:DEVICE123")
+	manager.Release("default:DEVICE123")
+
+	assert.Equal(t, []Assignment{}, manager.List())
+	assert.ErrorIs(t, manager.Listen("default:DEVICE123", 22017), ErrDisabled)
+}
@@ -0,0 +1,188 @@
+// Package proxyprotocol reads the PROXY protocol header sent by the load balancers in front of the server, so the
+// connections' remote addresses are the ones of the original clients.
+//
+// The header is only read from the trusted load balancers. Any other client sending it is refused, as its header
+// would spoof the address used by the firewall, the brute-force protection and the audit events.
+package proxyprotocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/pires/go-proxyproto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoTrusted          = errors.New("PROXY protocol needs the trusted load balancers")
+	ErrInvalidTrusted     = errors.New("invalid PROXY protocol trusted address")
+	ErrInvalidVersions    = errors.New("invalid PROXY protocol versions; use \"1\", \"2\" or \"1,2\"")
+	ErrUnsupportedVersion = errors.New("PROXY protocol version not allowed")
+)
+
+// Policy decides which connections may send the PROXY protocol header, and which versions of it.
+type Policy struct {
+	// Trusted are the addresses of the load balancers allowed to send the header.
+	Trusted []netip.Prefix
+	// Versions are the allowed versions of the header, 1 for the text one and 2 for the binary one.
+	Versions []byte
+	// Required refuses the connections from the trusted addresses without the header, like health checks that should
+	// go through the load balancer.
+	Required bool
+}
+
+// ParsePolicy parses a policy from a comma separated list of trusted IPs or prefixes, like "10.0.0.0/8,192.168.1.10",
+// and a comma separated list of versions, like "1,2". Empty versions allow both.
+func ParsePolicy(trusted, versions string, required bool) (*Policy, error) {
+	policy := &Policy{Trusted: []netip.Prefix{}, Versions: []byte{1, 2}, Required: required}
+
+	for _, address := range strings.Split(trusted, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+
+		prefix, err := parsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", ErrInvalidTrusted, address)
+		}
+
+		policy.Trusted = append(policy.Trusted, prefix)
+	}
+
+	if len(policy.Trusted) == 0 {
+		return nil, ErrNoTrusted
+	}
+
+	if versions = strings.TrimSpace(versions); versions != "" {
+		policy.Versions = []byte{}
+		for _, version := range strings.Split(versions, ",") {
+			switch strings.TrimSpace(version) {
+			case "1":
+				policy.Versions = append(policy.Versions, 1)
+			case "2":
+				policy.Versions = append(policy.Versions, 2)
+			default:
+				return nil, ErrInvalidVersions
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+func parsePrefix(address string) (netip.Prefix, error) {
+	if strings.Contains(address, "/") {
+		prefix, err := netip.ParsePrefix(address)
+
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Trusts checks if the upstream, the address the connection comes from, is a trusted load balancer.
+func (p *Policy) Trusts(upstream net.Addr) bool {
+	addr, err := netip.ParseAddrPort(upstream.String())
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range p.Trusted {
+		if prefix.Contains(addr.Addr().Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decide is the policy of the header on a connection from the upstream: read from the trusted load balancers, and
+// refused from any other address.
+func (p *Policy) Decide(options proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+	if !p.Trusts(options.Upstream) {
+		return proxyproto.REJECT, nil
+	}
+
+	if p.Required {
+		return proxyproto.REQUIRE, nil
+	}
+
+	return proxyproto.USE, nil
+}
+
+// Validate refuses the headers of the versions not allowed.
+func (p *Policy) Validate(header *proxyproto.Header) error {
+	for _, version := range p.Versions {
+		if header.Version == version {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+}
+
+// Listener wraps the listener to read the header of its connections as decided by the policy. The connections whose
+// header is refused fail on their first read, closing them, which is logged with the load balancer's address.
+func (p *Policy) Listener(listener net.Listener) net.Listener {
+	return &proxyListener{
+		Listener: &proxyproto.Listener{ // nolint: exhaustruct
+			Listener:       listener,
+			ConnPolicy:     p.Decide,
+			ValidateHeader: p.Validate,
+		},
+	}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	proxied, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return conn, nil
+	}
+
+	return &proxyConn{Conn: proxied}, nil
+}
+
+// proxyConn logs the header of the connection, or why it was refused, on its first read.
+type proxyConn struct {
+	*proxyproto.Conn
+	once sync.Once
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.once.Do(func() {
+		logger := log.WithFields(log.Fields{
+			"upstream": c.Raw().RemoteAddr().String(),
+			"ip":       c.RemoteAddr().String(),
+		})
+
+		switch header := c.ProxyHeader(); {
+		case err != nil && !errors.Is(err, io.EOF):
+			logger.WithError(err).Warn("connection refused by the PROXY protocol policy")
+		case header != nil:
+			logger.WithField("version", header.Version).Debug("client address read from the PROXY protocol header")
+		}
+	})
+
+	return n, err
+}
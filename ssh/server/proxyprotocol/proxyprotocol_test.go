@@ -0,0 +1,212 @@
+package proxyprotocol
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	type Expected struct {
+		policy *Policy
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		trusted     string
+		versions    string
+		expected    Expected
+	}{
+		{
+			description: "succeeds with both versions when versions are empty",
+			trusted:     "10.0.0.0/8, 192.168.1.10",
+			versions:    "",
+			expected: Expected{
+				policy: &Policy{
+					Trusted:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.10/32")},
+					Versions: []byte{1, 2},
+				},
+				err: nil,
+			},
+		},
+		{
+			description: "succeeds when a single version is set",
+			trusted:     "10.1.2.3/8",
+			versions:    "2",
+			expected: Expected{
+				policy: &Policy{Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, Versions: []byte{2}},
+				err:    nil,
+			},
+		},
+		{
+			description: "fails when trusted addresses are empty",
+			trusted:     "",
+			versions:    "",
+			expected:    Expected{policy: nil, err: ErrNoTrusted},
+		},
+		{
+			description: "fails when trusted address is invalid",
+			trusted:     "10.0.0.0/33",
+			versions:    "",
+			expected:    Expected{policy: nil, err: ErrInvalidTrusted},
+		},
+		{
+			description: "fails when version is unknown",
+			trusted:     "10.0.0.0/8",
+			versions:    "1,3",
+			expected:    Expected{policy: nil, err: ErrInvalidVersions},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := ParsePolicy(tc.trusted, tc.versions, false)
+			assert.ErrorIs(t, err, tc.expected.err)
+			assert.Equal(t, tc.expected.policy, policy)
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	cases := []struct {
+		description string
+		required    bool
+		upstream    string
+		expected    proxyproto.Policy
+	}{
+		{
+			description: "uses the header from a trusted load balancer",
+			required:    false,
+			upstream:    "10.0.0.5:41000",
+			expected:    proxyproto.USE,
+		},
+		{
+			description: "requires the header from a trusted load balancer when required",
+			required:    true,
+			upstream:    "10.0.0.5:41000",
+			expected:    proxyproto.REQUIRE,
+		},
+		{
+			description: "rejects the header from other addresses",
+			required:    true,
+			upstream:    "172.16.0.1:41000",
+			expected:    proxyproto.REJECT,
+		},
+		{
+			description: "uses the header from a trusted load balancer over IPv4-mapped IPv6",
+			required:    false,
+			upstream:    "[::ffff:10.0.0.5]:41000",
+			expected:    proxyproto.USE,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := ParsePolicy("10.0.0.0/8", "", tc.required)
+			require.NoError(t, err)
+
+			upstream, err := net.ResolveTCPAddr("tcp", tc.upstream)
+			require.NoError(t, err)
+
+			decided, err := policy.Decide(proxyproto.ConnPolicyOptions{Upstream: upstream})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, decided)
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	header := func(version byte) []byte {
+		source := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 50000}
+		destination := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2222}
+
+		raw, err := proxyproto.HeaderProxyFromAddrs(version, source, destination).Format()
+		require.NoError(t, err)
+
+		return raw
+	}
+
+	cases := []struct {
+		description string
+		trusted     string
+		versions    string
+		sent        []byte
+		remote      string
+		refused     bool
+	}{
+		{
+			description: "reads the client address from a trusted load balancer",
+			trusted:     "127.0.0.1",
+			versions:    "",
+			sent:        header(1),
+			remote:      "203.0.113.7",
+			refused:     false,
+		},
+		{
+			description: "keeps the address when a trusted load balancer sends no header",
+			trusted:     "127.0.0.1",
+			versions:    "",
+			sent:        []byte{},
+			remote:      "127.0.0.1",
+			refused:     false,
+		},
+		{
+			description: "refuses the header from other addresses",
+			trusted:     "10.0.0.0/8",
+			versions:    "",
+			sent:        header(1),
+			remote:      "127.0.0.1",
+			refused:     true,
+		},
+		{
+			description: "refuses the header of a version not allowed",
+			trusted:     "127.0.0.1",
+			versions:    "2",
+			sent:        header(1),
+			remote:      "127.0.0.1",
+			refused:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			policy, err := ParsePolicy(tc.trusted, tc.versions, false)
+			require.NoError(t, err)
+
+			tcp, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			listener := policy.Listener(tcp)
+			defer listener.Close()
+
+			client, err := net.Dial("tcp", tcp.Addr().String())
+			require.NoError(t, err)
+
+			_, err = client.Write(append(tc.sent, []byte("SSH-2.0-test\r\n")...))
+			require.NoError(t, err)
+			require.NoError(t, client.Close())
+
+			conn, err := listener.Accept()
+			require.NoError(t, err)
+			defer conn.Close()
+
+			data, err := io.ReadAll(conn)
+			if tc.refused {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "SSH-2.0-test\r\n", string(data))
+			}
+
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			require.NoError(t, err)
+			assert.Equal(t, tc.remote, host)
+		})
+	}
+}
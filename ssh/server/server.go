@@ -1,35 +1,142 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	_ "embed"
+	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	gliderssh "github.com/gliderlabs/ssh"
-	"github.com/pires/go-proxyproto"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
     "github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+	"github.com/shellhub-io/mini-shellhub/ssh/firewall"
+	"github.com/shellhub-io/mini-shellhub/ssh/limits"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/admin"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/forward"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/proxyprotocol"
     "github.com/shellhub-io/mini-shellhub/ssh/server/auth"
     "github.com/shellhub-io/mini-shellhub/ssh/server/channels"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
     "github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/crypto/ssh"
 )
 
 type Options struct {
+	// Address is the TCP address the server listens on for SSH. Defaults to [DefaultAddress].
+	Address string
+	// ProxyProtocol reads the PROXY protocol header, sent by the trusted load balancers, on the connections to Address
+	// and to the devices' ports, so the clients' addresses are the original ones. When nil, the header is not read.
+	ProxyProtocol  *proxyprotocol.Policy
+	// ConnectTimeout bounds how long the sessions wait for the device to complete the SSH handshake and to open a
+	// channel; the tunnel bounds the opening of the streams. Zero waits indefinitely.
 	ConnectTimeout time.Duration
 	// Allows SSH to connect with an agent via a public key when the agent version is less than 0.6.0.
 	// Agents 0.5.x or earlier do not validate the public key request and may panic.
 	// Please refer to: https://github.com/shellhub-io/shellhub/issues/3453
 	AllowPublickeyAccessBelow060 bool
+	// Users are the users who authenticate on the server itself, for the device picker and the admin shell. When nil,
+	// the server runs in test mode: the device picker accepts any credential and the admin shell is disabled.
+	Users *users.Store
+	// Sessions keeps track of the connections to the server, for the admin shell and API.
+	Sessions *session.Registry
+	// Service is the service used by the admin shell. When nil, the admin shell is disabled.
+	Service *services.Service
+	// AdminTarget is the reserved SSHID target of the admin shell, like "admin" in `ssh alice@admin@server` or the
+	// whole SSHID in `ssh admin@server`. Defaults to [DefaultAdminTarget].
+	AdminTarget string
+	// SSHIDForms are the alternative forms of the SSHID accepted besides "user@device", like "user%device", for
+	// clients that cannot handle the "@" on the username.
+	SSHIDForms []target.Form
+	// AgentPool keeps the connections authenticated on the agents for reuse by new client connections to the same
+	// device, as the same user and with the same credential. When nil, every client connection authenticates on the
+	// agent.
+	AgentPool *session.Pool
+	// ReversePortForward is the policy of the addresses and ports the clients can ask the devices to listen on through
+	// reverse port forwarding. When nil, reverse port forwarding is disabled.
+	ReversePortForward *forward.Policy
+	// LocalPortForward allows the clients to reach the devices' networks through local port forwarding, like
+	// `ssh -L`. When false, local port forwarding is disabled.
+	LocalPortForward bool
+	// Firewall decides which sessions reach the devices. When nil, every session is allowed.
+	Firewall *firewall.Firewall
+	// Limits are the idle timeouts and maximum durations of the sessions. When nil, the sessions have no limits.
+	Limits *limits.Limits
+	// Concurrency caps the concurrent connections and channels to the devices. When nil, there are no caps.
+	Concurrency *concurrency.Limiter
+	// Guard protects the authentications from brute force, refusing the denied or locked out clients' addresses and
+	// SSHIDs. When nil, the authentications aren't guarded.
+	Guard *guard.Guard
+	// Audit records the audit events, like the sessions terminated by their limits. When nil, they are only logged.
+	Audit *audit.Log
+	// Messages are shown on the banner when the connections cannot reach the devices.
+	Messages Messages
+}
+
+// Messages are shown to the clients on the banner when their connections cannot reach the devices. Empty ones are the
+// default messages.
+type Messages struct {
+	InvalidSSHID      string
+	ConnectionFailed  string
+	ConnectionTimeout string
+	AccessDenied      string
+	ConnectionLimit   string
+}
+
+func (m Messages) withDefaults() *Messages {
+	if m.InvalidSSHID == "" {
+		m.InvalidSSHID = InvalidSSHIDMessage
+	}
+
+	if m.ConnectionFailed == "" {
+		m.ConnectionFailed = ConnectionFailedMessage
+	}
+
+	if m.ConnectionTimeout == "" {
+		m.ConnectionTimeout = ConnectionTimeoutMessage
+	}
+
+	if m.AccessDenied == "" {
+		m.AccessDenied = AccessDeniedMessage
+	}
+
+	if m.ConnectionLimit == "" {
+		m.ConnectionLimit = ConnectionLimitMessage
+	}
+
+	return &m
+}
+
+// DefaultAdminTarget is the default reserved SSHID target of the admin shell.
+const DefaultAdminTarget = "admin"
+
+// DefaultAddress is the default TCP address the server listens on for SSH.
+const DefaultAddress = ":2222"
+
+// AllowLocalPortForwarding is the forwarding policy of the destinations the clients reach through the devices, with
+// local port forwarding on direct-tcpip channels or with the SOCKS5 proxy. The server allows every destination,
+// leaving the decision to the device.
+func AllowLocalPortForwarding(_ string, _ uint32) bool {
+	return true
 }
 
 type Server struct {
-	sshd   *gliderssh.Server
-	opts   *Options
-	tunnel Tunnel
+	sshd     *gliderssh.Server
+	opts     *Options
+	tunnel   Tunnel
+	messages atomic.Pointer[Messages]
 }
 
 var (
@@ -39,21 +146,85 @@ var (
 	//go:embed messages/connection_failed.txt
 	ConnectionFailedMessage string
 
+	//go:embed messages/connection_timeout.txt
+	ConnectionTimeoutMessage string
+
 	//go:embed messages/access_denied.txt
 	AccessDeniedMessage string
+
+	//go:embed messages/connection_limit.txt
+	ConnectionLimitMessage string
 )
 
 func NewServer(opts *Options, tunnel Tunnel) *Server {
+	if opts.AdminTarget == "" {
+		opts.AdminTarget = DefaultAdminTarget
+	}
+
+	if opts.Address == "" {
+		opts.Address = DefaultAddress
+	}
+
 	server := &Server{ // nolint: exhaustruct
 		opts:   opts,
 		tunnel: tunnel,
 	}
 
+	server.SetMessages(opts.Messages)
+
+	forwardHandler := forward.NewHandler()
+
 	server.sshd = &gliderssh.Server{ // nolint: exhaustruct
-		Addr: ":2222",
+		Addr: opts.Address,
 		ConnCallback: func(ctx gliderssh.Context, conn net.Conn) net.Conn {
+			// NOTE: Denied and locked out clients are dropped before the SSH handshake. Behind a load balancer speaking
+			// the PROXY protocol, trusted by its policy, the address is the one of the original client.
+			if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+				if err := opts.Guard.Check(host, ""); err != nil {
+					log.WithError(err).WithField("ip", host).Info("connection refused by the brute-force protection")
+
+					return nil
+				}
+			}
+
 			ctx.SetValue("conn", conn)
 
+			session.Trace(ctx, conn)
+
+			if bound, ok := conn.(*deviceConn); ok {
+				session.SetBoundDevice(ctx, bound.device)
+			}
+
+			if opts.Sessions != nil {
+				opts.Sessions.Add(ctx, conn)
+			}
+
+			if opts.AgentPool != nil {
+				session.SetPool(ctx, opts.AgentPool)
+			}
+
+			session.SetConnectTimeout(ctx, opts.ConnectTimeout)
+
+			if opts.Firewall != nil {
+				session.SetFirewall(ctx, opts.Firewall)
+			}
+
+			if opts.Limits != nil {
+				session.SetLimits(ctx, opts.Limits)
+			}
+
+			if opts.Concurrency != nil {
+				session.SetConcurrency(ctx, opts.Concurrency)
+			}
+
+			if opts.Guard != nil {
+				session.SetGuard(ctx, opts.Guard)
+			}
+
+			if opts.Audit != nil {
+				session.SetAudit(ctx, opts.Audit)
+			}
+
 			return conn
 		},
 		BannerHandler: func(ctx gliderssh.Context) string {
@@ -65,51 +236,112 @@ func NewServer(opts *Options, tunnel Tunnel) *Server {
 
 			logger.Info("new connection established")
 
+			_, span := session.StartSpan(ctx, "ssh.banner")
+			defer span.End()
+
+			messages := server.messages.Load()
 			message := func(msg string) string {
 				return fmt.Sprintf("%s\r\n", msg)
 			}
 
-            if _, err := target.NewTarget(ctx.User()); err != nil {
-                // For testing: do not block on SSHID format; proceed without banner error
-                logger.WithError(err).Warn("sshid format not recognized; proceeding for test mode")
+			// NOTE: On the port of a device, the whole username is the user on the device, so it needs no quoting.
+			// Otherwise, the username may be in one of the alternative forms of the SSHID, like "user%device".
+			sshid := ctx.User()
+			bound := session.GetBoundDevice(ctx)
+			if bound != "" {
+				sshid = fmt.Sprintf("%s@%s", ctx.User(), bound)
+				logger = logger.WithField("device", bound)
+			} else {
+				normalized, err := target.Normalize(ctx.User(), opts.SSHIDForms)
+				if err != nil {
+					logger.WithError(err).Info("sshid is ambiguous")
+
+					return message(messages.InvalidSSHID)
+				}
+
+				sshid = normalized
+			}
+
+			session.SetSSHID(ctx, sshid)
+			session.GetSpan(ctx).SetAttributes(attribute.String("sshid", sshid))
+
+			if bound == "" && server.isAdmin(ctx) {
+				logger.Info("sshid targets the admin shell")
+
+				session.SetKind(ctx, session.KindAdmin)
+
+				return ""
+			}
+
+            if _, err := target.NewTarget(sshid); err != nil {
+                // NOTE: Without a device on the SSHID, the client authenticates on the server and chooses the device
+                // through the device picker on the session channel.
+                logger.WithError(err).Info("sshid has no device; offering the device picker")
+
+                session.SetKind(ctx, session.KindPicker)
+
+                return ""
             }
 
-			sess, err := session.NewSession(ctx, tunnel)
+			sess, err := session.NewSessionWithSSHID(ctx, tunnel, sshid)
 			if err != nil {
 				logger.WithError(err).Error("failed to create the session")
 
-				return message(ConnectionFailedMessage)
+				return message(messages.ConnectionFailed)
+			}
+
+			// NOTE: The sessions returning before their evaluation, like the ones over a cap or denied by the firewall,
+			// have their authentication refused and their connection closed by the password and public key handlers.
+			if err := sess.Admit(ctx); err != nil {
+				span.SetStatus(codes.Error, err.Error())
+
+				return message(messages.ConnectionLimit)
 			}
 
 			if err := sess.Dial(ctx); err != nil {
 				logger.WithError(err).Error("destination device is offline or cannot be reached")
+				span.SetStatus(codes.Error, err.Error())
+
+				if errors.Is(err, session.ErrTimeout) {
+					return message(messages.ConnectionTimeout)
+				}
 
-				return message(ConnectionFailedMessage)
+				return message(messages.ConnectionFailed)
 			}
 
 			if err := sess.Evaluate(ctx); err != nil {
 				logger.WithError(err).Error("destination device has a firewall to blocked it or a billing issue")
+				span.SetStatus(codes.Error, err.Error())
 
-				return message(AccessDeniedMessage)
+				return message(messages.AccessDenied)
 			}
 
 			return ""
 		},
-		PasswordHandler:  auth.PasswordHandler,
-		PublicKeyHandler: auth.PublicKeyHandler,
+		PasswordHandler:  auth.NewPasswordHandler(opts.Users),
+		PublicKeyHandler: auth.NewPublicKeyHandler(opts.Users),
 		// Channels form the foundation of secure communication between clients and servers in SSH connections. A
 		// channel, in the context of SSH, is a logical conduit through which data travels securely between the client
 		// and the server. SSH channels serve as the infrastructure for executing commands, establishing shell sessions,
 		// and securely forwarding network services.
 		ChannelHandlers: map[string]gliderssh.ChannelHandler{
-			channels.SessionChannel:     channels.DefaultSessionHandler(),
-			channels.DirectTCPIPChannel: channels.DefaultDirectTCPIPHandler,
+			channels.SessionChannel:     server.sessionHandler(),
+			channels.DirectTCPIPChannel: server.directTCPIPHandler(),
+			// NOTE: Unix socket forwarding is relayed to the agent, which decides the socket paths each user can reach.
+			channels.DirectStreamLocalChannel: channels.DefaultDirectStreamLocalHandler,
 		},
-		LocalPortForwardingCallback: func(_ gliderssh.Context, _ string, _ uint32) bool {
-			return true
+		LocalPortForwardingCallback: func(_ gliderssh.Context, host string, port uint32) bool {
+			return metrics.Decided("local-forward", opts.LocalPortForward && AllowLocalPortForwarding(host, port))
 		},
-		ReversePortForwardingCallback: func(_ gliderssh.Context, _ string, _ uint32) bool {
-			return false
+		ReversePortForwardingCallback: func(ctx gliderssh.Context, addr string, port uint32) bool {
+			return metrics.Decided("reverse-forward", opts.ReversePortForward != nil &&
+				session.GetKind(ctx) != session.KindAdmin && opts.ReversePortForward.Allow(addr, port))
+		},
+		RequestHandlers: map[string]gliderssh.RequestHandler{
+			forward.RequestTcpipForward:             forwardHandler.HandleSSHRequest,
+			forward.RequestCancelTcpipForward:       forwardHandler.HandleSSHRequest,
+			forward.RequestStreamLocalForward:       forwardHandler.HandleSSHRequest,
+			forward.RequestCancelStreamLocalForward: forwardHandler.HandleSSHRequest,
 		},
 	}
 
@@ -129,6 +361,124 @@ func NewServer(opts *Options, tunnel Tunnel) *Server {
 	return server
 }
 
+// isAdmin checks if the SSHID targets the admin shell, that is enabled only when a service is set.
+func (s *Server) isAdmin(ctx gliderssh.Context) bool {
+	if s.opts.Service == nil {
+		return false
+	}
+
+	sshid := session.GetSSHID(ctx)
+	if sshid == s.opts.AdminTarget {
+		return true
+	}
+
+	tgt, err := target.NewTarget(sshid)
+
+	return err == nil && tgt.Data == s.opts.AdminTarget
+}
+
+// pickable lists the accepted devices the user of the connection can choose on the device picker.
+func (s *Server) pickable(ctx gliderssh.Context) []models.Device {
+	devices := make([]models.Device, 0)
+	for _, device := range s.opts.Users.Accessible(session.LocalUser(ctx), s.tunnel.Devices()) {
+		if device.Status == "" || device.Status == models.DeviceStatusAccepted {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
+
+// sessionHandler dispatches the session's channel to the handler of the connection's kind. Once the device picker
+// has bridged the connection to a device, further session's channels are handled as regular device ones.
+func (s *Server) sessionHandler() gliderssh.ChannelHandler {
+	device := channels.DefaultSessionHandler()
+	picker := channels.PickerSessionHandler(s.pickable, s.tunnel)
+
+	var shell gliderssh.ChannelHandler
+	if s.opts.Service != nil {
+		shell = channels.AdminSessionHandler(admin.New(s.opts.Service))
+	}
+
+	return func(srv *gliderssh.Server, conn *ssh.ServerConn, newChan ssh.NewChannel, ctx gliderssh.Context) {
+		if session.GetKind(ctx) == session.KindAdmin && shell != nil {
+			shell(srv, conn, newChan, ctx)
+
+			return
+		}
+
+		_, state := session.ObtainSession(ctx)
+		if session.GetKind(ctx) == session.KindPicker && state < session.StateFinished {
+			picker(srv, conn, newChan, ctx)
+
+			return
+		}
+
+		// NOTE: Device connections only authenticate once their session is on the agent, but the channels are
+		// checked too, as the admin connections without the admin shell have no session at all.
+		if state < session.StateFinished {
+			newChan.Reject(ssh.ConnectionFailed, "session to the device is not established") //nolint:errcheck
+
+			return
+		}
+
+		device(srv, conn, newChan, ctx)
+	}
+}
+
+// directTCPIPHandler dispatches the direct-tcpip channel to the jump host handler when the client authenticated on the
+// server itself, like `ssh -J jump@server user@device`, and to the default handler when it is connected to a device.
+func (s *Server) directTCPIPHandler() gliderssh.ChannelHandler {
+	jump := channels.JumpDirectTCPIPHandler(s.pickable, s.tunnel)
+
+	return func(srv *gliderssh.Server, conn *ssh.ServerConn, newChan ssh.NewChannel, ctx gliderssh.Context) {
+		_, state := session.ObtainSession(ctx)
+
+		switch kind := session.GetKind(ctx); {
+		case kind == session.KindAdmin:
+			newChan.Reject(ssh.Prohibited, "port forwarding is not available on the admin shell") //nolint:errcheck
+		case kind == session.KindPicker && state < session.StateFinished:
+			jump(srv, conn, newChan, ctx)
+		case state < session.StateFinished:
+			newChan.Reject(ssh.ConnectionFailed, "session to the device is not established") //nolint:errcheck
+		default:
+			channels.DefaultDirectTCPIPHandler(srv, conn, newChan, ctx)
+		}
+	}
+}
+
+// HandleConn serves an SSH connection accepted elsewhere than the TCP listener, like the byte stream of a WebSocket,
+// as if it came from the listener. It returns when the connection is closed.
+func (s *Server) HandleConn(conn net.Conn) {
+	s.sshd.HandleConn(conn)
+}
+
+// SetMessages replaces the messages shown on the banner of the new connections.
+func (s *Server) SetMessages(messages Messages) {
+	s.messages.Store(messages.withDefaults())
+}
+
+// Shutdown stops accepting connections and warns the users of the active sessions that the server is shutting down,
+// waiting for their connections to finish until the context is done, when the remaining ones are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.opts.Sessions != nil {
+		message := "The server is shutting down."
+		if deadline, ok := ctx.Deadline(); ok {
+			message = fmt.Sprintf("The server is shutting down. The session will be closed in %s.", time.Until(deadline).Round(time.Second))
+		}
+
+		s.opts.Sessions.Notify(message)
+	}
+
+	if err := s.sshd.Shutdown(ctx); err != nil {
+		s.sshd.Close() //nolint:errcheck
+
+		return err
+	}
+
+	return nil
+}
+
 func (s *Server) ListenAndServe() error {
 	log.WithFields(log.Fields{
 		"addr": s.sshd.Addr,
@@ -141,8 +491,46 @@ func (s *Server) ListenAndServe() error {
 		return err
 	}
 
-	proxy := &proxyproto.Listener{Listener: list} // nolint: exhaustruct
-	defer proxy.Close()
+	return s.sshd.Serve(s.listener(list))
+}
+
+// ServeDevice serves the SSH connections accepted on the listener as connections to the device, like `ssh -p 22017
+// root@server` for `ssh root@device@server`. It returns when the listener is closed.
+func (s *Server) ServeDevice(listener net.Listener, device string) error {
+	log.WithFields(log.Fields{
+		"addr":   listener.Addr().String(),
+		"device": device,
+	}).Info("ssh server listening for the device")
+
+	return s.sshd.Serve(&deviceListener{Listener: s.listener(listener), device: device})
+}
+
+// listener wraps the listener to read the PROXY protocol header of its connections, when enabled.
+func (s *Server) listener(listener net.Listener) net.Listener {
+	if s.opts.ProxyProtocol == nil {
+		return listener
+	}
+
+	return s.opts.ProxyProtocol.Listener(listener)
+}
+
+// deviceListener is a listener whose connections are bound to a device.
+type deviceListener struct {
+	net.Listener
+	device string
+}
+
+func (l *deviceListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &deviceConn{Conn: conn, device: l.device}, nil
+}
 
-	return s.sshd.Serve(proxy)
+// deviceConn is a connection accepted on the port of a device.
+type deviceConn struct {
+	net.Conn
+	device string
 }
@@ -0,0 +1,797 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+	"github.com/shellhub-io/mini-shellhub/ssh/firewall"
+	"github.com/shellhub-io/mini-shellhub/ssh/limits"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/forward"
+	"github.com/shellhub-io/mini-shellhub/ssh/services"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// tunnel connects the sessions to a local agent.
+type tunnel struct {
+	address string
+}
+
+func (t *tunnel) Dial(string) (net.Conn, error) {
+	return net.Dial("tcp", t.address)
+}
+
+func (t *tunnel) Connect(target, addr string) (net.Conn, error) {
+	return proxy.Connect(t, target, addr, 0)
+}
+
+func (t *tunnel) Devices() []models.Device {
+	return []models.Device{{UID: "default:DEVICE123", Name: "DEVICE123", TenantID: "default", Status: models.DeviceStatusAccepted}}
+}
+
+// newTunnel serves an agent accepting any credential but the password "wrong", whose sessions write "in" and wait for
+// stdin to be closed, and that listens on the ports asked for reverse port forwarding.
+func newTunnel(t *testing.T) *tunnel {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	forwarded := &gliderssh.ForwardedTCPHandler{}
+
+	agent := &gliderssh.Server{ // nolint: exhaustruct
+		HostSigners: []gliderssh.Signer{signer},
+		Handler: func(s gliderssh.Session) {
+			io.WriteString(s, "in\n") //nolint:errcheck
+			io.Copy(io.Discard, s)    //nolint:errcheck
+		},
+		PasswordHandler:  func(_ gliderssh.Context, password string) bool { return password != "wrong" },
+		PublicKeyHandler: func(gliderssh.Context, gliderssh.PublicKey) bool { return true },
+		// NOTE: The agent listens on any port the server asks, leaving the policy to the server.
+		ReversePortForwardingCallback: func(gliderssh.Context, string, uint32) bool { return true },
+		RequestHandlers: map[string]gliderssh.RequestHandler{
+			"tcpip-forward":        forwarded.HandleSSHRequest,
+			"cancel-tcpip-forward": forwarded.HandleSSHRequest,
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go agent.Serve(listener) //nolint:errcheck
+
+	t.Cleanup(func() { agent.Close() })
+
+	return &tunnel{address: listener.Addr().String()}
+}
+
+// serve serves the server on a random local port, returning its address.
+func serve(t *testing.T, server *Server) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.HandleConn(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// publicKey returns an authentication method with a new public key.
+func publicKey(t *testing.T) gossh.AuthMethod {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return gossh.PublicKeys(signer)
+}
+
+// connect opens an SSH connection to the server as user.
+func connect(address, user string, auth gossh.AuthMethod) (*gossh.Client, error) {
+	return gossh.Dial("tcp", address, &gossh.ClientConfig{ // nolint: exhaustruct
+		User:            user,
+		Auth:            []gossh.AuthMethod{auth},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	cases := []struct {
+		description string
+		rules       []firewall.Rule
+		auth        func(t *testing.T) gossh.AuthMethod
+		refused     bool
+	}{
+		{
+			description: "succeeds with public key when firewall allows",
+			rules:       []firewall.Rule{},
+			auth:        publicKey,
+			refused:     false,
+		},
+		{
+			description: "fails with public key when firewall denies",
+			rules:       []firewall.Rule{{Name: "all", Action: firewall.ActionDeny}},
+			auth:        publicKey,
+			refused:     true,
+		},
+		{
+			description: "fails with password when firewall denies",
+			rules:       []firewall.Rule{{Name: "all", Action: firewall.ActionDeny}},
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("secret") },
+			refused:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			rules, err := firewall.New(tc.rules)
+			require.NoError(t, err)
+
+			address := serve(t, NewServer(&Options{Firewall: rules}, newTunnel(t)))
+
+			client, err := connect(address, "root@DEVICE123", tc.auth(t))
+			if tc.refused {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			defer client.Close()
+
+			sess, err := client.NewSession()
+			require.NoError(t, err)
+
+			stdout, err := sess.StdoutPipe()
+			require.NoError(t, err)
+			require.NoError(t, sess.Start("true"))
+
+			line := make([]byte, 3)
+			_, err = io.ReadFull(stdout, line)
+			require.NoError(t, err)
+			assert.Equal(t, "in\n", string(line))
+		})
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	limiter, err := concurrency.New(concurrency.Config{Device: concurrency.Caps{Connections: 1}})
+	require.NoError(t, err)
+
+	address := serve(t, NewServer(&Options{Concurrency: limiter}, newTunnel(t)))
+
+	first, err := connect(address, "root@DEVICE123", publicKey(t))
+	require.NoError(t, err)
+
+	// NOTE: The connection over the cap is refused with either authentication method.
+	_, err = connect(address, "root@DEVICE123", publicKey(t))
+	assert.Error(t, err)
+
+	_, err = connect(address, "root@DEVICE123", gossh.Password("secret"))
+	assert.Error(t, err)
+
+	require.NoError(t, first.Close())
+
+	assert.Eventually(t, func() bool {
+		client, err := connect(address, "root@DEVICE123", publicKey(t))
+		if err != nil {
+			return false
+		}
+
+		return client.Close() == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestBruteForce(t *testing.T) {
+	cases := []struct {
+		description string
+		sshids      []string
+		password    string
+		expected    map[string]int
+	}{
+		{
+			description: "counts the password refused by the agent on the client and the user on the device",
+			sshids:      []string{"root@DEVICE123"},
+			password:    "wrong",
+			expected:    map[string]int{"source:127.0.0.1": 1, "target:root@default:DEVICE123": 1},
+		},
+		{
+			description: "counts the forms of the SSHID of the same device together",
+			sshids:      []string{"root@DEVICE123", "root@default:DEVICE123"},
+			password:    "wrong",
+			expected:    map[string]int{"source:127.0.0.1": 2, "target:root@default:DEVICE123": 2},
+		},
+		{
+			description: "counts nothing when the agent accepts the password",
+			sshids:      []string{"root@DEVICE123"},
+			password:    "secret",
+			expected:    map[string]int{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Default())
+			require.NoError(t, err)
+
+			address := serve(t, NewServer(&Options{Guard: lockouts}, newTunnel(t)))
+
+			for _, sshid := range tc.sshids {
+				client, err := connect(address, sshid, gossh.Password(tc.password))
+				if err == nil {
+					client.Close()
+				}
+			}
+
+			failures := make(map[string]int)
+			for _, status := range lockouts.List() {
+				failures[status.Key] = status.Failures
+			}
+
+			assert.Equal(t, tc.expected, failures)
+		})
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	rules, err := limits.New([]limits.Rule{{Name: "all", MaxDuration: time.Second}})
+	require.NoError(t, err)
+
+	address := serve(t, NewServer(&Options{Limits: rules}, newTunnel(t)))
+
+	// NOTE: The connection opens no session channel, like `ssh -N -L`.
+	client, err := connect(address, "root@DEVICE123", publicKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed at its maximum duration")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	cases := []struct {
+		description string
+		user        string
+		interactive bool
+		drain       time.Duration
+		finish      bool
+		expected    error
+	}{
+		{
+			description: "notifies the sessions on the device and waits for them to finish",
+			user:        "root@DEVICE123",
+			interactive: false,
+			drain:       5 * time.Second,
+			finish:      true,
+			expected:    nil,
+		},
+		{
+			description: "notifies the users on the device picker and waits for them to finish",
+			user:        "root",
+			interactive: true,
+			drain:       5 * time.Second,
+			finish:      true,
+			expected:    nil,
+		},
+		{
+			description: "closes the sessions still active after the drain period",
+			user:        "root@DEVICE123",
+			interactive: false,
+			drain:       500 * time.Millisecond,
+			finish:      false,
+			expected:    context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			server := NewServer(&Options{Sessions: session.NewRegistry()}, newTunnel(t))
+			address := serve(t, server)
+
+			client, err := connect(address, tc.user, publicKey(t))
+			require.NoError(t, err)
+			defer client.Close()
+
+			sess, err := client.NewSession()
+			require.NoError(t, err)
+
+			stdout, err := sess.StdoutPipe()
+			require.NoError(t, err)
+
+			stderr, err := sess.StderrPipe()
+			require.NoError(t, err)
+
+			if tc.interactive {
+				require.NoError(t, sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{}))
+				require.NoError(t, sess.Shell())
+			} else {
+				require.NoError(t, sess.Start("true"))
+			}
+
+			// NOTE: Any output means the channel is being handled by the agent or the picker.
+			_, err = stdout.Read(make([]byte, 1))
+			require.NoError(t, err)
+
+			go io.Copy(io.Discard, stdout) //nolint:errcheck
+
+			notified := make(chan string, 1)
+			go func() {
+				scanner := bufio.NewScanner(stderr)
+				for scanner.Scan() {
+					if line := scanner.Text(); line != "" {
+						notified <- line
+
+						return
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.drain)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() { done <- server.Shutdown(ctx) }()
+
+			select {
+			case line := <-notified:
+				assert.Contains(t, line, "The server is shutting down.")
+			case <-time.After(5 * time.Second):
+				t.Fatal("the session was not notified of the shutdown")
+			}
+
+			if tc.finish {
+				require.NoError(t, client.Close())
+			}
+
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, tc.expected)
+			case <-time.After(10 * time.Second):
+				t.Fatal("the shutdown did not return")
+			}
+
+			// NOTE: Either finished by the client or closed by the server, the connection is gone.
+			waited := make(chan error, 1)
+			go func() { waited <- client.Wait() }()
+
+			select {
+			case <-waited:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the connection was not closed")
+			}
+		})
+	}
+}
+
+func TestJumpHost(t *testing.T) {
+	cases := []struct {
+		description string
+		rules       []firewall.Rule
+		caps        concurrency.Config
+		expected    string
+	}{
+		{
+			description: "succeeds when firewall allows the hop",
+			rules:       []firewall.Rule{},
+			caps:        concurrency.Config{},
+			expected:    audit.TypeJumpConnected,
+		},
+		{
+			description: "fails when firewall denies the hop",
+			rules:       []firewall.Rule{{Name: "lab", Action: firewall.ActionDeny, Device: "default:*"}},
+			caps:        concurrency.Config{},
+			expected:    audit.TypeConnectionRejected,
+		},
+		{
+			description: "fails when hop is over the cap of the device",
+			rules:       []firewall.Rule{},
+			caps:        concurrency.Config{Device: concurrency.Caps{Connections: 1}},
+			expected:    audit.TypeConnectionRejected,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			rules, err := firewall.New(tc.rules)
+			require.NoError(t, err)
+
+			limiter, err := concurrency.New(tc.caps)
+			require.NoError(t, err)
+
+			registry := session.NewRegistry()
+			events := audit.NewLog()
+
+			address := serve(t, NewServer(&Options{
+				Sessions:    registry,
+				Firewall:    rules,
+				Concurrency: limiter,
+				Audit:       events,
+			}, newTunnel(t)))
+
+			// NOTE: The cap is reached by a session to the device on another connection.
+			if tc.caps.Device.Connections > 0 {
+				other, err := connect(address, "root@DEVICE123", publicKey(t))
+				require.NoError(t, err)
+				defer other.Close()
+			}
+
+			client, err := connect(address, "alice", publicKey(t))
+			require.NoError(t, err)
+			defer client.Close()
+
+			conn, err := client.Dial("tcp", "DEVICE123:22")
+			if tc.expected != audit.TypeJumpConnected {
+				assert.Error(t, err)
+
+				for _, sess := range registry.Sessions() {
+					assert.NotEqual(t, "jump", sess.Type)
+				}
+			} else {
+				require.NoError(t, err)
+				defer conn.Close()
+
+				// NOTE: The client authenticates on the agent itself, through the hop.
+				agent, _, _, err := gossh.NewClientConn(conn, "DEVICE123:22", &gossh.ClientConfig{ // nolint: exhaustruct
+					User:            "root",
+					Auth:            []gossh.AuthMethod{gossh.Password("secret")},
+					HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+				})
+				require.NoError(t, err)
+				defer agent.Close()
+
+				sessions := registry.Sessions()
+				require.Len(t, sessions, 1)
+				assert.Equal(t, "jump", sessions[0].Type)
+
+				// NOTE: Killing the hop closes its stream to the device, keeping the connection to the server.
+				require.NoError(t, registry.Kill(sessions[0].UID))
+
+				done := make(chan error, 1)
+				go func() { done <- agent.Wait() }()
+
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the hop was not closed")
+				}
+
+				assert.Eventually(t, func() bool { return len(registry.Sessions()) == 0 }, 5*time.Second, 50*time.Millisecond)
+			}
+
+			types := []string{}
+			for _, event := range events.Events() {
+				types = append(types, event.Type)
+			}
+
+			assert.Contains(t, types, tc.expected)
+		})
+	}
+}
+
+// inventory is a [services.DeviceStore] with the device of the tunnel, which ignores the changes.
+type inventory struct {
+	*tunnel
+}
+
+func (inventory) SetDeviceStatus(string, models.DeviceStatus) error {
+	return nil
+}
+
+func (inventory) SetDeviceTags(string, []string) error {
+	return nil
+}
+
+func TestAdminShell(t *testing.T) {
+	cases := []struct {
+		description string
+		user        string
+		auth        func(t *testing.T) gossh.AuthMethod
+		command     string
+		refused     bool
+		status      int
+		stdout      string
+		stderr      string
+	}{
+		{
+			description: "fails when the user is not an admin",
+			user:        "bob@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("x") },
+			refused:     true,
+		},
+		{
+			description: "fails when the password is wrong",
+			user:        "alice@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("wrong") },
+			refused:     true,
+		},
+		{
+			description: "fails with public key",
+			user:        "alice@admin",
+			auth:        publicKey,
+			refused:     true,
+		},
+		{
+			description: "runs the command of the admin",
+			user:        "alice@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("x") },
+			command:     "devices --json",
+			status:      0,
+			stdout:      `"uid": "default:DEVICE123"`,
+		},
+		{
+			description: "fails when the command is unknown",
+			user:        "alice@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("x") },
+			command:     "reboot",
+			status:      1,
+			stderr:      "error: unknown command",
+		},
+		{
+			description: "fails when the command cannot be parsed",
+			user:        "alice@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("x") },
+			command:     `tags "DEVICE123`,
+			status:      1,
+			stderr:      "error: ",
+		},
+		{
+			description: "fails to run the shell without a pty",
+			user:        "alice@admin",
+			auth:        func(*testing.T) gossh.AuthMethod { return gossh.Password("x") },
+			command:     "",
+			status:      1,
+			stderr:      "The admin shell requires a pty.",
+		},
+	}
+
+	// NOTE: The password of the users is "x"; alice is an admin and bob is not.
+	store, err := users.Parse(strings.NewReader(
+		"alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881:admin:\n" +
+			"bob:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881::\n",
+	))
+	require.NoError(t, err)
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			tunnel := newTunnel(t)
+			service := services.New(inventory{tunnel}, session.NewRegistry(), nil, nil, nil, audit.NewLog(), nil)
+
+			address := serve(t, NewServer(&Options{Users: store, Service: service}, tunnel))
+
+			client, err := connect(address, tc.user, tc.auth(t))
+			if tc.refused {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			defer client.Close()
+
+			sess, err := client.NewSession()
+			require.NoError(t, err)
+			defer sess.Close()
+
+			var stdout, stderr bytes.Buffer
+			sess.Stdout = &stdout
+			sess.Stderr = &stderr
+
+			if tc.command == "" {
+				require.NoError(t, sess.Shell())
+				err = sess.Wait()
+			} else {
+				err = sess.Run(tc.command)
+			}
+
+			if tc.status == 0 {
+				require.NoError(t, err)
+			} else {
+				var exit *gossh.ExitError
+				require.ErrorAs(t, err, &exit)
+				assert.Equal(t, tc.status, exit.ExitStatus())
+			}
+
+			assert.Contains(t, stdout.String(), tc.stdout)
+			assert.Contains(t, stderr.String(), tc.stderr)
+		})
+	}
+}
+
+// tcpipForward marshals the payload of the "tcpip-forward" and "cancel-tcpip-forward" requests.
+func tcpipForward(addr string, port uint32) []byte {
+	return gossh.Marshal(&struct {
+		BindAddr string
+		BindPort uint32
+	}{addr, port})
+}
+
+func TestReversePortForwardDenied(t *testing.T) {
+	// NOTE: The policy allows every address, so the addresses are checked by the handler itself.
+	policy := &forward.Policy{Addresses: []string{forward.Any}, MinPort: 8000, MaxPort: 9000}
+
+	cases := []struct {
+		description string
+		policy      *forward.Policy
+		user        string
+		established bool
+		request     string
+		payload     []byte
+	}{
+		{
+			description: "fails when reverse port forwarding is disabled",
+			policy:      nil,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestTcpipForward,
+			payload:     tcpipForward("127.0.0.1", 8080),
+		},
+		{
+			description: "fails when the port is out of the policy",
+			policy:      policy,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestTcpipForward,
+			payload:     tcpipForward("127.0.0.1", 9090),
+		},
+		{
+			description: "fails when the bind address is not an IP address",
+			policy:      policy,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestTcpipForward,
+			payload:     tcpipForward("device.local", 8080),
+		},
+		{
+			description: "fails when the payload is invalid",
+			policy:      policy,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestTcpipForward,
+			payload:     []byte("invalid"),
+		},
+		{
+			description: "fails when the session to the device is not established",
+			policy:      policy,
+			user:        "alice",
+			established: false,
+			request:     forward.RequestTcpipForward,
+			payload:     tcpipForward("127.0.0.1", 8080),
+		},
+		{
+			description: "fails when the forwarding to cancel does not exist",
+			policy:      policy,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestCancelTcpipForward,
+			payload:     tcpipForward("127.0.0.1", 8080),
+		},
+		{
+			description: "fails when the device refuses to listen on the unix socket",
+			policy:      policy,
+			user:        "root@DEVICE123",
+			established: true,
+			request:     forward.RequestStreamLocalForward,
+			payload:     gossh.Marshal(&struct{ SocketPath string }{"/tmp/app.sock"}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			address := serve(t, NewServer(&Options{ReversePortForward: tc.policy}, newTunnel(t)))
+
+			client, err := connect(address, tc.user, publicKey(t))
+			require.NoError(t, err)
+			defer client.Close()
+
+			// NOTE: The session to the device is established once a session's channel is opened, which the device
+			// picker only does when a device is chosen.
+			if tc.established {
+				sess, err := client.NewSession()
+				require.NoError(t, err)
+				defer sess.Close()
+			}
+
+			ok, _, err := client.SendRequest(tc.request, true, tc.payload)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestReversePortForward(t *testing.T) {
+	address := serve(t, NewServer(&Options{ReversePortForward: &forward.Policy{
+		Addresses: []string{"127.0.0.1"},
+		MinPort:   1,
+		MaxPort:   65535,
+	}}, newTunnel(t)))
+
+	client, err := connect(address, "root@DEVICE123", publicKey(t))
+	require.NoError(t, err)
+	defer client.Close()
+
+	// NOTE: The fake agent only cancels the forwardings by the port asked, so a free port is asked explicitly.
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	port := free.Addr().(*net.TCPAddr).Port //nolint:forcetypeassert
+	free.Close()
+
+	listener, err := client.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	accepted, err := listener.Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buffer := make([]byte, 4)
+	_, err = io.ReadFull(accepted, buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buffer))
+
+	// NOTE: Closing the listener cancels the forwarding, which closes the listener on the device.
+	require.NoError(t, listener.Close())
+
+	// NOTE: The forwarding was removed by the first cancel, so another one is refused.
+	ok, _, err := client.SendRequest(forward.RequestCancelTcpipForward, true, tcpipForward("127.0.0.1", uint32(port)))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,59 +1,166 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/shellhub/pkg/models"
 )
 
+// ErrDeadlineUnsupported is returned when setting a deadline on a stream that doesn't support them.
+var ErrDeadlineUnsupported = errors.New("stream does not support deadlines")
+
 // Tunnel interface for different tunnel implementations
 type Tunnel interface {
 	// Dial creates a connection to the specified target
 	Dial(target string) (net.Conn, error)
+	// Connect connects to addr through the target, like a host on its network.
+	Connect(target, addr string) (net.Conn, error)
+	// Devices lists the devices known by the tunnel, including the ones currently offline.
+	Devices() []models.Device
+}
+
+// DeviceManager is the interface of the device manager used by [DeviceManagerTunnel].
+type DeviceManager interface {
+	OpenStream(deviceID string) (io.ReadWriteCloser, error)
+	Devices() []models.Device
 }
 
 // DeviceManager tunnel implementation
 type DeviceManagerTunnel struct {
-	deviceManager interface {
-		OpenStream(deviceID string) (io.ReadWriteCloser, error)
-	}
+	deviceManager DeviceManager
+	// connect bounds the opening of the streams and of their connections to the addresses.
+	connect time.Duration
+	// idle closes the streams without data either way for it.
+	idle time.Duration
 }
 
-func NewDeviceManagerTunnel(dm interface {
-	OpenStream(deviceID string) (io.ReadWriteCloser, error)
-}) *DeviceManagerTunnel {
-	return &DeviceManagerTunnel{deviceManager: dm}
+// NewDeviceManagerTunnel creates a tunnel to the devices of dm, giving up on the streams the devices don't open within
+// connect, and closing the ones without data either way for idle. Zero disables each timeout.
+func NewDeviceManagerTunnel(dm DeviceManager, connect, idle time.Duration) *DeviceManagerTunnel {
+	return &DeviceManagerTunnel{deviceManager: dm, connect: connect, idle: idle}
 }
 
 func (t *DeviceManagerTunnel) Dial(target string) (net.Conn, error) {
+	return proxy.Open(proxy.DialerFunc(t.open), target, t.connect)
+}
+
+// Connect connects to addr through the target, giving up when the device doesn't answer within the connect timeout.
+func (t *DeviceManagerTunnel) Connect(target, addr string) (net.Conn, error) {
+	stream, err := t.Dial(target)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := proxy.DialTimeout(stream, addr, t.connect)
+	if err != nil {
+		stream.Close()
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *DeviceManagerTunnel) open(target string) (net.Conn, error) {
 	stream, err := t.deviceManager.OpenStream(target)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open stream to device %s: %w", target, err)
 	}
 	
 	// Convert stream to net.Conn
-	return &streamConn{stream: stream, target: target}, nil
+	return newStreamConn(stream, target, t.idle), nil
+}
+
+func (t *DeviceManagerTunnel) Devices() []models.Device {
+	return t.deviceManager.Devices()
 }
 
 // streamConn adapts a stream to net.Conn
 type streamConn struct {
 	stream io.ReadWriteCloser
 	target string
+	// idle is the idle timeout, restarted by the data read or written, and expired when it fires.
+	idle    time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newStreamConn(stream io.ReadWriteCloser, target string, idle time.Duration) *streamConn {
+	c := &streamConn{stream: stream, target: target, idle: idle}
+	if idle > 0 {
+		c.timer = time.AfterFunc(idle, c.expire)
+	}
+
+	return c
+}
+
+// expire fails the pending and next reads and writes of the idle stream with a deadline in the past, or closes it
+// when it doesn't support deadlines.
+func (c *streamConn) expire() {
+	if !c.expired.CompareAndSwap(false, true) {
+		return
+	}
+
+	metrics.Timeouts.WithLabelValues(metrics.StageIdle).Inc()
+
+	if stream, ok := c.stream.(deadliner); ok {
+		stream.SetDeadline(time.Now()) //nolint:errcheck
+
+		return
+	}
+
+	c.stream.Close()
+}
+
+// active restarts the idle timeout when data was read or written.
+func (c *streamConn) active(n int) {
+	if c.timer != nil && n > 0 && !c.expired.Load() {
+		c.timer.Reset(c.idle)
+	}
 }
 
 func (c *streamConn) Read(b []byte) (int, error) {
-	return c.stream.Read(b)
+	n, err := c.stream.Read(b)
+	c.active(n)
+
+	return n, err
 }
 
 func (c *streamConn) Write(b []byte) (int, error) {
-	return c.stream.Write(b)
+	n, err := c.stream.Write(b)
+	c.active(n)
+
+	return n, err
 }
 
 func (c *streamConn) Close() error {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
 	return c.stream.Close()
 }
 
+// CloseWrite closes the writing side of the stream. Closing a yamux stream only closes its writing side, as it keeps
+// reading until the device closes it too; the other streams, like the ones through the other nodes of the cluster, are
+// closed whole.
+func (c *streamConn) CloseWrite() error {
+	if _, ok := c.stream.(*yamux.Stream); ok {
+		return c.stream.Close()
+	}
+
+	return relay.CloseWrite(c.stream)
+}
+
 func (c *streamConn) LocalAddr() net.Addr {
 	return &tunnelAddr{network: "yamux", address: "local"}
 }
@@ -62,16 +169,48 @@ func (c *streamConn) RemoteAddr() net.Addr {
 	return &tunnelAddr{network: "yamux", address: c.target}
 }
 
-func (c *streamConn) SetDeadline(_ time.Time) error {
-	return nil // yamux handles deadlines internally
+// deadliner is implemented by the streams that support deadlines, like the yamux streams and the WebSocket
+// connections to the other nodes of the cluster.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
 }
 
-func (c *streamConn) SetReadDeadline(_ time.Time) error {
-	return nil
+func (c *streamConn) SetDeadline(t time.Time) error {
+	stream, ok := c.stream.(deadliner)
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return stream.SetDeadline(c.deadline(t))
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	stream, ok := c.stream.(deadliner)
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return stream.SetReadDeadline(c.deadline(t))
 }
 
-func (c *streamConn) SetWriteDeadline(_ time.Time) error {
-	return nil
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	stream, ok := c.stream.(deadliner)
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return stream.SetWriteDeadline(c.deadline(t))
+}
+
+// deadline keeps the deadline in the past of an idle stream, so clearing a deadline doesn't revive it.
+func (c *streamConn) deadline(t time.Time) time.Time {
+	if c.expired.Load() {
+		return time.Now()
+	}
+
+	return t
 }
 
 type tunnelAddr struct {
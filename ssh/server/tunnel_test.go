@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deviceManager opens the yamux streams to a device echoing the data.
+type deviceManager struct {
+	session *yamux.Session
+}
+
+func (m *deviceManager) OpenStream(string) (io.ReadWriteCloser, error) {
+	return m.session.OpenStream()
+}
+
+func (m *deviceManager) Devices() []models.Device {
+	return []models.Device{}
+}
+
+func newDeviceManager(t *testing.T) *deviceManager {
+	t.Helper()
+
+	server, agent := net.Pipe()
+
+	session, err := yamux.Client(server, nil)
+	require.NoError(t, err)
+
+	device, err := yamux.Server(agent, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		session.Close()
+		device.Close()
+	})
+
+	go func() {
+		for {
+			stream, err := device.Accept()
+			if err != nil {
+				return
+			}
+
+			go io.Copy(stream, stream) //nolint:errcheck
+		}
+	}()
+
+	return &deviceManager{session: session}
+}
+
+func TestDeviceManagerTunnelIdle(t *testing.T) {
+	cases := []struct {
+		description string
+		writes      int
+		expected    bool
+	}{
+		{
+			description: "fails reads when stream has no data for the idle timeout",
+			writes:      0,
+			expected:    true,
+		},
+		{
+			description: "keeps stream while data flows within the idle timeout",
+			writes:      5,
+			expected:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			tunnel := NewDeviceManagerTunnel(newDeviceManager(t), time.Second, 100*time.Millisecond)
+
+			conn, err := tunnel.Dial("default:DEVICE123")
+			require.NoError(t, err)
+			defer conn.Close()
+
+			for i := 0; i < tc.writes; i++ {
+				time.Sleep(50 * time.Millisecond)
+
+				_, err := conn.Write([]byte("a"))
+				require.NoError(t, err)
+
+				_, err = io.ReadFull(conn, make([]byte, 1))
+				require.NoError(t, err)
+			}
+
+			if !tc.expected {
+				return
+			}
+
+			// NOTE: Clearing the deadline doesn't revive the idle stream.
+			time.Sleep(200 * time.Millisecond)
+			require.NoError(t, conn.SetDeadline(time.Time{}))
+
+			_, err = conn.Read(make([]byte, 1))
+
+			var netErr net.Error
+			assert.True(t, errors.As(err, &netErr) && netErr.Timeout())
+		})
+	}
+}
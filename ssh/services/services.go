@@ -0,0 +1,315 @@
+// Package services provides the service layer used to manage the server, shared by the admin REST API and the admin
+// shell over SSH.
+package services
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/portmap"
+	"github.com/shellhub-io/mini-shellhub/ssh/publish"
+	"github.com/shellhub-io/mini-shellhub/ssh/server/ports"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+)
+
+var (
+	ErrDeviceNotFound      = errors.New("device not found")
+	ErrDeviceAmbiguous     = errors.New("more than one device matches the name; use the device UID")
+	ErrDeviceNotPending    = errors.New("device is not pending")
+	ErrInvalidTag          = errors.New("tag must have from 1 to 255 letters, numbers, dots, dashes or underscores")
+	ErrSessionNotFound     = session.ErrSessionNotFound
+	ErrRecordingNotFound   = errors.New("recording not found")
+	ErrMappingNotFound     = portmap.ErrMappingNotFound
+	ErrMappingExists       = portmap.ErrMappingExists
+	ErrInvalidMapping      = portmap.ErrInvalidMapping
+	ErrPublicationNotFound = publish.ErrPublicationNotFound
+	ErrLockoutNotFound     = guard.ErrNotFound
+)
+
+// DeviceStore is the interface of the store that keeps the devices known by the server.
+type DeviceStore interface {
+	// Devices lists every known device.
+	Devices() []models.Device
+	// SetDeviceStatus sets the status of the device with the UID.
+	SetDeviceStatus(uid string, status models.DeviceStatus) error
+	// SetDeviceTags replaces the tags of the device with the UID.
+	SetDeviceTags(uid string, tags []string) error
+}
+
+// MappingManager is the interface of the manager that runs the port mappings.
+type MappingManager interface {
+	// List lists the mappings with their counters.
+	List() []portmap.Status
+	// Get gets a mapping with its counters.
+	Get(name string) (*portmap.Status, error)
+	// Add starts a mapping.
+	Add(mapping portmap.Mapping) (*portmap.Status, error)
+	// Remove stops a mapping.
+	Remove(name string) error
+}
+
+// PublicationManager is the interface of the manager that keeps the services published by the devices.
+type PublicationManager interface {
+	// List lists the publications.
+	List() []publish.Publication
+	// Revoke stops serving a publication.
+	Revoke(device, name string) (*publish.Publication, error)
+}
+
+// PortManager is the interface of the manager that listens on the SSH ports of the devices.
+type PortManager interface {
+	// List lists the ports assigned to the devices.
+	List() []ports.Assignment
+}
+
+// Service manages the devices, sessions, port mappings and publications of the server.
+type Service struct {
+	devices      DeviceStore
+	sessions     *session.Registry
+	mappings     MappingManager
+	publications PublicationManager
+	ports        PortManager
+	events       *audit.Log
+	guard        *guard.Guard
+}
+
+// New creates a new [Service].
+func New(devices DeviceStore, sessions *session.Registry, mappings MappingManager, publications PublicationManager, ports PortManager, events *audit.Log, guard *guard.Guard) *Service {
+	return &Service{
+		devices:      devices,
+		sessions:     sessions,
+		mappings:     mappings,
+		publications: publications,
+		ports:        ports,
+		events:       events,
+		guard:        guard,
+	}
+}
+
+// ListDevices lists the devices sorted by namespace and name. When query is not empty, only the devices whose UID,
+// name or namespace contain it are returned; a query in the form "tag:<tag>" returns the devices with that tag.
+func (s *Service) ListDevices(query string) []models.Device {
+	devices := s.devices.Devices()
+
+	filtered := make([]models.Device, 0, len(devices))
+	for _, device := range devices {
+		if matchDevice(device, query) {
+			filtered = append(filtered, device)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].TenantID != filtered[j].TenantID {
+			return filtered[i].TenantID < filtered[j].TenantID
+		}
+
+		return filtered[i].Name < filtered[j].Name
+	})
+
+	return filtered
+}
+
+func matchDevice(device models.Device, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	if tag, ok := strings.CutPrefix(query, "tag:"); ok {
+		for _, t := range device.Tags {
+			if t == tag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	query = strings.ToLower(query)
+
+	return strings.Contains(strings.ToLower(device.UID), query) ||
+		strings.Contains(strings.ToLower(device.Name), query) ||
+		strings.Contains(strings.ToLower(device.TenantID), query)
+}
+
+// GetDevice gets a device by its UID or, when unique, by its name.
+func (s *Service) GetDevice(id string) (*models.Device, error) {
+	var found *models.Device
+
+	for _, device := range s.devices.Devices() {
+		if device.UID == id {
+			return &device, nil
+		}
+
+		if device.Name == id {
+			if found != nil {
+				return nil, ErrDeviceAmbiguous
+			}
+
+			found = &device
+		}
+	}
+
+	if found == nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	return found, nil
+}
+
+// AcceptDevice accepts a pending device, allowing connections to it.
+func (s *Service) AcceptDevice(id string) (*models.Device, error) {
+	device, err := s.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if device.Status != models.DeviceStatusPending {
+		return nil, ErrDeviceNotPending
+	}
+
+	if err := s.devices.SetDeviceStatus(device.UID, models.DeviceStatusAccepted); err != nil {
+		return nil, err
+	}
+
+	return s.GetDevice(device.UID)
+}
+
+var tagRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+
+// SetDeviceTags replaces the tags of a device.
+func (s *Service) SetDeviceTags(id string, tags []string) (*models.Device, error) {
+	for _, tag := range tags {
+		if !tagRegexp.MatchString(tag) {
+			return nil, ErrInvalidTag
+		}
+	}
+
+	device, err := s.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.devices.SetDeviceTags(device.UID, tags); err != nil {
+		return nil, err
+	}
+
+	return s.GetDevice(device.UID)
+}
+
+// ListSessions lists the active sessions sorted by start time.
+func (s *Service) ListSessions() []models.Session {
+	return toModels(s.sessions.Sessions(), true)
+}
+
+// KillSession closes the connection of an active session.
+func (s *Service) KillSession(uid string) error {
+	return s.sessions.Kill(uid)
+}
+
+// ListRecordings lists the recorded sessions, active and finished, sorted by start time.
+func (s *Service) ListRecordings() []models.Session {
+	active := make(map[string]bool)
+	for _, sess := range s.sessions.Sessions() {
+		active[sess.UID] = true
+	}
+
+	recordings := toModels(s.sessions.Recordings(), false)
+	for i := range recordings {
+		recordings[i].Active = active[recordings[i].UID]
+	}
+
+	return recordings
+}
+
+// GetRecording gets the recorded frames of a session.
+func (s *Service) GetRecording(uid string) ([]session.Frame, error) {
+	sess, err := s.sessions.Recording(uid)
+	if err != nil {
+		return nil, ErrRecordingNotFound
+	}
+
+	return sess.Frames(), nil
+}
+
+// ListMappings lists the port mappings sorted by name.
+func (s *Service) ListMappings() []portmap.Status {
+	return s.mappings.List()
+}
+
+// GetMapping gets a port mapping.
+func (s *Service) GetMapping(name string) (*portmap.Status, error) {
+	return s.mappings.Get(name)
+}
+
+// AddMapping starts a port mapping, listening on its port. It is not kept on the mappings file.
+func (s *Service) AddMapping(mapping portmap.Mapping) (*portmap.Status, error) {
+	return s.mappings.Add(mapping)
+}
+
+// RemoveMapping stops a port mapping, closing its connections.
+func (s *Service) RemoveMapping(name string) error {
+	return s.mappings.Remove(name)
+}
+
+// ListPublications lists the services published by the devices, sorted by device and name.
+func (s *Service) ListPublications() []publish.Publication {
+	return s.publications.List()
+}
+
+// RevokePublication stops serving a service published by a device, which is ignored when the device publishes it
+// again.
+func (s *Service) RevokePublication(device, name string) (*publish.Publication, error) {
+	return s.publications.Revoke(device, name)
+}
+
+// ListDevicePorts lists the SSH ports assigned to the devices, sorted by port.
+func (s *Service) ListDevicePorts() []ports.Assignment {
+	return s.ports.List()
+}
+
+// ListAuditEvents lists the latest audit events, from the oldest to the newest.
+func (s *Service) ListAuditEvents() []audit.Event {
+	return s.events.Events()
+}
+
+// ListLockouts lists the clients' addresses and SSHIDs with authentication failures or locked out, sorted by key.
+func (s *Service) ListLockouts() []guard.Status {
+	return s.guard.List()
+}
+
+// ClearLockout forgets the authentication failures and lockouts of the key, like "source:10.0.0.5" or
+// "target:root@DEVICE123".
+func (s *Service) ClearLockout(key string) error {
+	return s.guard.Clear(key)
+}
+
+func toModels(sessions []*session.Session, active bool) []models.Session {
+	list := make([]models.Session, 0, len(sessions))
+	for _, sess := range sessions {
+		list = append(list, models.Session{
+			UID:           sess.UID,
+			DeviceUID:     models.UID(sess.Device.UID),
+			Device:        sess.Device,
+			TenantID:      sess.Device.TenantID,
+			Username:      sess.Target.Username,
+			IPAddress:     sess.IPAddress,
+			StartedAt:     sess.StartedAt,
+			Active:        active,
+			Authenticated: true,
+			Recorded:      sess.IsRecorded(),
+			Type:          sess.Type,
+			Term:          sess.Term,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+
+	return list
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/session"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// store is a [DeviceStore] keeping the devices in memory.
+type store struct {
+	devices []models.Device
+}
+
+func (s *store) Devices() []models.Device {
+	return append([]models.Device{}, s.devices...)
+}
+
+func (s *store) device(uid string) (*models.Device, error) {
+	for i := range s.devices {
+		if s.devices[i].UID == uid {
+			return &s.devices[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("device %s not found", uid)
+}
+
+func (s *store) SetDeviceStatus(uid string, status models.DeviceStatus) error {
+	device, err := s.device(uid)
+	if err != nil {
+		return err
+	}
+
+	device.Status = status
+
+	return nil
+}
+
+func (s *store) SetDeviceTags(uid string, tags []string) error {
+	device, err := s.device(uid)
+	if err != nil {
+		return err
+	}
+
+	device.Tags = tags
+
+	return nil
+}
+
+func newStore() *store {
+	return &store{devices: []models.Device{
+		{UID: "lab:DEVICE456", Name: "DEVICE456", TenantID: "lab", Status: models.DeviceStatusPending},
+		{
+			UID:      "default:DEVICE123",
+			Name:     "DEVICE123",
+			TenantID: "default",
+			Status:   models.DeviceStatusAccepted,
+			Tags:     []string{"prod"},
+		},
+		{UID: "lab:DEVICE123", Name: "DEVICE123", TenantID: "lab", Status: models.DeviceStatusAccepted},
+	}}
+}
+
+func TestListDevices(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    []string
+	}{
+		{
+			description: "lists every device sorted by namespace and name",
+			query:       "",
+			expected:    []string{"default:DEVICE123", "lab:DEVICE123", "lab:DEVICE456"},
+		},
+		{
+			description: "lists the devices whose namespace contains the query",
+			query:       "LAB",
+			expected:    []string{"lab:DEVICE123", "lab:DEVICE456"},
+		},
+		{
+			description: "lists the devices with the tag",
+			query:       "tag:prod",
+			expected:    []string{"default:DEVICE123"},
+		},
+		{
+			description: "lists no device when none matches",
+			query:       "switch",
+			expected:    []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			uids := []string{}
+			for _, device := range New(newStore(), nil, nil, nil, nil, nil, nil).ListDevices(tc.query) {
+				uids = append(uids, device.UID)
+			}
+
+			assert.Equal(t, tc.expected, uids)
+		})
+	}
+}
+
+func TestGetDevice(t *testing.T) {
+	cases := []struct {
+		description string
+		id          string
+		expected    string
+		err         error
+	}{
+		{
+			description: "gets the device by its UID",
+			id:          "lab:DEVICE123",
+			expected:    "lab:DEVICE123",
+			err:         nil,
+		},
+		{
+			description: "gets the device by its unique name",
+			id:          "DEVICE456",
+			expected:    "lab:DEVICE456",
+			err:         nil,
+		},
+		{
+			description: "fails when more than one device has the name",
+			id:          "DEVICE123",
+			err:         ErrDeviceAmbiguous,
+		},
+		{
+			description: "fails when no device has the UID or name",
+			id:          "DEVICE789",
+			err:         ErrDeviceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			device, err := New(newStore(), nil, nil, nil, nil, nil, nil).GetDevice(tc.id)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, device.UID)
+		})
+	}
+}
+
+func TestAcceptDevice(t *testing.T) {
+	cases := []struct {
+		description string
+		id          string
+		err         error
+	}{
+		{
+			description: "accepts the pending device",
+			id:          "DEVICE456",
+			err:         nil,
+		},
+		{
+			description: "fails when the device is already accepted",
+			id:          "lab:DEVICE123",
+			err:         ErrDeviceNotPending,
+		},
+		{
+			description: "fails when the device is unknown",
+			id:          "DEVICE789",
+			err:         ErrDeviceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			device, err := New(newStore(), nil, nil, nil, nil, nil, nil).AcceptDevice(tc.id)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, models.DeviceStatusAccepted, device.Status)
+		})
+	}
+}
+
+func TestSetDeviceTags(t *testing.T) {
+	cases := []struct {
+		description string
+		tags        []string
+		expected    []string
+		err         error
+	}{
+		{
+			description: "replaces the tags of the device",
+			tags:        []string{"db", "v1.2_3-rc"},
+			expected:    []string{"db", "v1.2_3-rc"},
+			err:         nil,
+		},
+		{
+			description: "removes the tags of the device",
+			tags:        []string{},
+			expected:    []string{},
+			err:         nil,
+		},
+		{
+			description: "fails when a tag has invalid characters",
+			tags:        []string{"db", "prod env"},
+			err:         ErrInvalidTag,
+		},
+		{
+			description: "fails when a tag is empty",
+			tags:        []string{""},
+			err:         ErrInvalidTag,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			devices := newStore()
+
+			device, err := New(devices, nil, nil, nil, nil, nil, nil).SetDeviceTags("default:DEVICE123", tc.tags)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Equal(t, []string{"prod"}, devices.devices[1].Tags)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, device.Tags)
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	service := New(newStore(), session.NewRegistry(), nil, nil, nil, nil, nil)
+
+	assert.Empty(t, service.ListSessions())
+	assert.Empty(t, service.ListRecordings())
+	assert.ErrorIs(t, service.KillSession("unknown"), ErrSessionNotFound)
+
+	_, err := service.GetRecording("unknown")
+	assert.ErrorIs(t, err, ErrRecordingNotFound)
+}
+
+func TestLockouts(t *testing.T) {
+	config := guard.Config{MaxFailures: 1, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+
+	lockouts, err := guard.New(config)
+	require.NoError(t, err)
+
+	service := New(newStore(), nil, nil, nil, nil, nil, lockouts)
+
+	lockouts.Fail("10.0.0.5", "root@DEVICE123")
+
+	listed := service.ListLockouts()
+	require.Len(t, listed, 2)
+
+	require.NoError(t, service.ClearLockout(listed[0].Key))
+	assert.Len(t, service.ListLockouts(), 1)
+
+	assert.ErrorIs(t, service.ClearLockout(listed[0].Key), ErrLockoutNotFound)
+}
@@ -0,0 +1,35 @@
+package session
+
+import (
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+)
+
+// SetAudit sets the log recording the audit events of the connection associated with the provided context.
+func SetAudit(ctx gliderssh.Context, events *audit.Log) {
+	ctx.SetValue("audit", events)
+}
+
+// GetAudit gets the log stored by [SetAudit], or nil when the audit events are only logged.
+func GetAudit(ctx gliderssh.Context) *audit.Log {
+	events, _ := ctx.Value("audit").(*audit.Log)
+
+	return events
+}
+
+// Audit records the event on the connection's audit log, filled with the session's identification.
+func (s *Session) Audit(ctx gliderssh.Context, event audit.Event) {
+	event.Session = s.UID
+	event.SSHID = s.Data.SSHID
+	event.IPAddress = s.Data.IPAddress
+
+	if s.Data.Device != nil {
+		event.Device = s.DeviceKey()
+	}
+
+	if s.Data.Target != nil {
+		event.Username = s.Data.Target.Username
+	}
+
+	GetAudit(ctx).Record(event)
+}
@@ -1,6 +1,12 @@
 package session
 
 import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "sync"
+
     gossh "golang.org/x/crypto/ssh"
 )
 
@@ -10,12 +16,16 @@ type authMethod int8
 
 const (
     AuthMethodPassword authMethod = iota
+    AuthMethodPublicKey
 )
 
 type Auth interface {
     Method() authMethod
     Auth() authFunc
     Evaluate(*Session) error
+    // Fingerprint identifies the credential without keeping it, so pooled connections to the agents are only reused
+    // with the credential they were authenticated with.
+    Fingerprint() string
 }
 
 type passwordAuth struct{ pwd string }
@@ -29,3 +39,42 @@ func (p *passwordAuth) Auth() authFunc {
     }
 }
 func (*passwordAuth) Evaluate(*Session) error { return nil }
+func (p *passwordAuth) Fingerprint() string {
+    sum := sha256.Sum256([]byte("password:" + p.pwd))
+    return hex.EncodeToString(sum[:])
+}
+
+// agentSigner is the key the server authenticates with on the agents for the clients authenticated by public key,
+// generated once per process, as the clients' private keys never reach the server.
+var agentSigner = sync.OnceValues(func() (gossh.Signer, error) {
+    _, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+
+    return gossh.NewSignerFromKey(key)
+})
+
+type publicKeyAuth struct{ key gossh.PublicKey }
+
+// AuthPublicKey authenticates on the agent by public key for a client authenticated with key. The agent accepts any
+// public key, so the client is never refused, nor counted as a failure, like in single-user mode.
+func AuthPublicKey(key gossh.PublicKey) Auth { return &publicKeyAuth{key: key} }
+func (*publicKeyAuth) Method() authMethod { return AuthMethodPublicKey }
+func (*publicKeyAuth) Auth() authFunc {
+    return func(_ *Session, cfg *gossh.ClientConfig) error {
+        signer, err := agentSigner()
+        if err != nil {
+            return err
+        }
+
+        cfg.Auth = []gossh.AuthMethod{gossh.PublicKeys(signer)}
+        return nil
+    }
+}
+func (*publicKeyAuth) Evaluate(*Session) error { return nil }
+func (p *publicKeyAuth) Fingerprint() string {
+    // NOTE: Each client key is a credential of its own, so clients with different keys never share a pooled connection.
+    sum := sha256.Sum256([]byte("publickey:" + gossh.FingerprintSHA256(p.key)))
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"errors"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetConcurrency sets the limiter capping the connections and channels of the connection associated with the provided
+// context.
+func SetConcurrency(ctx gliderssh.Context, limiter *concurrency.Limiter) {
+	ctx.SetValue("concurrency", limiter)
+}
+
+// GetConcurrency gets the limiter stored by [SetConcurrency], or nil when there are no caps.
+func GetConcurrency(ctx gliderssh.Context) *concurrency.Limiter {
+	limiter, _ := ctx.Value("concurrency").(*concurrency.Limiter)
+
+	return limiter
+}
+
+// owner is who holds the session's connection and channels on the limiter.
+func (s *Session) owner() concurrency.Owner {
+	return concurrency.Owner{
+		Tenant: s.Data.Device.TenantID,
+		Device: s.DeviceKey(),
+		User:   s.Data.Target.Username,
+	}
+}
+
+// reject logs and records on the audit log that a cap of the limiter rejected the session's connection or channel.
+func (s *Session) reject(ctx gliderssh.Context, kind string, err error) {
+	reason := concurrency.ErrLimitReached.Error()
+
+	var limit *concurrency.Error
+	if errors.As(err, &limit) {
+		reason = limit.Reason()
+	}
+
+	log.WithError(err).WithFields(log.Fields{"uid": s.UID, "sshid": s.Data.SSHID, "reason": reason}).Warn("concurrency limit reached")
+
+	s.Audit(ctx, audit.Event{Type: kind, Reason: reason})
+}
+
+// Admit holds the session's connection on the limiter until the connection is done, or the session leaves it, failing
+// when any of its caps is reached.
+func (s *Session) Admit(ctx gliderssh.Context) error {
+	release, err := s.limiter.Acquire(concurrency.Connections, s.owner())
+	if err != nil {
+		s.reject(ctx, audit.TypeConnectionRejected, err)
+
+		return errors.Join(ErrConnectionLimit, err)
+	}
+
+	s.mu.Lock()
+	s.admitted = release
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		release()
+	}()
+
+	return nil
+}
+
+// Leave releases the session's connection held by [Session.Admit] before the connection is done, like when the device
+// chosen on the device picker cannot be reached and the client may choose another one.
+func (s *Session) Leave() {
+	s.mu.Lock()
+	release := s.admitted
+	s.mu.Unlock()
+
+	if release != nil {
+		release()
+	}
+}
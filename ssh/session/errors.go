@@ -16,4 +16,7 @@ var (
 	ErrUnexpectedAuthMethod    = fmt.Errorf("failed to authenticate the session due to a unexpected method")
 	ErrEvaluatePublicKey       = fmt.Errorf("failed to evaluate the provided public key")
 	ErrSeatAlreadySet          = fmt.Errorf("this seat was already set")
+	ErrConnectionLimit         = fmt.Errorf("too many connections to the device")
+	ErrAuthRejected            = fmt.Errorf("the device refused the credential")
+	ErrChannelLimit            = fmt.Errorf("too many channels to the device")
 )
@@ -0,0 +1,18 @@
+package session
+
+import (
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/firewall"
+)
+
+// SetFirewall sets the firewall evaluated on the sessions of the connection associated with the provided context.
+func SetFirewall(ctx gliderssh.Context, firewall *firewall.Firewall) {
+	ctx.SetValue("firewall", firewall)
+}
+
+// GetFirewall gets the firewall stored by [SetFirewall], or nil when every session is allowed.
+func GetFirewall(ctx gliderssh.Context) *firewall.Firewall {
+	firewall, _ := ctx.Value("firewall").(*firewall.Firewall)
+
+	return firewall
+}
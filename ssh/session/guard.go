@@ -0,0 +1,19 @@
+package session
+
+import (
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+)
+
+// SetGuard sets the guard against brute force checked on the authentications of the connection associated with the
+// provided context.
+func SetGuard(ctx gliderssh.Context, guard *guard.Guard) {
+	ctx.SetValue("guard", guard)
+}
+
+// GetGuard gets the guard stored by [SetGuard], or nil when the authentications aren't guarded.
+func GetGuard(ctx gliderssh.Context) *guard.Guard {
+	guard, _ := ctx.Value("guard").(*guard.Guard)
+
+	return guard
+}
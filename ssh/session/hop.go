@@ -0,0 +1,59 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+)
+
+// hops are the sessions of the jump host connections to the devices on a connection, like `ssh -J`, which opens a
+// direct-tcpip channel to each device instead of authenticating on it through the server.
+type hops struct {
+	mu       sync.Mutex
+	next     int
+	sessions map[*Session]struct{}
+}
+
+// AddHop adds the session of a jump host connection to the connection associated with the provided context, so it is
+// listed and killed like the sessions authenticated on the devices. As a connection may open several, the session gets
+// its own UID, after the connection's one. It returns a function that removes it, to be called when the hop is done.
+func AddHop(ctx gliderssh.Context, sess *Session) func() {
+	ctx.Lock()
+	jumps, ok := ctx.Value("hops").(*hops)
+	if !ok {
+		jumps = &hops{sessions: make(map[*Session]struct{})}
+		ctx.SetValue("hops", jumps)
+	}
+	ctx.Unlock()
+
+	jumps.mu.Lock()
+	jumps.next++
+	sess.UID = fmt.Sprintf("%s-%d", ctx.SessionID(), jumps.next)
+	jumps.sessions[sess] = struct{}{}
+	jumps.mu.Unlock()
+
+	return func() {
+		jumps.mu.Lock()
+		delete(jumps.sessions, sess)
+		jumps.mu.Unlock()
+	}
+}
+
+// getHops gets the sessions added by [AddHop] to the connection associated with the provided context.
+func getHops(ctx gliderssh.Context) []*Session {
+	jumps, ok := ctx.Value("hops").(*hops)
+	if !ok {
+		return nil
+	}
+
+	jumps.mu.Lock()
+	defer jumps.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(jumps.sessions))
+	for sess := range jumps.sessions {
+		sessions = append(sessions, sess)
+	}
+
+	return sessions
+}
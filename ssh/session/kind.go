@@ -0,0 +1,97 @@
+package session
+
+import (
+	"strings"
+
+	gliderssh "github.com/gliderlabs/ssh"
+)
+
+// Kind identifies how the server handles a client connection.
+type Kind int
+
+const (
+	// KindDevice bridges the connection to the device named in the SSHID. It is the default kind.
+	KindDevice Kind = iota
+	// KindPicker authenticates the connection on the server itself and lets the user choose the device to connect to
+	// through a text-mode picker on the session channel, or through direct-tcpip channels when the server is used as a
+	// jump host, like `ssh -J jump@server user@device`.
+	KindPicker
+	// KindAdmin authenticates the connection on the server itself and handles the session channel with the admin
+	// shell, without any device involved.
+	KindAdmin
+)
+
+// String returns the name of the kind, like "device".
+func (k Kind) String() string {
+	switch k {
+	case KindPicker:
+		return "picker"
+	case KindAdmin:
+		return "admin"
+	default:
+		return "device"
+	}
+}
+
+// SetKind sets the kind of the connection associated with the provided context.
+func SetKind(ctx gliderssh.Context, kind Kind) {
+	ctx.SetValue("kind", kind)
+}
+
+// GetKind gets the kind of the connection associated with the provided context. When no kind was set, [KindDevice]
+// is returned.
+func GetKind(ctx gliderssh.Context) Kind {
+	kind, _ := ctx.Value("kind").(Kind)
+
+	return kind
+}
+
+// SetCredential stores the authentication method used by the client on the server, to be replayed on the agent once
+// the destination device is known.
+func SetCredential(ctx gliderssh.Context, auth Auth) {
+	ctx.SetValue("credential", auth)
+}
+
+// GetCredential gets the authentication method stored by [SetCredential].
+func GetCredential(ctx gliderssh.Context) (Auth, bool) {
+	auth, ok := ctx.Value("credential").(Auth)
+
+	return auth, ok
+}
+
+// SetSSHID stores the SSHID of the connection, when it differs from the connection's user, like when the client used
+// an alternative form of the SSHID or connected to the port of a device.
+func SetSSHID(ctx gliderssh.Context, sshid string) {
+	ctx.SetValue("sshid", sshid)
+}
+
+// GetSSHID gets the SSHID stored by [SetSSHID], or the connection's user when none was stored.
+func GetSSHID(ctx gliderssh.Context) string {
+	if sshid, ok := ctx.Value("sshid").(string); ok {
+		return sshid
+	}
+
+	return ctx.User()
+}
+
+// LocalUser gets the name of the user who authenticates on the server itself, that is the username part of the SSHID,
+// like "alice" in "alice@admin", or the whole SSHID when it has no device.
+func LocalUser(ctx gliderssh.Context) string {
+	user, _, _ := strings.Cut(GetSSHID(ctx), "@")
+
+	return user
+}
+
+// SetBoundDevice stores the device bound to the port the client connected to, when the server listens on a port for
+// each device, so the username of the connection is the user on that device.
+func SetBoundDevice(ctx gliderssh.Context, device string) {
+	ctx.SetValue("bound-device", device)
+}
+
+// GetBoundDevice gets the device stored by [SetBoundDevice], or an empty string when the client connected to the
+// server's own port.
+func GetBoundDevice(ctx gliderssh.Context) string {
+	device, _ := ctx.Value("bound-device").(string)
+
+	return device
+}
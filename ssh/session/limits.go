@@ -0,0 +1,146 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/audit"
+	"github.com/shellhub-io/mini-shellhub/ssh/limits"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ReasonIdleTimeout is the reason of the sessions terminated for being idle.
+	ReasonIdleTimeout = "idle-timeout"
+	// ReasonMaxDuration is the reason of the sessions terminated for lasting too long.
+	ReasonMaxDuration = "max-duration"
+)
+
+// WarningLead is how long before terminating a session the user is warned, or half of the limit when it is shorter.
+const WarningLead = time.Minute
+
+// watchInterval is how often the limits of a session are checked.
+var watchInterval = time.Second
+
+// SetLimits sets the limits matched on the sessions of the connection associated with the provided context.
+func SetLimits(ctx gliderssh.Context, limits *limits.Limits) {
+	ctx.SetValue("limits", limits)
+}
+
+// GetLimits gets the limits stored by [SetLimits], or nil when the sessions have no limits.
+func GetLimits(ctx gliderssh.Context) *limits.Limits {
+	limits, _ := ctx.Value("limits").(*limits.Limits)
+
+	return limits
+}
+
+// Active wraps the writer to mark the session as active on each write, resetting its idle time.
+func (s *Session) Active(w io.Writer) io.Writer {
+	return &activeWriter{session: s, writer: w}
+}
+
+type activeWriter struct {
+	session *Session
+	writer  io.Writer
+}
+
+func (a *activeWriter) Write(p []byte) (int, error) {
+	a.session.activity.Store(time.Now().UnixNano())
+
+	return a.writer.Write(p)
+}
+
+// SetInteractive marks the session as interactive, as when the client requests a pty, so that it may be terminated for
+// being idle.
+func (s *Session) SetInteractive() {
+	s.interactive.Store(true)
+}
+
+// Idle returns how long the session has gone without data flowing through its channels.
+func (s *Session) Idle(now time.Time) time.Duration {
+	last := s.StartedAt
+	if activity := s.activity.Load(); activity != 0 {
+		last = time.Unix(0, activity)
+	}
+
+	return now.Sub(last)
+}
+
+// lead returns how long before reaching the limit the user is warned.
+func lead(limit time.Duration) time.Duration {
+	return min(WarningLead, limit/2)
+}
+
+// Watch terminates the session once it reaches the limit matched on its user and device, warning the user shortly
+// before. Only interactive sessions are terminated for being idle. It is started once per session, in background, when
+// the session is authenticated on the agent, and stops when the connection is done.
+func (s *Session) Watch(ctx gliderssh.Context) {
+	limit := GetLimits(ctx).Match(s.Data.Target.Username, s.DeviceKey())
+	if limit.Idle <= 0 && limit.Max <= 0 {
+		return
+	}
+
+	s.watch.Do(func() {
+		go s.watchLimit(ctx, limit)
+	})
+}
+
+func (s *Session) watchLimit(ctx gliderssh.Context, limit limits.Limit) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var warnedIdle, warnedMax bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if limit.Max > 0 {
+				left := s.StartedAt.Add(limit.Max).Sub(now)
+				if left <= 0 {
+					s.terminate(ctx, ReasonMaxDuration, fmt.Sprintf("The session reached its maximum duration of %s and was closed.", limit.Max))
+
+					return
+				}
+
+				if left <= lead(limit.Max) && !warnedMax {
+					s.Notify(fmt.Sprintf("The session will be closed in %s, when it reaches its maximum duration of %s.", left.Round(time.Second), limit.Max))
+					warnedMax = true
+				}
+			}
+
+			if limit.Idle > 0 && s.interactive.Load() {
+				left := limit.Idle - s.Idle(now)
+				if left <= 0 {
+					s.terminate(ctx, ReasonIdleTimeout, fmt.Sprintf("The session was idle for %s and was closed.", limit.Idle))
+
+					return
+				}
+
+				// NOTE: The user is warned again when the session goes idle again after some activity.
+				switch {
+				case left > lead(limit.Idle):
+					warnedIdle = false
+				case !warnedIdle:
+					s.Notify(fmt.Sprintf("The session will be closed in %s for being idle.", left.Round(time.Second)))
+					warnedIdle = true
+				}
+			}
+		}
+	}
+}
+
+// terminate notifies the user, records the reason on the audit log and closes the client's connection.
+func (s *Session) terminate(ctx gliderssh.Context, reason, message string) {
+	log.WithFields(log.Fields{"uid": s.UID, "sshid": s.Data.SSHID, "reason": reason}).Info("session terminated by its limit")
+
+	s.Notify(message)
+	s.Audit(ctx, audit.Event{Type: audit.TypeSessionTerminated, Reason: reason})
+
+	if conn, ok := ctx.Value("conn").(net.Conn); ok {
+		conn.Close()
+	}
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// localChannels are the session channels the server handles itself on a connection, like the ones of the device
+// picker and of the admin shell.
+type localChannels struct {
+	mu       sync.Mutex
+	channels map[gossh.Channel]struct{}
+}
+
+// AddLocalChannel adds a session channel the server handles itself to the connection associated with the provided
+// context, so its user is notified like the ones of the sessions to the devices. It returns a function that removes
+// it, to be called when the channel is closed.
+func AddLocalChannel(ctx gliderssh.Context, channel gossh.Channel) func() {
+	ctx.Lock()
+	local, ok := ctx.Value("local-channels").(*localChannels)
+	if !ok {
+		local = &localChannels{channels: make(map[gossh.Channel]struct{})}
+		ctx.SetValue("local-channels", local)
+	}
+	ctx.Unlock()
+
+	local.mu.Lock()
+	local.channels[channel] = struct{}{}
+	local.mu.Unlock()
+
+	return func() {
+		local.mu.Lock()
+		delete(local.channels, channel)
+		local.mu.Unlock()
+	}
+}
+
+// notifyLocal writes the message on the standard error of the channels added by [AddLocalChannel] to the connection
+// associated with the provided context.
+func notifyLocal(ctx gliderssh.Context, message string) {
+	local, ok := ctx.Value("local-channels").(*localChannels)
+	if !ok {
+		return
+	}
+
+	local.mu.Lock()
+	channels := make([]gossh.Channel, 0, len(local.channels))
+	for channel := range local.channels {
+		channels = append(channels, channel)
+	}
+	local.mu.Unlock()
+
+	for _, channel := range channels {
+		fmt.Fprintf(channel.Stderr(), "\r\n%s\r\n", message) //nolint:errcheck
+	}
+}
@@ -0,0 +1,215 @@
+package session
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// PoolKey identifies the connections to the agents that can be shared: the ones to the same device and gateway,
+// authenticated as the same user with the same credential, so a client connection never reuses a connection
+// authenticated with a credential it did not present.
+func PoolKey(device, gateway, username string, auth Auth) string {
+	return strings.Join([]string{target.DeviceUID(device), gateway, username, auth.Fingerprint()}, "\x00")
+}
+
+// Pool keeps the connections authenticated on the agents while client connections use them, and for an idle window
+// after the last one is done, so new client connections with the same [PoolKey] open their channels on them instead
+// of doing a new SSH handshake with the agent.
+//
+// A nil Pool is valid and means the connections to the agents are not pooled.
+type Pool struct {
+	idle time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*pooled
+}
+
+type pooled struct {
+	client *gossh.Client
+	// leases is the number of client connections using the client; when it drops to zero, timer closes the client
+	// after the idle window.
+	leases int
+	timer  *time.Timer
+}
+
+// NewPool creates a new [Pool] that closes the connections after idle without client connections using them.
+func NewPool(idle time.Duration) *Pool {
+	return &Pool{
+		idle:    idle,
+		clients: make(map[string]*pooled),
+	}
+}
+
+// Acquire leases the connection kept under key until it is released. It returns nil when there is none.
+func (p *Pool) Acquire(key string) *gossh.Client {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.clients[key]
+	if !ok {
+		return nil
+	}
+
+	if entry.timer != nil {
+		entry.timer.Stop()
+		entry.timer = nil
+	}
+
+	entry.leases++
+
+	return entry.client
+}
+
+// Add keeps the client under key, leased once, until it is closed by the agent or idle. It returns false when
+// another client is already kept under key, like when two client connections authenticated at the same time; the
+// client is not pooled then.
+func (p *Pool) Add(key string, client *gossh.Client) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.clients[key]; ok {
+		return false
+	}
+
+	entry := &pooled{client: client, leases: 1}
+	p.clients[key] = entry
+
+	go func() {
+		client.Wait() //nolint:errcheck
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.clients[key] == entry {
+			delete(p.clients, key)
+		}
+	}()
+
+	return true
+}
+
+// Release returns a lease of the connection kept under key. The connection is closed after the idle window without
+// leases.
+func (p *Pool) Release(key string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.clients[key]
+	if !ok || entry.leases == 0 {
+		return
+	}
+
+	if entry.leases--; entry.leases > 0 {
+		return
+	}
+
+	entry.timer = time.AfterFunc(p.idle, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		// NOTE: The connection may have been acquired again after the timer fired, but before it took the lock.
+		if p.clients[key] != entry || entry.leases > 0 {
+			return
+		}
+
+		delete(p.clients, key)
+
+		entry.client.Close() //nolint:errcheck
+	})
+}
+
+// Len returns the number of connections kept by the pool.
+func (p *Pool) Len() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.clients)
+}
+
+// SetPool sets the pool of the connections to the agents used by the sessions of the connection associated with the
+// provided context.
+func SetPool(ctx gliderssh.Context, pool *Pool) {
+	ctx.SetValue("pool", pool)
+}
+
+// GetPool gets the pool stored by [SetPool], or nil when connections are not pooled.
+func GetPool(ctx gliderssh.Context) *Pool {
+	pool, _ := ctx.Value("pool").(*Pool)
+
+	return pool
+}
+
+// acquire leases a pooled connection to the agent for the session, releasing it when the client connection is done.
+// It returns false when the pool has no connection for the session.
+func (s *Session) acquire(ctx gliderssh.Context, pool *Pool, key string) bool {
+	client := pool.Acquire(key)
+	if client == nil {
+		return false
+	}
+
+	s.Agent.Client = client
+	s.lease(ctx, pool, key)
+
+	log.WithFields(log.Fields{
+		"uid":    s.UID,
+		"sshid":  s.SSHID,
+		"device": s.Device.UID,
+	}).Info("reusing a pooled connection to the agent")
+
+	return true
+}
+
+// lease keeps the pooled connection of the session until the client connection is done.
+func (s *Session) lease(ctx gliderssh.Context, pool *Pool, key string) {
+	s.mu.Lock()
+	s.pool, s.pooled = pool, key
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.release()
+	}()
+}
+
+// release returns the lease of the session's pooled connection, once.
+func (s *Session) release() {
+	s.mu.Lock()
+	pool, key := s.pool, s.pooled
+	s.pooled = ""
+	s.mu.Unlock()
+
+	if key != "" {
+		pool.Release(key)
+	}
+}
+
+// Pooled checks if the session's connection to the agent is kept in the pool, shared with other client connections.
+func (s *Session) Pooled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pool != nil
+}
@@ -0,0 +1,174 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// publicKey returns a new public key.
+func publicKey(t *testing.T) gossh.PublicKey {
+	t.Helper()
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestPoolKey(t *testing.T) {
+	key := publicKey(t)
+
+	cases := []struct {
+		description string
+		first       string
+		second      string
+		equal       bool
+	}{
+		{
+			description: "succeeds when device has the default tenant or none",
+			first:       PoolKey("DEVICE123", "", "root", AuthPassword("secret")),
+			second:      PoolKey("default:DEVICE123", "", "root", AuthPassword("secret")),
+			equal:       true,
+		},
+		{
+			description: "fails when credentials differ",
+			first:       PoolKey("DEVICE123", "", "root", AuthPassword("secret")),
+			second:      PoolKey("DEVICE123", "", "root", AuthPassword("other")),
+			equal:       false,
+		},
+		{
+			description: "succeeds when the public keys are the same",
+			first:       PoolKey("DEVICE123", "", "root", AuthPublicKey(key)),
+			second:      PoolKey("DEVICE123", "", "root", AuthPublicKey(key)),
+			equal:       true,
+		},
+		{
+			description: "fails when the public keys differ",
+			first:       PoolKey("DEVICE123", "", "root", AuthPublicKey(key)),
+			second:      PoolKey("DEVICE123", "", "root", AuthPublicKey(publicKey(t))),
+			equal:       false,
+		},
+		{
+			description: "fails when users differ",
+			first:       PoolKey("DEVICE123", "", "root", AuthPassword("secret")),
+			second:      PoolKey("DEVICE123", "", "admin", AuthPassword("secret")),
+			equal:       false,
+		},
+		{
+			description: "fails when gateways differ",
+			first:       PoolKey("DEVICE123", "", "root", AuthPassword("secret")),
+			second:      PoolKey("DEVICE123", "10.0.0.5:22", "root", AuthPassword("secret")),
+			equal:       false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.equal, tc.first == tc.second)
+		})
+	}
+}
+
+// client connects a new SSH client to a server that accepts it without authentication.
+func client(t *testing.T) *gossh.Client {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// NOTE: The connection is on the loopback, as both sides send their version first, blocking on a [net.Pipe].
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		conn, chans, reqs, err := gossh.NewServerConn(server, config)
+		if err != nil {
+			return
+		}
+
+		go gossh.DiscardRequests(reqs)
+		go func() {
+			for newChannel := range chans {
+				newChannel.Reject(gossh.Prohibited, "") //nolint:errcheck
+			}
+		}()
+
+		conn.Wait() //nolint:errcheck
+	}()
+
+	local, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	conn, chans, reqs, err := gossh.NewClientConn(local, listener.Addr().String(), &gossh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	})
+	require.NoError(t, err)
+
+	return gossh.NewClient(conn, chans, reqs)
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool(50 * time.Millisecond)
+
+	first := client(t)
+	require.True(t, pool.Add("key", first))
+
+	// NOTE: A client authenticated at the same time is not pooled.
+	second := client(t)
+	defer second.Close()
+	assert.False(t, pool.Add("key", second))
+
+	assert.Nil(t, pool.Acquire("other"))
+	assert.Same(t, first, pool.Acquire("key"))
+
+	pool.Release("key")
+	pool.Release("key")
+
+	// NOTE: Once idle, the connection is closed after the window.
+	assert.Equal(t, 1, pool.Len())
+	assert.Eventually(t, func() bool {
+		return pool.Len() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Error(t, first.Wait())
+
+	// NOTE: A connection closed by the agent leaves the pool.
+	third := client(t)
+	require.True(t, pool.Add("key", third))
+	third.Close()
+	assert.Eventually(t, func() bool {
+		return pool.Acquire("key") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNilPool(t *testing.T) {
+	var pool *Pool
+
+	assert.False(t, pool.Add("key", nil))
+	assert.Nil(t, pool.Acquire("key"))
+	assert.Equal(t, 0, pool.Len())
+
+	pool.Release("key")
+}
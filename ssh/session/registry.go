@@ -0,0 +1,134 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	gliderssh "github.com/gliderlabs/ssh"
+)
+
+// MaxRecordings is the number of finished recorded sessions kept by the [Registry].
+const MaxRecordings = 100
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Registry keeps track of the connections handled by the server, to list and kill their sessions, and of the recorded
+// sessions that have already finished.
+type Registry struct {
+	mu         sync.RWMutex
+	conns      map[gliderssh.Context]net.Conn
+	recordings []*Session
+}
+
+// NewRegistry creates a new empty [Registry].
+func NewRegistry() *Registry {
+	return &Registry{
+		conns:      make(map[gliderssh.Context]net.Conn),
+		recordings: make([]*Session, 0),
+	}
+}
+
+// Add adds a new connection to the registry. The connection is removed when its context is done.
+func (r *Registry) Add(ctx gliderssh.Context, conn net.Conn) {
+	r.mu.Lock()
+	r.conns[ctx] = conn
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.conns, ctx)
+
+		if sess, state := ObtainSession(ctx); state >= StateFinished && sess.IsRecorded() {
+			r.recordings = append(r.recordings, sess)
+			if len(r.recordings) > MaxRecordings {
+				r.recordings = r.recordings[len(r.recordings)-MaxRecordings:]
+			}
+		}
+	}()
+}
+
+// Sessions lists the sessions already authenticated on the device, and the ones of the jump host connections.
+func (r *Registry) Sessions() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(r.conns))
+	for ctx := range r.conns {
+		if sess, state := ObtainSession(ctx); state >= StateFinished {
+			sessions = append(sessions, sess)
+		}
+
+		sessions = append(sessions, getHops(ctx)...)
+	}
+
+	return sessions
+}
+
+// Kill closes the connection of the session, finishing it. A jump host connection only has its stream to the device
+// closed, keeping the client's other ones.
+func (r *Registry) Kill(uid string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for ctx, conn := range r.conns {
+		if sess, state := ObtainSession(ctx); state >= StateFinished && sess.UID == uid {
+			return conn.Close()
+		}
+
+		for _, hop := range getHops(ctx) {
+			if hop.UID == uid {
+				return hop.Agent.Conn.Close()
+			}
+		}
+	}
+
+	return ErrSessionNotFound
+}
+
+// Notify writes the message to the users of the sessions already authenticated on the device, and to the ones on the
+// device picker or the admin shell. It does not wait for the clients to read it.
+func (r *Registry) Notify(message string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for ctx := range r.conns {
+		if sess, state := ObtainSession(ctx); state >= StateFinished {
+			go sess.Notify(message)
+		} else {
+			go notifyLocal(ctx, message)
+		}
+	}
+}
+
+// Recordings lists the recorded sessions, both active and finished.
+func (r *Registry) Recordings() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	recordings := make([]*Session, 0, len(r.recordings))
+	recordings = append(recordings, r.recordings...)
+
+	for ctx := range r.conns {
+		if sess, state := ObtainSession(ctx); state >= StateFinished && sess.IsRecorded() {
+			recordings = append(recordings, sess)
+		}
+	}
+
+	return recordings
+}
+
+// Recording gets a recorded session by its UID.
+func (r *Registry) Recording(uid string) (*Session, error) {
+	for _, sess := range r.Recordings() {
+		if sess.UID == uid {
+			return sess, nil
+		}
+	}
+
+	return nil, ErrSessionNotFound
+}
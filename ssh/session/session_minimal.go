@@ -3,21 +3,30 @@ package session
 import (
     "errors"
     "fmt"
+    "io"
     "net"
     "net/http"
     "time"
-    "strings"
+    "sync"
+    "sync/atomic"
 
     gliderssh "github.com/gliderlabs/ssh"
+    "github.com/shellhub-io/mini-shellhub/ssh/audit"
+    "github.com/shellhub-io/mini-shellhub/ssh/concurrency"
+    "github.com/shellhub-io/mini-shellhub/pkg/tracing"
+    "github.com/shellhub-io/mini-shellhub/ssh/metrics"
     "github.com/shellhub-io/shellhub/pkg/models"
     "github.com/shellhub-io/mini-shellhub/ssh/pkg/host"
     "github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+    "go.opentelemetry.io/otel/attribute"
     gossh "golang.org/x/crypto/ssh"
 )
 
 // Tunnel interface for different tunnel implementations
 type Tunnel interface {
 	Dial(target string) (net.Conn, error)
+	// Connect connects to addr through the target, like the gateway of the session.
+	Connect(target, addr string) (net.Conn, error)
 }
 
 // Data holds minimal metadata used by channel handlers and logging.
@@ -27,6 +36,9 @@ type Data struct {
     Device    *models.Device
     Namespace *models.Namespace
     IPAddress string
+    // Gateway is the address of the host reached through the device, on the device's network, when the SSHID is like
+    // "user@device/10.0.0.5:22" or "user@device+switch01"; empty when the SSHID is the device itself.
+    Gateway   string
     Type      string
     Term      string
     Handled   bool
@@ -74,13 +86,44 @@ func (s *Seats) Get(int) (*Seat, bool) { return &Seat{}, true }
 // helper to clear deadlines when needed
 func clearReadDeadline(c net.Conn) error { return c.SetReadDeadline(time.Time{}) }
 
+// MaxRecordingSize is the maximum number of output bytes recorded per session. Output beyond it is not recorded.
+const MaxRecordingSize = 1 << 20
+
+// Frame is a chunk of the pty output of a recorded session.
+type Frame struct {
+    Time   time.Time `json:"time"`
+    Seat   int       `json:"seat"`
+    Output string    `json:"output"`
+}
+
 // Session is a minimal session used only to bridge SSH client <-> agent.
 type Session struct {
-    UID    string
-    Agent  *Agent
-    Client *Client
+    UID       string
+    Agent     *Agent
+    Client    *Client
+    StartedAt time.Time
 
     tunnel Tunnel
+    // timeout bounds the stream open, the SSH handshake and the channel opens on the agent; zero waits indefinitely.
+    timeout time.Duration
+    // limiter caps the connections and channels to the device.
+    limiter *concurrency.Limiter
+    // admitted releases the connection held on the limiter, and seats the channels held by seat.
+    admitted func()
+    seats    map[int]func()
+
+    mu       sync.Mutex
+    recorded bool
+    // pool is the pool of the session's connection to the agent, and pooled its key until the lease is released.
+    pool     *Pool
+    pooled   string
+    size     int
+    frames   []Frame
+    // activity is when data last flowed through the session's channels, in Unix nanoseconds, and interactive whether
+    // the client requested a pty; both are used by [Session.Watch].
+    activity    atomic.Int64
+    interactive atomic.Bool
+    watch       sync.Once
 
     Seats Seats
     Data  // embed to promote fields (SSHID, Device, Target, IPAddress, Type, ...)
@@ -88,8 +131,12 @@ type Session struct {
 
 // NewSession creates a new minimal session without API or cache.
 func NewSession(ctx gliderssh.Context, tunnel Tunnel) (*Session, error) {
-    sshid := ctx.User()
+    return NewSessionWithSSHID(ctx, tunnel, ctx.User())
+}
 
+// NewSessionWithSSHID creates a new minimal session to the provided SSHID instead of the one used as the connection's
+// user. It is used when the destination device is only known after the client has authenticated.
+func NewSessionWithSSHID(ctx gliderssh.Context, tunnel Tunnel, sshid string) (*Session, error) {
     hos, err := host.NewHost(ctx.RemoteAddr().String())
     if err != nil {
         return nil, ErrHost
@@ -103,9 +150,24 @@ func NewSession(ctx gliderssh.Context, tunnel Tunnel) (*Session, error) {
     // In minimal mode, treat target.Data as device ID directly.
     deviceID := tgt.Data
 
+    var gateway string
+    if tgt.IsGateway() {
+        deviceID, gateway, err = tgt.SplitGateway()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // NOTE: The device ID is either `tenant:device` or `device`, when the tenant is the default one.
+    tenant, name := target.SplitDevice(deviceID)
+
     sess := &Session{
         UID:    ctx.SessionID(),
+        StartedAt: time.Now(),
         tunnel: tunnel,
+        timeout: GetConnectTimeout(ctx),
+        limiter: GetConcurrency(ctx),
+        seats:   make(map[int]func()),
         Agent:  &Agent{Channels: make(map[int]*AgentChannel)},
         Client: &Client{Channels: make(map[int]*ClientChannel)},
         Seats:  NewSeats(),
@@ -113,9 +175,11 @@ func NewSession(ctx gliderssh.Context, tunnel Tunnel) (*Session, error) {
             SSHID:    sshid,
             Target:   tgt,
             IPAddress: hos.Host,
+            Gateway:   gateway,
             Device: &models.Device{
-                UID:  deviceID,
-                Name: deviceID,
+                UID:      deviceID,
+                Name:     name,
+                TenantID: tenant,
                 Info: &models.DeviceInfo{Version: "v0.9.3"},
             },
             Namespace: &models.Namespace{},
@@ -128,14 +192,25 @@ func NewSession(ctx gliderssh.Context, tunnel Tunnel) (*Session, error) {
     return sess, nil
 }
 
-// Dial establishes a yamux stream connection to the agent using the device ID.
-func (s *Session) Dial(ctx gliderssh.Context) error {
-    id := s.Data.Device.UID
-    if !strings.Contains(id, ":") {
-        id = "default:" + id
-    }
+// Dial establishes a yamux stream connection to the agent using the device ID. When the session has a gateway, the
+// agent connects the stream to the host on its network, and the SSH handshake happens with that host instead.
+func (s *Session) Dial(ctx gliderssh.Context) (err error) {
+    id := target.DeviceUID(s.Data.Device.UID)
+    traced, span := StartSpan(ctx, "session.dial", attribute.String("device", id), attribute.String("gateway", s.Data.Gateway))
+    defer func() { EndSpan(span, err) }()
+
     ctx.Lock()
-    conn, err := s.tunnel.Dial(id)
+    _, open := tracer.Start(traced, "yamux.open")
+    started := time.Now()
+    // NOTE: The tunnel gives up on the devices that don't answer within the connect timeout.
+    var conn net.Conn
+    if s.Data.Gateway != "" {
+        conn, err = s.tunnel.Connect(id, s.Data.Gateway)
+    } else {
+        conn, err = s.tunnel.Dial(id)
+    }
+    metrics.Dialed(started, err)
+    EndSpan(open, err)
     if err != nil {
         ctx.Unlock()
         return errors.Join(ErrDial, err)
@@ -145,36 +220,87 @@ func (s *Session) Dial(ctx gliderssh.Context) error {
     return nil
 }
 
-// Evaluate does nothing in minimal mode.
+// DeviceKey is the session's device as "tenant:name", like "default:DEVICE123", the way the firewall, the limits, the
+// caps and the audit events refer to it.
+func (s *Session) DeviceKey() string {
+    return s.Data.Device.TenantID + ":" + s.Data.Device.Name
+}
+
+// Evaluate checks the session against the connection's firewall, allowing it when no rule denies it.
 func (s *Session) Evaluate(ctx gliderssh.Context) error {
+    if rule, allowed := GetFirewall(ctx).Evaluate(s.Data.IPAddress, s.Data.Target.Username, s.DeviceKey()); !metrics.Decided(rule, allowed) {
+        return fmt.Errorf("%w: %s", ErrFirewallBlock, rule)
+    }
+
     snap := getSnapshot(ctx)
     snap.save(s, StateEvaluated)
     return nil
 }
 
 // Auth authenticates to the agent using the provided method and wires a client.
-func (s *Session) Auth(ctx gliderssh.Context, auth Auth) error {
+func (s *Session) Auth(ctx gliderssh.Context, auth Auth) (err error) {
+    traced, span := StartSpan(ctx, "session.auth")
+    defer func() { EndSpan(span, err) }()
+
     snap := getSnapshot(ctx)
     sess, state := snap.retrieve()
     if state != StateEvaluated && state != StateRegistered {
         return errors.New("invalid session state")
     }
+    span.SetAttributes(attribute.String("device", sess.Data.Device.UID), attribute.String("username", sess.Data.Target.Username))
+    // NOTE: The client's version carries the trace context, so the agent's spans of the connection join its trace.
+    // The agent's host key is checked once the key exchange is done, right before the authentication, so a handshake
+    // failing after it, other than by the connection, failed because the agent refused the credential.
+    var exchanged atomic.Bool
     cfg := &gossh.ClientConfig{
-        User:            sess.Data.Target.Username,
-        HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+        User: sess.Data.Target.Username,
+        HostKeyCallback: func(string, net.Addr, gossh.PublicKey) error {
+            exchanged.Store(true)
+            return nil
+        },
+        ClientVersion: tracing.Version(traced, "SSH-2.0-Go"),
     }
     if err := auth.Auth()(sess, cfg); err != nil {
         return err
     }
+
+    // NOTE: A pooled connection authenticated with the same credential replaces the handshake; the stream dialed to
+    // check the device is online is not needed then.
+    pool := GetPool(ctx)
+    key := PoolKey(sess.Data.Device.UID, sess.Data.Gateway, sess.Data.Target.Username, auth)
+    if sess.acquire(ctx, pool, key) {
+        span.SetAttributes(attribute.Bool("pooled", true))
+
+        if sess.Agent.Conn != nil {
+            sess.Agent.Conn.Close()
+            sess.Agent.Conn = nil
+        }
+
+        snap.save(sess, StateFinished)
+        sess.Watch(ctx)
+        return nil
+    }
+
     if sess.Agent.Conn == nil {
         if err := sess.Dial(ctx); err != nil {
             return err
         }
     }
-    conn, chans, reqs, err := gossh.NewClientConn(sess.Agent.Conn, "tcp", cfg)
+    var conn gossh.Conn
+    var chans <-chan gossh.NewChannel
+    var reqs <-chan *gossh.Request
+    err = withDeadline(sess.Agent.Conn, sess.timeout, metrics.StageHandshake, func() (err error) {
+        conn, chans, reqs, err = gossh.NewClientConn(sess.Agent.Conn, "tcp", cfg)
+        return err
+    })
     if err != nil {
         // reset so future attempts can redial
         sess.Agent.Conn = nil
+        // NOTE: The agent's authenticator refusing the credential is told apart from the other failures, like
+        // timeouts, to count it against brute force.
+        if exchanged.Load() && !connectionError(err) {
+            return errors.Join(ErrAuthRejected, err)
+        }
         return err
     }
     ch := make(chan *gossh.Request)
@@ -182,10 +308,30 @@ func (s *Session) Auth(ctx gliderssh.Context, auth Auth) error {
     sess.Agent.Client = gossh.NewClient(conn, chans, ch)
     sess.Agent.Requests = reqs
 
+    // NOTE: The global requests of a pooled connection, like the agent's keepalives, belong to no client connection,
+    // so they are discarded instead of relayed.
+    if pool.Add(key, sess.Agent.Client) {
+        sess.Agent.Requests = nil
+        go gossh.DiscardRequests(reqs)
+
+        sess.lease(ctx, pool, key)
+    }
+
+    // NOTE: The limits are watched from the authentication on, so connections without session channels, like
+    // `ssh -N -L`, reach their maximum duration too.
     snap.save(sess, StateFinished)
+    sess.Watch(ctx)
     return nil
 }
 
+// connectionError checks if the error comes from the connection to the agent, like a timeout or the connection being
+// closed, instead of the SSH protocol.
+func connectionError(err error) bool {
+    var netErr net.Error
+
+    return errors.Is(err, ErrTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
 // NewClientChannel accepts a new channel from a client and set a seat for it.
 func (s *Session) NewClientChannel(newChannel gossh.NewChannel, seat int) (*ClientChannel, error) {
     if _, ok := s.Client.Channels[seat]; ok {
@@ -195,11 +341,37 @@ func (s *Session) NewClientChannel(newChannel gossh.NewChannel, seat int) (*Clie
     if err != nil {
         return nil, err
     }
+    return s.AttachClientChannel(channel, requests, seat)
+}
+
+// AttachClientChannel sets a seat for a channel already accepted from the client, like the one used by the device
+// picker before the session existed.
+func (s *Session) AttachClientChannel(channel gossh.Channel, requests <-chan *gossh.Request, seat int) (*ClientChannel, error) {
+    if _, ok := s.Client.Channels[seat]; ok {
+        return nil, ErrSeatAlreadySet
+    }
     c := &ClientChannel{Channel: channel, Requests: requests}
+    s.mu.Lock()
     s.Client.Channels[seat] = c
+    s.mu.Unlock()
     return c, nil
 }
 
+// Notify writes the message on the standard error of the client's channels, like to warn the user that the server is
+// shutting down.
+func (s *Session) Notify(message string) {
+    s.mu.Lock()
+    channels := make([]*ClientChannel, 0, len(s.Client.Channels))
+    for _, channel := range s.Client.Channels {
+        channels = append(channels, channel)
+    }
+    s.mu.Unlock()
+
+    for _, channel := range channels {
+        fmt.Fprintf(channel.Channel.Stderr(), "\r\n%s\r\n", message) //nolint:errcheck
+    }
+}
+
 // NewAgentChannel opens a new channel to agent and set a seat for it.
 func (s *Session) NewAgentChannel(name string, seat int) (*AgentChannel, error) {
     if _, ok := s.Agent.Channels[seat]; ok {
@@ -208,7 +380,12 @@ func (s *Session) NewAgentChannel(name string, seat int) (*AgentChannel, error)
     if s.Agent == nil || s.Agent.Client == nil {
         return nil, errors.New("agent client not established")
     }
-    channel, requests, err := s.Agent.Client.OpenChannel(name, nil)
+    // NOTE: A pooled connection is shared with other sessions, whose channels would fail too on a deadline on its stream.
+    conn := s.Agent.Conn
+    if s.Pooled() {
+        conn = nil
+    }
+    channel, requests, err := openChannel(s.Agent.Client, conn, name, s.timeout)
     if err != nil {
         return nil, err
     }
@@ -217,17 +394,99 @@ func (s *Session) NewAgentChannel(name string, seat int) (*AgentChannel, error)
     return a, nil
 }
 
-// NewSeat delegates to Seats.NewSeat for channel handlers compatibility.
-func (s *Session) NewSeat() (int, error) { return s.Seats.NewSeat() }
+// NewSeat holds a channel of the device on the limiter and delegates to Seats.NewSeat for channel handlers
+// compatibility. The channel is released by [Session.LeaveSeat].
+func (s *Session) NewSeat(ctx gliderssh.Context) (int, error) {
+    release, err := s.limiter.Acquire(concurrency.Channels, s.owner())
+    if err != nil {
+        s.reject(ctx, audit.TypeChannelRejected, err)
+
+        return 0, errors.Join(ErrChannelLimit, err)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    seat, err := s.Seats.NewSeat()
+    if err != nil {
+        release()
+
+        return 0, err
+    }
+
+    s.seats[seat] = release
+
+    return seat, nil
+}
+
+// LeaveSeat releases the channel of the seat held on the limiter.
+func (s *Session) LeaveSeat(seat int) {
+    s.mu.Lock()
+    release, ok := s.seats[seat]
+    delete(s.seats, seat)
+    s.mu.Unlock()
+
+    if ok {
+        release()
+    }
+}
 
 // KeepAlive is a no-op in minimal mode.
 func (s *Session) KeepAlive() error { return nil }
 
-// Event is a no-op in minimal mode.
-func (s *Session) Event(string, any, int) {}
+// PtyOutputEventType is the event's type for a pty output, the only event kept in minimal mode.
+const PtyOutputEventType = "pty-output"
 
-// Recorded is a no-op in minimal mode.
-func (s *Session) Recorded(int) error { return nil }
+// Event records the pty output of recorded sessions; other events are ignored in minimal mode.
+func (s *Session) Event(t string, data any, seat int) {
+    if t != PtyOutputEventType {
+        return
+    }
+
+    output, ok := data.(*models.SSHPtyOutput)
+    if !ok {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !s.recorded || s.size+len(output.Output) > MaxRecordingSize {
+        return
+    }
+
+    s.size += len(output.Output)
+    s.frames = append(s.frames, Frame{Time: time.Now(), Seat: seat, Output: output.Output})
+}
+
+// Recorded sets the session as recorded, keeping its pty output in memory.
+func (s *Session) Recorded(int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.recorded = true
+
+    return nil
+}
+
+// IsRecorded checks if the session is recorded.
+func (s *Session) IsRecorded() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.recorded
+}
+
+// Frames returns the recorded pty output of the session.
+func (s *Session) Frames() []Frame {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    frames := make([]Frame, len(s.frames))
+    copy(frames, s.frames)
+
+    return frames
+}
 
 // Event is a generic free function used by channel handlers; no-op here.
 func Event[D any](_ *Session, _ string, _ []byte, _ int) {}
@@ -236,7 +495,13 @@ func Event[D any](_ *Session, _ string, _ []byte, _ int) {}
 func (s *Session) Announce(gossh.Channel) error { return nil }
 
 // Finish closes server->agent side politely.
+//
+// A pooled connection is kept for other client connections, so only the session's lease is released.
 func (s *Session) Finish() error {
+    if s.Pooled() {
+        s.release()
+        return nil
+    }
     if s.Agent != nil && s.Agent.Conn != nil {
         req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/ssh/close/%s", s.UID), nil)
         _ = req.Write(s.Agent.Conn)
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"github.com/shellhub-io/mini-shellhub/ssh/metrics"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrTimeout is returned when the device doesn't answer within the connect timeout.
+var ErrTimeout = proxy.ErrTimeout
+
+// SetConnectTimeout sets how long the sessions of the connection associated with the provided context wait for the
+// device to complete the SSH handshake and to open a channel; the tunnel bounds the opening of the stream itself.
+func SetConnectTimeout(ctx gliderssh.Context, timeout time.Duration) {
+	ctx.SetValue("connect-timeout", timeout)
+}
+
+// GetConnectTimeout gets the timeout stored by [SetConnectTimeout], or zero when the sessions wait indefinitely.
+func GetConnectTimeout(ctx gliderssh.Context) time.Duration {
+	timeout, _ := ctx.Value("connect-timeout").(time.Duration)
+
+	return timeout
+}
+
+// withDeadline runs fn with a deadline on the connection after the timeout, when set, clearing it once fn is done. When
+// fn fails after the deadline, the timeout is counted on the stage and [ErrTimeout] is returned with its error.
+func withDeadline(conn net.Conn, timeout time.Duration, stage string, fn func() error) error {
+	if conn == nil || timeout <= 0 {
+		return fn()
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fn()
+	}
+
+	err := fn()
+	if err != nil && !time.Now().Before(deadline) {
+		metrics.Timeouts.WithLabelValues(stage).Inc()
+
+		return errors.Join(ErrTimeout, err)
+	}
+
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	return err
+}
+
+// openChannel opens a channel on the agent's connection, giving up after the timeout, when set. The session's own
+// connection gets a deadline on its stream, conn; a pooled connection, shared with other sessions, is passed without
+// one, and a channel opened on it after the timeout is closed instead.
+func openChannel(client *gossh.Client, conn net.Conn, name string, timeout time.Duration) (gossh.Channel, <-chan *gossh.Request, error) {
+	if conn != nil || timeout <= 0 {
+		var channel gossh.Channel
+		var requests <-chan *gossh.Request
+		err := withDeadline(conn, timeout, metrics.StageChannel, func() (err error) {
+			channel, requests, err = client.OpenChannel(name, nil)
+
+			return err
+		})
+
+		return channel, requests, err
+	}
+
+	type result struct {
+		channel  gossh.Channel
+		requests <-chan *gossh.Request
+		err      error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		channel, requests, err := client.OpenChannel(name, nil)
+		done <- result{channel: channel, requests: requests, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.channel, r.requests, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.channel != nil {
+				r.channel.Close()
+			}
+		}()
+
+		metrics.Timeouts.WithLabelValues(metrics.StageChannel).Inc()
+
+		return nil, nil, ErrTimeout
+	}
+}
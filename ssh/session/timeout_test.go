@@ -0,0 +1,194 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestWithDeadline(t *testing.T) {
+	cases := []struct {
+		description string
+		answer      bool
+		expected    error
+	}{
+		{
+			description: "succeeds when peer answers before the deadline",
+			answer:      true,
+			expected:    nil,
+		},
+		{
+			description: "fails when peer does not answer",
+			answer:      false,
+			expected:    ErrTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer local.Close()
+			defer remote.Close()
+
+			if tc.answer {
+				go remote.Write([]byte("x")) //nolint:errcheck
+			}
+
+			err := withDeadline(local, 50*time.Millisecond, "test", func() error {
+				_, err := local.Read(make([]byte, 1))
+
+				return err
+			})
+			assert.ErrorIs(t, err, tc.expected)
+
+			if tc.expected == nil {
+				// NOTE: The deadline is cleared, so later reads wait for the peer.
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					remote.Write([]byte("y")) //nolint:errcheck
+				}()
+
+				_, err := local.Read(make([]byte, 1))
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWithDeadlineKeepsOtherErrors(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	failure := errors.New("refused")
+	err := withDeadline(local, time.Second, "test", func() error { return failure })
+
+	assert.ErrorIs(t, err, failure)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
+
+// stalled connects a new SSH client to a server that answers the channel opens only once answer is closed.
+func stalled(t *testing.T, answer <-chan struct{}) (*gossh.Client, net.Conn) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		conn, chans, reqs, err := gossh.NewServerConn(server, config)
+		if err != nil {
+			return
+		}
+
+		go gossh.DiscardRequests(reqs)
+		go func() {
+			<-answer
+
+			for newChannel := range chans {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+
+				go gossh.DiscardRequests(requests)
+				go io.Copy(io.Discard, channel) //nolint:errcheck
+			}
+		}()
+
+		conn.Wait() //nolint:errcheck
+	}()
+
+	local, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	conn, chans, reqs, err := gossh.NewClientConn(local, listener.Addr().String(), &gossh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	})
+	require.NoError(t, err)
+
+	client := gossh.NewClient(conn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+
+	return client, local
+}
+
+func TestOpenChannel(t *testing.T) {
+	cases := []struct {
+		description string
+		pooled      bool
+		answer      bool
+		expected    error
+	}{
+		{
+			description: "succeeds when agent answers before the timeout",
+			pooled:      false,
+			answer:      true,
+			expected:    nil,
+		},
+		{
+			description: "fails when agent does not answer on the session's connection",
+			pooled:      false,
+			answer:      false,
+			expected:    ErrTimeout,
+		},
+		{
+			description: "succeeds when agent answers before the timeout on a pooled connection",
+			pooled:      true,
+			answer:      true,
+			expected:    nil,
+		},
+		{
+			description: "fails when agent does not answer on a pooled connection",
+			pooled:      true,
+			answer:      false,
+			expected:    ErrTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			answer := make(chan struct{})
+			if tc.answer {
+				close(answer)
+			} else {
+				defer close(answer)
+			}
+
+			client, conn := stalled(t, answer)
+			if tc.pooled {
+				conn = nil
+			}
+
+			channel, _, err := openChannel(client, conn, "session", 100*time.Millisecond)
+			assert.ErrorIs(t, err, tc.expected)
+
+			if tc.expected == nil {
+				require.NoError(t, channel.Close())
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package session
+
+import (
+	"context"
+	"net"
+
+	gliderssh "github.com/gliderlabs/ssh"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of the server's connections.
+var tracer = otel.Tracer("github.com/shellhub-io/mini-shellhub/ssh")
+
+// Trace starts the span of the connection associated with the provided context, ended when the connection is closed.
+// The spans of the connection's stages, started by [StartSpan], are its children. The peer's address comes from conn, as
+// the connection's metadata is not set on the context yet when the connection is accepted.
+func Trace(ctx gliderssh.Context, conn net.Conn) {
+	_, span := tracer.Start(context.Background(), "ssh.connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("net.peer.address", conn.RemoteAddr().String())),
+	)
+
+	ctx.SetValue("span", span)
+
+	go func() {
+		<-ctx.Done()
+
+		span.End()
+	}()
+}
+
+// GetSpan gets the span of the connection started by [Trace], or a span that records nothing when none was started.
+func GetSpan(ctx gliderssh.Context) trace.Span {
+	if span, ok := ctx.Value("span").(trace.Span); ok {
+		return span
+	}
+
+	return trace.SpanFromContext(context.Background())
+}
+
+// StartSpan starts the span of a stage of the connection associated with the provided context, as a child of its
+// span. The returned context starts the children of the stage's span.
+func StartSpan(ctx gliderssh.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(trace.ContextWithSpan(context.Background(), GetSpan(ctx)), name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span, recording the error when the stage failed.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
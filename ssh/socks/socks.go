@@ -0,0 +1,333 @@
+// Package socks implements a SOCKS5 proxy on the server to the addresses reachable from the devices, for browsers and
+// scripts that speak SOCKS without an SSH session.
+//
+// The destination of a CONNECT request encodes the device on its host name, as "<host>.<device>.<domain>", like
+// "10.0.0.1.device123.shellhub" with the default [Domain]. The device is its name, when unique, or its UID, and the host
+// is an IP address or a name resolved by the device.
+//
+// Clients authenticate with the username and password of a server user (RFC 1929), who can only reach the devices
+// they can access. Each connection reaches the destination through the tunnel's Connect.
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/relay"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	log "github.com/sirupsen/logrus"
+)
+
+// Domain is the default domain of the destinations' host names.
+const Domain = "shellhub"
+
+// HandshakeTimeout bounds the handshake of a client, from its greeting to the reply of its request, including the
+// connection to the destination through the device.
+const HandshakeTimeout = time.Minute
+
+const (
+	version             = 0x05
+	versionUserPassword = 0x01
+
+	methodUserPassword = 0x02
+	methodNoAcceptable = 0xff
+
+	commandConnect = 0x01
+
+	addressIPv4   = 0x01
+	addressDomain = 0x03
+	addressIPv6   = 0x04
+)
+
+// Replies to a request, from RFC 1928.
+const (
+	replySucceeded           = 0x00
+	replyFailure             = 0x01
+	replyNotAllowed          = 0x02
+	replyHostUnreachable     = 0x04
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+)
+
+var (
+	ErrInvalidAddress     = errors.New("destination does not address a device; use \"<host>.<device>.<domain>\"")
+	ErrInvalidVersion     = errors.New("invalid SOCKS version")
+	ErrMethodNotSupported = errors.New("client does not support username and password authentication")
+)
+
+// ParseAddress parses the host name of a destination in the form "<host>.<device>.<domain>", returning the device and
+// the host. The domain is matched regardless of case.
+func ParseAddress(name, domain string) (string, string, error) {
+	suffix := "." + strings.Trim(domain, ".")
+	if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", "", ErrInvalidAddress
+	}
+
+	rest := name[:len(name)-len(suffix)]
+
+	index := strings.LastIndex(rest, ".")
+	if index <= 0 || index == len(rest)-1 {
+		return "", "", ErrInvalidAddress
+	}
+
+	// NOTE: The host keeps its case, as the part of the name before the device.
+	return strings.ToLower(rest[index+1:]), rest[:index], nil
+}
+
+// Server is a SOCKS5 proxy to the devices.
+type Server struct {
+	tunnel proxy.Tunnel
+	store  *users.Store
+	guard  *users.Guard
+	domain string
+	allow  func(host string, port uint32) bool
+	// handshake bounds the handshake of the clients, [HandshakeTimeout].
+	handshake time.Duration
+}
+
+// New creates a new [Server], whose clients authenticate through the guard. The allow function is the forwarding
+// policy of the destinations, the same one of the local port forwarding; when nil, every destination is allowed. When
+// domain is empty, [Domain] is used.
+func New(tunnel proxy.Tunnel, store *users.Store, guard *users.Guard, domain string, allow func(host string, port uint32) bool) *Server {
+	if domain == "" {
+		domain = Domain
+	}
+
+	return &Server{tunnel: tunnel, store: store, guard: guard, domain: domain, allow: allow, handshake: HandshakeTimeout}
+}
+
+// ListenAndServe listens on the address and serves the SOCKS5 clients.
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"addr": address, "domain": s.domain}).Info("SOCKS5 proxy listening")
+
+	return s.Serve(listener)
+}
+
+// Serve serves the SOCKS5 clients accepted on the listener.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	logger := log.WithField("remote", conn.RemoteAddr().String())
+
+	// NOTE: A client that doesn't complete the handshake, like one connecting without sending anything, is dropped
+	// instead of holding the connection.
+	conn.SetDeadline(time.Now().Add(s.handshake)) //nolint:errcheck
+
+	reader := bufio.NewReader(conn)
+
+	user, err := s.authenticate(reader, conn)
+	if err != nil {
+		logger.WithError(err).Info("SOCKS5 client failed to authenticate")
+
+		return
+	}
+
+	logger = logger.WithField("user", user.Name)
+
+	host, port, reply, err := s.request(reader)
+	if err != nil {
+		logger.WithError(err).Info("SOCKS5 client sent an invalid request")
+		writeReply(conn, reply) //nolint:errcheck
+
+		return
+	}
+
+	name, dest, err := ParseAddress(host, s.domain)
+	if err != nil {
+		logger.WithError(err).WithField("host", host).Info("SOCKS5 request does not address a device")
+		writeReply(conn, replyHostUnreachable) //nolint:errcheck
+
+		return
+	}
+
+	logger = logger.WithFields(log.Fields{"device": name, "dest": dest, "port": port})
+
+	if s.allow != nil && !s.allow(dest, uint32(port)) {
+		logger.Info("SOCKS5 destination is not allowed")
+		writeReply(conn, replyNotAllowed) //nolint:errcheck
+
+		return
+	}
+
+	device, err := target.ResolveDevice(s.store.Accessible(user.Name, s.tunnel.Devices()), name)
+	if err != nil {
+		logger.WithError(err).Info("SOCKS5 proxy failed to resolve the device")
+		writeReply(conn, replyHostUnreachable) //nolint:errcheck
+
+		return
+	}
+
+	logger = logger.WithField("device", device.UID)
+
+	remote, err := s.tunnel.Connect(device.UID, net.JoinHostPort(dest, strconv.Itoa(int(port))))
+	if err != nil {
+		logger.WithError(err).Warn("SOCKS5 proxy failed to connect through the device")
+
+		var reply byte = replyHostUnreachable
+		if errors.Is(err, proxy.ErrRefused) {
+			reply = replyNotAllowed
+		}
+
+		writeReply(conn, reply) //nolint:errcheck
+
+		return
+	}
+
+	defer remote.Close()
+
+	if err := writeReply(conn, replySucceeded); err != nil {
+		return
+	}
+
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	logger.Debug("SOCKS5 connection started")
+
+	// NOTE: The reader keeps any data sent by the client right after the request.
+	relay.Pipe(relay.WithReader(conn, reader), remote)
+
+	logger.Debug("SOCKS5 connection done")
+}
+
+// authenticate negotiates the username and password method and checks the credentials against the users, through the
+// guard.
+func (s *Server) authenticate(reader *bufio.Reader, conn net.Conn) (*users.User, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != version {
+		return nil, ErrInvalidVersion
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, err
+	}
+
+	if bytes.IndexByte(methods, methodUserPassword) < 0 {
+		conn.Write([]byte{version, methodNoAcceptable}) //nolint:errcheck
+
+		return nil, ErrMethodNotSupported
+	}
+
+	if _, err := conn.Write([]byte{version, methodUserPassword}); err != nil {
+		return nil, err
+	}
+
+	if b, err := reader.ReadByte(); err != nil {
+		return nil, err
+	} else if b != versionUserPassword {
+		return nil, ErrInvalidVersion
+	}
+
+	name, err := readString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := readString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.guard.Authenticate(s.store, conn.RemoteAddr().String(), name, password)
+	if err != nil {
+		conn.Write([]byte{versionUserPassword, 0x01}) //nolint:errcheck
+
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{versionUserPassword, 0x00}); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// request reads a request, returning the destination's host and port, or the reply to the invalid request.
+func (s *Server) request(reader *bufio.Reader) (string, uint16, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", 0, replyFailure, err
+	}
+
+	if header[0] != version {
+		return "", 0, replyFailure, ErrInvalidVersion
+	}
+
+	if header[1] != commandConnect {
+		return "", 0, replyCommandNotSupported, errors.New("command not supported")
+	}
+
+	var host string
+	switch header[3] {
+	case addressDomain:
+		value, err := readString(reader)
+		if err != nil {
+			return "", 0, replyFailure, err
+		}
+
+		host = value
+	case addressIPv4, addressIPv6:
+		// NOTE: An IP address has no room for the device.
+		return "", 0, replyAddressNotSupported, ErrInvalidAddress
+	default:
+		return "", 0, replyAddressNotSupported, errors.New("address type not supported")
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", 0, replyFailure, err
+	}
+
+	return host, binary.BigEndian.Uint16(port), replySucceeded, nil
+}
+
+// readString reads a string prefixed by its length in a byte.
+func readString(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// writeReply writes the reply to a request, with an empty bound address.
+func writeReply(w io.Writer, reply byte) error {
+	_, err := w.Write([]byte{version, reply, 0x00, addressIPv4, 0, 0, 0, 0, 0, 0})
+
+	return err
+}
@@ -0,0 +1,349 @@
+package socks
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/shellhub-io/shellhub/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	type Expected struct {
+		device string
+		host   string
+		err    error
+	}
+
+	cases := []struct {
+		description string
+		name        string
+		domain      string
+		expected    Expected
+	}{
+		{
+			description: "succeeds when host is an IP address",
+			name:        "10.0.0.1.device123.shellhub",
+			domain:      "shellhub",
+			expected:    Expected{device: "device123", host: "10.0.0.1", err: nil},
+		},
+		{
+			description: "succeeds keeping the host's case when domain has mixed case",
+			name:        "Switch01.Device123.ShellHub",
+			domain:      "shellhub",
+			expected:    Expected{device: "device123", host: "Switch01", err: nil},
+		},
+		{
+			description: "succeeds keeping the host when lowering it would change its length",
+			name:        "İstanbul.device123.shellhub",
+			domain:      "shellhub",
+			expected:    Expected{device: "device123", host: "İstanbul", err: nil},
+		},
+		{
+			description: "succeeds when domain has many labels",
+			name:        "localhost.device123.tunnels.example",
+			domain:      "tunnels.example",
+			expected:    Expected{device: "device123", host: "localhost", err: nil},
+		},
+		{
+			description: "fails when host is missing",
+			name:        "device123.shellhub",
+			domain:      "shellhub",
+			expected:    Expected{device: "", host: "", err: ErrInvalidAddress},
+		},
+		{
+			description: "fails when name is of another domain",
+			name:        "10.0.0.1.device123.example",
+			domain:      "shellhub",
+			expected:    Expected{device: "", host: "", err: ErrInvalidAddress},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			device, host, err := ParseAddress(tc.name, tc.domain)
+			assert.Equal(t, tc.expected, Expected{device, host, err})
+		})
+	}
+}
+
+// tunnel is a fake reverse tunnel whose device answers the CONNECT requests with the status and echoes the data.
+type tunnel struct {
+	status int
+	addr   chan string
+}
+
+func (t *tunnel) Dial(_ string) (net.Conn, error) {
+	server, client := net.Pipe()
+
+	go func() {
+		defer server.Close()
+
+		reader := bufio.NewReader(server)
+
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		t.addr <- req.Host
+
+		(&http.Response{StatusCode: t.status, ProtoMajor: 1, ProtoMinor: 1}).Write(server) //nolint:errcheck
+
+		io.Copy(server, reader) //nolint:errcheck
+	}()
+
+	return client, nil
+}
+
+func (t *tunnel) Connect(target, addr string) (net.Conn, error) {
+	return proxy.Connect(t, target, addr, 0)
+}
+
+func (t *tunnel) Devices() []models.Device {
+	return []models.Device{{UID: "default:DEVICE123", Name: "device123", TenantID: "default", Status: models.DeviceStatusAccepted}}
+}
+
+// connect authenticates and sends a CONNECT request to the host on port 22, returning the reply.
+func connect(t *testing.T, conn net.Conn, user, password, host string) byte {
+	t.Helper()
+
+	_, err := conn.Write([]byte{version, 1, methodUserPassword})
+	require.NoError(t, err)
+
+	method := make([]byte, 2)
+	_, err = io.ReadFull(conn, method)
+	require.NoError(t, err)
+	require.Equal(t, []byte{version, methodUserPassword}, method)
+
+	auth := append([]byte{versionUserPassword, byte(len(user))}, user...)
+	auth = append(append(auth, byte(len(password))), password...)
+	_, err = conn.Write(auth)
+	require.NoError(t, err)
+
+	status := make([]byte, 2)
+	_, err = io.ReadFull(conn, status)
+	require.NoError(t, err)
+
+	if status[1] != 0x00 {
+		return replyNotAllowed
+	}
+
+	req := append([]byte{version, commandConnect, 0x00, addressDomain, byte(len(host))}, host...)
+	_, err = conn.Write(append(req, 0, 22))
+	require.NoError(t, err)
+
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	return reply[1]
+}
+
+func TestServer(t *testing.T) {
+	// NOTE: The password of both users is "x".
+	store, err := users.Parse(strings.NewReader(
+		"alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881:admin:\n" +
+			"bob:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881::lab:*\n",
+	))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		user        string
+		password    string
+		host        string
+		status      int
+		allow       func(host string, port uint32) bool
+		expected    byte
+	}{
+		{
+			description: "succeeds when the user can access the device",
+			user:        "alice",
+			password:    "x",
+			host:        "10.0.0.1.device123.shellhub",
+			status:      http.StatusOK,
+			expected:    replySucceeded,
+		},
+		{
+			description: "fails when the password is wrong",
+			user:        "alice",
+			password:    "y",
+			host:        "10.0.0.1.device123.shellhub",
+			status:      http.StatusOK,
+			expected:    replyNotAllowed,
+		},
+		{
+			description: "fails when the user cannot access the device",
+			user:        "bob",
+			password:    "x",
+			host:        "10.0.0.1.device123.shellhub",
+			status:      http.StatusOK,
+			expected:    replyHostUnreachable,
+		},
+		{
+			description: "fails when the host does not address a device",
+			user:        "alice",
+			password:    "x",
+			host:        "10.0.0.1",
+			status:      http.StatusOK,
+			expected:    replyHostUnreachable,
+		},
+		{
+			description: "fails when the forwarding policy does not allow the destination",
+			user:        "alice",
+			password:    "x",
+			host:        "10.0.0.1.device123.shellhub",
+			status:      http.StatusOK,
+			allow:       func(_ string, _ uint32) bool { return false },
+			expected:    replyNotAllowed,
+		},
+		{
+			description: "fails when the device refuses the destination",
+			user:        "alice",
+			password:    "x",
+			host:        "10.0.0.1.device123.shellhub",
+			status:      http.StatusForbidden,
+			expected:    replyNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			fake := &tunnel{status: tc.status, addr: make(chan string, 1)}
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			go New(fake, store, nil, "", tc.allow).handle(server)
+
+			assert.Equal(t, tc.expected, connect(t, client, tc.user, tc.password, tc.host))
+
+			if tc.expected != replySucceeded {
+				return
+			}
+
+			assert.Equal(t, "10.0.0.1:22", <-fake.addr)
+
+			_, err := client.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			echo := make([]byte, 4)
+			_, err = io.ReadFull(client, echo)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(echo))
+		})
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	// NOTE: The password of the user is "x".
+	store, err := users.Parse(strings.NewReader("alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881:admin:\n"))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		handshake   bool
+		expected    error
+	}{
+		{
+			description: "drops client that does not complete the handshake in time",
+			handshake:   false,
+			expected:    io.EOF,
+		},
+		{
+			description: "keeps connection idle after the reply beyond the handshake timeout",
+			handshake:   true,
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			fake := &tunnel{status: http.StatusOK, addr: make(chan string, 1)}
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			srv := New(fake, store, nil, "", nil)
+			srv.handshake = 100 * time.Millisecond
+
+			go srv.handle(server)
+
+			if tc.handshake {
+				require.Equal(t, byte(replySucceeded), connect(t, client, "alice", "x", "10.0.0.1.device123.shellhub"))
+			}
+
+			time.Sleep(200 * time.Millisecond)
+
+			client.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+
+			if !tc.handshake {
+				_, err := client.Read(make([]byte, 1))
+				assert.ErrorIs(t, err, tc.expected)
+
+				return
+			}
+
+			_, err := client.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			echo := make([]byte, 4)
+			_, err = io.ReadFull(client, echo)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Equal(t, "ping", string(echo))
+		})
+	}
+}
+
+func TestBruteForce(t *testing.T) {
+	// NOTE: The password of the user is "x".
+	store, err := users.Parse(strings.NewReader("alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881:admin:\n"))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		passwords   []string
+		expected    byte
+	}{
+		{
+			description: "authenticates the user when password is right",
+			passwords:   []string{"x"},
+			expected:    replySucceeded,
+		},
+		{
+			description: "refuses the right password when locked out",
+			passwords:   []string{"y", "y", "x"},
+			expected:    replyNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+			require.NoError(t, err)
+
+			srv := New(&tunnel{status: http.StatusOK, addr: make(chan string, 1)}, store, &users.Guard{Lockouts: lockouts}, "", nil)
+
+			var reply byte
+			for _, password := range tc.passwords {
+				server, client := net.Pipe()
+				go srv.handle(server)
+
+				reply = connect(t, client, "alice", password, "10.0.0.1.device123.shellhub")
+				client.Close()
+			}
+
+			assert.Equal(t, tc.expected, reply)
+		})
+	}
+}
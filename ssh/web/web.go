@@ -0,0 +1,193 @@
+// Package web serves the web UIs of the devices, like the admin pages of routers and the dashboards of services, through
+// an HTTP reverse proxy on the server.
+//
+// A request reaches a port of a device by its host, as "<port>-<device>.<domain>", like "8080-device123.tunnels.example".
+// The device is resolved by [target.ResolveDevice], among the ones the user can access.
+//
+// NOTE: Each port of each device is its own origin, so the pages of a device's web UI can't script the ones of another
+// device, nor reuse the basic authentication cached by the browser for it.
+//
+// Each request reaches the port on the device's loopback interface through [proxy.Dial]. WebSocket upgrades are proxied
+// as well.
+//
+// Every request requires HTTPS and HTTP basic authentication of a user who can access the device.
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/proxy"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/target"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	log "github.com/sirupsen/logrus"
+)
+
+// Host is the address the agent connects to on the device, with the port of the request.
+const Host = "127.0.0.1"
+
+var (
+	ErrInvalidAddress = errors.New("request does not address a port of a device")
+	ErrNoDomain       = errors.New("web proxy requires the domain of the devices' hosts")
+	ErrNoTLS          = errors.New("web proxy requires HTTPS")
+)
+
+// Address is the port of a device addressed by a request.
+type Address struct {
+	// Device is the device's UID, name or "<name>.<namespace>".
+	Device string
+	Port   uint16
+}
+
+// ParseHost parses the host of a request in the form "<port>-<device>.<domain>". The domain is matched regardless of
+// case, and the host may have a port.
+func ParseHost(host, domain string) (*Address, error) {
+	if domain == "" {
+		return nil, ErrInvalidAddress
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	name, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(strings.Trim(domain, ".")))
+	if !ok {
+		return nil, ErrInvalidAddress
+	}
+
+	value, device, ok := strings.Cut(name, "-")
+	if !ok || device == "" {
+		return nil, ErrInvalidAddress
+	}
+
+	port, err := parsePort(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Address{Device: device, Port: port}, nil
+}
+
+func parsePort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || port == 0 {
+		return 0, ErrInvalidAddress
+	}
+
+	return uint16(port), nil
+}
+
+type handler struct {
+	tunnel proxy.Tunnel
+	store  *users.Store
+	domain string
+}
+
+// New creates the router of the web proxy, addressing the devices on the hosts of the domain, whose users authenticate
+// through the guard.
+func New(tunnel proxy.Tunnel, store *users.Store, guard *users.Guard, domain string) (*echo.Echo, error) {
+	if strings.Trim(domain, ".") == "" {
+		return nil, ErrNoDomain
+	}
+
+	h := &handler{tunnel: tunnel, store: store, domain: domain}
+
+	e := echo.New()
+	e.HideBanner = true
+
+	// NOTE: The basic authentication sends the password in the clear, so it is never asked for without TLS.
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().TLS == nil {
+				return echo.NewHTTPError(http.StatusForbidden, ErrNoTLS.Error())
+			}
+
+			return next(c)
+		}
+	})
+
+	e.Use(middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
+		Realm: "ShellHub",
+		Validator: func(name, password string, c echo.Context) (bool, error) {
+			// NOTE: The address of the connection, not the forwarded one, which the client could spoof.
+			user, err := guard.Authenticate(store, c.Request().RemoteAddr, name, password)
+			if err != nil {
+				return false, nil
+			}
+
+			c.Set("user", user)
+
+			return true, nil
+		},
+	}))
+
+	e.Any("/*", h.proxy)
+
+	return e, nil
+}
+
+func (h *handler) proxy(c echo.Context) error {
+	req := c.Request()
+
+	addr, err := ParseHost(req.Host, h.domain)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user, _ := c.Get("user").(*users.User)
+
+	logger := log.WithFields(log.Fields{
+		"user":   user.Name,
+		"device": addr.Device,
+		"port":   addr.Port,
+	})
+
+	device, err := target.ResolveDevice(h.store.Accessible(user.Name, h.tunnel.Devices()), addr.Device)
+	if err != nil {
+		logger.WithError(err).Info("web proxy failed to resolve the device")
+
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	logger = logger.WithField("device", device.UID)
+
+	dest := net.JoinHostPort(Host, strconv.Itoa(int(addr.Port)))
+
+	proxied := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = dest
+			r.Out.Host = dest
+
+			r.SetXForwarded()
+
+			// NOTE: The credentials are of the server, not of the device's web UI.
+			r.Out.Header.Del("Authorization")
+		},
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return h.tunnel.Connect(device.UID, dest)
+			},
+			// NOTE: Each request has its own stream, as the transport is not shared between devices.
+			DisableKeepAlives: true,
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			logger.WithError(err).Warn("web proxy failed to reach the device")
+
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	logger.Debug("web proxy request")
+
+	proxied.ServeHTTP(c.Response(), req)
+
+	return nil
+}
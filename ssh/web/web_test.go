@@ -0,0 +1,165 @@
+package web
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shellhub-io/mini-shellhub/pkg/guard"
+	"github.com/shellhub-io/mini-shellhub/ssh/pkg/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHost(t *testing.T) {
+	type Expected struct {
+		addr *Address
+		err  error
+	}
+
+	cases := []struct {
+		description string
+		host        string
+		domain      string
+		expected    Expected
+	}{
+		{
+			description: "fails when domain is empty",
+			host:        "8080-device123.tunnels.example",
+			domain:      "",
+			expected:    Expected{addr: nil, err: ErrInvalidAddress},
+		},
+		{
+			description: "succeeds when host has the port and the device",
+			host:        "8080-device123.tunnels.example",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: &Address{Device: "device123", Port: 8080}, err: nil},
+		},
+		{
+			description: "succeeds when host has the server's port and mixed case",
+			host:        "8080-Device123.Tunnels.Example:8443",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: &Address{Device: "device123", Port: 8080}, err: nil},
+		},
+		{
+			description: "succeeds when device has dashes and namespace",
+			host:        "3000-web-01.lab.tunnels.example",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: &Address{Device: "web-01.lab", Port: 3000}, err: nil},
+		},
+		{
+			description: "fails when host is of another domain",
+			host:        "8080-device123.example",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: nil, err: ErrInvalidAddress},
+		},
+		{
+			description: "fails when port is missing",
+			host:        "device123.tunnels.example",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: nil, err: ErrInvalidAddress},
+		},
+		{
+			description: "fails when port is out of range",
+			host:        "70000-device123.tunnels.example",
+			domain:      "tunnels.example",
+			expected:    Expected{addr: nil, err: ErrInvalidAddress},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			addr, err := ParseHost(tc.host, tc.domain)
+			assert.Equal(t, tc.expected, Expected{addr, err})
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, nil, nil, "")
+	assert.ErrorIs(t, err, ErrNoDomain)
+
+	e, err := New(nil, nil, nil, "tunnels.example")
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		tls         *tls.ConnectionState
+		expected    int
+	}{
+		{
+			description: "refuses requests over plain HTTP before asking for credentials",
+			tls:         nil,
+			expected:    http.StatusForbidden,
+		},
+		{
+			description: "asks for credentials over HTTPS",
+			tls:         &tls.ConnectionState{},
+			expected:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "8080-device123.tunnels.example"
+			req.TLS = tc.tls
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expected, rec.Code)
+			assert.Equal(t, tc.tls != nil, rec.Header().Get("WWW-Authenticate") != "")
+		})
+	}
+}
+
+func TestBruteForce(t *testing.T) {
+	// NOTE: The password of the user is "x".
+	store, err := users.Parse(strings.NewReader("alice:2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881\n"))
+	require.NoError(t, err)
+
+	cases := []struct {
+		description string
+		passwords   []string
+		expected    int
+	}{
+		{
+			description: "authenticates the user when password is right",
+			passwords:   []string{"x"},
+			expected:    http.StatusNotFound,
+		},
+		{
+			description: "refuses the right password when locked out",
+			passwords:   []string{"y", "y", "x"},
+			expected:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			lockouts, err := guard.New(guard.Config{MaxFailures: 2, Window: time.Minute, Lockout: time.Minute, MaxLockout: time.Hour})
+			require.NoError(t, err)
+
+			e, err := New(nil, store, &users.Guard{Lockouts: lockouts}, "tunnels.example")
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			for _, password := range tc.passwords {
+				// NOTE: The host addresses no device, so an authenticated request is not found.
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Host = "device123.tunnels.example"
+				req.TLS = &tls.ConnectionState{}
+				req.SetBasicAuth("alice", password)
+
+				rec = httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tc.expected, rec.Code)
+		})
+	}
+}